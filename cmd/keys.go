@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/auth"
+	"github.com/go-redis/redis"
+	"github.com/spf13/cobra"
+)
+
+var keysCmd *cobra.Command
+
+// keyStore loads the config and returns the Redis client of the key store of
+// --mode, nil for API_KEYS_FILE.
+func keyStore(cmd *cobra.Command) (*redis.Client, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	mode, _ := cmd.Flags().GetString("mode")
+	switch mode {
+	case "local":
+		return nil, nil
+	case "redis":
+		rdb := redis.NewClient(&redis.Options{
+			Addr: fmt.Sprintf("%s:%s", cfg.REDIS.HOST, cfg.REDIS.PORT),
+		})
+		if err := rdb.Ping().Err(); err != nil {
+			return nil, fmt.Errorf("could not connect to Redis: %w", err)
+		}
+		return rdb, nil
+	default:
+		return nil, fmt.Errorf("invalid mode %q, must be local or redis", mode)
+	}
+}
+
+func createKey(cmd *cobra.Command, args []string) error {
+	flags := cmd.Flags()
+	rawScopes, _ := flags.GetString("scopes")
+	scopes, err := auth.ParseScopes(splitList(rawScopes))
+	if err != nil {
+		return err
+	}
+	rawTypes, _ := flags.GetString("types")
+	name, _ := flags.GetString("name")
+
+	rdb, err := keyStore(cmd)
+	if err != nil {
+		return err
+	}
+	record, key, err := auth.NewKey(name, scopes, splitList(rawTypes))
+	if err != nil {
+		return fmt.Errorf("could not generate key: %w", err)
+	}
+	if err := auth.Save(record, rdb); err != nil {
+		return fmt.Errorf("could not save key: %w", err)
+	}
+
+	fmt.Printf("Created key %s. It is not shown again:\n%s\n", record.ID, key)
+	if !config.AppConfig.AUTH_ENABLED {
+		fmt.Println("Keys are only required once AUTH_ENABLED=true is set in .env.")
+	}
+	return nil
+}
+
+func listKeys(cmd *cobra.Command, args []string) error {
+	rdb, err := keyStore(cmd)
+	if err != nil {
+		return err
+	}
+	keys, err := auth.List(rdb)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		fmt.Println("(no keys)")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSCOPES\tTYPES\tCREATED")
+	for _, key := range keys {
+		scopes := make([]string, len(key.Scopes))
+		for i, scope := range key.Scopes {
+			scopes[i] = string(scope)
+		}
+		types := "all"
+		if len(key.TaskTypes) > 0 {
+			types = strings.Join(key.TaskTypes, ",")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", key.ID, key.Name, strings.Join(scopes, ","), types, key.CreatedAt.Local().Format("2006-01-02 15:04"))
+	}
+	return tw.Flush()
+}
+
+func revokeKey(cmd *cobra.Command, args []string) error {
+	rdb, err := keyStore(cmd)
+	if err != nil {
+		return err
+	}
+	err = auth.Revoke(args[0], rdb)
+	if errors.Is(err, auth.ErrKeyNotFound) {
+		return fmt.Errorf("no key with ID %s", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Revoked key %s\n", args[0])
+	return nil
+}
+
+func init() {
+	keysCmd = &cobra.Command{
+		Use:   "keys",
+		Short: "Manage the API keys of the REST API and gRPC server",
+		Long: `Manage API keys, which are required while AUTH_ENABLED is set.
+
+Only hashes of keys are stored: in API_KEYS_FILE in local mode, in Redis in
+redis mode. Changes apply to running servers right away.`,
+	}
+	keysCmd.PersistentFlags().StringP("mode", "m", "local", "where keys are stored: local (API_KEYS_FILE) | redis")
+
+	createCmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a key and print it",
+		Args:  cobra.NoArgs,
+		RunE:  createKey,
+	}
+	createCmd.Flags().String("name", "", "name telling what the key is for")
+	createCmd.Flags().String("scopes", "", "comma separated scopes: enqueue, read, worker, admin")
+	createCmd.Flags().String("types", "", "comma separated task types the key may enqueue (default: all)")
+	_ = createCmd.MarkFlagRequired("scopes")
+
+	keysCmd.AddCommand(
+		createCmd,
+		&cobra.Command{
+			Use:   "list",
+			Short: "List keys without their secrets",
+			Args:  cobra.NoArgs,
+			RunE:  listKeys,
+		},
+		&cobra.Command{
+			Use:   "revoke <id>",
+			Short: "Revoke a key",
+			Args:  cobra.ExactArgs(1),
+			RunE:  revokeKey,
+		},
+	)
+	rootCmd.AddCommand(keysCmd)
+}
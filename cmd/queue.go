@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/spf13/cobra"
+)
+
+var queueCmd *cobra.Command
+
+// setQueueState asks the running server to change a queue's state through the REST API,
+// so that it works in local mode too. The request is made with the key of QUGOPY_API_KEY.
+func setQueueState(server, name string, state tasks.QueueState) error {
+	action := map[tasks.QueueState]string{
+		tasks.QueuePaused:   "pause",
+		tasks.QueueActive:   "resume",
+		tasks.QueueDraining: "drain",
+	}[state]
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/queues/%s/%s", server, name, action), nil)
+	if err != nil {
+		return err
+	}
+	// read from the environment, as .env is not loaded when --server is given
+	if key := os.Getenv("QUGOPY_API_KEY"); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach server: %w", err)
+	}
+	defer res.Body.Close()
+
+	var body map[string]any
+	_ = json.NewDecoder(res.Body).Decode(&body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not %s queue %s: %v", action, name, body["error"])
+	}
+	fmt.Printf("Queue %s is %s\n", name, state)
+	return nil
+}
+
+func queueStateCmd(use, short string, state tasks.QueueState) *cobra.Command {
+	return &cobra.Command{
+		Use:   use + " <queue>",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			server, _ := cmd.Flags().GetString("server")
+			if server == "" {
+				if _, err := config.LoadConfig(); err != nil {
+					return fmt.Errorf("failed to load config: %w", err)
+				}
+				server = fmt.Sprintf("http://%s:%s", config.AppConfig.HOST, config.AppConfig.PORT)
+			}
+			return setQueueState(server, args[0], state)
+		},
+	}
+}
+
+func init() {
+	queueCmd = &cobra.Command{
+		Use:   "queue",
+		Short: "Control queues of a running server",
+	}
+
+	queueCmd.PersistentFlags().StringP("server", "s", "", "REST API address (default: HOST and PORT of .env)")
+	queueCmd.AddCommand(
+		queueStateCmd("pause", "Stop workers pulling from a queue, keeping queued tasks", tasks.QueuePaused),
+		queueStateCmd("resume", "Resume a paused or draining queue", tasks.QueueActive),
+		queueStateCmd("drain", "Reject new tasks while workers empty a queue", tasks.QueueDraining),
+	)
+	rootCmd.AddCommand(queueCmd)
+}
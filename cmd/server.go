@@ -2,31 +2,214 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
 
 	"github.com/Yulian302/qugopy/config"
 	"github.com/Yulian302/qugopy/internal/api"
+	"github.com/Yulian302/qugopy/internal/callbacks"
+	"github.com/Yulian302/qugopy/internal/events"
+	"github.com/Yulian302/qugopy/internal/metrics"
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/Yulian302/qugopy/internal/tracing"
+	"github.com/Yulian302/qugopy/logging"
 	w "github.com/Yulian302/qugopy/workers"
 	"github.com/go-redis/redis"
+	"github.com/spf13/cobra"
 )
 
 var rdb *redis.Client
 
-func StartApp(mode string, workers int, isProduction bool) (context.CancelFunc, error) {
-	wd := w.NewWorkerDistributor(rdb)
-	cancel, err := wd.DistributeWorkers(workers, mode, isProduction, rdb)
+// workerPlan sizes the worker pools from the configuration. Explicit Go and
+// Python worker counts take precedence over splitting WORKERS in half.
+func workerPlan(cfg *config.RootConfig) (w.WorkerPlan, error) {
+	plan := w.SplitWorkers(cfg.WORKERS)
+	if cfg.GO_WORKERS >= 0 {
+		plan.Go = cfg.GO_WORKERS
+	}
+	if cfg.PYTHON_WORKERS >= 0 {
+		plan.Python = cfg.PYTHON_WORKERS
+	}
+	plan.GoConcurrency = cfg.GO_CONCURRENCY
+
+	capPolicy, err := w.ParseCapPolicy(cfg.WORKER_CAP)
 	if err != nil {
-		return nil, fmt.Errorf("failed to distribute workers: %w", err)
+		return plan, err
 	}
+	plan.Cap = capPolicy
+	return plan, nil
+}
 
-	router := api.NewRouter(rdb)
+// time the REST API gets to answer running requests on shutdown
+const httpShutdownTimeout = 5 * time.Second
 
+// serveMetrics serves /metrics on METRICS_ADDR. The returned function stops it.
+func serveMetrics(addr string) (func(), error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	srv := &http.Server{Addr: addr, Handler: mux}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start metrics server: %w", err)
+	}
 	go func() {
-		if err := router.Run(fmt.Sprintf("%s:%s", config.AppConfig.HOST, config.AppConfig.PORT)); err != nil {
-			log.Printf("failed to start server: %v", err)
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics server exited", logging.Err(err))
 		}
 	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.Error("could not stop metrics server", logging.Err(err))
+		}
+	}, nil
+}
+
+// startTracing sets up the export of spans. The returned function flushes the
+// spans not exported yet and stops it.
+func startTracing(exporter string) (func(), error) {
+	shutdown, err := tracing.Init(context.Background(), exporter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			slog.Error("could not flush spans", logging.Err(err))
+		}
+	}, nil
+}
+
+// StartApp starts the workers, unless they run in other processes, and the REST
+// API. The returned function shuts them down: new tasks are rejected, running
+// tasks get SHUTDOWN_GRACE to finish and unfinished ones are requeued before the
+// REST API stops. Clients streaming events are disconnected before that, and
+// callback deliveries which are not due yet are left to other instances.
+func StartApp(mode string, plan w.WorkerPlan, embedWorkers bool, isProduction bool) (context.CancelFunc, error) {
+	if err := tasks.LoadQueues(rdb); err != nil {
+		return nil, fmt.Errorf("failed to load queues: %w", err)
+	}
+	if migrated, err := tasks.MigrateLegacyQueues(rdb); err != nil {
+		return nil, fmt.Errorf("failed to migrate queues: %w", err)
+	} else if migrated > 0 {
+		slog.Info("migrated tasks of legacy queue keys", "tasks", migrated)
+	}
+	if err := metrics.SetGauges(tasks.NewGaugeCollector(rdb)); err != nil {
+		return nil, fmt.Errorf("failed to register metrics: %w", err)
+	}
+	stopMetrics := func() {}
+	if config.AppConfig.METRICS_ADDR != "" {
+		var err error
+		if stopMetrics, err = serveMetrics(config.AppConfig.METRICS_ADDR); err != nil {
+			return nil, err
+		}
+	}
+	stopTracing, err := startTracing(config.AppConfig.TRACING_EXPORTER)
+	if err != nil {
+		stopMetrics()
+		return nil, err
+	}
+
+	// events of all instances, and queues they declare, are shared through Redis
+	eventsCtx, stopEvents := context.WithCancel(context.Background())
+	if mode == "redis" {
+		events.Forward(eventsCtx, rdb)
+		events.FanIn(eventsCtx, rdb)
+		if err := tasks.WatchQueues(eventsCtx, rdb); err != nil {
+			stopEvents()
+			stopMetrics()
+			stopTracing()
+			return nil, err
+		}
+	}
+
+	callbacksCtx, stopCallbacks := context.WithCancel(context.Background())
+	callbacksDone := make(chan struct{})
+	go func() {
+		defer close(callbacksDone)
+		callbacks.Run(callbacksCtx, rdb)
+	}()
+	// stopDelivering waits for the attempts being made
+	stopDelivering := func() {
+		stopCallbacks()
+		<-callbacksDone
+	}
+
+	stopWorkers := func() {}
+	if embedWorkers {
+		wd := w.NewWorkerDistributor(rdb)
+		var err error
+		if stopWorkers, err = wd.Distribute(plan, mode, isProduction, rdb); err != nil {
+			stopEvents()
+			stopDelivering()
+			stopMetrics()
+			stopTracing()
+			return nil, fmt.Errorf("failed to distribute workers: %w", err)
+		}
+	}
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf("%s:%s", config.AppConfig.HOST, config.AppConfig.PORT),
+		Handler: api.NewRouter(rdb),
+	}
+	// bind before returning, so that the app is only ready once it accepts requests
+	lis, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		stopWorkers()
+		stopEvents()
+		stopDelivering()
+		stopMetrics()
+		stopTracing()
+		return nil, fmt.Errorf("failed to start server: %w", err)
+	}
+
+	go func() {
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("server exited", logging.Err(err))
+		}
+	}()
+
+	return func() {
+		tasks.StopAccepting()
+		stopWorkers()
+		stopDelivering()
+		events.Close()
+		defer stopEvents()
+
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.Error("could not stop server", logging.Err(err))
+		}
+		stopMetrics()
+		stopTracing()
+	}, nil
+}
+
+var serverCmd *cobra.Command
+
+func init() {
+	serverCmd = &cobra.Command{
+		Use:   "server",
+		Short: "Run the REST API and gRPC server without the interactive shell",
+		Long: `Run qugopy headless, e.g. under systemd or in a container.
+
+The server is ready once GET /ready answers 200. Under a Type=notify systemd
+service it also sends READY=1 and STOPPING=1 to the service manager.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			noWorkers, _ := cmd.Flags().GetBool("no-workers")
+			runApp(cmd, appOptions{production: true, workers: !noWorkers})
+		},
+	}
 
-	return cancel, nil
+	serverCmd.Flags().StringP("mode", "m", "local", "mode for queuing tasks: redis | local")
+	serverCmd.Flags().Bool("no-workers", false, "run no workers in this process, e.g. when they run as qugopy worker")
+	addWorkerFlags(serverCmd)
+	rootCmd.AddCommand(serverCmd)
 }
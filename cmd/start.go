@@ -3,38 +3,135 @@ package cmd
 import (
 	"context"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/Yulian302/qugopy/config"
 	"github.com/Yulian302/qugopy/grpc"
+	"github.com/Yulian302/qugopy/internal/readiness"
 	"github.com/Yulian302/qugopy/logging"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 
 	"github.com/Yulian302/qugopy/shell"
 )
 
 var startCmd *cobra.Command
 
+// time gRPC calls of workers get to finish on shutdown
+const grpcStopTimeout = 5 * time.Second
+
+// appOptions select what a run of the app includes.
+type appOptions struct {
+	production bool
+	// run the interactive shell, leaving it shuts the app down
+	shell bool
+	// run workers in this process
+	workers bool
+}
+
+// applyWorkerFlags overrides the worker configuration with the flags given on
+// the command line.
+func applyWorkerFlags(cmd *cobra.Command, cfg *config.RootConfig) error {
+	flags := cmd.Flags()
+	if flags.Changed("workers") {
+		cfg.WORKERS, _ = flags.GetInt("workers")
+	}
+	if flags.Changed("go-workers") {
+		cfg.GO_WORKERS, _ = flags.GetInt("go-workers")
+	}
+	if flags.Changed("python-workers") {
+		cfg.PYTHON_WORKERS, _ = flags.GetInt("python-workers")
+	}
+	if flags.Changed("go-concurrency") {
+		cfg.GO_CONCURRENCY, _ = flags.GetInt("go-concurrency")
+	}
+	if flags.Changed("worker-cap") {
+		cfg.WORKER_CAP, _ = flags.GetString("worker-cap")
+	}
+	if flags.Changed("labels") {
+		raw, _ := flags.GetString("labels")
+		labels, err := config.ParseLabels(raw)
+		if err != nil {
+			return fmt.Errorf("invalid --labels: %w", err)
+		}
+		cfg.WORKER_LABELS = labels
+	}
+	return nil
+}
+
+// setupLogging sets up the logger configured by cfg. While the interactive
+// shell runs, the log goes to a file by default, so that it does not garble
+// the shell. The returned function closes the log files.
+func setupLogging(cfg *config.RootConfig, shell bool) (func(), error) {
+	outputs := cfg.LOG_OUTPUT
+	if len(outputs) == 0 && shell {
+		outputs = []string{filepath.Join(config.ProjectRootPath, "qugopy.log")}
+	}
+	closeLog, err := logging.Setup(logging.Config{
+		Level:      cfg.LOG_LEVEL,
+		Format:     cfg.LOG_FORMAT,
+		Outputs:    outputs,
+		MaxSizeMB:  cfg.LOG_MAX_SIZE_MB,
+		MaxBackups: cfg.LOG_MAX_BACKUPS,
+		MaxAgeDays: cfg.LOG_MAX_AGE_DAYS,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() { _ = closeLog() }, nil
+}
+
+// fatal logs an error the app can not run with and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, logging.Err(err))
+	os.Exit(1)
+}
+
+// addWorkerFlags adds the flags sizing the worker pools to cmd.
+func addWorkerFlags(cmd *cobra.Command) {
+	cmd.Flags().IntP("workers", "w", 2, "number of concurrent workers")
+	cmd.Flags().Int("go-workers", -1, "number of Go workers (default: half of --workers)")
+	cmd.Flags().Int("python-workers", -1, "number of Python workers (default: half of --workers)")
+	cmd.Flags().Int("go-concurrency", 1, "number of tasks each Go worker runs at once")
+	cmd.Flags().String("worker-cap", "cpu", "worker cap policy: cpu (Python workers <= CPUs) | none | max total workers")
+	cmd.Flags().String("labels", "", "labels of the workers matched against task affinity, e.g. disk=large,zone=eu (default: WORKER_LABELS)")
+}
+
 func RunApp(isProduction bool) {
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	opts := appOptions{production: isProduction, shell: isProduction, workers: true}
+	// e.g. under systemd or in a container
+	if opts.shell && !term.IsTerminal(int(os.Stdin.Fd())) {
+		slog.Info("stdin is not a terminal, running without the interactive shell")
+		opts.shell = false
+	}
+	runApp(startCmd, opts)
+}
+
+func runApp(cmd *cobra.Command, opts appOptions) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
 	cfg, err := config.LoadConfig()
 
 	if err != nil {
-		log.Fatalf("failed to load config: %v", err)
+		fatal("failed to load config", err)
+	}
+	closeLog, err := setupLogging(cfg, opts.shell)
+	if err != nil {
+		fatal("failed to set up logging", err)
 	}
+	defer closeLog()
 
 	// process debug mode params
-	if !isProduction {
+	if !opts.production {
 		envMode := os.Getenv("MODE")
 		if envMode == "" {
 			envMode = "local"
@@ -46,25 +143,21 @@ func RunApp(isProduction bool) {
 			if parsed, err := strconv.Atoi(envWorkers); err == nil {
 				config.AppConfig.WORKERS = parsed
 			} else {
-				fmt.Println("Invalid WORKERS value:", err)
+				slog.Warn("invalid WORKERS value", logging.Err(err))
 			}
 		} else {
 			config.AppConfig.WORKERS = 2
 		}
 	} else {
 		gin.SetMode(gin.ReleaseMode)
-		f, _ := os.Create(fmt.Sprintf(filepath.Join(config.ProjectRootPath, "gin.log")))
-		gin.DefaultWriter = io.MultiWriter(f)
 	}
 
 	// process cli params
-	if modeFlag := startCmd.Flag("mode").Value.String(); modeFlag != "" && startCmd.Flag("mode").Changed {
-		cfg.MODE = modeFlag
+	if cmd.Flag("mode").Changed {
+		cfg.MODE = cmd.Flag("mode").Value.String()
 	}
-	if workersFlag := startCmd.Flag("workers").Value.String(); workersFlag != "" && startCmd.Flag("workers").Changed {
-		if workers, err := strconv.Atoi(workersFlag); err == nil {
-			cfg.WORKERS = workers
-		}
+	if err := applyWorkerFlags(cmd, cfg); err != nil {
+		fatal("invalid flags", err)
 	}
 
 	// set up redis
@@ -72,36 +165,72 @@ func RunApp(isProduction bool) {
 		rdb = redis.NewClient(&redis.Options{
 			Addr: fmt.Sprintf("%s:%s", config.AppConfig.REDIS.HOST, config.AppConfig.REDIS.PORT),
 		})
-		logging.DebugLog(fmt.Sprintf("Successfully connected to Redis (host: %s, port: %s)", config.AppConfig.REDIS.HOST, config.AppConfig.REDIS.PORT))
+		slog.Info("connected to Redis", "host", config.AppConfig.REDIS.HOST, "port", config.AppConfig.REDIS.PORT)
 	}
 
 	errCh := make(chan error, 2)
 
-	if cfg.MODE == "local" {
-		go func() { errCh <- grpc.Start() }()
-		time.Sleep(100 * time.Millisecond)
+	// producers use the gRPC server in both modes, workers only in local mode
+	lis, err := grpc.Listen()
+	if err != nil {
+		fatal("failed to start gRPC server", err)
+	}
+	go func() { errCh <- grpc.Serve(lis, rdb) }()
+
+	plan, err := workerPlan(cfg)
+	if err != nil && opts.workers {
+		fatal("invalid worker configuration", err)
 	}
 
 	var cancel context.CancelFunc
-	cancel, err = StartApp(cfg.MODE, cfg.WORKERS, isProduction)
+	cancel, err = StartApp(cfg.MODE, plan, opts.workers, opts.production)
 	if err != nil {
-		log.Fatalf("App failed to start: %v", err)
+		fatal("app failed to start", err)
 	}
 
-	// start shell only in prod
-	if isProduction {
-		shell.StartInteractiveShell(rdb)
+	readiness.Set(true)
+	if err := readiness.Notify("READY=1"); err != nil {
+		slog.Warn("could not notify readiness", logging.Err(err))
+	}
+	if !opts.shell {
+		slog.Info("qugopy is ready", "addr", fmt.Sprintf("%s:%s", config.AppConfig.HOST, config.AppConfig.PORT), "mode", cfg.MODE)
+	}
+
+	// leaving the shell shuts down the app
+	shellDone := make(chan struct{})
+	if opts.shell {
+		go func() {
+			shell.StartInteractiveShell(rdb)
+			close(shellDone)
+		}()
 	}
 
 	select {
 	case err := <-errCh:
-		fmt.Println("Service exited with error:", err)
+		slog.Error("service exited", logging.Err(err))
 	case <-ctx.Done():
-		fmt.Println("Shutting down gracefully...")
-		cancel()
+	case <-shellDone:
 	}
 
+	// a second signal kills the process
 	stop()
+	shell.RestoreTerminal()
+
+	if opts.shell {
+		fmt.Println("Shutting down gracefully...")
+	}
+	slog.Info("shutting down gracefully")
+	readiness.Set(false)
+	if err := readiness.Notify("STOPPING=1"); err != nil {
+		slog.Warn("could not notify readiness", logging.Err(err))
+	}
+	cancel()
+	grpc.Stop(grpcStopTimeout)
+	if rdb != nil {
+		if err := rdb.Close(); err != nil {
+			slog.Error("could not close Redis connection", logging.Err(err))
+		}
+	}
 }
 
 func init() {
@@ -114,6 +243,6 @@ func init() {
 	}
 
 	startCmd.Flags().StringP("mode", "m", "local", "mode for queuing tasks: redis | local")
-	startCmd.Flags().IntP("workers", "w", 2, "number of concurrent workers")
+	addWorkerFlags(startCmd)
 	rootCmd.AddCommand(startCmd)
 }
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/grpc"
+	"github.com/Yulian302/qugopy/internal/events"
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/Yulian302/qugopy/logging"
+	w "github.com/Yulian302/qugopy/workers"
+	"github.com/go-redis/redis"
+	"github.com/spf13/cobra"
+)
+
+var workerCmd *cobra.Command
+
+// splitList splits a comma separated flag value, dropping empty entries.
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// selectQueues returns the queues a worker process consumes: the given queues
+// and the queues the given task types are routed to, or all queues if neither
+// is given. Only queues of the given runtime are kept, unless it is "all".
+func selectQueues(names, types []string, runtime string) ([]tasks.QueueType, error) {
+	switch tasks.Runtime(runtime) {
+	case "all", tasks.GoRuntime, tasks.PythonRuntime, tasks.ExternalRuntime:
+	default:
+		return nil, fmt.Errorf("invalid runtime %q: must be go, python, external or all", runtime)
+	}
+
+	selected := make(map[tasks.QueueType]bool)
+	for _, name := range names {
+		if _, exists := tasks.GetQueue(tasks.QueueType(name)); !exists {
+			return nil, fmt.Errorf("%w: %s", tasks.ErrUnknownQueue, name)
+		}
+		selected[tasks.QueueType(name)] = true
+	}
+	for _, taskType := range types {
+		queueType, err := tasks.GetQueueType(taskType)
+		if err != nil {
+			return nil, err
+		}
+		selected[queueType] = true
+	}
+
+	var queues []tasks.QueueType
+	for _, spec := range tasks.Queues() {
+		if len(selected) > 0 && !selected[spec.Name] {
+			continue
+		}
+		if runtime != "all" && string(spec.Runtime) != runtime {
+			continue
+		}
+		queues = append(queues, spec.Name)
+	}
+	if len(queues) == 0 {
+		return nil, fmt.Errorf("no queue matches the given queues, types and runtime")
+	}
+	return queues, nil
+}
+
+func runWorkers(cmd *cobra.Command) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	closeLog, err := setupLogging(cfg, false)
+	if err != nil {
+		return fmt.Errorf("failed to set up logging: %w", err)
+	}
+	defer closeLog()
+
+	flags := cmd.Flags()
+	cfg.MODE, _ = flags.GetString("mode")
+	if err := applyWorkerFlags(cmd, cfg); err != nil {
+		return err
+	}
+
+	var rdb *redis.Client
+	switch cfg.MODE {
+	case "redis":
+		rdb = redis.NewClient(&redis.Options{
+			Addr: fmt.Sprintf("%s:%s", cfg.REDIS.HOST, cfg.REDIS.PORT),
+		})
+		defer rdb.Close()
+		// the events of the workers reach API servers through Redis
+		eventsCtx, stopEvents := context.WithCancel(context.Background())
+		defer stopEvents()
+		events.Forward(eventsCtx, rdb)
+	case "local":
+	default:
+		return fmt.Errorf("invalid mode %q: must be redis or local", cfg.MODE)
+	}
+
+	if err := tasks.LoadQueues(rdb); err != nil {
+		return fmt.Errorf("failed to load queues: %w", err)
+	}
+	if migrated, err := tasks.MigrateLegacyQueues(rdb); err != nil {
+		return fmt.Errorf("failed to migrate queues: %w", err)
+	} else if migrated > 0 {
+		slog.Info("migrated tasks of legacy queue keys", "tasks", migrated)
+	}
+	if rdb != nil {
+		// queues declared through the API while the worker runs get workers too
+		queuesCtx, stopWatching := context.WithCancel(context.Background())
+		defer stopWatching()
+		if err := tasks.WatchQueues(queuesCtx, rdb); err != nil {
+			return err
+		}
+	}
+	// task metrics of local mode are recorded by the server handing out the tasks
+	if cfg.METRICS_ADDR != "" {
+		stopMetrics, err := serveMetrics(cfg.METRICS_ADDR)
+		if err != nil {
+			return err
+		}
+		defer stopMetrics()
+	}
+	stopTracing, err := startTracing(cfg.TRACING_EXPORTER)
+	if err != nil {
+		return err
+	}
+	defer stopTracing()
+
+	plan, err := workerPlan(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid worker configuration: %w", err)
+	}
+	queueNames, _ := flags.GetString("queues")
+	taskTypes, _ := flags.GetString("types")
+	runtime, _ := flags.GetString("runtime")
+	if plan.Queues, err = selectQueues(splitList(queueNames), splitList(taskTypes), runtime); err != nil {
+		return err
+	}
+	if !slices.Contains(plan.Queues, tasks.GoQueue) {
+		plan.Go = 0
+	}
+	if !slices.Contains(plan.Queues, tasks.PyQueue) {
+		plan.Python = 0
+	}
+
+	wd := w.NewWorkerDistributor(rdb)
+	if cfg.MODE == "local" {
+		server, _ := flags.GetString("server")
+		if err := wd.ConnectRemote(server); err != nil {
+			return err
+		}
+		slog.Info("taking tasks from gRPC server", "server", server)
+	} else {
+		// Python workers report their metrics over gRPC, which this process
+		// serves on a loopback port to export them
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return fmt.Errorf("gRPC listen failed: %w", err)
+		}
+		go func() {
+			if err := grpc.Serve(lis, rdb); err != nil {
+				slog.Error("gRPC server exited", logging.Err(err))
+			}
+		}()
+		defer grpc.Stop(grpcStopTimeout)
+		wd.ReportMetricsTo(lis.Addr().String())
+	}
+
+	shutdown, err := wd.Distribute(plan, cfg.MODE, true, rdb)
+	if err != nil {
+		return fmt.Errorf("failed to start workers: %w", err)
+	}
+	slog.Info("workers started", "queues", plan.Queues)
+
+	<-ctx.Done()
+	// a second signal kills the process
+	stop()
+	slog.Info("shutting down gracefully")
+	shutdown()
+	return nil
+}
+
+func init() {
+	workerCmd = &cobra.Command{
+		Use:   "worker",
+		Short: "Run only workers, against Redis or a remote qugopy server",
+		Long: `Run workers without the REST API and shell, e.g. on other hosts than the server.
+
+In redis mode the workers take tasks from Redis. In local mode they take tasks
+from the in-memory queues of the qugopy server given by --server over gRPC.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runWorkers(cmd)
+		},
+	}
+
+	workerCmd.Flags().StringP("mode", "m", "local", "where tasks come from: redis | local (gRPC server)")
+	workerCmd.Flags().StringP("server", "s", "localhost:50051", "gRPC address of the qugopy server in local mode")
+	workerCmd.Flags().String("runtime", "all", "workers to run: go | python | external | all")
+	workerCmd.Flags().String("queues", "", "comma separated queues to consume (default: all)")
+	workerCmd.Flags().String("types", "", "comma separated task types whose queues are consumed")
+	addWorkerFlags(workerCmd)
+	rootCmd.AddCommand(workerCmd)
+}
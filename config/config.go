@@ -1,11 +1,18 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -37,6 +44,72 @@ type BrevoConfig struct {
 	EMAIL   string
 }
 
+// TenantQuota controls how a tenant shares the queues with other tenants.
+type TenantQuota struct {
+	// WEIGHT is the number of tasks served per scheduling round. Defaults to 1.
+	WEIGHT int
+	// MAX_QUEUED caps the number of tasks a tenant can have queued. 0 means unlimited.
+	MAX_QUEUED int
+}
+
+// QueueConfig declares a named queue in addition to the built-in go_queue and python_queue.
+type QueueConfig struct {
+	NAME string
+	// RUNTIME is the kind of workers consuming the queue: `go` or `python`.
+	RUNTIME string
+	// WORKERS is the size of the queue's own worker pool.
+	WORKERS int
+	// AGING is the wait after which a queued task ranks one priority level more urgent. 0 disables aging.
+	AGING time.Duration
+}
+
+// ExternalPoolConfig declares a queue consumed by worker processes running an
+// arbitrary command which speaks the external worker protocol.
+type ExternalPoolConfig struct {
+	NAME    string            `json:"name"`
+	COMMAND string            `json:"command"`
+	ARGS    []string          `json:"args"`
+	ENV     map[string]string `json:"env"`
+	// DIR is the working directory of the processes. Defaults to the project root.
+	DIR string `json:"dir"`
+	// TASK_TYPES are routed to the queue. Types which are not built in are
+	// registered as new task types.
+	TASK_TYPES []string `json:"task_types"`
+	// WORKERS is the number of processes.
+	WORKERS int `json:"workers"`
+	// CONCURRENCY is the number of tasks each process is sent at once. Defaults to 1.
+	CONCURRENCY int `json:"concurrency"`
+	// TRANSPORT is `stdio` (the default) or `unix` for a Unix socket.
+	TRANSPORT string `json:"transport"`
+}
+
+// RateLimitConfig limits how often tasks of a type are executed.
+type RateLimitConfig struct {
+	// RATE is the number of tasks executed per second on average.
+	RATE float64
+	// BURST is the number of tasks which may run at once after an idle period.
+	BURST int
+	// PARTITION_BY is a payload field holding an email address. If set, the
+	// limit applies per domain of that address instead of to the whole type.
+	PARTITION_BY string
+}
+
+// UniqueTaskConfig makes tasks of a type unique by payload fields.
+type UniqueTaskConfig struct {
+	// FIELDS are the top-level payload fields the unique key is computed from.
+	FIELDS []string
+	// ON_CONFLICT is `reject` or `replace`.
+	ON_CONFLICT string
+	// LOCK_TTL is the lease of the lock held while a task runs. 0 disables the lock.
+	LOCK_TTL time.Duration
+}
+
+// AutoscaleConfig bounds the autoscaled worker pool of a queue.
+type AutoscaleConfig struct {
+	MIN int
+	MAX int
+}
+
 type RootConfig struct {
 	HOST    string
 	PORT    string
@@ -44,6 +117,382 @@ type RootConfig struct {
 	BREVO   BrevoConfig
 	MODE    string
 	WORKERS int
+	// GO_WORKERS and PYTHON_WORKERS size the pools of the built-in queues.
+	// -1 splits WORKERS in half instead.
+	GO_WORKERS     int
+	PYTHON_WORKERS int
+	// GO_CONCURRENCY is the number of tasks each Go worker runs at once.
+	GO_CONCURRENCY int
+	// WORKER_CAP limits the number of workers: `cpu`, `none` or a maximum total.
+	WORKER_CAP string
+	TENANTS    map[string]TenantQuota
+	QUEUES     []QueueConfig
+	// ROUTES maps task types to the queue they are enqueued in.
+	ROUTES map[string]string
+	// AUTOSCALE maps queue names to the bounds of their autoscaled pools.
+	AUTOSCALE map[string]AutoscaleConfig
+	// RATE_LIMITS maps task types to their rate limits. Types which are not
+	// listed are not limited.
+	RATE_LIMITS map[string]RateLimitConfig
+	// CONCURRENCY_LIMITS maps task types to how many of them run at once
+	// across all workers. Types which are not listed are not limited.
+	CONCURRENCY_LIMITS map[string]int
+	// UNIQUE_TASKS maps task types to the payload fields which make them unique.
+	// Types which are not listed are not deduplicated.
+	UNIQUE_TASKS map[string]UniqueTaskConfig
+	// SHUTDOWN_GRACE is how long running tasks may take to finish on shutdown.
+	SHUTDOWN_GRACE time.Duration
+	// EXTERNAL_POOLS are the queues of external workers, read from EXTERNAL_WORKERS_FILE.
+	EXTERNAL_POOLS []ExternalPoolConfig
+	// WORKER_LABELS are advertised by the workers of this process and matched
+	// against the affinity of tasks, e.g. disk=large.
+	WORKER_LABELS map[string]string
+	// AFFINITY_FALLBACK is how long a task waits for a worker with its preferred
+	// labels before any worker with its required labels may run it.
+	AFFINITY_FALLBACK time.Duration
+	// AFFINITY_TIMEOUT is how long a task may be unschedulable, i.e. taken only
+	// by workers without its required labels, before it expires. 0 disables it.
+	AFFINITY_TIMEOUT time.Duration
+	// CALLBACK_ATTEMPTS is how many times a task's callback is POSTed before its
+	// delivery fails, CALLBACK_TIMEOUT how long each attempt may take and
+	// CALLBACK_BACKOFF the delay before the first retry, doubled with each one.
+	CALLBACK_ATTEMPTS int
+	CALLBACK_TIMEOUT  time.Duration
+	CALLBACK_BACKOFF  time.Duration
+	// METRICS_ADDR serves /metrics on a separate address, e.g. `:9090`. Empty
+	// serves it on the REST API.
+	METRICS_ADDR string
+	// TRACING_EXPORTER is where spans are sent: `none` (the default), `stdout`
+	// or `otlp`, which is configured by the standard OTEL_EXPORTER_OTLP_* variables.
+	TRACING_EXPORTER string
+	// LOG_LEVEL is the lowest level logged: debug, info, warn or error.
+	LOG_LEVEL slog.Level
+	// LOG_FORMAT is `text` or `json`.
+	LOG_FORMAT string
+	// LOG_OUTPUT are the sinks of the log: `stderr`, `stdout` or file paths. Files
+	// are rotated at LOG_MAX_SIZE_MB, keeping LOG_MAX_BACKUPS rotated files for
+	// LOG_MAX_AGE_DAYS. Empty logs to stderr, or to qugopy.log while the
+	// interactive shell runs.
+	LOG_OUTPUT       []string
+	LOG_MAX_SIZE_MB  int
+	LOG_MAX_BACKUPS  int
+	LOG_MAX_AGE_DAYS int
+	// AUTH_ENABLED requires an API key with the scope of every REST and gRPC
+	// call, except for health checks and metrics.
+	AUTH_ENABLED bool
+	// API_KEYS_FILE holds hashed API keys. It is the key store of local mode,
+	// managed by `qugopy keys`, and its keys are valid in redis mode as well.
+	// Defaults to api_keys.json in the project root.
+	API_KEYS_FILE string
+	// API_KEY is the key standalone workers call the gRPC server with, read
+	// from QUGOPY_API_KEY.
+	API_KEY string
+}
+
+// TenantQuota returns the quota of a tenant, falling back to weight 1 without a cap.
+func (cfg *RootConfig) TenantQuota(tenant string) TenantQuota {
+	quota, exists := cfg.TENANTS[tenant]
+	if !exists {
+		quota = TenantQuota{}
+	}
+	if quota.WEIGHT < 1 {
+		quota.WEIGHT = 1
+	}
+	return quota
+}
+
+// parseTenantQuotas parses `tenant:weight[:max_queued]` entries separated by commas,
+// e.g. `team-a:3:1000,team-b:1`.
+func parseTenantQuotas(raw string) (map[string]TenantQuota, error) {
+	quotas := make(map[string]TenantQuota)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid tenant quota %q", entry)
+		}
+		var quota TenantQuota
+		var err error
+		if quota.WEIGHT, err = strconv.Atoi(parts[1]); err != nil {
+			return nil, fmt.Errorf("invalid weight of tenant %s: %w", parts[0], err)
+		}
+		if len(parts) == 3 {
+			if quota.MAX_QUEUED, err = strconv.Atoi(parts[2]); err != nil {
+				return nil, fmt.Errorf("invalid max queued of tenant %s: %w", parts[0], err)
+			}
+		}
+		quotas[parts[0]] = quota
+	}
+	return quotas, nil
+}
+
+// parseQueues parses `name:runtime[:workers[:aging]]` entries separated by commas,
+// e.g. `reports:python:2:1m,bulk:go:4`.
+func parseQueues(raw string) ([]QueueConfig, error) {
+	var queues []QueueConfig
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 4 {
+			return nil, fmt.Errorf("invalid queue %q", entry)
+		}
+		q := QueueConfig{NAME: parts[0], RUNTIME: parts[1]}
+		if q.RUNTIME != "go" && q.RUNTIME != "python" {
+			return nil, fmt.Errorf("invalid runtime of queue %s: %s", q.NAME, q.RUNTIME)
+		}
+		var err error
+		if len(parts) > 2 {
+			if q.WORKERS, err = strconv.Atoi(parts[2]); err != nil {
+				return nil, fmt.Errorf("invalid workers of queue %s: %w", q.NAME, err)
+			}
+		}
+		if len(parts) > 3 {
+			if q.AGING, err = time.ParseDuration(parts[3]); err != nil {
+				return nil, fmt.Errorf("invalid aging of queue %s: %w", q.NAME, err)
+			}
+		}
+		queues = append(queues, q)
+	}
+	return queues, nil
+}
+
+// ParseLabels parses `key=value` labels separated by commas, e.g. `disk=large,zone=eu`.
+func ParseLabels(raw string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(entry, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("invalid label %q", entry)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// parseRoutes parses `task_type:queue` entries separated by commas, e.g. `send_email:bulk`.
+func parseRoutes(raw string) (map[string]string, error) {
+	routes := make(map[string]string)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		taskType, queueName, ok := strings.Cut(entry, ":")
+		if !ok || taskType == "" || queueName == "" {
+			return nil, fmt.Errorf("invalid route %q", entry)
+		}
+		routes[taskType] = queueName
+	}
+	return routes, nil
+}
+
+// envOr returns an environment variable, or def if it is not set.
+func envOr(name string, def string) string {
+	if raw := os.Getenv(name); raw != "" {
+		return raw
+	}
+	return def
+}
+
+// intEnv parses an integer environment variable, returning def if it is not set.
+func intEnv(name string, def int) (int, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return value, nil
+}
+
+// durationEnv parses a duration environment variable, returning def if it is not set.
+func durationEnv(name string, def time.Duration) (time.Duration, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid %s: %q", name, raw)
+	}
+	return value, nil
+}
+
+// parseAutoscale parses `queue:min:max` entries separated by commas, e.g. `python_queue:1:8`.
+func parseAutoscale(raw string) (map[string]AutoscaleConfig, error) {
+	bounds := make(map[string]AutoscaleConfig)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid autoscale bounds %q", entry)
+		}
+		var b AutoscaleConfig
+		var err error
+		if b.MIN, err = strconv.Atoi(parts[1]); err != nil {
+			return nil, fmt.Errorf("invalid min workers of queue %s: %w", parts[0], err)
+		}
+		if b.MAX, err = strconv.Atoi(parts[2]); err != nil {
+			return nil, fmt.Errorf("invalid max workers of queue %s: %w", parts[0], err)
+		}
+		if b.MIN < 0 || b.MAX < b.MIN || b.MAX < 1 {
+			return nil, fmt.Errorf("invalid autoscale bounds of queue %s: %d-%d", parts[0], b.MIN, b.MAX)
+		}
+		bounds[parts[0]] = b
+	}
+	return bounds, nil
+}
+
+// parseRateLimits parses `task_type:rate[:burst[:email_field]]` entries separated
+// by commas, e.g. `send_email:5:10:recipient_email`. Burst defaults to the rate,
+// rounded up.
+func parseRateLimits(raw string) (map[string]RateLimitConfig, error) {
+	limits := make(map[string]RateLimitConfig)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 4 {
+			return nil, fmt.Errorf("invalid rate limit %q", entry)
+		}
+		var limit RateLimitConfig
+		var err error
+		if limit.RATE, err = strconv.ParseFloat(parts[1], 64); err != nil || limit.RATE <= 0 {
+			return nil, fmt.Errorf("invalid rate of task type %s: %s", parts[0], parts[1])
+		}
+		limit.BURST = int(math.Ceil(limit.RATE))
+		if len(parts) > 2 {
+			if limit.BURST, err = strconv.Atoi(parts[2]); err != nil || limit.BURST < 1 {
+				return nil, fmt.Errorf("invalid burst of task type %s: %s", parts[0], parts[2])
+			}
+		}
+		if len(parts) > 3 {
+			limit.PARTITION_BY = parts[3]
+		}
+		limits[parts[0]] = limit
+	}
+	return limits, nil
+}
+
+// parseConcurrencyLimits parses `task_type:limit` entries separated by commas,
+// e.g. `download_file:2`.
+func parseConcurrencyLimits(raw string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		taskType, value, found := strings.Cut(entry, ":")
+		if !found || taskType == "" {
+			return nil, fmt.Errorf("invalid concurrency limit %q", entry)
+		}
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("invalid concurrency limit of task type %s: %s", taskType, value)
+		}
+		limits[taskType] = limit
+	}
+	return limits, nil
+}
+
+// parseUniqueTasks parses `task_type:field[+field...][:policy[:lock_ttl]]` entries
+// separated by commas, e.g. `download_file:filename:reject:30s`. The policy
+// defaults to reject and the lock is disabled by default.
+func parseUniqueTasks(raw string) (map[string]UniqueTaskConfig, error) {
+	rules := make(map[string]UniqueTaskConfig)
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 4 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid unique task rule %q", entry)
+		}
+		rule := UniqueTaskConfig{FIELDS: strings.Split(parts[1], "+"), ON_CONFLICT: "reject"}
+		if len(parts) > 2 {
+			if parts[2] != "reject" && parts[2] != "replace" {
+				return nil, fmt.Errorf("invalid conflict policy of task type %s: %s", parts[0], parts[2])
+			}
+			rule.ON_CONFLICT = parts[2]
+		}
+		if len(parts) > 3 {
+			var err error
+			if rule.LOCK_TTL, err = time.ParseDuration(parts[3]); err != nil || rule.LOCK_TTL < 0 {
+				return nil, fmt.Errorf("invalid lock ttl of task type %s: %s", parts[0], parts[3])
+			}
+		}
+		rules[parts[0]] = rule
+	}
+	return rules, nil
+}
+
+// parseExternalPools reads the JSON array of external worker pools in file, a
+// path relative to the project root unless absolute.
+func parseExternalPools(file string) ([]ExternalPoolConfig, error) {
+	if file == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(ProjectRootPath, file)
+	}
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read external workers: %w", err)
+	}
+
+	var pools []ExternalPoolConfig
+	if err := json.Unmarshal(raw, &pools); err != nil {
+		return nil, fmt.Errorf("invalid external workers file %s: %w", file, err)
+	}
+	for i := range pools {
+		pool := &pools[i]
+		if pool.NAME == "" || strings.ContainsAny(pool.NAME, ": ") {
+			return nil, fmt.Errorf("invalid name of external pool %q", pool.NAME)
+		}
+		if pool.COMMAND == "" {
+			return nil, fmt.Errorf("external pool %s has no command", pool.NAME)
+		}
+		if len(pool.TASK_TYPES) == 0 {
+			return nil, fmt.Errorf("external pool %s handles no task types", pool.NAME)
+		}
+		if pool.WORKERS < 0 || pool.CONCURRENCY < 0 {
+			return nil, fmt.Errorf("invalid workers or concurrency of external pool %s", pool.NAME)
+		}
+		if pool.CONCURRENCY == 0 {
+			pool.CONCURRENCY = 1
+		}
+		switch pool.TRANSPORT {
+		case "":
+			pool.TRANSPORT = "stdio"
+		case "stdio", "unix":
+		default:
+			return nil, fmt.Errorf("invalid transport of external pool %s: %s", pool.NAME, pool.TRANSPORT)
+		}
+		if pool.DIR == "" {
+			pool.DIR = ProjectRootPath
+		}
+	}
+	return pools, nil
 }
 
 func LoadConfig() (*RootConfig, error) {
@@ -64,13 +513,114 @@ func LoadConfig() (*RootConfig, error) {
 			API_KEY: os.Getenv("BREVO_API_KEY"),
 			EMAIL:   os.Getenv("BREVO_EMAIL"),
 		},
-		MODE:    "local",
-		WORKERS: 2,
+		MODE:       "local",
+		WORKERS:    2,
+		WORKER_CAP: os.Getenv("WORKER_CAP"),
 	}
 
 	if cfg.HOST == "" || cfg.PORT == "" {
 		return nil, errors.New("configuration error")
 	}
+
+	tenants, err := parseTenantQuotas(os.Getenv("TENANT_QUOTAS"))
+	if err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	cfg.TENANTS = tenants
+
+	if cfg.GO_WORKERS, err = intEnv("GO_WORKERS", -1); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	if cfg.PYTHON_WORKERS, err = intEnv("PYTHON_WORKERS", -1); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	if cfg.GO_CONCURRENCY, err = intEnv("GO_CONCURRENCY", 1); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+
+	if cfg.QUEUES, err = parseQueues(os.Getenv("QUEUES")); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	if cfg.ROUTES, err = parseRoutes(os.Getenv("TASK_ROUTES")); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	if cfg.AUTOSCALE, err = parseAutoscale(os.Getenv("AUTOSCALE")); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	if cfg.RATE_LIMITS, err = parseRateLimits(os.Getenv("RATE_LIMITS")); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	if cfg.CONCURRENCY_LIMITS, err = parseConcurrencyLimits(os.Getenv("CONCURRENCY_LIMITS")); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	if cfg.UNIQUE_TASKS, err = parseUniqueTasks(os.Getenv("UNIQUE_TASKS")); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	if cfg.SHUTDOWN_GRACE, err = durationEnv("SHUTDOWN_GRACE", 30*time.Second); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	if cfg.EXTERNAL_POOLS, err = parseExternalPools(os.Getenv("EXTERNAL_WORKERS_FILE")); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	if cfg.WORKER_LABELS, err = ParseLabels(os.Getenv("WORKER_LABELS")); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	if cfg.AFFINITY_FALLBACK, err = durationEnv("AFFINITY_FALLBACK", 30*time.Second); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	if cfg.AFFINITY_TIMEOUT, err = durationEnv("AFFINITY_TIMEOUT", 10*time.Minute); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	cfg.METRICS_ADDR = os.Getenv("METRICS_ADDR")
+	if cfg.CALLBACK_ATTEMPTS, err = intEnv("CALLBACK_ATTEMPTS", 5); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	if cfg.CALLBACK_TIMEOUT, err = durationEnv("CALLBACK_TIMEOUT", 10*time.Second); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	if cfg.CALLBACK_BACKOFF, err = durationEnv("CALLBACK_BACKOFF", time.Second); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	cfg.TRACING_EXPORTER = strings.ToLower(os.Getenv("TRACING_EXPORTER"))
+	switch cfg.TRACING_EXPORTER {
+	case "":
+		cfg.TRACING_EXPORTER = "none"
+	case "none", "stdout", "otlp":
+	default:
+		return nil, fmt.Errorf("configuration error: invalid TRACING_EXPORTER %q, must be none, stdout or otlp", cfg.TRACING_EXPORTER)
+	}
+
+	if err := cfg.LOG_LEVEL.UnmarshalText([]byte(envOr("LOG_LEVEL", "info"))); err != nil {
+		return nil, fmt.Errorf("configuration error: invalid LOG_LEVEL: %w", err)
+	}
+	cfg.LOG_FORMAT = strings.ToLower(envOr("LOG_FORMAT", "text"))
+	if cfg.LOG_FORMAT != "text" && cfg.LOG_FORMAT != "json" {
+		return nil, fmt.Errorf("configuration error: invalid LOG_FORMAT %q, must be text or json", cfg.LOG_FORMAT)
+	}
+	for _, output := range strings.Split(os.Getenv("LOG_OUTPUT"), ",") {
+		if output = strings.TrimSpace(output); output != "" {
+			cfg.LOG_OUTPUT = append(cfg.LOG_OUTPUT, output)
+		}
+	}
+	if cfg.LOG_MAX_SIZE_MB, err = intEnv("LOG_MAX_SIZE_MB", 100); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	if cfg.LOG_MAX_BACKUPS, err = intEnv("LOG_MAX_BACKUPS", 5); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+	if cfg.LOG_MAX_AGE_DAYS, err = intEnv("LOG_MAX_AGE_DAYS", 0); err != nil {
+		return nil, fmt.Errorf("configuration error: %w", err)
+	}
+
+	if cfg.AUTH_ENABLED, err = strconv.ParseBool(envOr("AUTH_ENABLED", "false")); err != nil {
+		return nil, fmt.Errorf("configuration error: invalid AUTH_ENABLED: %w", err)
+	}
+	cfg.API_KEYS_FILE = envOr("API_KEYS_FILE", "api_keys.json")
+	if !filepath.IsAbs(cfg.API_KEYS_FILE) {
+		cfg.API_KEYS_FILE = filepath.Join(ProjectRootPath, cfg.API_KEYS_FILE)
+	}
+	cfg.API_KEY = os.Getenv("QUGOPY_API_KEY")
+
 	AppConfig = cfg
 	return cfg, nil
 }
@@ -1,7 +1,12 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func (cfg *RootConfig) isValid() bool {
@@ -20,3 +25,149 @@ func TestLoadConfig(t *testing.T) {
 		t.Error("Config is invalid - required fields are empty")
 	}
 }
+
+func TestParseTenantQuotas(t *testing.T) {
+	quotas, err := parseTenantQuotas("team-a:3:1000, team-b:1")
+	assert.NoError(t, err)
+	assert.Equal(t, TenantQuota{WEIGHT: 3, MAX_QUEUED: 1000}, quotas["team-a"])
+	assert.Equal(t, TenantQuota{WEIGHT: 1}, quotas["team-b"])
+
+	cfg := &RootConfig{TENANTS: quotas}
+	assert.Equal(t, 1, cfg.TenantQuota("unknown").WEIGHT, "unknown tenants must default to weight 1")
+
+	_, err = parseTenantQuotas("team-a")
+	assert.Error(t, err)
+	_, err = parseTenantQuotas("team-a:x")
+	assert.Error(t, err)
+}
+
+func TestParseQueues(t *testing.T) {
+	queues, err := parseQueues("reports:python:2:1m, bulk:go")
+	assert.NoError(t, err)
+	assert.Equal(t, []QueueConfig{
+		{NAME: "reports", RUNTIME: "python", WORKERS: 2, AGING: time.Minute},
+		{NAME: "bulk", RUNTIME: "go"},
+	}, queues)
+
+	_, err = parseQueues("reports:rust")
+	assert.Error(t, err)
+	_, err = parseQueues("reports:go:x")
+	assert.Error(t, err)
+
+	routes, err := parseRoutes("send_email:bulk")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"send_email": "bulk"}, routes)
+	_, err = parseRoutes("send_email")
+	assert.Error(t, err)
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels("disk=large, zone = eu")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"disk": "large", "zone": "eu"}, labels)
+
+	for _, raw := range []string{"disk", "disk=", "=large"} {
+		_, err = ParseLabels(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestParseAutoscale(t *testing.T) {
+	bounds, err := parseAutoscale("python_queue:1:8, go_queue:0:32")
+	assert.NoError(t, err)
+	assert.Equal(t, AutoscaleConfig{MIN: 1, MAX: 8}, bounds["python_queue"])
+	assert.Equal(t, AutoscaleConfig{MIN: 0, MAX: 32}, bounds["go_queue"])
+
+	for _, raw := range []string{"python_queue:1", "python_queue:4:2", "python_queue:0:0", "python_queue:a:2"} {
+		_, err = parseAutoscale(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := parseRateLimits("send_email:5:10:recipient_email, process_image:0.5")
+	assert.NoError(t, err)
+	assert.Equal(t, RateLimitConfig{RATE: 5, BURST: 10, PARTITION_BY: "recipient_email"}, limits["send_email"])
+	assert.Equal(t, RateLimitConfig{RATE: 0.5, BURST: 1}, limits["process_image"])
+
+	limits, err = parseRateLimits("")
+	assert.NoError(t, err)
+	assert.Empty(t, limits, "Task types should not be limited by default")
+
+	for _, raw := range []string{"send_email", "send_email:fast", "send_email:0", "send_email:5:0", "send_email:5:10:to:cc"} {
+		_, err = parseRateLimits(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestParseConcurrencyLimits(t *testing.T) {
+	limits, err := parseConcurrencyLimits("download_file:2, process_image:1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"download_file": 2, "process_image": 1}, limits)
+
+	limits, err = parseConcurrencyLimits("")
+	assert.NoError(t, err)
+	assert.Empty(t, limits, "Task types should not be limited by default")
+
+	for _, raw := range []string{"download_file", "download_file:0", "download_file:two", ":2"} {
+		_, err = parseConcurrencyLimits(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestParseUniqueTasks(t *testing.T) {
+	rules, err := parseUniqueTasks("download_file:filename:reject:30s, send_email:recipient_email+subject")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]UniqueTaskConfig{
+		"download_file": {FIELDS: []string{"filename"}, ON_CONFLICT: "reject", LOCK_TTL: 30 * time.Second},
+		"send_email":    {FIELDS: []string{"recipient_email", "subject"}, ON_CONFLICT: "reject"},
+	}, rules)
+
+	rules, err = parseUniqueTasks("")
+	assert.NoError(t, err)
+	assert.Empty(t, rules, "Tasks should not be unique by default")
+
+	for _, raw := range []string{"download_file", "download_file:", "download_file:filename:skip", "download_file:filename:reject:soon", ":filename"} {
+		_, err = parseUniqueTasks(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestParseExternalPools(t *testing.T) {
+	pools, err := parseExternalPools("")
+	assert.NoError(t, err)
+	assert.Empty(t, pools)
+
+	file := filepath.Join(t.TempDir(), "workers.json")
+	write := func(content string) {
+		assert.NoError(t, os.WriteFile(file, []byte(content), 0o644))
+	}
+
+	write(`[{"name": "videos", "command": "node", "args": ["worker.js"], "task_types": ["resize_video"], "workers": 2}]`)
+	pools, err = parseExternalPools(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []ExternalPoolConfig{{
+		NAME:        "videos",
+		COMMAND:     "node",
+		ARGS:        []string{"worker.js"},
+		DIR:         ProjectRootPath,
+		TASK_TYPES:  []string{"resize_video"},
+		WORKERS:     2,
+		CONCURRENCY: 1,
+		TRANSPORT:   "stdio",
+	}}, pools)
+
+	for _, content := range []string{
+		`{"name": "videos"}`,
+		`[{"name": "videos", "task_types": ["resize_video"]}]`,
+		`[{"name": "videos", "command": "node"}]`,
+		`[{"name": "a:b", "command": "node", "task_types": ["resize_video"]}]`,
+		`[{"name": "videos", "command": "node", "task_types": ["resize_video"], "transport": "tcp"}]`,
+	} {
+		write(content)
+		_, err = parseExternalPools(file)
+		assert.Error(t, err, content)
+	}
+	_, err = parseExternalPools(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,34 @@
+// Example external worker speaking the qugopy NDJSON protocol over stdin/stdout.
+// Register it in the file given by EXTERNAL_WORKERS_FILE:
+//
+//   [{"name": "node", "command": "node", "args": ["examples/external/worker.js"], "task_types": ["echo"], "workers": 1}]
+//
+// Logs must go to stderr, stdout carries the results.
+const readline = require("node:readline");
+
+const handlers = {
+  async echo(payload) {
+    console.error(`[${process.env.WORKER_ID}] echo:`, JSON.stringify(payload));
+  },
+};
+
+const rl = readline.createInterface({ input: process.stdin });
+
+rl.on("line", async (line) => {
+  const req = JSON.parse(line);
+  if (req.op !== "run") {
+    return; // e.g. "cancel"
+  }
+  const result = { id: req.id };
+  try {
+    const handler = handlers[req.type];
+    if (!handler) {
+      throw new Error(`unsupported task type: ${req.type}`);
+    }
+    await handler(req.payload);
+  } catch (err) {
+    result.error = String(err.message || err);
+  }
+  process.stdout.write(JSON.stringify(result) + "\n");
+});
+// qugopy closes stdin to stop the worker, node exits once running tasks are answered
@@ -125,6 +125,9 @@ func (QueueType) EnumDescriptor() ([]byte, []int) {
 type GetTaskRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	WorkerType    WorkerType             `protobuf:"varint,1,opt,name=worker_type,json=workerType,proto3,enum=task.WorkerType" json:"worker_type,omitempty"`
+	Queue         string                 `protobuf:"bytes,2,opt,name=queue,proto3" json:"queue,omitempty"`
+	WorkerId      string                 `protobuf:"bytes,3,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	Labels        map[string]string      `protobuf:"bytes,4,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -166,18 +169,696 @@ func (x *GetTaskRequest) GetWorkerType() WorkerType {
 	return WorkerType_WORKER_TYPE_UNSPECIFIED
 }
 
+func (x *GetTaskRequest) GetQueue() string {
+	if x != nil {
+		return x.Queue
+	}
+	return ""
+}
+
+func (x *GetTaskRequest) GetWorkerId() string {
+	if x != nil {
+		return x.WorkerId
+	}
+	return ""
+}
+
+func (x *GetTaskRequest) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+type CompleteTaskRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	WorkerId      string                 `protobuf:"bytes,2,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	Success       bool                   `protobuf:"varint,3,opt,name=success,proto3" json:"success,omitempty"`
+	Error         string                 `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	Requeue       bool                   `protobuf:"varint,5,opt,name=requeue,proto3" json:"requeue,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CompleteTaskRequest) Reset() {
+	*x = CompleteTaskRequest{}
+	mi := &file_task_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CompleteTaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompleteTaskRequest) ProtoMessage() {}
+
+func (x *CompleteTaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_task_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompleteTaskRequest.ProtoReflect.Descriptor instead.
+func (*CompleteTaskRequest) Descriptor() ([]byte, []int) {
+	return file_task_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CompleteTaskRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *CompleteTaskRequest) GetWorkerId() string {
+	if x != nil {
+		return x.WorkerId
+	}
+	return ""
+}
+
+func (x *CompleteTaskRequest) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *CompleteTaskRequest) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *CompleteTaskRequest) GetRequeue() bool {
+	if x != nil {
+		return x.Requeue
+	}
+	return false
+}
+
+type WorkerHeartbeat struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	WorkerId      string                 `protobuf:"bytes,1,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	Runtime       string                 `protobuf:"bytes,2,opt,name=runtime,proto3" json:"runtime,omitempty"`
+	Queue         string                 `protobuf:"bytes,3,opt,name=queue,proto3" json:"queue,omitempty"`
+	Host          string                 `protobuf:"bytes,4,opt,name=host,proto3" json:"host,omitempty"`
+	Pid           int32                  `protobuf:"varint,5,opt,name=pid,proto3" json:"pid,omitempty"`
+	CurrentTasks  []string               `protobuf:"bytes,6,rep,name=current_tasks,json=currentTasks,proto3" json:"current_tasks,omitempty"`
+	Processed     int64                  `protobuf:"varint,7,opt,name=processed,proto3" json:"processed,omitempty"`
+	Failed        int64                  `protobuf:"varint,8,opt,name=failed,proto3" json:"failed,omitempty"`
+	StartedAt     *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	Stopped       bool                   `protobuf:"varint,10,opt,name=stopped,proto3" json:"stopped,omitempty"`
+	Labels        map[string]string      `protobuf:"bytes,11,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WorkerHeartbeat) Reset() {
+	*x = WorkerHeartbeat{}
+	mi := &file_task_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WorkerHeartbeat) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WorkerHeartbeat) ProtoMessage() {}
+
+func (x *WorkerHeartbeat) ProtoReflect() protoreflect.Message {
+	mi := &file_task_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WorkerHeartbeat.ProtoReflect.Descriptor instead.
+func (*WorkerHeartbeat) Descriptor() ([]byte, []int) {
+	return file_task_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *WorkerHeartbeat) GetWorkerId() string {
+	if x != nil {
+		return x.WorkerId
+	}
+	return ""
+}
+
+func (x *WorkerHeartbeat) GetRuntime() string {
+	if x != nil {
+		return x.Runtime
+	}
+	return ""
+}
+
+func (x *WorkerHeartbeat) GetQueue() string {
+	if x != nil {
+		return x.Queue
+	}
+	return ""
+}
+
+func (x *WorkerHeartbeat) GetHost() string {
+	if x != nil {
+		return x.Host
+	}
+	return ""
+}
+
+func (x *WorkerHeartbeat) GetPid() int32 {
+	if x != nil {
+		return x.Pid
+	}
+	return 0
+}
+
+func (x *WorkerHeartbeat) GetCurrentTasks() []string {
+	if x != nil {
+		return x.CurrentTasks
+	}
+	return nil
+}
+
+func (x *WorkerHeartbeat) GetProcessed() int64 {
+	if x != nil {
+		return x.Processed
+	}
+	return 0
+}
+
+func (x *WorkerHeartbeat) GetFailed() int64 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
+}
+
+func (x *WorkerHeartbeat) GetStartedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartedAt
+	}
+	return nil
+}
+
+func (x *WorkerHeartbeat) GetStopped() bool {
+	if x != nil {
+		return x.Stopped
+	}
+	return false
+}
+
+func (x *WorkerHeartbeat) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+type WorkerMetrics struct {
+	state         protoimpl.MessageState      `protogen:"open.v1"`
+	WorkerId      string                      `protobuf:"bytes,1,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	Queue         string                      `protobuf:"bytes,2,opt,name=queue,proto3" json:"queue,omitempty"`
+	Tasks         map[string]*TaskTypeMetrics `protobuf:"bytes,3,rep,name=tasks,proto3" json:"tasks,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	CpuSeconds    float64                     `protobuf:"fixed64,4,opt,name=cpu_seconds,json=cpuSeconds,proto3" json:"cpu_seconds,omitempty"`
+	MaxRssBytes   int64                       `protobuf:"varint,5,opt,name=max_rss_bytes,json=maxRssBytes,proto3" json:"max_rss_bytes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WorkerMetrics) Reset() {
+	*x = WorkerMetrics{}
+	mi := &file_task_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WorkerMetrics) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WorkerMetrics) ProtoMessage() {}
+
+func (x *WorkerMetrics) ProtoReflect() protoreflect.Message {
+	mi := &file_task_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WorkerMetrics.ProtoReflect.Descriptor instead.
+func (*WorkerMetrics) Descriptor() ([]byte, []int) {
+	return file_task_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *WorkerMetrics) GetWorkerId() string {
+	if x != nil {
+		return x.WorkerId
+	}
+	return ""
+}
+
+func (x *WorkerMetrics) GetQueue() string {
+	if x != nil {
+		return x.Queue
+	}
+	return ""
+}
+
+func (x *WorkerMetrics) GetTasks() map[string]*TaskTypeMetrics {
+	if x != nil {
+		return x.Tasks
+	}
+	return nil
+}
+
+func (x *WorkerMetrics) GetCpuSeconds() float64 {
+	if x != nil {
+		return x.CpuSeconds
+	}
+	return 0
+}
+
+func (x *WorkerMetrics) GetMaxRssBytes() int64 {
+	if x != nil {
+		return x.MaxRssBytes
+	}
+	return 0
+}
+
+type TaskTypeMetrics struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Succeeded     int64                  `protobuf:"varint,1,opt,name=succeeded,proto3" json:"succeeded,omitempty"`
+	Failed        int64                  `protobuf:"varint,2,opt,name=failed,proto3" json:"failed,omitempty"`
+	RunSeconds    float64                `protobuf:"fixed64,3,opt,name=run_seconds,json=runSeconds,proto3" json:"run_seconds,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TaskTypeMetrics) Reset() {
+	*x = TaskTypeMetrics{}
+	mi := &file_task_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskTypeMetrics) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskTypeMetrics) ProtoMessage() {}
+
+func (x *TaskTypeMetrics) ProtoReflect() protoreflect.Message {
+	mi := &file_task_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskTypeMetrics.ProtoReflect.Descriptor instead.
+func (*TaskTypeMetrics) Descriptor() ([]byte, []int) {
+	return file_task_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *TaskTypeMetrics) GetSucceeded() int64 {
+	if x != nil {
+		return x.Succeeded
+	}
+	return 0
+}
+
+func (x *TaskTypeMetrics) GetFailed() int64 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
+}
+
+func (x *TaskTypeMetrics) GetRunSeconds() float64 {
+	if x != nil {
+		return x.RunSeconds
+	}
+	return 0
+}
+
+type EnqueueResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnqueueResponse) Reset() {
+	*x = EnqueueResponse{}
+	mi := &file_task_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnqueueResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnqueueResponse) ProtoMessage() {}
+
+func (x *EnqueueResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_task_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnqueueResponse.ProtoReflect.Descriptor instead.
+func (*EnqueueResponse) Descriptor() ([]byte, []int) {
+	return file_task_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *EnqueueResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type EnqueueBatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Tasks         []*Task                `protobuf:"bytes,1,rep,name=tasks,proto3" json:"tasks,omitempty"`
+	Atomic        bool                   `protobuf:"varint,2,opt,name=atomic,proto3" json:"atomic,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnqueueBatchRequest) Reset() {
+	*x = EnqueueBatchRequest{}
+	mi := &file_task_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnqueueBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnqueueBatchRequest) ProtoMessage() {}
+
+func (x *EnqueueBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_task_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnqueueBatchRequest.ProtoReflect.Descriptor instead.
+func (*EnqueueBatchRequest) Descriptor() ([]byte, []int) {
+	return file_task_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *EnqueueBatchRequest) GetTasks() []*Task {
+	if x != nil {
+		return x.Tasks
+	}
+	return nil
+}
+
+func (x *EnqueueBatchRequest) GetAtomic() bool {
+	if x != nil {
+		return x.Atomic
+	}
+	return false
+}
+
+type EnqueueResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnqueueResult) Reset() {
+	*x = EnqueueResult{}
+	mi := &file_task_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnqueueResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnqueueResult) ProtoMessage() {}
+
+func (x *EnqueueResult) ProtoReflect() protoreflect.Message {
+	mi := &file_task_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnqueueResult.ProtoReflect.Descriptor instead.
+func (*EnqueueResult) Descriptor() ([]byte, []int) {
+	return file_task_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *EnqueueResult) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *EnqueueResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type EnqueueBatchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*EnqueueResult       `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnqueueBatchResponse) Reset() {
+	*x = EnqueueBatchResponse{}
+	mi := &file_task_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnqueueBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnqueueBatchResponse) ProtoMessage() {}
+
+func (x *EnqueueBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_task_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnqueueBatchResponse.ProtoReflect.Descriptor instead.
+func (*EnqueueBatchResponse) Descriptor() ([]byte, []int) {
+	return file_task_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *EnqueueBatchResponse) GetResults() []*EnqueueResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type TaskRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TaskRequest) Reset() {
+	*x = TaskRequest{}
+	mi := &file_task_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskRequest) ProtoMessage() {}
+
+func (x *TaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_task_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskRequest.ProtoReflect.Descriptor instead.
+func (*TaskRequest) Descriptor() ([]byte, []int) {
+	return file_task_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *TaskRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type TaskStatus struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Queue         string                 `protobuf:"bytes,2,opt,name=queue,proto3" json:"queue,omitempty"`
+	State         string                 `protobuf:"bytes,3,opt,name=state,proto3" json:"state,omitempty"`
+	WorkerId      string                 `protobuf:"bytes,4,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	Error         string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	UpdatedAt     *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TaskStatus) Reset() {
+	*x = TaskStatus{}
+	mi := &file_task_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskStatus) ProtoMessage() {}
+
+func (x *TaskStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_task_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskStatus.ProtoReflect.Descriptor instead.
+func (*TaskStatus) Descriptor() ([]byte, []int) {
+	return file_task_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *TaskStatus) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *TaskStatus) GetQueue() string {
+	if x != nil {
+		return x.Queue
+	}
+	return ""
+}
+
+func (x *TaskStatus) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *TaskStatus) GetWorkerId() string {
+	if x != nil {
+		return x.WorkerId
+	}
+	return ""
+}
+
+func (x *TaskStatus) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *TaskStatus) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
 type IntTask struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 	Task          *Task                  `protobuf:"bytes,2,opt,name=task,proto3" json:"task,omitempty"`
 	QueueType     QueueType              `protobuf:"varint,3,opt,name=queue_type,json=queueType,proto3,enum=task.QueueType" json:"queue_type,omitempty"`
+	TraceContext  map[string]string      `protobuf:"bytes,4,rep,name=trace_context,json=traceContext,proto3" json:"trace_context,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *IntTask) Reset() {
 	*x = IntTask{}
-	mi := &file_task_proto_msgTypes[1]
+	mi := &file_task_proto_msgTypes[11]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -189,7 +870,7 @@ func (x *IntTask) String() string {
 func (*IntTask) ProtoMessage() {}
 
 func (x *IntTask) ProtoReflect() protoreflect.Message {
-	mi := &file_task_proto_msgTypes[1]
+	mi := &file_task_proto_msgTypes[11]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -202,7 +883,7 @@ func (x *IntTask) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use IntTask.ProtoReflect.Descriptor instead.
 func (*IntTask) Descriptor() ([]byte, []int) {
-	return file_task_proto_rawDescGZIP(), []int{1}
+	return file_task_proto_rawDescGZIP(), []int{11}
 }
 
 func (x *IntTask) GetId() string {
@@ -226,6 +907,13 @@ func (x *IntTask) GetQueueType() QueueType {
 	return QueueType_QUEUE_TYPE_UNSPECIFIED
 }
 
+func (x *IntTask) GetTraceContext() map[string]string {
+	if x != nil {
+		return x.TraceContext
+	}
+	return nil
+}
+
 type Task struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
@@ -233,13 +921,16 @@ type Task struct {
 	Priority      uint32                 `protobuf:"varint,3,opt,name=priority,proto3" json:"priority,omitempty"`
 	Deadline      *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=deadline,proto3" json:"deadline,omitempty"`
 	Recurring     *wrapperspb.BoolValue  `protobuf:"bytes,5,opt,name=recurring,proto3" json:"recurring,omitempty"`
+	Tenant        string                 `protobuf:"bytes,6,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	Affinity      *Affinity              `protobuf:"bytes,7,opt,name=affinity,proto3" json:"affinity,omitempty"`
+	Callback      *Callback              `protobuf:"bytes,8,opt,name=callback,proto3" json:"callback,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Task) Reset() {
 	*x = Task{}
-	mi := &file_task_proto_msgTypes[2]
+	mi := &file_task_proto_msgTypes[12]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -251,7 +942,7 @@ func (x *Task) String() string {
 func (*Task) ProtoMessage() {}
 
 func (x *Task) ProtoReflect() protoreflect.Message {
-	mi := &file_task_proto_msgTypes[2]
+	mi := &file_task_proto_msgTypes[12]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -264,7 +955,7 @@ func (x *Task) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Task.ProtoReflect.Descriptor instead.
 func (*Task) Descriptor() ([]byte, []int) {
-	return file_task_proto_rawDescGZIP(), []int{2}
+	return file_task_proto_rawDescGZIP(), []int{12}
 }
 
 func (x *Task) GetType() string {
@@ -302,27 +993,247 @@ func (x *Task) GetRecurring() *wrapperspb.BoolValue {
 	return nil
 }
 
+func (x *Task) GetTenant() string {
+	if x != nil {
+		return x.Tenant
+	}
+	return ""
+}
+
+func (x *Task) GetAffinity() *Affinity {
+	if x != nil {
+		return x.Affinity
+	}
+	return nil
+}
+
+func (x *Task) GetCallback() *Callback {
+	if x != nil {
+		return x.Callback
+	}
+	return nil
+}
+
+type Affinity struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Required      map[string]string      `protobuf:"bytes,1,rep,name=required,proto3" json:"required,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Preferred     map[string]string      `protobuf:"bytes,2,rep,name=preferred,proto3" json:"preferred,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Affinity) Reset() {
+	*x = Affinity{}
+	mi := &file_task_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Affinity) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Affinity) ProtoMessage() {}
+
+func (x *Affinity) ProtoReflect() protoreflect.Message {
+	mi := &file_task_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Affinity.ProtoReflect.Descriptor instead.
+func (*Affinity) Descriptor() ([]byte, []int) {
+	return file_task_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *Affinity) GetRequired() map[string]string {
+	if x != nil {
+		return x.Required
+	}
+	return nil
+}
+
+func (x *Affinity) GetPreferred() map[string]string {
+	if x != nil {
+		return x.Preferred
+	}
+	return nil
+}
+
+type Callback struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Url           string                 `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	Events        []string               `protobuf:"bytes,2,rep,name=events,proto3" json:"events,omitempty"`
+	Secret        string                 `protobuf:"bytes,3,opt,name=secret,proto3" json:"secret,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Callback) Reset() {
+	*x = Callback{}
+	mi := &file_task_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Callback) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Callback) ProtoMessage() {}
+
+func (x *Callback) ProtoReflect() protoreflect.Message {
+	mi := &file_task_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Callback.ProtoReflect.Descriptor instead.
+func (*Callback) Descriptor() ([]byte, []int) {
+	return file_task_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *Callback) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *Callback) GetEvents() []string {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+func (x *Callback) GetSecret() string {
+	if x != nil {
+		return x.Secret
+	}
+	return ""
+}
+
 var File_task_proto protoreflect.FileDescriptor
 
 const file_task_proto_rawDesc = "" +
 	"\n" +
 	"\n" +
-	"task.proto\x12\x04task\x1a\x1fgoogle/protobuf/timestamp.proto\x1a\x1egoogle/protobuf/wrappers.proto\x1a\x1bgoogle/protobuf/empty.proto\"C\n" +
+	"task.proto\x12\x04task\x1a\x1fgoogle/protobuf/timestamp.proto\x1a\x1egoogle/protobuf/wrappers.proto\x1a\x1bgoogle/protobuf/empty.proto\"\xeb\x01\n" +
 	"\x0eGetTaskRequest\x121\n" +
 	"\vworker_type\x18\x01 \x01(\x0e2\x10.task.WorkerTypeR\n" +
-	"workerType\"i\n" +
+	"workerType\x12\x14\n" +
+	"\x05queue\x18\x02 \x01(\tR\x05queue\x12\x1b\n" +
+	"\tworker_id\x18\x03 \x01(\tR\bworkerId\x128\n" +
+	"\x06labels\x18\x04 \x03(\v2 .task.GetTaskRequest.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x8c\x01\n" +
+	"\x13CompleteTaskRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1b\n" +
+	"\tworker_id\x18\x02 \x01(\tR\bworkerId\x12\x18\n" +
+	"\asuccess\x18\x03 \x01(\bR\asuccess\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\x12\x18\n" +
+	"\arequeue\x18\x05 \x01(\bR\arequeue\"\xaa\x03\n" +
+	"\x0fWorkerHeartbeat\x12\x1b\n" +
+	"\tworker_id\x18\x01 \x01(\tR\bworkerId\x12\x18\n" +
+	"\aruntime\x18\x02 \x01(\tR\aruntime\x12\x14\n" +
+	"\x05queue\x18\x03 \x01(\tR\x05queue\x12\x12\n" +
+	"\x04host\x18\x04 \x01(\tR\x04host\x12\x10\n" +
+	"\x03pid\x18\x05 \x01(\x05R\x03pid\x12#\n" +
+	"\rcurrent_tasks\x18\x06 \x03(\tR\fcurrentTasks\x12\x1c\n" +
+	"\tprocessed\x18\a \x01(\x03R\tprocessed\x12\x16\n" +
+	"\x06failed\x18\b \x01(\x03R\x06failed\x129\n" +
+	"\n" +
+	"started_at\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\tstartedAt\x12\x18\n" +
+	"\astopped\x18\n" +
+	" \x01(\bR\astopped\x129\n" +
+	"\x06labels\x18\v \x03(\v2!.task.WorkerHeartbeat.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x8e\x02\n" +
+	"\rWorkerMetrics\x12\x1b\n" +
+	"\tworker_id\x18\x01 \x01(\tR\bworkerId\x12\x14\n" +
+	"\x05queue\x18\x02 \x01(\tR\x05queue\x124\n" +
+	"\x05tasks\x18\x03 \x03(\v2\x1e.task.WorkerMetrics.TasksEntryR\x05tasks\x12\x1f\n" +
+	"\vcpu_seconds\x18\x04 \x01(\x01R\n" +
+	"cpuSeconds\x12\"\n" +
+	"\rmax_rss_bytes\x18\x05 \x01(\x03R\vmaxRssBytes\x1aO\n" +
+	"\n" +
+	"TasksEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12+\n" +
+	"\x05value\x18\x02 \x01(\v2\x15.task.TaskTypeMetricsR\x05value:\x028\x01\"h\n" +
+	"\x0fTaskTypeMetrics\x12\x1c\n" +
+	"\tsucceeded\x18\x01 \x01(\x03R\tsucceeded\x12\x16\n" +
+	"\x06failed\x18\x02 \x01(\x03R\x06failed\x12\x1f\n" +
+	"\vrun_seconds\x18\x03 \x01(\x01R\n" +
+	"runSeconds\"!\n" +
+	"\x0fEnqueueResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"O\n" +
+	"\x13EnqueueBatchRequest\x12 \n" +
+	"\x05tasks\x18\x01 \x03(\v2\n" +
+	".task.TaskR\x05tasks\x12\x16\n" +
+	"\x06atomic\x18\x02 \x01(\bR\x06atomic\"5\n" +
+	"\rEnqueueResult\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\"E\n" +
+	"\x14EnqueueBatchResponse\x12-\n" +
+	"\aresults\x18\x01 \x03(\v2\x13.task.EnqueueResultR\aresults\"\x1d\n" +
+	"\vTaskRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"\xb6\x01\n" +
+	"\n" +
+	"TaskStatus\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05queue\x18\x02 \x01(\tR\x05queue\x12\x14\n" +
+	"\x05state\x18\x03 \x01(\tR\x05state\x12\x1b\n" +
+	"\tworker_id\x18\x04 \x01(\tR\bworkerId\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\x129\n" +
+	"\n" +
+	"updated_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\"\xf0\x01\n" +
 	"\aIntTask\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1e\n" +
 	"\x04task\x18\x02 \x01(\v2\n" +
 	".task.TaskR\x04task\x12.\n" +
 	"\n" +
-	"queue_type\x18\x03 \x01(\x0e2\x0f.task.QueueTypeR\tqueueType\"\xc2\x01\n" +
+	"queue_type\x18\x03 \x01(\x0e2\x0f.task.QueueTypeR\tqueueType\x12D\n" +
+	"\rtrace_context\x18\x04 \x03(\v2\x1f.task.IntTask.TraceContextEntryR\ftraceContext\x1a?\n" +
+	"\x11TraceContextEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\xb2\x02\n" +
 	"\x04Task\x12\x12\n" +
 	"\x04type\x18\x01 \x01(\tR\x04type\x12\x18\n" +
 	"\apayload\x18\x02 \x01(\fR\apayload\x12\x1a\n" +
 	"\bpriority\x18\x03 \x01(\rR\bpriority\x126\n" +
 	"\bdeadline\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\bdeadline\x128\n" +
-	"\trecurring\x18\x05 \x01(\v2\x1a.google.protobuf.BoolValueR\trecurring*U\n" +
+	"\trecurring\x18\x05 \x01(\v2\x1a.google.protobuf.BoolValueR\trecurring\x12\x16\n" +
+	"\x06tenant\x18\x06 \x01(\tR\x06tenant\x12*\n" +
+	"\baffinity\x18\a \x01(\v2\x0e.task.AffinityR\baffinity\x12*\n" +
+	"\bcallback\x18\b \x01(\v2\x0e.task.CallbackR\bcallback\"\xfc\x01\n" +
+	"\bAffinity\x128\n" +
+	"\brequired\x18\x01 \x03(\v2\x1c.task.Affinity.RequiredEntryR\brequired\x12;\n" +
+	"\tpreferred\x18\x02 \x03(\v2\x1d.task.Affinity.PreferredEntryR\tpreferred\x1a;\n" +
+	"\rRequiredEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a<\n" +
+	"\x0ePreferredEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"L\n" +
+	"\bCallback\x12\x10\n" +
+	"\x03url\x18\x01 \x01(\tR\x03url\x12\x16\n" +
+	"\x06events\x18\x02 \x03(\tR\x06events\x12\x16\n" +
+	"\x06secret\x18\x03 \x01(\tR\x06secret*U\n" +
 	"\n" +
 	"WorkerType\x12\x1b\n" +
 	"\x17WORKER_TYPE_UNSPECIFIED\x10\x00\x12\x12\n" +
@@ -331,11 +1242,21 @@ const file_task_proto_rawDesc = "" +
 	"\tQueueType\x12\x1a\n" +
 	"\x16QUEUE_TYPE_UNSPECIFIED\x10\x00\x12\x11\n" +
 	"\rQUEUE_TYPE_GO\x10\x01\x12\x15\n" +
-	"\x11QUEUE_TYPE_PYTHON\x10\x022\xa9\x01\n" +
+	"\x11QUEUE_TYPE_PYTHON\x10\x022\xf8\x04\n" +
 	"\vTaskService\x12.\n" +
 	"\aGetTask\x12\x14.task.GetTaskRequest\x1a\r.task.IntTask\x122\n" +
 	"\tGetGoTask\x12\x16.google.protobuf.Empty\x1a\r.task.IntTask\x126\n" +
-	"\rGetPythonTask\x12\x16.google.protobuf.Empty\x1a\r.task.IntTaskB*Z(github.com/Yulian302/qugopy/proto;taskpbb\x06proto3"
+	"\rGetPythonTask\x12\x16.google.protobuf.Empty\x1a\r.task.IntTask\x12A\n" +
+	"\fCompleteTask\x12\x19.task.CompleteTaskRequest\x1a\x16.google.protobuf.Empty\x12:\n" +
+	"\tHeartbeat\x12\x15.task.WorkerHeartbeat\x1a\x16.google.protobuf.Empty\x12<\n" +
+	"\rReportMetrics\x12\x13.task.WorkerMetrics\x1a\x16.google.protobuf.Empty\x12,\n" +
+	"\aEnqueue\x12\n" +
+	".task.Task\x1a\x15.task.EnqueueResponse\x12E\n" +
+	"\fEnqueueBatch\x12\x19.task.EnqueueBatchRequest\x1a\x1a.task.EnqueueBatchResponse\x124\n" +
+	"\rGetTaskStatus\x12\x11.task.TaskRequest\x1a\x10.task.TaskStatus\x121\n" +
+	"\n" +
+	"CancelTask\x12\x11.task.TaskRequest\x1a\x10.task.TaskStatus\x122\n" +
+	"\tWatchTask\x12\x11.task.TaskRequest\x1a\x10.task.TaskStatus0\x01B*Z(github.com/Yulian302/qugopy/proto;taskpbb\x06proto3"
 
 var (
 	file_task_proto_rawDescOnce sync.Once
@@ -350,34 +1271,81 @@ func file_task_proto_rawDescGZIP() []byte {
 }
 
 var file_task_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
-var file_task_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_task_proto_msgTypes = make([]protoimpl.MessageInfo, 21)
 var file_task_proto_goTypes = []any{
 	(WorkerType)(0),               // 0: task.WorkerType
 	(QueueType)(0),                // 1: task.QueueType
 	(*GetTaskRequest)(nil),        // 2: task.GetTaskRequest
-	(*IntTask)(nil),               // 3: task.IntTask
-	(*Task)(nil),                  // 4: task.Task
-	(*timestamppb.Timestamp)(nil), // 5: google.protobuf.Timestamp
-	(*wrapperspb.BoolValue)(nil),  // 6: google.protobuf.BoolValue
-	(*emptypb.Empty)(nil),         // 7: google.protobuf.Empty
+	(*CompleteTaskRequest)(nil),   // 3: task.CompleteTaskRequest
+	(*WorkerHeartbeat)(nil),       // 4: task.WorkerHeartbeat
+	(*WorkerMetrics)(nil),         // 5: task.WorkerMetrics
+	(*TaskTypeMetrics)(nil),       // 6: task.TaskTypeMetrics
+	(*EnqueueResponse)(nil),       // 7: task.EnqueueResponse
+	(*EnqueueBatchRequest)(nil),   // 8: task.EnqueueBatchRequest
+	(*EnqueueResult)(nil),         // 9: task.EnqueueResult
+	(*EnqueueBatchResponse)(nil),  // 10: task.EnqueueBatchResponse
+	(*TaskRequest)(nil),           // 11: task.TaskRequest
+	(*TaskStatus)(nil),            // 12: task.TaskStatus
+	(*IntTask)(nil),               // 13: task.IntTask
+	(*Task)(nil),                  // 14: task.Task
+	(*Affinity)(nil),              // 15: task.Affinity
+	(*Callback)(nil),              // 16: task.Callback
+	nil,                           // 17: task.GetTaskRequest.LabelsEntry
+	nil,                           // 18: task.WorkerHeartbeat.LabelsEntry
+	nil,                           // 19: task.WorkerMetrics.TasksEntry
+	nil,                           // 20: task.IntTask.TraceContextEntry
+	nil,                           // 21: task.Affinity.RequiredEntry
+	nil,                           // 22: task.Affinity.PreferredEntry
+	(*timestamppb.Timestamp)(nil), // 23: google.protobuf.Timestamp
+	(*wrapperspb.BoolValue)(nil),  // 24: google.protobuf.BoolValue
+	(*emptypb.Empty)(nil),         // 25: google.protobuf.Empty
 }
 var file_task_proto_depIdxs = []int32{
-	0, // 0: task.GetTaskRequest.worker_type:type_name -> task.WorkerType
-	4, // 1: task.IntTask.task:type_name -> task.Task
-	1, // 2: task.IntTask.queue_type:type_name -> task.QueueType
-	5, // 3: task.Task.deadline:type_name -> google.protobuf.Timestamp
-	6, // 4: task.Task.recurring:type_name -> google.protobuf.BoolValue
-	2, // 5: task.TaskService.GetTask:input_type -> task.GetTaskRequest
-	7, // 6: task.TaskService.GetGoTask:input_type -> google.protobuf.Empty
-	7, // 7: task.TaskService.GetPythonTask:input_type -> google.protobuf.Empty
-	3, // 8: task.TaskService.GetTask:output_type -> task.IntTask
-	3, // 9: task.TaskService.GetGoTask:output_type -> task.IntTask
-	3, // 10: task.TaskService.GetPythonTask:output_type -> task.IntTask
-	8, // [8:11] is the sub-list for method output_type
-	5, // [5:8] is the sub-list for method input_type
-	5, // [5:5] is the sub-list for extension type_name
-	5, // [5:5] is the sub-list for extension extendee
-	0, // [0:5] is the sub-list for field type_name
+	0,  // 0: task.GetTaskRequest.worker_type:type_name -> task.WorkerType
+	17, // 1: task.GetTaskRequest.labels:type_name -> task.GetTaskRequest.LabelsEntry
+	23, // 2: task.WorkerHeartbeat.started_at:type_name -> google.protobuf.Timestamp
+	18, // 3: task.WorkerHeartbeat.labels:type_name -> task.WorkerHeartbeat.LabelsEntry
+	19, // 4: task.WorkerMetrics.tasks:type_name -> task.WorkerMetrics.TasksEntry
+	14, // 5: task.EnqueueBatchRequest.tasks:type_name -> task.Task
+	9,  // 6: task.EnqueueBatchResponse.results:type_name -> task.EnqueueResult
+	23, // 7: task.TaskStatus.updated_at:type_name -> google.protobuf.Timestamp
+	14, // 8: task.IntTask.task:type_name -> task.Task
+	1,  // 9: task.IntTask.queue_type:type_name -> task.QueueType
+	20, // 10: task.IntTask.trace_context:type_name -> task.IntTask.TraceContextEntry
+	23, // 11: task.Task.deadline:type_name -> google.protobuf.Timestamp
+	24, // 12: task.Task.recurring:type_name -> google.protobuf.BoolValue
+	15, // 13: task.Task.affinity:type_name -> task.Affinity
+	16, // 14: task.Task.callback:type_name -> task.Callback
+	21, // 15: task.Affinity.required:type_name -> task.Affinity.RequiredEntry
+	22, // 16: task.Affinity.preferred:type_name -> task.Affinity.PreferredEntry
+	6,  // 17: task.WorkerMetrics.TasksEntry.value:type_name -> task.TaskTypeMetrics
+	2,  // 18: task.TaskService.GetTask:input_type -> task.GetTaskRequest
+	25, // 19: task.TaskService.GetGoTask:input_type -> google.protobuf.Empty
+	25, // 20: task.TaskService.GetPythonTask:input_type -> google.protobuf.Empty
+	3,  // 21: task.TaskService.CompleteTask:input_type -> task.CompleteTaskRequest
+	4,  // 22: task.TaskService.Heartbeat:input_type -> task.WorkerHeartbeat
+	5,  // 23: task.TaskService.ReportMetrics:input_type -> task.WorkerMetrics
+	14, // 24: task.TaskService.Enqueue:input_type -> task.Task
+	8,  // 25: task.TaskService.EnqueueBatch:input_type -> task.EnqueueBatchRequest
+	11, // 26: task.TaskService.GetTaskStatus:input_type -> task.TaskRequest
+	11, // 27: task.TaskService.CancelTask:input_type -> task.TaskRequest
+	11, // 28: task.TaskService.WatchTask:input_type -> task.TaskRequest
+	13, // 29: task.TaskService.GetTask:output_type -> task.IntTask
+	13, // 30: task.TaskService.GetGoTask:output_type -> task.IntTask
+	13, // 31: task.TaskService.GetPythonTask:output_type -> task.IntTask
+	25, // 32: task.TaskService.CompleteTask:output_type -> google.protobuf.Empty
+	25, // 33: task.TaskService.Heartbeat:output_type -> google.protobuf.Empty
+	25, // 34: task.TaskService.ReportMetrics:output_type -> google.protobuf.Empty
+	7,  // 35: task.TaskService.Enqueue:output_type -> task.EnqueueResponse
+	10, // 36: task.TaskService.EnqueueBatch:output_type -> task.EnqueueBatchResponse
+	12, // 37: task.TaskService.GetTaskStatus:output_type -> task.TaskStatus
+	12, // 38: task.TaskService.CancelTask:output_type -> task.TaskStatus
+	12, // 39: task.TaskService.WatchTask:output_type -> task.TaskStatus
+	29, // [29:40] is the sub-list for method output_type
+	18, // [18:29] is the sub-list for method input_type
+	18, // [18:18] is the sub-list for extension type_name
+	18, // [18:18] is the sub-list for extension extendee
+	0,  // [0:18] is the sub-list for field type_name
 }
 
 func init() { file_task_proto_init() }
@@ -391,7 +1359,7 @@ func file_task_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_task_proto_rawDesc), len(file_task_proto_rawDesc)),
 			NumEnums:      2,
-			NumMessages:   3,
+			NumMessages:   21,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -2,12 +2,27 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"sync"
+	"time"
 
+	"github.com/Yulian302/qugopy/config"
 	taskpb "github.com/Yulian302/qugopy/github.com/Yulian302/qugopy/proto"
+	"github.com/Yulian302/qugopy/internal/auth"
+	"github.com/Yulian302/qugopy/internal/events"
+	"github.com/Yulian302/qugopy/internal/locks"
+	"github.com/Yulian302/qugopy/internal/metrics"
 	"github.com/Yulian302/qugopy/internal/queue"
+	"github.com/Yulian302/qugopy/internal/ratelimit"
+	"github.com/Yulian302/qugopy/internal/tasks"
 	"github.com/Yulian302/qugopy/logging"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/go-redis/redis"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc/filters"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -16,12 +31,58 @@ import (
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
+const (
+	// delay before a task held back by its concurrency limit is handed out again
+	limitedTaskRetryDelay = 200 * time.Millisecond
+	// delay before a task whose lock is held by a running duplicate is handed out again
+	lockedTaskRetryDelay = time.Second
+)
+
+// time WatchTask waits for a task it did not find to start, as a task taken by
+// a worker is neither queued nor running until then
+const watchGrace = time.Second
+
+// interval WatchTask looks the task up at, in case events of it were lost, e.g.
+// dropped for a slow subscriber or published while Redis was unreachable
+var watchPollInterval = 5 * time.Second
+
+// errRedisMode is returned to workers asking for tasks in redis mode, in which
+// they take tasks from Redis themselves.
+var errRedisMode = status.Error(codes.FailedPrecondition, "workers take tasks from Redis in redis mode")
+
 type Server struct {
 	taskpb.UnimplementedTaskServiceServer
+
+	// Redis client of the app, nil in local mode
+	rdb     *redis.Client
+	locker  locks.Locker
+	sem     locks.Semaphore
+	limiter ratelimit.Limiter
+
+	// run locks, concurrency permits and tasks handed out to workers, keyed by
+	// task ID
+	locks     map[string]locks.Lock
+	permits   map[string]locks.Permit
+	handedOut map[string]handedOutTask
+	permitsMu sync.Mutex
+}
+
+// handedOutTask is a task handed out to a worker and the queue it was taken from.
+type handedOutTask struct {
+	queue tasks.QueueType
+	task  queue.IntTask
 }
 
-func NewServer() *Server {
-	return &Server{}
+func NewServer(rdb *redis.Client) *Server {
+	return &Server{
+		rdb:       rdb,
+		locker:    locks.New("local", nil),
+		sem:       locks.NewSemaphore("local", nil),
+		limiter:   ratelimit.New("local", nil),
+		locks:     make(map[string]locks.Lock),
+		permits:   make(map[string]locks.Permit),
+		handedOut: make(map[string]handedOutTask),
+	}
 }
 
 func ToProto(t *queue.IntTask, queueType taskpb.QueueType) *taskpb.IntTask {
@@ -47,64 +108,593 @@ func ToProto(t *queue.IntTask, queueType taskpb.QueueType) *taskpb.IntTask {
 			Priority:  uint32(t.Task.Priority),
 			Deadline:  deadline,
 			Recurring: recurring,
+			Tenant:    t.Task.Tenant,
+			Affinity:  affinityToProto(t.Task.Affinity),
+			Callback:  callbackToProto(t.Task.Callback),
 		},
-		QueueType: queueType,
+		QueueType:    queueType,
+		TraceContext: t.TraceContext,
 	}
 }
 
-func (s *Server) GetTask(ctx context.Context, req *taskpb.GetTaskRequest) (*taskpb.IntTask, error) {
-	var task queue.IntTask
-	var ok bool
-	var queueType taskpb.QueueType
+// FromProto converts a task handed out by the server back into an IntTask.
+func FromProto(t *taskpb.IntTask) queue.IntTask {
+	task := queue.IntTask{ID: t.Id, TraceContext: t.TraceContext}
+	if t.Task == nil {
+		return task
+	}
+	task.Task = TaskFromProto(t.Task)
+	return task
+}
+
+// TaskFromProto converts a task sent by a producer into a models.Task.
+func TaskFromProto(t *taskpb.Task) models.Task {
+	task := models.Task{
+		Type:     t.Type,
+		Payload:  t.Payload,
+		Priority: uint16(t.Priority),
+		Tenant:   t.Tenant,
+	}
+	if t.Deadline != nil {
+		deadline := t.Deadline.AsTime()
+		task.Deadline = &deadline
+	}
+	if t.Recurring != nil {
+		recurring := t.Recurring.Value
+		task.Recurring = &recurring
+	}
+	if t.Affinity != nil {
+		task.Affinity = &models.Affinity{Required: t.Affinity.Required, Preferred: t.Affinity.Preferred}
+	}
+	if t.Callback != nil {
+		task.Callback = &models.Callback{URL: t.Callback.Url, Events: t.Callback.Events, Secret: t.Callback.Secret}
+	}
+	return task
+}
+
+func affinityToProto(a *models.Affinity) *taskpb.Affinity {
+	if a == nil {
+		return nil
+	}
+	return &taskpb.Affinity{Required: a.Required, Preferred: a.Preferred}
+}
+
+func callbackToProto(cb *models.Callback) *taskpb.Callback {
+	if cb == nil {
+		return nil
+	}
+	return &taskpb.Callback{Url: cb.URL, Events: cb.Events, Secret: cb.Secret}
+}
 
+func (s *Server) GetTask(ctx context.Context, req *taskpb.GetTaskRequest) (*taskpb.IntTask, error) {
+	if req.Queue != "" {
+		return s.getQueueTask(req)
+	}
 	switch req.WorkerType {
 	case taskpb.WorkerType_WORKER_TYPE_PYTHON:
-		task, ok = queue.PythonLocalQueue.PQ.Pop()
-		queueType = taskpb.QueueType_QUEUE_TYPE_PYTHON
+		return s.handOut(tasks.PyQueue, taskpb.QueueType_QUEUE_TYPE_PYTHON, req.WorkerId, req.Labels)
 	case taskpb.WorkerType_WORKER_TYPE_GO:
-		task, ok = queue.GoLocalQueue.PQ.Pop()
-		queueType = taskpb.QueueType_QUEUE_TYPE_GO
+		return s.handOut(tasks.GoQueue, taskpb.QueueType_QUEUE_TYPE_GO, req.WorkerId, req.Labels)
 	default:
 		return nil, status.Errorf(codes.InvalidArgument, "invalid worker type: %v", req.WorkerType)
 	}
+}
 
-	if !ok {
-		return nil, status.Error(codes.NotFound, "queue empty")
+func (s *Server) GetPythonTask(ctx context.Context, e *emptypb.Empty) (*taskpb.IntTask, error) {
+	return s.handOut(tasks.PyQueue, taskpb.QueueType_QUEUE_TYPE_PYTHON, "", nil)
+}
+
+func (s *Server) GetGoTask(ctx context.Context, e *emptypb.Empty) (*taskpb.IntTask, error) {
+	return s.handOut(tasks.GoQueue, taskpb.QueueType_QUEUE_TYPE_GO, "", nil)
+}
+
+// getQueueTask hands out a task of a named queue to a worker of the queue's runtime.
+func (s *Server) getQueueTask(req *taskpb.GetTaskRequest) (*taskpb.IntTask, error) {
+	spec, exists := tasks.GetQueue(tasks.QueueType(req.Queue))
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "unknown queue: %s", req.Queue)
+	}
+	switch {
+	case req.WorkerType == taskpb.WorkerType_WORKER_TYPE_PYTHON && spec.Runtime == tasks.PythonRuntime:
+		return s.handOut(spec.Name, taskpb.QueueType_QUEUE_TYPE_PYTHON, req.WorkerId, req.Labels)
+	// external workers take tasks through a Go worker loop, see workers/external.go
+	case req.WorkerType == taskpb.WorkerType_WORKER_TYPE_GO && (spec.Runtime == tasks.GoRuntime || spec.Runtime == tasks.ExternalRuntime):
+		return s.handOut(spec.Name, taskpb.QueueType_QUEUE_TYPE_GO, req.WorkerId, req.Labels)
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "queue %s is not consumed by %v workers", req.Queue, req.WorkerType)
 	}
+}
 
-	return ToProto(&task, queueType), nil
+// CompleteTask is called by a worker once it finished a task handed out by this
+// server. It releases the task's run lock and concurrency permit.
+func (s *Server) CompleteTask(ctx context.Context, req *taskpb.CompleteTaskRequest) (*emptypb.Empty, error) {
+	if config.AppConfig.MODE == "redis" {
+		return nil, errRedisMode
+	}
+	s.permitsMu.Lock()
+	lock, locked := s.locks[req.Id]
+	permit, exists := s.permits[req.Id]
+	out, handedOut := s.handedOut[req.Id]
+	delete(s.locks, req.Id)
+	delete(s.permits, req.Id)
+	delete(s.handedOut, req.Id)
+	s.permitsMu.Unlock()
+	log := logging.Task(string(out.queue), req.WorkerId, req.Id)
+
+	if handedOut && req.Requeue {
+		if _, err := tasks.RequeueRunningTask(out.queue, req.Id, s.rdb); err != nil {
+			log.ErrorContext(ctx, "could not requeue task", logging.Err(err))
+		}
+	} else if handedOut {
+		var taskErr error
+		if !req.Success {
+			taskErr = errors.New(req.Error)
+		}
+		if err := tasks.RecordResult(out.queue, out.task, req.WorkerId, taskErr, s.rdb); err != nil {
+			log.ErrorContext(ctx, "could not record result", logging.Err(err))
+		}
+		_ = tasks.FinishTask(out.queue, req.Id, s.rdb)
+	}
+
+	if exists {
+		if err := permit.Release(); err != nil {
+			log.ErrorContext(ctx, "could not release concurrency permit", logging.Err(err))
+		}
+	}
+	if locked {
+		if err := lock.Unlock(); err != nil {
+			log.ErrorContext(ctx, "could not release run lock", logging.Err(err))
+		}
+	}
+
+	if req.Requeue {
+		log.InfoContext(ctx, "worker returned task")
+	} else if req.Success {
+		log.DebugContext(ctx, "task completed")
+	} else {
+		log.WarnContext(ctx, "task failed", "error", req.Error)
+	}
+	return &emptypb.Empty{}, nil
 }
 
-func (s *Server) GetPythonTask(ctx context.Context, e *emptypb.Empty) (*taskpb.IntTask, error) {
-	task, ok := queue.PythonLocalQueue.PQ.Pop()
-	if !ok {
-		return nil, status.Error(codes.NotFound, "Python queue empty")
+// Heartbeat records the state of a worker taking tasks from this server in the
+// registry, or forgets the worker once it stopped.
+func (s *Server) Heartbeat(ctx context.Context, req *taskpb.WorkerHeartbeat) (*emptypb.Empty, error) {
+	if req.WorkerId == "" {
+		return nil, status.Error(codes.InvalidArgument, "worker_id is required")
+	}
+	if req.Stopped {
+		_ = tasks.RemoveWorker(req.WorkerId, s.rdb)
+		metrics.ForgetWorker(req.WorkerId)
+		return &emptypb.Empty{}, nil
 	}
-	logging.DebugLog(fmt.Sprintf("Dispatching task: %s", task.ID))
-	return ToProto(&task, taskpb.QueueType_QUEUE_TYPE_PYTHON), nil
+
+	err := tasks.RecordHeartbeat(tasks.WorkerInfo{
+		ID:           req.WorkerId,
+		Runtime:      tasks.Runtime(req.Runtime),
+		Queue:        tasks.QueueType(req.Queue),
+		Host:         req.Host,
+		PID:          int(req.Pid),
+		Labels:       req.Labels,
+		CurrentTasks: req.CurrentTasks,
+		Processed:    req.Processed,
+		Failed:       req.Failed,
+		StartedAt:    req.StartedAt.AsTime(),
+	}, s.rdb)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not record heartbeat: %v", err)
+	}
+	return &emptypb.Empty{}, nil
 }
 
-func (s *Server) GetGoTask(ctx context.Context, e *emptypb.Empty) (*taskpb.IntTask, error) {
-	task, ok := queue.GoLocalQueue.PQ.Pop()
+// ReportMetrics records the metrics a worker reports with its heartbeats, which
+// are exported until the worker stops or stops reporting.
+func (s *Server) ReportMetrics(ctx context.Context, req *taskpb.WorkerMetrics) (*emptypb.Empty, error) {
+	if req.WorkerId == "" {
+		return nil, status.Error(codes.InvalidArgument, "worker_id is required")
+	}
+	report := metrics.WorkerReport{
+		WorkerID:    req.WorkerId,
+		Queue:       req.Queue,
+		Tasks:       make(map[string]metrics.TaskTypeTotals, len(req.Tasks)),
+		CPUSeconds:  req.CpuSeconds,
+		MaxRSSBytes: req.MaxRssBytes,
+	}
+	for taskType, totals := range req.Tasks {
+		report.Tasks[taskType] = metrics.TaskTypeTotals{
+			Succeeded:  totals.GetSucceeded(),
+			Failed:     totals.GetFailed(),
+			RunSeconds: totals.GetRunSeconds(),
+		}
+	}
+	metrics.ReportWorker(report)
+	return &emptypb.Empty{}, nil
+}
+
+// Enqueue enqueues the task of a producer like POST /tasks and returns its ID.
+func (s *Server) Enqueue(ctx context.Context, req *taskpb.Task) (*taskpb.EnqueueResponse, error) {
+	if !auth.AllowsTaskType(ctx, req.Type) {
+		return nil, status.Errorf(codes.PermissionDenied, "%v: %s", auth.ErrTaskTypeForbidden, req.Type)
+	}
+	id, err := tasks.EnqueueContext(ctx, TaskFromProto(req), s.rdb)
+	if err != nil {
+		return nil, enqueueError(err)
+	}
+	return &taskpb.EnqueueResponse{Id: id}, nil
+}
+
+// enqueueError maps an error enqueuing tasks to the code matching the status
+// POST /tasks responds with.
+func enqueueError(err error) error {
+	switch {
+	case errors.Is(err, tasks.ErrInvalidTask), errors.Is(err, tasks.ErrBatchTooLarge):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, tasks.ErrDuplicateTask):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, tasks.ErrQueueDraining), errors.Is(err, tasks.ErrShuttingDown):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, tasks.ErrTenantQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		return status.Errorf(codes.Internal, "could not enqueue: %v", err)
+	}
+}
+
+// EnqueueBatch enqueues the tasks of a producer like POST /tasks/batch, returning
+// the ID or error of each.
+func (s *Server) EnqueueBatch(ctx context.Context, req *taskpb.EnqueueBatchRequest) (*taskpb.EnqueueBatchResponse, error) {
+	batch := make([]models.Task, 0, len(req.Tasks))
+	for _, t := range req.Tasks {
+		// a batch is rejected as a whole, as a single task is
+		if !auth.AllowsTaskType(ctx, t.Type) {
+			return nil, status.Errorf(codes.PermissionDenied, "%v: %s", auth.ErrTaskTypeForbidden, t.Type)
+		}
+		batch = append(batch, TaskFromProto(t))
+	}
+
+	results, err := tasks.EnqueueBatchContext(ctx, batch, req.Atomic, s.rdb)
+	if err != nil {
+		return nil, enqueueError(err)
+	}
+
+	resp := &taskpb.EnqueueBatchResponse{Results: make([]*taskpb.EnqueueResult, len(results))}
+	for i, result := range results {
+		resp.Results[i] = &taskpb.EnqueueResult{Id: result.ID}
+		if result.Err != nil {
+			resp.Results[i].Error = result.Err.Error()
+		}
+	}
+	return resp, nil
+}
+
+func statusToProto(taskStatus tasks.TaskStatus) *taskpb.TaskStatus {
+	return &taskpb.TaskStatus{
+		Id:        taskStatus.ID,
+		Queue:     string(taskStatus.Queue),
+		State:     string(taskStatus.State),
+		WorkerId:  taskStatus.WorkerID,
+		Error:     taskStatus.Error,
+		UpdatedAt: timestamppb.New(taskStatus.UpdatedAt),
+	}
+}
+
+func (s *Server) GetTaskStatus(ctx context.Context, req *taskpb.TaskRequest) (*taskpb.TaskStatus, error) {
+	taskStatus, err := tasks.GetTaskStatus(req.Id, s.rdb)
+	if errors.Is(err, tasks.ErrTaskNotFound) {
+		return nil, status.Errorf(codes.NotFound, "task %s not found", req.Id)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not look up task: %v", err)
+	}
+	return statusToProto(taskStatus), nil
+}
+
+// CancelTask removes a queued or deferred task from its queue. Running and
+// finished tasks fail with FailedPrecondition.
+func (s *Server) CancelTask(ctx context.Context, req *taskpb.TaskRequest) (*taskpb.TaskStatus, error) {
+	taskStatus, err := tasks.CancelTask(req.Id, s.rdb)
+	switch {
+	case errors.Is(err, tasks.ErrTaskNotFound):
+		return nil, status.Errorf(codes.NotFound, "task %s not found", req.Id)
+	case errors.Is(err, tasks.ErrTaskRunning), errors.Is(err, tasks.ErrTaskFinished):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case err != nil:
+		return nil, status.Errorf(codes.Internal, "could not cancel task: %v", err)
+	}
+	return statusToProto(taskStatus), nil
+}
+
+// WatchTask sends the status of a task and then every change of it, until the
+// task finished or the client goes away. Changes are taken from the events of
+// the task, and the task is looked up every watchPollInterval as events may
+// be lost.
+func (s *Server) WatchTask(req *taskpb.TaskRequest, stream grpc.ServerStreamingServer[taskpb.TaskStatus]) error {
+	// subscribed before the lookup, so that no change is missed
+	sub := events.Subscribe(events.Filter{TaskIDs: []string{req.Id}})
+	defer sub.Close()
+
+	var last tasks.TaskState
+	send := func(taskStatus tasks.TaskStatus) error {
+		if taskStatus.State == last {
+			return nil
+		}
+		last = taskStatus.State
+		return stream.Send(statusToProto(taskStatus))
+	}
+
+	var grace <-chan time.Time
+	taskStatus, err := tasks.GetTaskStatus(req.Id, s.rdb)
+	switch {
+	case errors.Is(err, tasks.ErrTaskNotFound):
+		grace = time.After(watchGrace)
+	case err != nil:
+		return status.Errorf(codes.Internal, "could not look up task: %v", err)
+	default:
+		if err := send(taskStatus); err != nil {
+			return err
+		}
+		if taskStatus.Finished() {
+			return nil
+		}
+	}
+
+	poll := time.NewTicker(watchPollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-grace:
+			return status.Errorf(codes.NotFound, "task %s not found", req.Id)
+		case <-poll.C:
+			taskStatus, err := tasks.GetTaskStatus(req.Id, s.rdb)
+			if errors.Is(err, tasks.ErrTaskNotFound) {
+				continue
+			}
+			if err != nil {
+				logging.FromContext(stream.Context()).WarnContext(stream.Context(), "could not look up watched task", "task_id", req.Id, logging.Err(err))
+				continue
+			}
+			grace = nil
+			if err := send(taskStatus); err != nil {
+				return err
+			}
+			if taskStatus.Finished() {
+				return nil
+			}
+		case event, ok := <-sub.C:
+			if !ok {
+				return status.Error(codes.Unavailable, "server is shutting down")
+			}
+			taskStatus, changed := eventStatus(event)
+			if !changed {
+				continue
+			}
+			grace = nil
+			if err := send(taskStatus); err != nil {
+				return err
+			}
+			if taskStatus.Finished() {
+				return nil
+			}
+		}
+	}
+}
+
+// eventStatus returns the status of a task after an event of it. Reports
+// whether the event changes the task's state. Outcomes are taken from the
+// event, as workers publish them before they are recorded.
+func eventStatus(event events.Event) (tasks.TaskStatus, bool) {
+	taskStatus := tasks.TaskStatus{
+		ID:        event.TaskID,
+		Type:      event.TaskType,
+		Queue:     tasks.QueueType(event.Queue),
+		WorkerID:  event.WorkerID,
+		Error:     event.Error,
+		UpdatedAt: event.Time,
+	}
+	switch event.Type {
+	case events.Enqueued, events.Retried:
+		taskStatus.State = tasks.TaskQueued
+	case events.Started:
+		taskStatus.State = tasks.TaskRunning
+	case events.Succeeded:
+		taskStatus.State = tasks.TaskSucceeded
+	case events.Failed:
+		taskStatus.State = tasks.TaskFailed
+	case events.Expired:
+		taskStatus.State = tasks.TaskExpired
+	case events.Cancelled:
+		taskStatus.State = tasks.TaskCancelled
+	default:
+		return taskStatus, false
+	}
+	return taskStatus, true
+}
+
+// handOut pops the next task of a queue for a worker with labels. Tasks whose
+// deadline passed are recorded as expired and skipped. A task whose affinity
+// does not match the labels, whose lock is held by a running duplicate, whose
+// type is at its concurrency limit or which exceeds its type's rate limit is
+// deferred and the queue is reported as empty to the worker, as is a paused
+// queue. The run lock and permit of a task handed out are held until the
+// worker calls CompleteTask.
+func (s *Server) handOut(name tasks.QueueType, queueType taskpb.QueueType, workerID string, labels map[string]string) (*taskpb.IntTask, error) {
+	if config.AppConfig.MODE == "redis" {
+		return nil, errRedisMode
+	}
+	if paused, _ := tasks.IsQueuePaused(name, s.rdb); paused {
+		return nil, status.Error(codes.NotFound, "queue paused")
+	}
+
+	lq := queue.Local(string(name))
+	task, ok := lq.Pop()
+	for ok && tasks.IsExpired(task) {
+		if err := tasks.ExpireTask(name, task, workerID, s.rdb); err != nil {
+			logging.Task(string(name), workerID, task.ID).Error("could not record task as expired", logging.Err(err))
+		}
+		task, ok = lq.Pop()
+	}
 	if !ok {
-		return nil, status.Error(codes.NotFound, "Go queue empty")
+		return nil, status.Error(codes.NotFound, "queue empty")
+	}
+
+	if !tasks.MatchesWorker(task, labels) {
+		return nil, s.deferTask(tasks.MarkUnmatched(task, labels), tasks.AffinityRetryDelay, "no task matching the worker's labels")
+	}
+	task.UnschedulableSince = nil
+
+	var lock locks.Lock
+	if key, ttl, needsLock := tasks.RunLockKey(task); needsLock {
+		var err error
+		lock, err = s.locker.TryLock(key, ttl)
+		if errors.Is(err, locks.ErrLocked) {
+			return nil, s.deferTask(task, lockedTaskRetryDelay, "task locked by a running duplicate")
+		}
+		if err != nil {
+			lq.Push(task)
+			return nil, status.Errorf(codes.Internal, "could not acquire run lock: %v", err)
+		}
+	}
+	release := func() {
+		if lock != nil {
+			_ = lock.Unlock()
+		}
+	}
+
+	var permit locks.Permit
+	if key, limit, limited := tasks.ConcurrencyKey(task.Task.Type); limited {
+		var err error
+		permit, err = s.sem.TryAcquire(key, limit, tasks.ConcurrencyLeaseTTL)
+		if errors.Is(err, locks.ErrLimitReached) {
+			release()
+			return nil, s.deferTask(task, limitedTaskRetryDelay, "concurrency limit reached")
+		}
+		if err != nil {
+			release()
+			lq.Push(task)
+			return nil, status.Errorf(codes.Internal, "could not acquire permit: %v", err)
+		}
+		unlock := release
+		release = func() {
+			_ = permit.Release()
+			unlock()
+		}
+	}
+
+	if key, rule, limited := tasks.RateLimitKey(task); limited {
+		allowed, wait, err := s.limiter.Allow(key, rule)
+		if err != nil {
+			release()
+			lq.Push(task)
+			return nil, status.Errorf(codes.Internal, "could not check rate limit: %v", err)
+		}
+		if !allowed {
+			release()
+			return nil, s.deferTask(task, wait, "rate limit exceeded")
+		}
+	}
+
+	_ = tasks.StartTask(name, workerID, task, s.rdb)
+	s.permitsMu.Lock()
+	if lock != nil {
+		s.locks[task.ID] = lock
 	}
-	return ToProto(&task, taskpb.QueueType_QUEUE_TYPE_GO), nil
+	if permit != nil {
+		s.permits[task.ID] = permit
+	}
+	s.handedOut[task.ID] = handedOutTask{queue: name, task: task}
+	s.permitsMu.Unlock()
+
+	logging.Task(string(name), workerID, task.ID).Debug("dispatching task")
+	return ToProto(&task, queueType), nil
 }
 
-func Start() error {
-	lis, err := net.Listen("tcp", ":50051")
+// deferTask hands a task out again after delay and returns the NotFound error
+// reporting the queue as empty to the worker, with reason as its message.
+func (s *Server) deferTask(task queue.IntTask, delay time.Duration, reason string) error {
+	if err := tasks.DeferTask(task, delay, s.rdb); err != nil {
+		return status.Errorf(codes.Internal, "could not defer task: %v", err)
+	}
+	return status.Error(codes.NotFound, reason)
+}
+
+var (
+	serverMu sync.Mutex
+	// server started by Start
+	server *grpc.Server
+)
+
+// Addr is the address the gRPC server listens on.
+const Addr = ":50051"
+
+// Listen binds the gRPC server's address, so that bind errors are reported
+// before the app tells it is ready.
+func Listen() (net.Listener, error) {
+	lis, err := net.Listen("tcp", Addr)
 	if err != nil {
-		return fmt.Errorf("gRPC listen failed: %w", err)
+		return nil, fmt.Errorf("gRPC listen failed: %w", err)
 	}
+	return lis, nil
+}
+
+// untracedMethods are called by workers in a loop, tracing them would bury the
+// spans of tasks.
+var untracedMethods = filters.None(
+	filters.MethodName("GetTask"),
+	filters.MethodName("GetGoTask"),
+	filters.MethodName("GetPythonTask"),
+	filters.MethodName("Heartbeat"),
+	filters.MethodName("ReportMetrics"),
+)
 
-	gs := grpc.NewServer()
-	taskpb.RegisterTaskServiceServer(gs, NewServer())
+// Serve serves the task service on lis until Stop is called. rdb is the app's
+// Redis client, nil in local mode.
+func Serve(lis net.Listener, rdb *redis.Client) error {
+	gs := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithFilter(untracedMethods))),
+		grpc.ChainUnaryInterceptor(metrics.UnaryServerInterceptor(), auth.UnaryServerInterceptor(rdb)),
+		grpc.ChainStreamInterceptor(metrics.StreamServerInterceptor(), auth.StreamServerInterceptor(rdb)),
+	)
+	taskpb.RegisterTaskServiceServer(gs, NewServer(rdb))
+	serverMu.Lock()
+	server = gs
+	serverMu.Unlock()
 
-	logging.DebugLog("gRPC server started on :50051")
+	slog.Info("gRPC server started", "addr", lis.Addr().String())
 
 	if err := gs.Serve(lis); err != nil {
 		return fmt.Errorf("gRPC serve failed: %w", err)
 	}
 	return nil
 }
+
+func Start(rdb *redis.Client) error {
+	lis, err := Listen()
+	if err != nil {
+		return err
+	}
+	return Serve(lis, rdb)
+}
+
+// Stop stops the server started by Start. Running calls get timeout to finish.
+func Stop(timeout time.Duration) {
+	serverMu.Lock()
+	gs := server
+	serverMu.Unlock()
+	if gs == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		gs.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		gs.Stop()
+	}
+}
@@ -0,0 +1,275 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	taskpb "github.com/Yulian302/qugopy/github.com/Yulian302/qugopy/proto"
+	"github.com/Yulian302/qugopy/internal/queue"
+	"github.com/Yulian302/qugopy/internal/ratelimit"
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestClient serves a local mode Server over an in-memory connection and
+// returns a client of it.
+func newTestClient(t *testing.T) taskpb.TaskServiceClient {
+	config.AppConfig.MODE = "local"
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	taskpb.RegisterTaskServiceServer(gs, NewServer(nil))
+	go func() { _ = gs.Serve(lis) }()
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return taskpb.NewTaskServiceClient(conn)
+}
+
+// enqueueEmail enqueues a send_email task through client and returns its ID.
+func enqueueEmail(t *testing.T, client taskpb.TaskServiceClient) string {
+	resp, err := client.Enqueue(context.Background(), &taskpb.Task{
+		Type:     "send_email",
+		Payload:  []byte(`{"recipient_email":"a@example.com","subject":"hi","body":"hi"}`),
+		Priority: 1,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _, _ = tasks.CancelTask(resp.Id, nil) })
+	return resp.Id
+}
+
+// pushTasks pushes tasks of a type with payload into a local queue and cancels
+// the ones left over once the test ends.
+func pushTasks(t *testing.T, name tasks.QueueType, taskType string, payload string, n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = uuid.New().String()
+		task := models.Task{Type: taskType, Payload: json.RawMessage(payload), Priority: 1}
+		uniqueKey, _, _ := tasks.UniqueKey(task)
+		queue.Local(string(name)).Push(models.IntTask{ID: ids[i], Task: task, UniqueKey: uniqueKey, EnqueuedAt: time.Now()})
+	}
+	t.Cleanup(func() {
+		for _, id := range ids {
+			_, _ = tasks.CancelTask(id, nil)
+		}
+	})
+	return ids
+}
+
+func TestHandOutRunLocksAndRateLimits(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	// limited per domain, as the buckets of the process outlive the test
+	tasks.RateLimitRules[models.SendEmail] = tasks.RateLimitRule{Limit: ratelimit.Rule{Rate: 0.001, Burst: 1}, PartitionBy: tasks.EmailDomain("recipient_email")}
+	t.Cleanup(func() { delete(tasks.RateLimitRules, models.SendEmail) })
+	rules := tasks.UniqueTaskRules
+	tasks.UniqueTaskRules = map[models.TaskType]tasks.UniqueRule{
+		models.DownloadFile: {Fields: []string{"filename"}, OnConflict: tasks.RejectDuplicate, LockTTL: time.Minute},
+	}
+	t.Cleanup(func() { tasks.UniqueTaskRules = rules })
+	if err := tasks.DeclareQueue(tasks.QueueSpec{Name: "handout_test", Runtime: tasks.GoRuntime}, nil); !errors.Is(err, tasks.ErrQueueExists) {
+		require.NoError(t, err)
+	}
+
+	s := NewServer(nil)
+	ctx := context.Background()
+	req := &taskpb.GetTaskRequest{Queue: "handout_test", WorkerType: taskpb.WorkerType_WORKER_TYPE_GO, WorkerId: "handout_test"}
+
+	// duplicates wait for the run lock of the task handed out
+	ids := pushTasks(t, "handout_test", "download_file", `{"url":"http://localhost","filename":"handout.json"}`, 2)
+	task, err := s.GetTask(ctx, req)
+	require.NoError(t, err)
+	_, err = s.GetTask(ctx, req)
+	assert.Equal(t, codes.NotFound, status.Code(err), "Duplicate should be deferred while the lock is held")
+
+	uniqueKey, _, _ := tasks.UniqueKey(FromProto(task).Task)
+	key, ttl, _ := tasks.RunLockKey(models.IntTask{Task: FromProto(task).Task, UniqueKey: uniqueKey})
+	_, err = s.locker.TryLock(key, ttl)
+	assert.Error(t, err, "Run lock should be held until the task completes")
+	_, err = s.CompleteTask(ctx, &taskpb.CompleteTaskRequest{Id: task.Id, WorkerId: "handout_test", Success: true})
+	require.NoError(t, err)
+	lock, err := s.locker.TryLock(key, ttl)
+	require.NoError(t, err, "CompleteTask should release the run lock")
+	require.NoError(t, lock.Unlock())
+
+	// tasks over the rate limit are deferred
+	ids = pushTasks(t, "handout_test", "send_email", `{"recipient_email":"a@`+uuid.New().String()+`.com"}`, 2)
+	task, err = s.GetTask(ctx, req)
+	require.NoError(t, err)
+	_, err = s.GetTask(ctx, req)
+	assert.Equal(t, codes.NotFound, status.Code(err), "Task over the rate limit should be deferred")
+	other := ids[0]
+	if task.Id == other {
+		other = ids[1]
+	}
+	deferred, err := tasks.GetTaskStatus(other, nil)
+	require.NoError(t, err)
+	assert.Equal(t, tasks.TaskDeferred, deferred.State)
+	_, err = s.CompleteTask(ctx, &taskpb.CompleteTaskRequest{Id: task.Id, WorkerId: "handout_test", Success: true})
+	require.NoError(t, err)
+}
+
+func TestTaskProtoRoundTrip(t *testing.T) {
+	deadline := time.Now().Add(time.Hour).UTC()
+	recurring := true
+	task := models.IntTask{
+		ID: uuid.New().String(),
+		Task: models.Task{
+			Type:      "send_email",
+			Payload:   json.RawMessage(`{"recipient_email":"a@example.com"}`),
+			Priority:  3,
+			Deadline:  &deadline,
+			Recurring: &recurring,
+			Tenant:    "team-a",
+			Affinity:  &models.Affinity{Required: map[string]string{"zone": "eu"}, Preferred: map[string]string{"disk": "ssd"}},
+			Callback:  &models.Callback{URL: "https://example.com/hook", Events: []string{"failed"}, Secret: "s3cret"},
+		},
+	}
+	assert.Equal(t, task, FromProto(ToProto(&task, taskpb.QueueType_QUEUE_TYPE_GO)))
+
+	task.Task.Affinity, task.Task.Callback = nil, nil
+	pb := ToProto(&task, taskpb.QueueType_QUEUE_TYPE_GO)
+	assert.Nil(t, pb.Task.Affinity)
+	assert.Nil(t, pb.Task.Callback)
+	assert.Equal(t, task, FromProto(pb))
+}
+
+func TestEnqueueErrorCodes(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	s := NewServer(nil)
+	ctx := context.Background()
+
+	_, err := s.Enqueue(ctx, &taskpb.Task{Type: "resize_image", Payload: []byte(`{}`), Priority: 1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "Unknown task types are invalid")
+	_, err = s.Enqueue(ctx, &taskpb.Task{Type: "send_email", Payload: []byte(`{}`)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "Tasks without priority are invalid")
+	batch := make([]*taskpb.Task, tasks.MaxBatchSize+1)
+	for i := range batch {
+		batch[i] = &taskpb.Task{Type: "send_email", Payload: []byte(`{}`), Priority: 1}
+	}
+	_, err = s.EnqueueBatch(ctx, &taskpb.EnqueueBatchRequest{Tasks: batch})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	queueType, err := tasks.GetQueueType("send_email")
+	require.NoError(t, err)
+	require.NoError(t, tasks.SetQueueState(queueType, tasks.QueueDraining, nil))
+	t.Cleanup(func() { _ = tasks.SetQueueState(queueType, tasks.QueueActive, nil) })
+	_, err = s.Enqueue(ctx, &taskpb.Task{Type: "send_email", Payload: []byte(`{}`), Priority: 1})
+	assert.Equal(t, codes.Unavailable, status.Code(err), "Draining queues should be reported as unavailable")
+}
+
+func TestEnqueueStatusAndCancel(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	id := enqueueEmail(t, client)
+	taskStatus, err := client.GetTaskStatus(ctx, &taskpb.TaskRequest{Id: id})
+	require.NoError(t, err)
+	assert.Equal(t, string(tasks.TaskQueued), taskStatus.State)
+
+	taskStatus, err = client.CancelTask(ctx, &taskpb.TaskRequest{Id: id})
+	require.NoError(t, err)
+	assert.Equal(t, string(tasks.TaskCancelled), taskStatus.State)
+	taskStatus, err = client.GetTaskStatus(ctx, &taskpb.TaskRequest{Id: id})
+	require.NoError(t, err)
+	assert.Equal(t, string(tasks.TaskCancelled), taskStatus.State, "Cancelled tasks should keep their outcome")
+
+	_, err = client.CancelTask(ctx, &taskpb.TaskRequest{Id: id})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "Finished tasks can not be cancelled")
+	_, err = client.GetTaskStatus(ctx, &taskpb.TaskRequest{Id: uuid.New().String()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.CancelTask(ctx, &taskpb.TaskRequest{Id: uuid.New().String()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// recvStates receives statuses of a watched task until the stream ends.
+func recvStates(t *testing.T, stream grpc.ServerStreamingClient[taskpb.TaskStatus], states chan<- string) {
+	defer close(states)
+	for {
+		taskStatus, err := stream.Recv()
+		if err == io.EOF {
+			return
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+		states <- taskStatus.State
+	}
+}
+
+func TestWatchTask(t *testing.T) {
+	client := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	id := enqueueEmail(t, client)
+	stream, err := client.WatchTask(ctx, &taskpb.TaskRequest{Id: id})
+	require.NoError(t, err)
+	states := make(chan string, 3)
+	go recvStates(t, stream, states)
+	assert.Equal(t, string(tasks.TaskQueued), <-states)
+
+	queueType, err := tasks.GetQueueType("send_email")
+	require.NoError(t, err)
+	task, err := client.GetTask(ctx, &taskpb.GetTaskRequest{Queue: string(queueType), WorkerType: taskpb.WorkerType_WORKER_TYPE_GO, WorkerId: "watch_test"})
+	require.NoError(t, err)
+	require.Equal(t, id, task.Id)
+	assert.Equal(t, string(tasks.TaskRunning), <-states)
+
+	_, err = client.CompleteTask(ctx, &taskpb.CompleteTaskRequest{Id: id, WorkerId: "watch_test", Success: true})
+	require.NoError(t, err)
+	assert.Equal(t, string(tasks.TaskSucceeded), <-states)
+	_, open := <-states
+	assert.False(t, open, "The stream should end once the task finished")
+
+	stream, err = client.WatchTask(ctx, &taskpb.TaskRequest{Id: uuid.New().String()})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.NotFound, status.Code(err), "Unknown tasks should be reported after the grace period")
+}
+
+func TestWatchTaskPollsLostEvents(t *testing.T) {
+	watchPollInterval = 20 * time.Millisecond
+	t.Cleanup(func() { watchPollInterval = 5 * time.Second })
+	client := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	id := enqueueEmail(t, client)
+	stream, err := client.WatchTask(ctx, &taskpb.TaskRequest{Id: id})
+	require.NoError(t, err)
+	states := make(chan string, 2)
+	go recvStates(t, stream, states)
+	assert.Equal(t, string(tasks.TaskQueued), <-states)
+
+	// the task finishes without publishing events
+	queueType, err := tasks.GetQueueType("send_email")
+	require.NoError(t, err)
+	task, ok := queue.Local(string(queueType)).Pop()
+	require.True(t, ok)
+	require.Equal(t, id, task.ID)
+	require.NoError(t, tasks.RecordOutcome(tasks.TaskStatus{ID: id, Type: "send_email", Queue: queueType, State: tasks.TaskSucceeded}, nil))
+	select {
+	case state := <-states:
+		assert.Equal(t, string(tasks.TaskSucceeded), state)
+	case <-ctx.Done():
+		t.Fatal("The watched task should be looked up")
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/Yulian302/qugopy/internal/events"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// interval at which an idle event stream sends a comment, so that proxies keep
+// the connection open
+const eventKeepAlive = 15 * time.Second
+
+// eventQuery are the query parameters of the event handlers. Lists are comma separated.
+type eventQuery struct {
+	TaskID string `form:"task_id"`
+	Type   string `form:"type"`
+	Queue  string `form:"queue"`
+	Event  string `form:"event"`
+}
+
+// eventFilter reads the filter of an event stream from the query, responding
+// 400 if it is invalid.
+func eventFilter(c *gin.Context) (events.Filter, bool) {
+	var query eventQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid query",
+			"details": err.Error(),
+		})
+		return events.Filter{}, false
+	}
+
+	filter := events.Filter{
+		TaskIDs:   splitList(query.TaskID),
+		TaskTypes: splitList(query.Type),
+		Queues:    splitList(query.Queue),
+	}
+	for _, eventType := range splitList(query.Event) {
+		if !slices.Contains(events.Types, events.Type(eventType)) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid query",
+				"details": "invalid event type: " + eventType,
+			})
+			return events.Filter{}, false
+		}
+		filter.Types = append(filter.Types, events.Type(eventType))
+	}
+	return filter, true
+}
+
+// EventStreamHandler streams the events matching the query as server-sent
+// events, named after their type, until the client disconnects.
+func EventStreamHandler(c *gin.Context) {
+	filter, ok := eventFilter(c)
+	if !ok {
+		return
+	}
+	sub := events.Subscribe(filter)
+	defer sub.Close()
+
+	c.Header("Cache-Control", "no-cache")
+	// e.g. nginx would buffer the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Header("Content-Type", "text/event-stream")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, open := <-sub.C:
+			if !open {
+				return false
+			}
+			c.SSEvent(string(event.Type), event)
+			return true
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keepalive\n\n")
+			return err == nil
+		}
+	})
+}
+
+// EventSocketHandler sends the events matching the query as JSON text messages
+// over a WebSocket until the client disconnects. Messages from the client are
+// ignored.
+func EventSocketHandler(c *gin.Context) {
+	filter, ok := eventFilter(c)
+	if !ok {
+		return
+	}
+
+	websocket.Server{Handler: func(ws *websocket.Conn) {
+		sub := events.Subscribe(filter)
+		defer sub.Close()
+
+		// reading fails once the client closed the connection
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			var msg string
+			for websocket.Message.Receive(ws, &msg) == nil {
+			}
+		}()
+
+		for {
+			select {
+			case <-closed:
+				return
+			case event, open := <-sub.C:
+				if !open {
+					return
+				}
+				if err := websocket.JSON.Send(ws, event); err != nil {
+					return
+				}
+			}
+		}
+	}}.ServeHTTP(c.Writer, c.Request)
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -8,14 +9,23 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/auth"
+	"github.com/Yulian302/qugopy/internal/callbacks"
+	"github.com/Yulian302/qugopy/internal/events"
 	"github.com/Yulian302/qugopy/internal/queue"
+	"github.com/Yulian302/qugopy/internal/readiness"
+	"github.com/Yulian302/qugopy/internal/tasks"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis"
+	"golang.org/x/net/websocket"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var (
@@ -28,6 +38,18 @@ var (
 func newTestRouter(rdb *redis.Client) *gin.Engine {
 	r := gin.New()
 	r.POST("/tasks", TaskEnqueueHandler(rdb))
+	r.GET("/tasks", TaskListHandler(rdb))
+	r.POST("/tasks/batch", TaskBatchHandler(rdb))
+	r.GET("/tasks/:id/callback", TaskCallbackHandler(rdb))
+	r.GET("/queues", QueueListHandler(rdb))
+	r.POST("/queues", QueueDeclareHandler(rdb))
+	r.GET("/queues/:name", QueueGetHandler(rdb))
+	r.POST("/queues/:name/pause", QueueStateHandler(tasks.QueuePaused, rdb))
+	r.POST("/queues/:name/resume", QueueStateHandler(tasks.QueueActive, rdb))
+	r.POST("/queues/:name/drain", QueueStateHandler(tasks.QueueDraining, rdb))
+	r.GET("/workers", WorkerListHandler(rdb))
+	r.GET("/events", EventStreamHandler)
+	r.GET("/events/ws", EventSocketHandler)
 	return r
 }
 
@@ -58,15 +80,31 @@ func TestHealth(t *testing.T) {
 	assert.Equal(t, 200, w.Code)
 	assert.Contains(t, w.Body.String(), `"health"`)
 }
+
+func TestReadiness(t *testing.T) {
+	path := "/ready"
+	r.GET(path, ReadinessHandler)
+	t.Cleanup(func() { readiness.Set(false) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	readiness.Set(true)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
 func TestEnqueueHandlerRedis_EdgeCases(t *testing.T) {
 	config.AppConfig.MODE = "redis"
 	rdb := redis.NewClient(&redis.Options{
 		Addr: fmt.Sprintf("%s:%s", cfg.REDIS.HOST, cfg.REDIS.PORT),
 	})
 
-	_ = rdb.Del("go_queue").Err()
+	_ = rdb.Del(tasks.QueueKey(tasks.GoQueue)).Err()
 	t.Cleanup(func() {
-		_ = rdb.Del("go_queue").Err()
+		_ = rdb.Del(tasks.QueueKey(tasks.GoQueue)).Err()
 	})
 	r := newTestRouter(rdb)
 
@@ -120,7 +158,7 @@ func TestEnqueueHandlerRedis_EdgeCases(t *testing.T) {
 				assert.Contains(t, w.Body.String(), tt.wantBody)
 			}
 			if tt.wantStatus == 201 {
-				res, err := rdb.ZPopMin(tt.queueType, 1).Result()
+				res, err := rdb.ZPopMin(tasks.QueueKey(tasks.QueueType(tt.queueType)), 1).Result()
 				assert.NoError(t, err)
 				var task queue.IntTask
 				if err := json.Unmarshal([]byte(res[0].Member.(string)), &task); err != nil {
@@ -156,6 +194,12 @@ func TestEnqueueHandlerLocal_EdgeCases(t *testing.T) {
 			wantStatus: 400,
 			wantBody:   "Invalid request payload",
 		},
+		{
+			name:       "unknown task type",
+			body:       `{"type": "resize_video", "payload": "test", "priority": 10}`,
+			wantStatus: 400,
+			wantBody:   "invalid task type",
+		},
 		{
 			name:       "invalid priority",
 			body:       `{"payload": "test", "priority": -1}`,
@@ -191,3 +235,402 @@ func TestEnqueueHandlerLocal_EdgeCases(t *testing.T) {
 	}
 
 }
+
+func TestQueueDeclareHandlerLocal(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	r := newTestRouter(rdb)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "valid queue",
+			body:       `{"name": "handler_test_queue", "runtime": "go", "workers": 0}`,
+			wantStatus: 201,
+			wantBody:   "Queue created",
+		},
+		{
+			name:       "existing queue",
+			body:       `{"name": "go_queue", "runtime": "go"}`,
+			wantStatus: 409,
+			wantBody:   "already exists",
+		},
+		{
+			name:       "invalid runtime",
+			body:       `{"name": "other", "runtime": "rust"}`,
+			wantStatus: 400,
+			wantBody:   "Invalid request payload",
+		},
+		{
+			name:       "task type of other runtime",
+			body:       `{"name": "other", "runtime": "go", "task_types": ["process_image"]}`,
+			wantStatus: 400,
+			wantBody:   "can not run on go workers",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/queues", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Contains(t, w.Body.String(), tt.wantBody)
+		})
+	}
+
+	req, _ := http.NewRequest("GET", "/queues", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "handler_test_queue")
+}
+
+func TestQueueStateHandlerLocal(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	r := newTestRouter(rdb)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	task := `{"type": "process_image", "payload": "test", "priority": 1}`
+
+	w := post("/queues/python_queue/drain", "")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "draining")
+	assert.Equal(t, 503, post("/tasks", task).Code, "draining queues must reject new tasks")
+
+	assert.Equal(t, 200, post("/queues/python_queue/pause", "").Code)
+	assert.Equal(t, 201, post("/tasks", task).Code, "paused queues must keep accepting tasks")
+
+	assert.Equal(t, 200, post("/queues/python_queue/resume", "").Code)
+	assert.Equal(t, 404, post("/queues/missing/pause", "").Code)
+	_, _ = queue.PythonLocalQueue.Pop()
+}
+
+func TestQueueStatsHandlerLocal(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	r := newTestRouter(rdb)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, priority := range []int{2, 5, 5} {
+		task := fmt.Sprintf(`{"type": "process_image", "payload": "test", "priority": %d}`, priority)
+		req, _ := http.NewRequest("POST", "/tasks", bytes.NewBufferString(task))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	defer func() {
+		for queue.PythonLocalQueue.Len() > 0 {
+			_, _ = queue.PythonLocalQueue.Pop()
+		}
+	}()
+
+	w := get("/queues/python_queue")
+	assert.Equal(t, 200, w.Code)
+	var view struct {
+		Name  string           `json:"name"`
+		State tasks.QueueState `json:"state"`
+		Stats tasks.QueueStats `json:"stats"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &view))
+	assert.Equal(t, "python_queue", view.Name)
+	assert.Equal(t, tasks.QueueActive, view.State)
+	assert.Equal(t, 3, view.Stats.Depth)
+	assert.Equal(t, map[uint16]int{2: 1, 5: 2}, view.Stats.Priorities)
+	assert.Equal(t, 300, view.Stats.WindowSeconds)
+
+	w = get("/queues")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"oldest_age_ms"`)
+	assert.Contains(t, w.Body.String(), `"concurrency"`)
+	assert.Equal(t, 404, get("/queues/missing").Code)
+}
+
+func TestWorkerListHandlerLocal(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	r := newTestRouter(rdb)
+
+	get := func() []tasks.WorkerInfo {
+		req, _ := http.NewRequest("GET", "/workers", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+		var body struct {
+			Workers []tasks.WorkerInfo `json:"workers"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body.Workers
+	}
+
+	assert.Empty(t, get())
+	assert.NoError(t, tasks.RecordHeartbeat(tasks.WorkerInfo{
+		ID:           "handler_test_worker",
+		Runtime:      tasks.GoRuntime,
+		Queue:        tasks.GoQueue,
+		CurrentTasks: []string{"t1"},
+		Processed:    2,
+	}, nil))
+	defer tasks.RemoveWorker("handler_test_worker", nil)
+
+	workers := get()
+	if assert.Len(t, workers, 1) {
+		assert.Equal(t, "handler_test_worker", workers[0].ID)
+		assert.Equal(t, []string{"t1"}, workers[0].CurrentTasks)
+		assert.Equal(t, int64(2), workers[0].Processed)
+	}
+}
+
+func TestTaskListHandlerLocal(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	r := newTestRouter(rdb)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/tasks"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, priority := range []int{1, 2, 3} {
+		task := fmt.Sprintf(`{"type": "process_image", "payload": "test", "priority": %d}`, priority)
+		req, _ := http.NewRequest("POST", "/tasks", bytes.NewBufferString(task))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	defer func() {
+		for queue.PythonLocalQueue.Len() > 0 {
+			_, _ = queue.PythonLocalQueue.Pop()
+		}
+	}()
+
+	w := get("?queue=python_queue&state=queued&sort=priority&order=desc&limit=2")
+	assert.Equal(t, 200, w.Code)
+	var page tasks.TaskPage
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	if assert.Len(t, page.Tasks, 2) {
+		assert.Equal(t, uint16(3), page.Tasks[0].Task.Priority)
+		assert.Equal(t, tasks.TaskQueued, page.Tasks[0].State)
+	}
+	assert.NotEmpty(t, page.NextCursor)
+
+	w = get("?queue=python_queue&state=queued&sort=priority&order=desc&limit=2&cursor=" + page.NextCursor)
+	assert.Equal(t, 200, w.Code)
+	page = tasks.TaskPage{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	if assert.Len(t, page.Tasks, 1) {
+		assert.Equal(t, uint16(1), page.Tasks[0].Task.Priority)
+	}
+	assert.Empty(t, page.NextCursor)
+	assert.Equal(t, 3, queue.PythonLocalQueue.Len(), "Listing should not take tasks from the queue")
+
+	assert.Equal(t, 400, get("?state=lost").Code)
+	assert.Equal(t, 400, get("?queue=missing").Code)
+	assert.Equal(t, 400, get("?sort=type").Code)
+	assert.Equal(t, 400, get("?limit=1000").Code)
+	assert.Equal(t, 400, get("?created_after=yesterday").Code)
+	assert.Equal(t, 400, get("?cursor=bogus").Code)
+}
+
+func TestTaskCallbackHandlerLocal(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	r := newTestRouter(rdb)
+
+	post := func(task string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/tasks", bytes.NewBufferString(task))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, 400, post(`{"type": "process_image", "payload": "test", "priority": 1, "callback": {"url": "ftp://example.com"}}`).Code)
+	assert.Equal(t, 400, post(`{"type": "process_image", "payload": "test", "priority": 1, "callback": {"url": "http://example.com", "events": ["started"]}}`).Code)
+
+	w := post(`{"type": "process_image", "payload": "test", "priority": 1, "callback": {"url": "http://example.com/hook", "secret": "s3cret"}}`)
+	require.Equal(t, 201, w.Code)
+	defer func() {
+		for queue.PythonLocalQueue.Len() > 0 {
+			_, _ = queue.PythonLocalQueue.Pop()
+		}
+	}()
+
+	w = get("/tasks?queue=python_queue")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "http://example.com/hook")
+	assert.NotContains(t, w.Body.String(), "s3cret", "Listings should not reveal callback secrets")
+
+	task, exists := queue.PythonLocalQueue.Pop()
+	require.True(t, exists)
+	assert.Equal(t, 404, get("/tasks/"+task.ID+"/callback").Code, "Unfinished tasks should have no delivery")
+	require.NoError(t, tasks.RecordResult("python_queue", task, "w1", nil, rdb))
+
+	w = get("/tasks/" + task.ID + "/callback")
+	assert.Equal(t, 200, w.Code)
+	var delivery callbacks.Delivery
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &delivery))
+	assert.Equal(t, "http://example.com/hook", delivery.URL)
+	assert.Equal(t, "succeeded", delivery.Event)
+	assert.Equal(t, callbacks.DeliveryPending, delivery.State)
+	assert.NotContains(t, w.Body.String(), "s3cret")
+
+	assert.Equal(t, 404, get("/tasks/missing/callback").Code)
+}
+
+func TestTaskBatchHandlerLocal(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	r := newTestRouter(rdb)
+	defer func() {
+		for queue.PythonLocalQueue.Len() > 0 {
+			_, _ = queue.PythonLocalQueue.Pop()
+		}
+	}()
+
+	post := func(body string) (int, map[string]any) {
+		req, _ := http.NewRequest("POST", "/tasks/batch", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp map[string]any
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	valid := `{"type": "process_image", "payload": "test", "priority": 1}`
+	invalid := `{"type": "unknown", "payload": "test", "priority": 1}`
+
+	code, resp := post(`{"tasks": [` + valid + `,` + valid + `]}`)
+	assert.Equal(t, 201, code)
+	assert.Equal(t, float64(2), resp["enqueued"])
+	assert.Equal(t, 2, queue.PythonLocalQueue.Len())
+
+	code, resp = post(`{"tasks": [` + valid + `,` + invalid + `]}`)
+	assert.Equal(t, 207, code)
+	results := resp["results"].([]any)
+	if assert.Len(t, results, 2) {
+		assert.NotEmpty(t, results[0].(map[string]any)["id"])
+		assert.Contains(t, results[1].(map[string]any)["error"], "invalid task type")
+	}
+	assert.Equal(t, 3, queue.PythonLocalQueue.Len())
+
+	code, resp = post(`{"atomic": true, "tasks": [` + valid + `,` + invalid + `]}`)
+	assert.Equal(t, 422, code)
+	assert.Equal(t, float64(0), resp["enqueued"])
+	assert.Equal(t, 3, queue.PythonLocalQueue.Len(), "A failed atomic batch must not enqueue any task")
+
+	code, _ = post(`{"tasks": []}`)
+	assert.Equal(t, 400, code)
+}
+
+func TestTaskTypeAllowlistLocal(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	defer func() {
+		for queue.PythonLocalQueue.Len() > 0 {
+			_, _ = queue.PythonLocalQueue.Pop()
+		}
+	}()
+	// requests as authenticated by auth.GinMiddleware with a key limited to process_image
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		key := auth.Key{Scopes: []auth.Scope{auth.ScopeEnqueue}, TaskTypes: []string{"process_image"}}
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), key))
+	})
+	r.POST("/tasks", TaskEnqueueHandler(rdb))
+	r.POST("/tasks/batch", TaskBatchHandler(rdb))
+
+	post := func(path, body string) (int, map[string]any) {
+		req, _ := http.NewRequest("POST", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp map[string]any
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	allowed := `{"type": "process_image", "payload": "test", "priority": 1}`
+	forbidden := `{"type": "download_file", "payload": {"url": "https://a.com/1", "filename": "a.json"}, "priority": 1}`
+
+	code, _ := post("/tasks", allowed)
+	assert.Equal(t, 201, code)
+	code, resp := post("/tasks", forbidden)
+	assert.Equal(t, 403, code)
+	assert.Equal(t, "download_file", resp["type"])
+
+	code, resp = post("/tasks/batch", `{"tasks": [`+allowed+`,`+forbidden+`]}`)
+	assert.Equal(t, 207, code)
+	results := resp["results"].([]any)
+	if assert.Len(t, results, 2) {
+		assert.Contains(t, results[1].(map[string]any)["error"], auth.ErrTaskTypeForbidden.Error())
+	}
+	assert.Equal(t, 2, queue.PythonLocalQueue.Len())
+}
+
+func TestEventHandlersLocal(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	srv := httptest.NewServer(newTestRouter(rdb))
+	defer srv.Close()
+	defer func() {
+		for queue.PythonLocalQueue.Len() > 0 {
+			_, _ = queue.PythonLocalQueue.Pop()
+		}
+	}()
+
+	resp, err := http.Get(srv.URL + "/events?event=started,unknown")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 400, resp.StatusCode)
+
+	resp, err = http.Get(srv.URL + "/events?event=enqueued&type=process_image")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	ws, err := websocket.Dial(strings.Replace(srv.URL, "http", "ws", 1)+"/events/ws?queue=python_queue", "", srv.URL)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	enqueue := func(taskType string) {
+		req, _ := http.NewRequest("POST", srv.URL+"/tasks", bytes.NewBufferString(`{"type": "`+taskType+`", "payload": "test", "priority": 1}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, 201, resp.StatusCode)
+	}
+	// filtered out by the type of the stream
+	enqueue("send_email")
+	enqueue("process_image")
+
+	lines := bufio.NewScanner(resp.Body)
+	require.True(t, lines.Scan())
+	assert.Equal(t, "event:enqueued", lines.Text())
+	require.True(t, lines.Scan())
+	var event events.Event
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(lines.Text(), "data:")), &event))
+	assert.Equal(t, "process_image", event.TaskType)
+
+	require.NoError(t, ws.SetReadDeadline(time.Now().Add(time.Second)))
+	require.NoError(t, websocket.JSON.Receive(ws, &event))
+	assert.Equal(t, events.Enqueued, event.Type)
+	assert.Equal(t, "python_queue", event.Queue)
+}
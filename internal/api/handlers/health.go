@@ -3,6 +3,7 @@ package handlers
 import (
 	"net/http"
 
+	"github.com/Yulian302/qugopy/internal/readiness"
 	"github.com/gin-gonic/gin"
 )
 
@@ -11,3 +12,13 @@ func HealthCheckHandler(c *gin.Context) {
 		"health": "ok",
 	})
 }
+
+// ReadinessHandler answers 200 once the app serves requests and 503 while it
+// starts or shuts down, for load balancers and container probes.
+func ReadinessHandler(c *gin.Context) {
+	if !readiness.Ready() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"ready": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ready": true})
+}
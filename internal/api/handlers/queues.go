@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+)
+
+type queueView struct {
+	tasks.QueueSpec
+	State tasks.QueueState `json:"state"`
+	Stats tasks.QueueStats `json:"stats"`
+}
+
+func newQueueView(spec tasks.QueueSpec, rdb *redis.Client) (queueView, error) {
+	state, err := tasks.GetQueueState(spec.Name, rdb)
+	if err != nil {
+		return queueView{}, err
+	}
+	stats, err := tasks.GetQueueStats(spec.Name, rdb)
+	if err != nil {
+		return queueView{}, err
+	}
+	return queueView{QueueSpec: spec, State: state, Stats: stats}, nil
+}
+
+// QueueListHandler lists the declared queues with their state and statistics.
+func QueueListHandler(rdb *redis.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		specs := tasks.Queues()
+		queues := make([]queueView, 0, len(specs))
+		for _, spec := range specs {
+			view, err := newQueueView(spec, rdb)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			queues = append(queues, view)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"queues": queues,
+		})
+	}
+}
+
+// QueueGetHandler returns the queue named in the path with its state and statistics.
+func QueueGetHandler(rdb *redis.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		spec, exists := tasks.GetQueue(tasks.QueueType(c.Param("name")))
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("%v: %s", tasks.ErrUnknownQueue, c.Param("name"))})
+			return
+		}
+		view, err := newQueueView(spec, rdb)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, view)
+	}
+}
+
+func QueueDeclareHandler(rdb *redis.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var spec tasks.QueueSpec
+
+		if err := c.ShouldBindJSON(&spec); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request payload",
+				"details": err.Error(),
+			})
+			return
+		}
+		err := tasks.DeclareQueue(spec, rdb)
+		if errors.Is(err, tasks.ErrQueueExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"status": "Queue created!",
+			"queue":  spec,
+		})
+	}
+}
+
+// QueueStateHandler sets the state of the queue named in the path, e.g.
+// `POST /queues/go_queue/pause` with state QueuePaused.
+func QueueStateHandler(state tasks.QueueState, rdb *redis.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := tasks.QueueType(c.Param("name"))
+
+		err := tasks.SetQueueState(name, state, rdb)
+		if errors.Is(err, tasks.ErrUnknownQueue) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"queue": name,
+			"state": state,
+		})
+	}
+}
@@ -1,11 +1,20 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/Yulian302/qugopy/internal/auth"
+	"github.com/Yulian302/qugopy/internal/callbacks"
 	"github.com/Yulian302/qugopy/internal/tasks"
 	"github.com/Yulian302/qugopy/models"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/go-redis/redis"
 )
 
@@ -20,7 +29,31 @@ func TaskEnqueueHandler(rdb *redis.Client) gin.HandlerFunc {
 			})
 			return
 		}
-		err := tasks.EnqueueTask(task, rdb)
+		// task types of external workers are only known at runtime
+		if !models.TaskType(task.Type).IsValid() {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request payload",
+				"details": "invalid task type: " + task.Type,
+			})
+			return
+		}
+		if !auth.AllowsTaskType(c.Request.Context(), task.Type) {
+			c.JSON(http.StatusForbidden, gin.H{"error": auth.ErrTaskTypeForbidden.Error(), "type": task.Type})
+			return
+		}
+		id, err := tasks.EnqueueContext(c.Request.Context(), task, rdb)
+		if errors.Is(err, tasks.ErrDuplicateTask) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, tasks.ErrQueueDraining) || errors.Is(err, tasks.ErrShuttingDown) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, tasks.ErrTenantQuotaExceeded) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -28,9 +61,234 @@ func TaskEnqueueHandler(rdb *redis.Client) gin.HandlerFunc {
 
 		c.JSON(http.StatusCreated, gin.H{
 			"status":   "Task enqueued!",
+			"id":       id,
 			"priority": task.Priority,
 			"type":     task.Type,
 		})
 	}
 
 }
+
+// taskListQuery are the query parameters of TaskListHandler. Lists are comma
+// separated and times are RFC 3339.
+type taskListQuery struct {
+	State         string    `form:"state"`
+	Type          string    `form:"type"`
+	Queue         string    `form:"queue"`
+	Tenant        string    `form:"tenant"`
+	MinPriority   uint16    `form:"min_priority"`
+	MaxPriority   uint16    `form:"max_priority"`
+	CreatedAfter  time.Time `form:"created_after"`
+	CreatedBefore time.Time `form:"created_before"`
+	Sort          string    `form:"sort" binding:"omitempty,oneof=created_at priority"`
+	Order         string    `form:"order" binding:"omitempty,oneof=asc desc"`
+	Limit         int       `form:"limit" binding:"omitempty,min=1,max=500"`
+	Cursor        string    `form:"cursor"`
+}
+
+func splitList(raw string) []string {
+	if raw == "" {
+		return nil
+	}
+	return strings.Split(raw, ",")
+}
+
+// TaskListHandler lists the queued, deferred and running tasks matching the
+// query, oldest first unless sorted otherwise. Further pages are fetched by
+// passing the returned next_cursor as cursor.
+func TaskListHandler(rdb *redis.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var query taskListQuery
+
+		if err := c.ShouldBindQuery(&query); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid query",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		filter := tasks.TaskFilter{
+			Types:         splitList(query.Type),
+			Tenant:        query.Tenant,
+			MinPriority:   query.MinPriority,
+			MaxPriority:   query.MaxPriority,
+			CreatedAfter:  query.CreatedAfter,
+			CreatedBefore: query.CreatedBefore,
+		}
+		for _, state := range splitList(query.State) {
+			if !slices.Contains(tasks.TaskStates, tasks.TaskState(state)) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error":   "Invalid query",
+					"details": "invalid task state: " + state,
+				})
+				return
+			}
+			filter.States = append(filter.States, tasks.TaskState(state))
+		}
+		for _, name := range splitList(query.Queue) {
+			filter.Queues = append(filter.Queues, tasks.QueueType(name))
+		}
+
+		page, err := tasks.ListTasks(tasks.TaskListOptions{
+			Filter: filter,
+			Sort:   tasks.TaskSort(query.Sort),
+			Desc:   query.Order == "desc",
+			Limit:  query.Limit,
+			Cursor: query.Cursor,
+		}, rdb)
+		if errors.Is(err, tasks.ErrUnknownQueue) || errors.Is(err, tasks.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid query",
+				"details": err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if page.Tasks == nil {
+			page.Tasks = []tasks.TaskRecord{}
+		}
+		for i, record := range page.Tasks {
+			if record.Task.Callback != nil {
+				// secrets of callbacks are never returned
+				callback := *record.Task.Callback
+				callback.Secret = ""
+				page.Tasks[i].Task.Callback = &callback
+			}
+		}
+		c.JSON(http.StatusOK, page)
+	}
+}
+
+// TaskCallbackHandler returns the delivery of the callback of a finished task,
+// including its attempts so far. Responds 404 if the task has no callback, did
+// not finish yet or finished more than callbacks.DeliveryTTL ago.
+func TaskCallbackHandler(rdb *redis.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		delivery, err := callbacks.GetDelivery(c.Param("id"), rdb)
+		if errors.Is(err, callbacks.ErrDeliveryNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, delivery)
+	}
+}
+
+// taskBatchRequest is the body of TaskBatchHandler. Tasks are decoded one by
+// one, so that an invalid task only fails its own result.
+type taskBatchRequest struct {
+	Tasks  []json.RawMessage `json:"tasks" binding:"required,min=1"`
+	Atomic bool              `json:"atomic"`
+}
+
+type taskBatchResult struct {
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// decodeBatchTask decodes and validates a task of a batch like
+// TaskEnqueueHandler does.
+func decodeBatchTask(raw json.RawMessage) (models.Task, error) {
+	var task models.Task
+	if err := json.Unmarshal(raw, &task); err != nil {
+		return task, fmt.Errorf("invalid request payload: %w", err)
+	}
+	if err := binding.Validator.ValidateStruct(&task); err != nil {
+		return task, fmt.Errorf("invalid request payload: %w", err)
+	}
+	if !models.TaskType(task.Type).IsValid() {
+		return task, fmt.Errorf("invalid request payload: invalid task type: %s", task.Type)
+	}
+	return task, nil
+}
+
+// TaskBatchHandler enqueues up to tasks.MaxBatchSize tasks at once and returns
+// the ID or error of each, in order. Responds 201 if all tasks were enqueued and
+// 207 otherwise. An atomic batch enqueues no task if any of them fails and
+// responds 422.
+func TaskBatchHandler(rdb *redis.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req taskBatchRequest
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request payload",
+				"details": err.Error(),
+			})
+			return
+		}
+		if len(req.Tasks) > tasks.MaxBatchSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": tasks.ErrBatchTooLarge.Error()})
+			return
+		}
+
+		results := make([]tasks.BatchResult, len(req.Tasks))
+		var batch []models.Task
+		// positions of the tasks of batch in the request
+		var positions []int
+		for i, raw := range req.Tasks {
+			task, err := decodeBatchTask(raw)
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+			if !auth.AllowsTaskType(c.Request.Context(), task.Type) {
+				results[i].Err = fmt.Errorf("%w: %s", auth.ErrTaskTypeForbidden, task.Type)
+				continue
+			}
+			batch = append(batch, task)
+			positions = append(positions, i)
+		}
+
+		aborted := req.Atomic && len(batch) < len(req.Tasks)
+		if aborted {
+			for _, i := range positions {
+				results[i].Err = tasks.ErrBatchAborted
+			}
+		} else {
+			enqueued, err := tasks.EnqueueBatchContext(c.Request.Context(), batch, req.Atomic, rdb)
+			if errors.Is(err, tasks.ErrShuttingDown) {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			for j, result := range enqueued {
+				results[positions[j]] = result
+			}
+		}
+
+		body := make([]taskBatchResult, len(results))
+		failed := 0
+		for i, result := range results {
+			if result.Err != nil {
+				body[i].Error = result.Err.Error()
+				failed++
+				continue
+			}
+			body[i].ID = result.ID
+		}
+
+		code := http.StatusCreated
+		switch {
+		case failed > 0 && req.Atomic:
+			code = http.StatusUnprocessableEntity
+		case failed > 0:
+			code = http.StatusMultiStatus
+		}
+		c.JSON(code, gin.H{
+			"enqueued": len(results) - failed,
+			"failed":   failed,
+			"results":  body,
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+)
+
+// WorkerListHandler returns the workers which recently sent a heartbeat, with
+// the tasks they are running and their counters.
+func WorkerListHandler(rdb *redis.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		workers, err := tasks.ListWorkers(rdb)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if workers == nil {
+			workers = []tasks.WorkerInfo{}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"workers": workers,
+		})
+	}
+}
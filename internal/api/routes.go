@@ -1,21 +1,50 @@
 package api
 
 import (
+	"github.com/Yulian302/qugopy/config"
 	"github.com/Yulian302/qugopy/internal/api/handlers"
+	"github.com/Yulian302/qugopy/internal/auth"
+	"github.com/Yulian302/qugopy/internal/metrics"
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/Yulian302/qugopy/internal/tracing"
+	"github.com/Yulian302/qugopy/logging"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis"
 )
 
 func NewRouter(rdb *redis.Client) *gin.Engine {
-	router := gin.Default()
+	router := gin.New()
 
 	router.Use(
-		gin.Logger(),
+		logging.GinMiddleware(),
 		gin.Recovery(),
+		metrics.GinMiddleware(),
+		tracing.GinMiddleware(),
 	)
 
+	// health checks and metrics are not authenticated
+	enqueue := auth.GinMiddleware(auth.ScopeEnqueue, rdb)
+	read := auth.GinMiddleware(auth.ScopeRead, rdb)
+	admin := auth.GinMiddleware(auth.ScopeAdmin, rdb)
+
 	router.GET("/test", handlers.HealthCheckHandler)
-	router.POST("/tasks", handlers.TaskEnqueueHandler(rdb))
+	router.GET("/ready", handlers.ReadinessHandler)
+	router.POST("/tasks", enqueue, handlers.TaskEnqueueHandler(rdb))
+	router.GET("/tasks", read, handlers.TaskListHandler(rdb))
+	router.POST("/tasks/batch", enqueue, handlers.TaskBatchHandler(rdb))
+	router.GET("/tasks/:id/callback", read, handlers.TaskCallbackHandler(rdb))
+	router.GET("/queues", read, handlers.QueueListHandler(rdb))
+	router.POST("/queues", admin, handlers.QueueDeclareHandler(rdb))
+	router.GET("/queues/:name", read, handlers.QueueGetHandler(rdb))
+	router.POST("/queues/:name/pause", admin, handlers.QueueStateHandler(tasks.QueuePaused, rdb))
+	router.POST("/queues/:name/resume", admin, handlers.QueueStateHandler(tasks.QueueActive, rdb))
+	router.POST("/queues/:name/drain", admin, handlers.QueueStateHandler(tasks.QueueDraining, rdb))
+	router.GET("/workers", read, handlers.WorkerListHandler(rdb))
+	router.GET("/events", read, handlers.EventStreamHandler)
+	router.GET("/events/ws", read, handlers.EventSocketHandler)
+	if config.AppConfig.METRICS_ADDR == "" {
+		router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	}
 
 	return router
 }
@@ -0,0 +1,180 @@
+// Package auth authenticates REST and gRPC calls with API keys.
+//
+// A key is shown once when it is created and only its SHA-256 hash is stored,
+// in API_KEYS_FILE or, in redis mode, in Redis. Keys grant scopes and may be
+// limited to some task types. Calls are authenticated by GinMiddleware and the
+// gRPC interceptors while AUTH_ENABLED is set.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Scope is a permission granted by a key.
+type Scope string
+
+const (
+	// ScopeEnqueue enqueues tasks.
+	ScopeEnqueue Scope = "enqueue"
+	// ScopeRead reads tasks, queues, workers and events.
+	ScopeRead Scope = "read"
+	// ScopeWorker takes and completes tasks over gRPC, as workers do.
+	ScopeWorker Scope = "worker"
+	// ScopeAdmin grants all scopes and declares and controls queues.
+	ScopeAdmin Scope = "admin"
+)
+
+var scopes = []Scope{ScopeEnqueue, ScopeRead, ScopeWorker, ScopeAdmin}
+
+// ParseScopes parses scope names, e.g. `enqueue,read`.
+func ParseScopes(names []string) ([]Scope, error) {
+	parsed := make([]Scope, 0, len(names))
+	for _, name := range names {
+		scope := Scope(strings.ToLower(strings.TrimSpace(name)))
+		if !slices.Contains(scopes, scope) {
+			return nil, fmt.Errorf("invalid scope %q, must be one of enqueue, read, worker or admin", name)
+		}
+		if !slices.Contains(parsed, scope) {
+			parsed = append(parsed, scope)
+		}
+	}
+	if len(parsed) == 0 {
+		return nil, errors.New("a key needs at least one scope")
+	}
+	return parsed, nil
+}
+
+// Key is a stored API key. The key itself is `qk_<id>_<secret>`, of which
+// only the hash is kept.
+type Key struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Hash is the hex SHA-256 of the key.
+	Hash   string  `json:"hash"`
+	Scopes []Scope `json:"scopes"`
+	// TaskTypes the key may enqueue. Empty allows all types.
+	TaskTypes []string  `json:"task_types,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// HasScope tells whether the key grants scope.
+func (k Key) HasScope(scope Scope) bool {
+	return slices.Contains(k.Scopes, ScopeAdmin) || slices.Contains(k.Scopes, scope)
+}
+
+// AllowsTaskType tells whether the key may enqueue tasks of a type.
+func (k Key) AllowsTaskType(taskType string) bool {
+	return len(k.TaskTypes) == 0 || slices.Contains(k.TaskTypes, taskType)
+}
+
+var (
+	// ErrUnauthenticated is returned for a missing, malformed, unknown or
+	// revoked key.
+	ErrUnauthenticated = errors.New("missing or invalid API key")
+	// ErrForbidden is returned for a key without the scope of a call.
+	ErrForbidden = errors.New("API key does not grant this call")
+	// ErrTaskTypeForbidden is returned for a key enqueuing a task type it is
+	// not allowed to.
+	ErrTaskTypeForbidden = errors.New("API key may not enqueue this task type")
+	// ErrKeyNotFound is returned when revoking an unknown key.
+	ErrKeyNotFound = errors.New("API key not found")
+)
+
+const keyPrefix = "qk_"
+
+// Hash returns the hash a key is stored by.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewKey generates a key and returns it with its record, which is not stored.
+func NewKey(name string, scopes []Scope, taskTypes []string) (Key, string, error) {
+	id, err := randomHex(6)
+	if err != nil {
+		return Key{}, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return Key{}, "", err
+	}
+	key := keyPrefix + id + "_" + secret
+	return Key{
+		ID:        id,
+		Name:      name,
+		Hash:      Hash(key),
+		Scopes:    scopes,
+		TaskTypes: taskTypes,
+		CreatedAt: time.Now().UTC(),
+	}, key, nil
+}
+
+// keyID returns the ID part of a key.
+func keyID(key string) (string, bool) {
+	rest, found := strings.CutPrefix(key, keyPrefix)
+	if !found {
+		return "", false
+	}
+	id, secret, found := strings.Cut(rest, "_")
+	return id, found && id != "" && secret != ""
+}
+
+var (
+	workerKeyOnce sync.Once
+	workerKey     string
+	workerRecord  Key
+)
+
+// WorkerKey returns the key of the Python workers this process starts. It
+// only exists in memory and is valid until the process exits.
+func WorkerKey() string {
+	workerKeyOnce.Do(func() {
+		var err error
+		workerRecord, workerKey, err = NewKey("workers", []Scope{ScopeWorker}, nil)
+		if err != nil {
+			panic(fmt.Sprintf("could not generate worker key: %v", err))
+		}
+	})
+	return workerKey
+}
+
+// Authenticate returns the stored record of key. Keys are looked up in
+// API_KEYS_FILE and, if rdb is set, in Redis.
+func Authenticate(key string, rdb *redis.Client) (Key, error) {
+	id, valid := keyID(key)
+	if !valid {
+		return Key{}, ErrUnauthenticated
+	}
+	WorkerKey() // generated on first use
+	record, exists := workerRecord, id == workerRecord.ID
+	if !exists {
+		var err error
+		if record, exists, err = lookup(id, rdb); err != nil {
+			return Key{}, err
+		}
+	}
+	if !exists || subtle.ConstantTimeCompare([]byte(Hash(key)), []byte(record.Hash)) != 1 {
+		return Key{}, ErrUnauthenticated
+	}
+	return record, nil
+}
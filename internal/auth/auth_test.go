@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useKeysFile makes the test store keys in a file of its own.
+func useKeysFile(t *testing.T) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "api_keys.json")
+	previous := config.AppConfig.API_KEYS_FILE
+	config.AppConfig.API_KEYS_FILE = file
+	t.Cleanup(func() { config.AppConfig.API_KEYS_FILE = previous })
+	return file
+}
+
+// createKey stores a new key in the keys file and returns it.
+func createKey(t *testing.T, scopes []Scope, taskTypes []string) (Key, string) {
+	t.Helper()
+	record, key, err := NewKey("test", scopes, taskTypes)
+	require.NoError(t, err)
+	require.NoError(t, Save(record, nil))
+	return record, key
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes([]string{"enqueue", " READ", "enqueue"})
+	require.NoError(t, err)
+	assert.Equal(t, []Scope{ScopeEnqueue, ScopeRead}, scopes)
+
+	_, err = ParseScopes([]string{"write"})
+	assert.Error(t, err)
+	_, err = ParseScopes(nil)
+	assert.Error(t, err)
+}
+
+func TestKeyPermissions(t *testing.T) {
+	key := Key{Scopes: []Scope{ScopeEnqueue}, TaskTypes: []string{"send_email"}}
+	assert.True(t, key.HasScope(ScopeEnqueue))
+	assert.False(t, key.HasScope(ScopeRead))
+	assert.True(t, key.AllowsTaskType("send_email"))
+	assert.False(t, key.AllowsTaskType("process_image"))
+
+	admin := Key{Scopes: []Scope{ScopeAdmin}}
+	assert.True(t, admin.HasScope(ScopeWorker), "Admin keys should grant all scopes")
+	assert.True(t, admin.AllowsTaskType("process_image"))
+}
+
+func TestAuthenticate(t *testing.T) {
+	file := useKeysFile(t)
+	_, err := Authenticate("qk_missing_file", nil)
+	assert.ErrorIs(t, err, ErrUnauthenticated, "A missing keys file should hold no keys")
+
+	record, key, err := NewKey("ci", []Scope{ScopeEnqueue}, []string{"send_email"})
+	require.NoError(t, err)
+	require.NoError(t, Save(record, nil))
+
+	authenticated, err := Authenticate(key, nil)
+	require.NoError(t, err)
+	assert.Equal(t, record.ID, authenticated.ID)
+	assert.Equal(t, []string{"send_email"}, authenticated.TaskTypes)
+
+	raw, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), key, "Only the hash of a key should be stored")
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	for _, invalid := range []string{"", "secret", "qk_" + record.ID, "qk_" + record.ID + "_wrong", key + "x"} {
+		_, err := Authenticate(invalid, nil)
+		assert.ErrorIs(t, err, ErrUnauthenticated, invalid)
+	}
+
+	worker, err := Authenticate(WorkerKey(), nil)
+	require.NoError(t, err)
+	assert.True(t, worker.HasScope(ScopeWorker))
+	assert.False(t, worker.HasScope(ScopeEnqueue))
+}
+
+func TestRevoke(t *testing.T) {
+	useKeysFile(t)
+	first, firstKey := createKey(t, []Scope{ScopeRead}, nil)
+	second, secondKey := createKey(t, []Scope{ScopeAdmin}, nil)
+
+	keys, err := List(nil)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, first.ID, keys[0].ID)
+
+	require.NoError(t, Revoke(first.ID, nil))
+	_, err = Authenticate(firstKey, nil)
+	assert.ErrorIs(t, err, ErrUnauthenticated, "Revoked keys should be rejected")
+	_, err = Authenticate(secondKey, nil)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, Revoke(first.ID, nil), ErrKeyNotFound)
+
+	keys, err = List(nil)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, second.ID, keys[0].ID)
+}
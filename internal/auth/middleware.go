@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type keyContextKey struct{}
+
+// NewContext returns ctx carrying the key a call was authenticated with.
+func NewContext(ctx context.Context, key Key) context.Context {
+	return context.WithValue(ctx, keyContextKey{}, key)
+}
+
+// FromContext returns the key a call was authenticated with. The boolean is
+// false if the call was not authenticated, e.g. while AUTH_ENABLED is not set.
+func FromContext(ctx context.Context) (Key, bool) {
+	key, ok := ctx.Value(keyContextKey{}).(Key)
+	return key, ok
+}
+
+// AllowsTaskType tells whether the key of ctx may enqueue tasks of a type.
+// Calls which were not authenticated may enqueue all types.
+func AllowsTaskType(ctx context.Context, taskType string) bool {
+	key, ok := FromContext(ctx)
+	return !ok || key.AllowsTaskType(taskType)
+}
+
+// bearer returns the key of an `Authorization: Bearer` or `X-API-Key` value.
+func bearer(authorization, apiKey string) string {
+	if token, found := strings.CutPrefix(authorization, "Bearer "); found {
+		return strings.TrimSpace(token)
+	}
+	return strings.TrimSpace(apiKey)
+}
+
+// authorize authenticates key and checks that it grants scope.
+func authorize(key string, scope Scope, rdb *redis.Client) (Key, error) {
+	record, err := Authenticate(key, rdb)
+	if err != nil {
+		return Key{}, err
+	}
+	if !record.HasScope(scope) {
+		return Key{}, ErrForbidden
+	}
+	return record, nil
+}
+
+// GinMiddleware authenticates requests with the key of their Authorization or
+// X-API-Key header and rejects those whose key does not grant scope. It does
+// nothing while AUTH_ENABLED is not set.
+func GinMiddleware(scope Scope, rdb *redis.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !config.AppConfig.AUTH_ENABLED {
+			c.Next()
+			return
+		}
+		key, err := authorize(bearer(c.GetHeader("Authorization"), c.GetHeader("X-API-Key")), scope, rdb)
+		switch {
+		case errors.Is(err, ErrUnauthenticated):
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, ErrForbidden):
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error(), "scope": scope})
+			return
+		case err != nil:
+			slog.ErrorContext(c.Request.Context(), "could not authenticate request", logging.Err(err))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "could not authenticate request"})
+			return
+		}
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), key))
+		c.Next()
+	}
+}
+
+// methodScopes are the scopes of the methods of the task service. Other
+// methods need the admin scope.
+var methodScopes = map[string]Scope{
+	"GetTask":       ScopeWorker,
+	"GetGoTask":     ScopeWorker,
+	"GetPythonTask": ScopeWorker,
+	"CompleteTask":  ScopeWorker,
+	"Heartbeat":     ScopeWorker,
+	"ReportMetrics": ScopeWorker,
+	"Enqueue":       ScopeEnqueue,
+	"EnqueueBatch":  ScopeEnqueue,
+	"GetTaskStatus": ScopeRead,
+	"WatchTask":     ScopeRead,
+}
+
+// methodScope returns the scope of a full gRPC method name.
+func methodScope(fullMethod string) Scope {
+	if scope, exists := methodScopes[path.Base(fullMethod)]; exists {
+		return scope
+	}
+	return ScopeAdmin
+}
+
+// authorizeCall authenticates a gRPC call with the key of its authorization or
+// x-api-key metadata and returns ctx carrying the key.
+func authorizeCall(ctx context.Context, fullMethod string, rdb *redis.Client) (context.Context, error) {
+	if !config.AppConfig.AUTH_ENABLED {
+		return ctx, nil
+	}
+	var authorization, apiKey string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+		if values := md.Get("x-api-key"); len(values) > 0 {
+			apiKey = values[0]
+		}
+	}
+	key, err := authorize(bearer(authorization, apiKey), methodScope(fullMethod), rdb)
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, ErrForbidden):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case err != nil:
+		slog.ErrorContext(ctx, "could not authenticate call", "method", fullMethod, logging.Err(err))
+		return nil, status.Error(codes.Unavailable, "could not authenticate call")
+	}
+	return NewContext(ctx, key), nil
+}
+
+// UnaryServerInterceptor authenticates the unary calls of a gRPC server like
+// GinMiddleware.
+func UnaryServerInterceptor(rdb *redis.Client) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authorizeCall(ctx, info.FullMethod, rdb)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor authenticates the streaming calls of a gRPC server
+// like GinMiddleware.
+func StreamServerInterceptor(rdb *redis.Client) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authorizeCall(ss.Context(), info.FullMethod, rdb)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authenticatedStream is a server stream whose context carries its key.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// keyCredentials sends a key with every call of a gRPC client.
+type keyCredentials string
+
+// Credentials returns the per-call credentials of a gRPC client calling with
+// key, e.g. a standalone worker.
+func Credentials(key string) credentials.PerRPCCredentials {
+	return keyCredentials(key)
+}
+
+func (k keyCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(k)}, nil
+}
+
+// RequireTransportSecurity is false, as workers connect to the gRPC server
+// without TLS.
+func (k keyCredentials) RequireTransportSecurity() bool {
+	return false
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// enable sets AUTH_ENABLED for the test.
+func enable(t *testing.T) {
+	t.Helper()
+	config.AppConfig.AUTH_ENABLED = true
+	t.Cleanup(func() { config.AppConfig.AUTH_ENABLED = false })
+}
+
+func TestGinMiddleware(t *testing.T) {
+	useKeysFile(t)
+	_, readKey := createKey(t, []Scope{ScopeRead}, nil)
+	_, enqueueKey := createKey(t, []Scope{ScopeEnqueue}, []string{"send_email"})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/tasks", GinMiddleware(ScopeEnqueue, nil), func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{
+			"send_email":    AllowsTaskType(c.Request.Context(), "send_email"),
+			"process_image": AllowsTaskType(c.Request.Context(), "process_image"),
+		})
+	})
+	post := func(header, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/tasks", nil)
+		if key != "" {
+			req.Header.Set(header, key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("", "")
+	assert.Equal(t, http.StatusCreated, w.Code, "Requests should not be authenticated unless AUTH_ENABLED is set")
+	assert.JSONEq(t, `{"send_email": true, "process_image": true}`, w.Body.String())
+
+	enable(t)
+	w = post("", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, http.StatusUnauthorized, post("Authorization", "Bearer qk_unknown_key").Code)
+	assert.Equal(t, http.StatusForbidden, post("Authorization", "Bearer "+readKey).Code)
+
+	w = post("Authorization", "Bearer "+enqueueKey)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"send_email": true, "process_image": false}`, w.Body.String())
+	assert.Equal(t, http.StatusCreated, post("X-API-Key", enqueueKey).Code)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	useKeysFile(t)
+	_, enqueueKey := createKey(t, []Scope{ScopeEnqueue}, nil)
+	enable(t)
+
+	interceptor := UnaryServerInterceptor(nil)
+	call := func(method string, md metadata.MD) (Key, error) {
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		var key Key
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/task.TaskService/" + method}, func(ctx context.Context, req any) (any, error) {
+			key, _ = FromContext(ctx)
+			return nil, nil
+		})
+		return key, err
+	}
+
+	_, err := call("Enqueue", nil)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = call("GetTask", metadata.Pairs("authorization", "Bearer "+enqueueKey))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "Producer keys should not take tasks")
+	_, err = call("CancelTask", metadata.Pairs("authorization", "Bearer "+enqueueKey))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "Methods without a scope should need admin")
+
+	key, err := call("Enqueue", metadata.Pairs("x-api-key", enqueueKey))
+	require.NoError(t, err)
+	assert.True(t, key.HasScope(ScopeEnqueue), "Handlers should see the key of the call")
+
+	// the Python workers started by this process call with its worker key
+	md, err := Credentials(WorkerKey()).GetRequestMetadata(context.Background())
+	require.NoError(t, err)
+	_, err = call("GetPythonTask", metadata.New(md))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/go-redis/redis"
+)
+
+// KeysKey is the Redis hash of the keys of redis mode, by ID.
+const KeysKey = "qugopy:api_keys"
+
+var (
+	fileMu sync.Mutex
+	// keys of API_KEYS_FILE, read again once the file changed, so that keys
+	// managed by `qugopy keys` apply to a running server
+	fileKeys    []Key
+	filePath    string
+	fileModTime time.Time
+	fileSize    int64
+)
+
+// readFile returns the keys of API_KEYS_FILE. A missing file holds no keys.
+func readFile() ([]Key, error) {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	return readFileLocked()
+}
+
+func readFileLocked() ([]Key, error) {
+	file := config.AppConfig.API_KEYS_FILE
+	if file == "" {
+		return nil, nil
+	}
+	info, err := os.Stat(file)
+	if errors.Is(err, os.ErrNotExist) {
+		fileKeys = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read API keys: %w", err)
+	}
+	if fileKeys != nil && file == filePath && info.ModTime().Equal(fileModTime) && info.Size() == fileSize {
+		return fileKeys, nil
+	}
+
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read API keys: %w", err)
+	}
+	keys := []Key{}
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return nil, fmt.Errorf("invalid API keys file %s: %w", file, err)
+	}
+	fileKeys, filePath, fileModTime, fileSize = keys, file, info.ModTime(), info.Size()
+	return keys, nil
+}
+
+// writeFileLocked replaces the keys of API_KEYS_FILE, which only its owner may read.
+func writeFileLocked(keys []Key) error {
+	file := config.AppConfig.API_KEYS_FILE
+	raw, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	// written next to the file and renamed, so that readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".api_keys-*")
+	if err != nil {
+		return fmt.Errorf("could not write API keys: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(raw, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write API keys: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write API keys: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("could not write API keys: %w", err)
+	}
+	fileKeys = nil
+	return nil
+}
+
+// lookup returns the key with an ID from API_KEYS_FILE or Redis.
+func lookup(id string, rdb *redis.Client) (Key, bool, error) {
+	keys, err := readFile()
+	if err != nil {
+		return Key{}, false, err
+	}
+	for _, key := range keys {
+		if key.ID == id {
+			return key, true, nil
+		}
+	}
+	if rdb == nil {
+		return Key{}, false, nil
+	}
+
+	raw, err := rdb.HGet(KeysKey, id).Result()
+	if err == redis.Nil {
+		return Key{}, false, nil
+	}
+	if err != nil {
+		return Key{}, false, fmt.Errorf("could not read API key: %w", err)
+	}
+	var key Key
+	if err := json.Unmarshal([]byte(raw), &key); err != nil {
+		return Key{}, false, fmt.Errorf("invalid API key %s: %w", id, err)
+	}
+	return key, true, nil
+}
+
+// Save stores a key in Redis if rdb is set, in API_KEYS_FILE otherwise.
+func Save(key Key, rdb *redis.Client) error {
+	if rdb != nil {
+		raw, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		return rdb.HSet(KeysKey, key.ID, raw).Err()
+	}
+
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	keys, err := readFileLocked()
+	if err != nil {
+		return err
+	}
+	return writeFileLocked(append(slices.Clone(keys), key))
+}
+
+// List returns the keys of API_KEYS_FILE and, if rdb is set, of Redis, oldest
+// first.
+func List(rdb *redis.Client) ([]Key, error) {
+	keys, err := readFile()
+	if err != nil {
+		return nil, err
+	}
+	keys = slices.Clone(keys)
+	if rdb != nil {
+		raw, err := rdb.HGetAll(KeysKey).Result()
+		if err != nil {
+			return nil, fmt.Errorf("could not list API keys: %w", err)
+		}
+		for id, value := range raw {
+			var key Key
+			if err := json.Unmarshal([]byte(value), &key); err != nil {
+				return nil, fmt.Errorf("invalid API key %s: %w", id, err)
+			}
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b Key) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return keys, nil
+}
+
+// Revoke deletes a key from API_KEYS_FILE and, if rdb is set, from Redis.
+// Calls made with it fail from then on.
+func Revoke(id string, rdb *redis.Client) error {
+	revoked := false
+	if rdb != nil {
+		deleted, err := rdb.HDel(KeysKey, id).Result()
+		if err != nil {
+			return fmt.Errorf("could not revoke API key: %w", err)
+		}
+		revoked = deleted > 0
+	}
+
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	keys, err := readFileLocked()
+	if err != nil {
+		return err
+	}
+	kept := slices.DeleteFunc(slices.Clone(keys), func(key Key) bool { return key.ID == id })
+	if len(kept) < len(keys) {
+		if err := writeFileLocked(kept); err != nil {
+			return err
+		}
+		revoked = true
+	}
+	if !revoked {
+		return ErrKeyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+// Package callbacks notifies the callback URLs of tasks once they finished.
+// Notifications are recorded as deliveries, which are POSTed by Run and
+// retried with exponential backoff until they succeed or run out of attempts.
+package callbacks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/logging"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/go-redis/redis"
+)
+
+// Headers of notification requests.
+const (
+	// SignatureHeader is "sha256=" followed by the hex HMAC-SHA256 of the body,
+	// keyed with the callback's secret. Only set for callbacks with a secret.
+	SignatureHeader = "X-Qugopy-Signature"
+	EventHeader     = "X-Qugopy-Event"
+	// DeliveryHeader is the ID of the task, the same for all attempts.
+	DeliveryHeader = "X-Qugopy-Delivery"
+)
+
+const (
+	// DeliveryTTL is how long a delivery can be looked up once it was created.
+	DeliveryTTL = 24 * time.Hour
+	// DueKey is the Redis sorted set of the IDs of pending deliveries, scored by
+	// the unix milliseconds of their next attempt.
+	DueKey = "qugopy:callbacks"
+
+	defaultAttempts = 5
+	defaultTimeout  = 10 * time.Second
+	defaultBackoff  = time.Second
+	maxBackoff      = 5 * time.Minute
+
+	// interval at which Run looks for due deliveries
+	pollInterval = 200 * time.Millisecond
+	// time an attempt may take beyond CALLBACK_TIMEOUT to be recorded before
+	// its delivery is due again for other instances, see claimDue
+	leaseMargin = 30 * time.Second
+	// number of notifications sent at once
+	maxConcurrentDeliveries = 8
+)
+
+// claimScript leases up to limit due deliveries by scoring them with the end of
+// their lease, so that a delivery whose attempt is never recorded, e.g. because
+// its instance crashed, is due again once the lease ends.
+// KEYS: due set. ARGV: now (unix ms), lease end (unix ms), limit.
+var claimScript = redis.NewScript(`
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[3])
+for _, id in ipairs(ids) do
+	redis.call("ZADD", KEYS[1], ARGV[2], id)
+end
+return ids`)
+
+// ErrDeliveryNotFound is returned for a task without a delivery, e.g. because
+// it has no callback or did not finish yet.
+var ErrDeliveryNotFound = errors.New("delivery not found")
+
+// DeliveryKey is the Redis key of the delivery of a task.
+func DeliveryKey(taskID string) string {
+	return "qugopy:callback:" + taskID
+}
+
+// Notification is the JSON body POSTed to a callback.
+type Notification struct {
+	// Event is the final state of the task, see models.CallbackEvents.
+	Event    string `json:"event"`
+	TaskID   string `json:"task_id"`
+	TaskType string `json:"task_type"`
+	Queue    string `json:"queue"`
+	WorkerID string `json:"worker_id,omitempty"`
+	// Error of a failed or expired task
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+type DeliveryState string
+
+const (
+	DeliveryPending   DeliveryState = "pending"
+	DeliveryDelivered DeliveryState = "delivered"
+	// DeliveryFailed deliveries ran out of attempts or were rejected by the callback.
+	DeliveryFailed DeliveryState = "failed"
+)
+
+// Attempt is one POST of a notification.
+type Attempt struct {
+	At time.Time `json:"at"`
+	// StatusCode of the response, zero if there was none
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Delivery is the notification of a task's callback and its attempts so far.
+type Delivery struct {
+	TaskID       string          `json:"task_id"`
+	URL          string          `json:"url"`
+	Event        string          `json:"event"`
+	State        DeliveryState   `json:"state"`
+	Notification json.RawMessage `json:"notification"`
+	Attempts     []Attempt       `json:"attempts"`
+	// NextAttemptAt is set for pending deliveries.
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// record is a delivery as stored, with the secret needed to sign its attempts.
+type record struct {
+	Delivery
+	Secret string `json:"secret,omitempty"`
+
+	// set while an attempt is made, local mode only
+	claimed bool
+}
+
+var (
+	mu sync.Mutex
+	// deliveries of local mode, keyed by task ID
+	deliveries = make(map[string]*record)
+	// IDs of deliveries in the order they were created, so that expired ones
+	// are found without scanning all of them
+	deliveryOrder []string
+)
+
+// Notify records a delivery notifying cb that a task finished, if cb is
+// notified of n.Event. The delivery is made by Run.
+func Notify(cb *models.Callback, n Notification, rdb *redis.Client) error {
+	if !cb.Notifies(n.Event) {
+		return nil
+	}
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+	now := time.Now()
+	rec := &record{
+		Delivery: Delivery{
+			TaskID:        n.TaskID,
+			URL:           cb.URL,
+			Event:         n.Event,
+			State:         DeliveryPending,
+			Notification:  body,
+			Attempts:      []Attempt{},
+			NextAttemptAt: &now,
+			CreatedAt:     now,
+		},
+		Secret: cb.Secret,
+	}
+
+	if config.AppConfig.MODE == "redis" {
+		return save(rec, rdb)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for len(deliveryOrder) > 0 {
+		oldest, exists := deliveries[deliveryOrder[0]]
+		if exists && (oldest.State == DeliveryPending || time.Since(oldest.CreatedAt) < DeliveryTTL) {
+			break
+		}
+		delete(deliveries, deliveryOrder[0])
+		deliveryOrder = deliveryOrder[1:]
+	}
+	deliveries[rec.TaskID] = rec
+	deliveryOrder = append(deliveryOrder, rec.TaskID)
+	return nil
+}
+
+// save stores a delivery in Redis and schedules its next attempt if it is pending.
+func save(rec *record, rdb *redis.Client) error {
+	recJson, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+	pipe := rdb.TxPipeline()
+	pipe.Set(DeliveryKey(rec.TaskID), recJson, DeliveryTTL)
+	if rec.State == DeliveryPending {
+		pipe.ZAdd(DueKey, redis.Z{Score: float64(rec.NextAttemptAt.UnixMilli()), Member: rec.TaskID})
+	} else {
+		pipe.ZRem(DueKey, rec.TaskID)
+	}
+	_, err = pipe.Exec()
+	return err
+}
+
+// GetDelivery returns the delivery of a task's callback.
+func GetDelivery(taskID string, rdb *redis.Client) (Delivery, error) {
+	if config.AppConfig.MODE == "redis" {
+		recJson, err := rdb.Get(DeliveryKey(taskID)).Result()
+		if err == redis.Nil {
+			return Delivery{}, ErrDeliveryNotFound
+		}
+		if err != nil {
+			return Delivery{}, err
+		}
+		var rec record
+		if err := json.Unmarshal([]byte(recJson), &rec); err != nil {
+			return Delivery{}, fmt.Errorf("invalid delivery of task %s: %w", taskID, err)
+		}
+		return rec.Delivery, nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	rec, exists := deliveries[taskID]
+	if !exists || rec.State != DeliveryPending && time.Since(rec.CreatedAt) >= DeliveryTTL {
+		return Delivery{}, ErrDeliveryNotFound
+	}
+	delivery := rec.Delivery
+	delivery.Attempts = append([]Attempt{}, rec.Attempts...)
+	return delivery, nil
+}
+
+// Run makes the attempts of due deliveries until ctx is done. In redis mode
+// the deliveries of all instances are shared, each attempt is made by one of
+// the instances running Run.
+func Run(ctx context.Context, rdb *redis.Client) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	slots := make(chan struct{}, maxConcurrentDeliveries)
+	var running sync.WaitGroup
+	defer running.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		due, err := claimDue(maxConcurrentDeliveries-len(slots), rdb)
+		if err != nil {
+			slog.Error("could not look up due callbacks", logging.Err(err))
+			continue
+		}
+		for _, rec := range due {
+			slots <- struct{}{}
+			running.Add(1)
+			go func() {
+				defer func() {
+					<-slots
+					running.Done()
+				}()
+				if err := attempt(ctx, rec, rdb); err != nil {
+					slog.Error("could not record callback attempt", logging.TaskIDKey, rec.TaskID, logging.Err(err))
+				}
+			}()
+		}
+	}
+}
+
+// claimDue takes up to limit due deliveries, so that no other Run attempts them
+// at the same time. In redis mode they are leased until their attempt may have
+// timed out, after which another instance retries a delivery whose attempt was
+// not recorded.
+func claimDue(limit int, rdb *redis.Client) ([]*record, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	now := time.Now()
+
+	if config.AppConfig.MODE == "redis" {
+		leaseEnd := now.Add(timeout() + leaseMargin)
+		res, err := claimScript.Run(rdb, []string{DueKey}, now.UnixMilli(), leaseEnd.UnixMilli(), limit).Result()
+		if err != nil {
+			return nil, err
+		}
+		ids, _ := res.([]interface{})
+		var due []*record
+		for _, member := range ids {
+			id, _ := member.(string)
+			recJson, err := rdb.Get(DeliveryKey(id)).Result()
+			if err == redis.Nil {
+				// expired with its task's outcome
+				rdb.ZRem(DueKey, id)
+				continue
+			}
+			if err != nil {
+				slog.Warn("could not load callback delivery", logging.TaskIDKey, id, logging.Err(err))
+				continue
+			}
+			var rec record
+			if err := json.Unmarshal([]byte(recJson), &rec); err != nil {
+				slog.Warn("invalid callback delivery", logging.TaskIDKey, id, logging.Err(err))
+				rdb.ZRem(DueKey, id)
+				continue
+			}
+			due = append(due, &rec)
+		}
+		return due, nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var due []*record
+	for _, rec := range deliveries {
+		if len(due) == limit {
+			break
+		}
+		if rec.State == DeliveryPending && !rec.claimed && !rec.NextAttemptAt.After(now) {
+			rec.claimed = true
+			due = append(due, rec)
+		}
+	}
+	return due, nil
+}
+
+// attempt POSTs a delivery's notification and records the attempt, scheduling
+// the next one if it failed and may be retried.
+func attempt(ctx context.Context, rec *record, rdb *redis.Client) error {
+	result, retry := post(ctx, rec)
+
+	if config.AppConfig.MODE != "redis" {
+		mu.Lock()
+		defer mu.Unlock()
+	}
+	rec.Attempts = append(rec.Attempts, result)
+	rec.claimed = false
+	switch {
+	case result.Error == "":
+		rec.State = DeliveryDelivered
+		rec.NextAttemptAt = nil
+	case retry && len(rec.Attempts) < maxAttempts():
+		next := time.Now().Add(backoff(len(rec.Attempts)))
+		rec.NextAttemptAt = &next
+	default:
+		rec.State = DeliveryFailed
+		rec.NextAttemptAt = nil
+	}
+
+	if config.AppConfig.MODE == "redis" {
+		return save(rec, rdb)
+	}
+	return nil
+}
+
+// post sends a notification. Reports whether a failed attempt may succeed if
+// retried: rejected notifications are not retried, except with 408 and 429.
+func post(ctx context.Context, rec *record) (Attempt, bool) {
+	result := Attempt{At: time.Now()}
+	defer func() { result.DurationMs = time.Since(result.At).Milliseconds() }()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rec.URL, bytes.NewReader(rec.Notification))
+	if err != nil {
+		result.Error = err.Error()
+		return result, false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "qugopy")
+	req.Header.Set(EventHeader, rec.Event)
+	req.Header.Set(DeliveryHeader, rec.TaskID)
+	if rec.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(rec.Secret, rec.Notification))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result, true
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	result.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return result, false
+	}
+	result.Error = resp.Status
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return result, retry
+}
+
+// Sign returns the SignatureHeader of a notification body, which receivers
+// compare to the header of the request with hmac.Equal.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff is the delay before the attempt following the given number of attempts:
+// CALLBACK_BACKOFF, doubled with every attempt and capped at five minutes.
+func backoff(attempts int) time.Duration {
+	base := config.AppConfig.CALLBACK_BACKOFF
+	if base <= 0 {
+		base = defaultBackoff
+	}
+	delay := base << min(attempts-1, 20)
+	return min(delay, maxBackoff)
+}
+
+func maxAttempts() int {
+	if n := config.AppConfig.CALLBACK_ATTEMPTS; n > 0 {
+		return n
+	}
+	return defaultAttempts
+}
+
+func timeout() time.Duration {
+	if d := config.AppConfig.CALLBACK_TIMEOUT; d > 0 {
+		return d
+	}
+	return defaultTimeout
+}
@@ -0,0 +1,119 @@
+package callbacks
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// awaitDelivery polls a delivery until it is no longer pending.
+func awaitDelivery(t *testing.T, taskID string) Delivery {
+	t.Helper()
+	var delivery Delivery
+	require.Eventually(t, func() bool {
+		var err error
+		delivery, err = GetDelivery(taskID, nil)
+		return err == nil && delivery.State != DeliveryPending
+	}, 5*time.Second, 20*time.Millisecond)
+	return delivery
+}
+
+func TestDelivery(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	config.AppConfig.CALLBACK_ATTEMPTS = 3
+	config.AppConfig.CALLBACK_BACKOFF = 10 * time.Millisecond
+	defer func() {
+		config.AppConfig.CALLBACK_ATTEMPTS = 0
+		config.AppConfig.CALLBACK_BACKOFF = 0
+	}()
+
+	var calls atomic.Int32
+	var verified atomic.Bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verified.Store(hmac.Equal([]byte(r.Header.Get(SignatureHeader)), []byte(Sign("s3cret", body))))
+		switch r.URL.Path {
+		case "/flaky":
+			// the first attempt fails
+			if calls.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			var n Notification
+			if json.Unmarshal(body, &n) != nil || n.TaskID != "flaky_task" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case "/rejecting":
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Run(ctx, nil)
+
+	t.Run("RetriedUntilDelivered", func(t *testing.T) {
+		cb := &models.Callback{URL: receiver.URL + "/flaky", Secret: "s3cret"}
+		require.NoError(t, Notify(cb, Notification{Event: "succeeded", TaskID: "flaky_task", TaskType: "send_email"}, nil))
+
+		delivery := awaitDelivery(t, "flaky_task")
+		assert.Equal(t, DeliveryDelivered, delivery.State)
+		require.Len(t, delivery.Attempts, 2)
+		assert.Equal(t, http.StatusServiceUnavailable, delivery.Attempts[0].StatusCode)
+		assert.NotEmpty(t, delivery.Attempts[0].Error)
+		assert.Equal(t, http.StatusNoContent, delivery.Attempts[1].StatusCode)
+		assert.Empty(t, delivery.Attempts[1].Error)
+		assert.Nil(t, delivery.NextAttemptAt)
+		assert.True(t, verified.Load(), "Notifications should be signed with the secret")
+	})
+
+	t.Run("RejectedNotRetried", func(t *testing.T) {
+		cb := &models.Callback{URL: receiver.URL + "/rejecting"}
+		require.NoError(t, Notify(cb, Notification{Event: "failed", TaskID: "rejected_task", Error: "boom"}, nil))
+
+		delivery := awaitDelivery(t, "rejected_task")
+		assert.Equal(t, DeliveryFailed, delivery.State)
+		assert.Len(t, delivery.Attempts, 1)
+	})
+
+	t.Run("AttemptsExhausted", func(t *testing.T) {
+		cb := &models.Callback{URL: receiver.URL + "/down"}
+		require.NoError(t, Notify(cb, Notification{Event: "expired", TaskID: "down_task"}, nil))
+
+		delivery := awaitDelivery(t, "down_task")
+		assert.Equal(t, DeliveryFailed, delivery.State)
+		assert.Len(t, delivery.Attempts, 3)
+	})
+
+	t.Run("EventNotNotified", func(t *testing.T) {
+		cb := &models.Callback{URL: receiver.URL + "/flaky", Events: []string{"failed"}}
+		require.NoError(t, Notify(cb, Notification{Event: "succeeded", TaskID: "quiet_task"}, nil))
+		require.NoError(t, Notify(nil, Notification{Event: "succeeded", TaskID: "quiet_task"}, nil))
+
+		_, err := GetDelivery("quiet_task", nil)
+		assert.ErrorIs(t, err, ErrDeliveryNotFound)
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	config.AppConfig.CALLBACK_BACKOFF = 0
+	assert.Equal(t, time.Second, backoff(1))
+	assert.Equal(t, 4*time.Second, backoff(3))
+	assert.Equal(t, maxBackoff, backoff(100), "Backoff should be capped")
+}
@@ -0,0 +1,289 @@
+// Package events publishes what happens to tasks, e.g. to stream it to API
+// clients. Events are delivered to the subscribers of this process and, in
+// redis mode, to the subscribers of all instances via Redis pub/sub.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Yulian302/qugopy/logging"
+	"github.com/go-redis/redis"
+	"github.com/google/uuid"
+)
+
+// Type is what happened to a task.
+type Type string
+
+const (
+	Enqueued Type = "enqueued"
+	Started  Type = "started"
+	// Progress events are reported by a task while it runs.
+	Progress  Type = "progress"
+	Succeeded Type = "succeeded"
+	Failed    Type = "failed"
+	// Retried tasks were put back into their queue, e.g. because their worker stopped.
+	Retried Type = "retried"
+	// Expired tasks were taken from their queue after their deadline and not run.
+	Expired   Type = "expired"
+	Cancelled Type = "cancelled"
+)
+
+// Types are all event types, in lifecycle order.
+var Types = []Type{Enqueued, Started, Progress, Succeeded, Failed, Retried, Expired, Cancelled}
+
+// Event is something that happened to a task.
+type Event struct {
+	Type     Type   `json:"type"`
+	TaskID   string `json:"task_id"`
+	TaskType string `json:"task_type,omitempty"`
+	Queue    string `json:"queue,omitempty"`
+	// WorkerID is set for events of running and finished tasks.
+	WorkerID string `json:"worker_id,omitempty"`
+	// Progress of a progress event, from 0 to 1
+	Progress float64 `json:"progress,omitempty"`
+	Message  string  `json:"message,omitempty"`
+	// Error of a failed or expired task
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+	// Origin identifies the instance which published the event.
+	Origin string `json:"origin"`
+}
+
+// Filter selects the events of a subscription. Zero fields match all events.
+type Filter struct {
+	TaskIDs   []string
+	TaskTypes []string
+	Queues    []string
+	Types     []Type
+}
+
+// Matches reports whether the filter selects event.
+func (f Filter) Matches(event Event) bool {
+	switch {
+	case len(f.TaskIDs) > 0 && !slices.Contains(f.TaskIDs, event.TaskID):
+		return false
+	case len(f.TaskTypes) > 0 && !slices.Contains(f.TaskTypes, event.TaskType):
+		return false
+	case len(f.Queues) > 0 && !slices.Contains(f.Queues, event.Queue):
+		return false
+	case len(f.Types) > 0 && !slices.Contains(f.Types, event.Type):
+		return false
+	}
+	return true
+}
+
+// Channel is the Redis pub/sub channel events are published on in redis mode.
+const Channel = "qugopy:events"
+
+const (
+	// SubscriberBuffer is the number of events buffered for a subscriber. Events
+	// are dropped for a subscriber whose buffer is full, so that a slow client
+	// never holds up workers.
+	SubscriberBuffer = 256
+	// number of events waiting to be published to Redis
+	forwardBuffer = 1024
+)
+
+// Subscription receives the events matching its filter on C until it is closed.
+type Subscription struct {
+	C <-chan Event
+
+	c       chan Event
+	filter  Filter
+	bus     *Bus
+	dropped atomic.Int64
+}
+
+// Close stops the subscription and closes C.
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// Dropped returns the number of events dropped because C was full.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Bus delivers published events to its subscriptions.
+type Bus struct {
+	origin string
+
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+	// events to publish to Redis, nil unless Forward was called
+	forward chan Event
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		origin: uuid.New().String(),
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+// Origin identifies the events published on this bus.
+func (b *Bus) Origin() string {
+	return b.origin
+}
+
+// Subscribe returns a subscription to the events matching filter. A subscription
+// to a closed bus is closed at once.
+func (b *Bus) Subscribe(filter Filter) *Subscription {
+	c := make(chan Event, SubscriberBuffer)
+	sub := &Subscription{C: c, c: c, filter: filter, bus: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(c)
+		return sub
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+func (b *Bus) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, exists := b.subs[sub]; exists {
+		delete(b.subs, sub)
+		close(sub.c)
+	}
+}
+
+// Publish delivers an event to the subscribers of this process and, once
+// Forward was called, to those of other instances. It never blocks.
+func (b *Bus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Origin == "" {
+		event.Origin = b.origin
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.deliverLocked(event)
+	if b.forward != nil {
+		select {
+		case b.forward <- event:
+		default:
+			slog.Warn("dropped event: too many events waiting for Redis", "event", event.Type, logging.TaskIDKey, event.TaskID)
+		}
+	}
+}
+
+func (b *Bus) deliverLocked(event Event) {
+	for sub := range b.subs {
+		if !sub.filter.Matches(event) {
+			continue
+		}
+		select {
+		case sub.c <- event:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Forward publishes the events of this bus on the Redis Channel until ctx is done.
+func (b *Bus) Forward(ctx context.Context, rdb *redis.Client) {
+	forward := make(chan Event, forwardBuffer)
+	b.mu.Lock()
+	b.forward = forward
+	b.mu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				b.mu.Lock()
+				b.forward = nil
+				b.mu.Unlock()
+				return
+			case event := <-forward:
+				eventJson, err := json.Marshal(event)
+				if err != nil {
+					continue
+				}
+				if err := rdb.Publish(Channel, eventJson).Err(); err != nil {
+					slog.Error("could not publish event", "event", event.Type, logging.TaskIDKey, event.TaskID, logging.Err(err))
+				}
+			}
+		}
+	}()
+}
+
+// FanIn delivers the events published on the Redis Channel by other instances
+// to the subscribers of this bus until ctx is done.
+func (b *Bus) FanIn(ctx context.Context, rdb *redis.Client) {
+	pubsub := rdb.Subscribe(Channel)
+	go func() {
+		<-ctx.Done()
+		_ = pubsub.Close()
+	}()
+
+	go func() {
+		for msg := range pubsub.Channel() {
+			var event Event
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				slog.Warn("invalid event", "channel", Channel, logging.Err(err))
+				continue
+			}
+			// events of this process were delivered when they were published
+			if event.Origin == b.origin {
+				continue
+			}
+			b.mu.Lock()
+			b.deliverLocked(event)
+			b.mu.Unlock()
+		}
+	}()
+}
+
+// Close closes all subscriptions, e.g. so that streaming clients are
+// disconnected on shutdown. Later subscriptions are closed at once.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		close(sub.c)
+	}
+}
+
+// bus is the bus of this process.
+var bus = NewBus()
+
+// Publish publishes an event on the bus of this process.
+func Publish(event Event) {
+	bus.Publish(event)
+}
+
+// Subscribe subscribes to the events of the bus of this process.
+func Subscribe(filter Filter) *Subscription {
+	return bus.Subscribe(filter)
+}
+
+// Forward publishes the events of this process to other instances, see Bus.Forward.
+func Forward(ctx context.Context, rdb *redis.Client) {
+	bus.Forward(ctx, rdb)
+}
+
+// FanIn receives the events of other instances, see Bus.FanIn.
+func FanIn(ctx context.Context, rdb *redis.Client) {
+	bus.FanIn(ctx, rdb)
+}
+
+// Close closes the subscriptions of this process, see Bus.Close.
+func Close() {
+	bus.Close()
+}
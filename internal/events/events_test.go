@@ -0,0 +1,54 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	all := bus.Subscribe(Filter{})
+	failed := bus.Subscribe(Filter{Types: []Type{Failed}, Queues: []string{"go_queue"}})
+	defer failed.Close()
+
+	bus.Publish(Event{Type: Started, TaskID: "a", Queue: "go_queue"})
+	bus.Publish(Event{Type: Failed, TaskID: "a", Queue: "go_queue", Error: "smtp down"})
+	bus.Publish(Event{Type: Failed, TaskID: "b", Queue: "python_queue"})
+
+	require.Len(t, all.C, 3)
+	event := <-all.C
+	assert.Equal(t, Started, event.Type)
+	assert.Equal(t, bus.Origin(), event.Origin)
+	assert.False(t, event.Time.IsZero(), "Published events should be timestamped")
+
+	require.Len(t, failed.C, 1, "Subscriptions should only get the events matching their filter")
+	event = <-failed.C
+	assert.Equal(t, "a", event.TaskID)
+	assert.Equal(t, "smtp down", event.Error)
+
+	all.Close()
+	bus.Publish(Event{Type: Started, TaskID: "c"})
+	left := 0
+	for range all.C {
+		left++
+	}
+	assert.Equal(t, 2, left, "Closing a subscription should close its channel after the buffered events")
+}
+
+func TestBusSlowSubscriber(t *testing.T) {
+	bus := NewBus()
+	sub := bus.Subscribe(Filter{TaskIDs: []string{"a"}})
+
+	for range SubscriberBuffer + 10 {
+		bus.Publish(Event{Type: Progress, TaskID: "a"})
+	}
+	assert.Len(t, sub.C, SubscriberBuffer)
+	assert.EqualValues(t, 10, sub.Dropped(), "Events for a full subscriber should be dropped, not block")
+
+	bus.Close()
+	assert.Len(t, sub.C, SubscriberBuffer, "Buffered events should still be received after Close")
+	_, open := <-bus.Subscribe(Filter{}).C
+	assert.False(t, open, "Subscriptions to a closed bus should be closed")
+}
@@ -0,0 +1,51 @@
+package locks
+
+import (
+	"sync"
+	"time"
+)
+
+// LocalLocker is an in-memory Locker. Locks live only as long as the process,
+// so the lease ttl is ignored and a lock is held until Unlock is called.
+type LocalLocker struct {
+	mu    sync.Mutex
+	locks map[string]string
+}
+
+var _ Locker = (*LocalLocker)(nil)
+
+func NewLocalLocker() *LocalLocker {
+	return &LocalLocker{
+		locks: make(map[string]string),
+	}
+}
+
+func (l *LocalLocker) TryLock(key string, ttl time.Duration) (Lock, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.locks[key]; ok {
+		return nil, ErrLocked
+	}
+
+	token := newToken()
+	l.locks[key] = token
+	return &localLock{locker: l, key: key, token: token}, nil
+}
+
+type localLock struct {
+	locker *LocalLocker
+	key    string
+	token  string
+}
+
+func (ll *localLock) Unlock() error {
+	ll.locker.mu.Lock()
+	defer ll.locker.mu.Unlock()
+
+	// only release the lock if it still belongs to us
+	if token, ok := ll.locker.locks[ll.key]; ok && token == ll.token {
+		delete(ll.locker.locks, ll.key)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+// Package locks provides mutual exclusion for tasks that must never run
+// concurrently for the same resource (e.g. two downloads into the same file),
+// and semaphores capping how many tasks of a kind run at once.
+//
+// Each primitive has two implementations: the local one keeps state in memory
+// and is used in `local` mode, the Redis one stores it in Redis with a lease so
+// that it is shared between processes in `redis` mode.
+package locks
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/google/uuid"
+)
+
+// ErrLocked is returned by TryLock when the lock is held by someone else.
+var ErrLocked = errors.New("lock is already held")
+
+// Lock is a held lock. Unlock releases it; calling Unlock more than once is a no-op.
+type Lock interface {
+	Unlock() error
+}
+
+// Locker acquires named locks.
+type Locker interface {
+	// TryLock acquires the lock for key without blocking.
+	// The lease expires after ttl unless the implementation renews it.
+	// Returns ErrLocked if the lock is already held.
+	TryLock(key string, ttl time.Duration) (Lock, error)
+}
+
+// process-wide locker shared by Go workers and the gRPC server in local mode
+var localLocker = NewLocalLocker()
+
+// New returns the locker matching the queue mode: RedisLocker for `redis`,
+// otherwise the process-wide LocalLocker so that a task handed out over gRPC
+// and one run by a Go worker of the process contend for the same locks.
+func New(mode string, rdb *redis.Client) Locker {
+	if mode == "redis" && rdb != nil {
+		return NewRedisLocker(rdb)
+	}
+	return localLocker
+}
+
+func newToken() string {
+	return uuid.New().String()
+}
@@ -0,0 +1,70 @@
+package locks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalLocker(t *testing.T) {
+	l := NewLocalLocker()
+
+	lock, err := l.TryLock("lock:a", time.Second)
+	require.NoError(t, err)
+
+	_, err = l.TryLock("lock:a", time.Second)
+	assert.ErrorIs(t, err, ErrLocked, "held lock must not be acquired twice")
+
+	_, err = l.TryLock("lock:b", time.Second)
+	assert.NoError(t, err, "different keys must not conflict")
+
+	require.NoError(t, lock.Unlock())
+	_, err = l.TryLock("lock:a", time.Second)
+	assert.NoError(t, err, "released lock must be acquirable")
+}
+
+func TestLocalLockerStaleUnlock(t *testing.T) {
+	l := NewLocalLocker()
+
+	first, err := l.TryLock("lock:a", time.Second)
+	require.NoError(t, err)
+	require.NoError(t, first.Unlock())
+
+	second, err := l.TryLock("lock:a", time.Second)
+	require.NoError(t, err)
+
+	// unlocking a stale handle must not release the new holder's lock
+	require.NoError(t, first.Unlock())
+	_, err = l.TryLock("lock:a", time.Second)
+	assert.ErrorIs(t, err, ErrLocked)
+
+	require.NoError(t, second.Unlock())
+}
+
+func TestLocalSemaphore(t *testing.T) {
+	now := time.Now()
+	s := NewLocalSemaphore()
+	s.now = func() time.Time { return now }
+
+	first, err := s.TryAcquire("concurrency:download_file", 2, time.Minute)
+	require.NoError(t, err)
+	_, err = s.TryAcquire("concurrency:download_file", 2, time.Minute)
+	require.NoError(t, err)
+
+	_, err = s.TryAcquire("concurrency:download_file", 2, time.Minute)
+	assert.ErrorIs(t, err, ErrLimitReached, "limit must not be exceeded")
+
+	inUse, _ := s.InUse("concurrency:download_file")
+	assert.Equal(t, 2, inUse)
+
+	require.NoError(t, first.Release())
+	_, err = s.TryAcquire("concurrency:download_file", 2, time.Minute)
+	assert.NoError(t, err, "released permit must be reusable")
+
+	// leases of crashed holders expire
+	now = now.Add(2 * time.Minute)
+	inUse, _ = s.InUse("concurrency:download_file")
+	assert.Equal(t, 0, inUse)
+}
@@ -0,0 +1,100 @@
+package locks
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Yulian302/qugopy/logging"
+	"github.com/go-redis/redis"
+)
+
+var (
+	// releases the lock only if it is still owned by the caller
+	unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+	// extends the lease only if the lock is still owned by the caller
+	renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// RedisLocker is a Locker backed by Redis. A held lock has a lease of ttl which
+// is renewed in the background every ttl/3 until Unlock is called, so a crashed
+// holder releases the lock after at most one lease.
+type RedisLocker struct {
+	rdb *redis.Client
+}
+
+var _ Locker = (*RedisLocker)(nil)
+
+func NewRedisLocker(rdb *redis.Client) *RedisLocker {
+	return &RedisLocker{rdb: rdb}
+}
+
+func (rl *RedisLocker) TryLock(key string, ttl time.Duration) (Lock, error) {
+	token := newToken()
+	ok, err := rl.rdb.SetNX(key, token, ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("could not acquire lock %s: %w", key, err)
+	}
+	if !ok {
+		return nil, ErrLocked
+	}
+
+	lock := &redisLock{
+		rdb:   rl.rdb,
+		key:   key,
+		token: token,
+		done:  make(chan struct{}),
+	}
+	go lock.renew(ttl)
+	return lock, nil
+}
+
+type redisLock struct {
+	rdb   *redis.Client
+	key   string
+	token string
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (rl *redisLock) renew(ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rl.done:
+			return
+		case <-ticker.C:
+			res, err := renewScript.Run(rl.rdb, []string{rl.key}, rl.token, ttl.Milliseconds()).Int()
+			if err != nil {
+				slog.Error("could not renew lock", "key", rl.key, logging.Err(err))
+				continue
+			}
+			if res == 0 {
+				// lease expired and the lock was taken over
+				slog.Warn("lost lock", "key", rl.key)
+				return
+			}
+		}
+	}
+}
+
+func (rl *redisLock) Unlock() error {
+	var err error
+	rl.once.Do(func() {
+		close(rl.done)
+		err = unlockScript.Run(rl.rdb, []string{rl.key}, rl.token).Err()
+	})
+	return err
+}
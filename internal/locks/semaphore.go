@@ -0,0 +1,217 @@
+package locks
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Yulian302/qugopy/logging"
+	"github.com/go-redis/redis"
+)
+
+// ErrLimitReached is returned by TryAcquire when all permits are taken.
+var ErrLimitReached = errors.New("concurrency limit reached")
+
+// Permit is one of the slots of a semaphore. Its lease expires unless it is
+// refreshed, so permits of crashed holders are eventually freed.
+type Permit interface {
+	Refresh() error
+	Release() error
+}
+
+// Semaphore limits how many holders run at once for a key.
+type Semaphore interface {
+	// TryAcquire takes one of limit permits for key without blocking.
+	// Returns ErrLimitReached if all permits are taken.
+	TryAcquire(key string, limit int, ttl time.Duration) (Permit, error)
+
+	// InUse returns the number of permits currently held for key.
+	InUse(key string) (int, error)
+}
+
+// process-wide semaphore shared by Go workers and the gRPC server in local mode
+var localSemaphore = NewLocalSemaphore()
+
+// NewSemaphore returns the semaphore matching the queue mode: RedisSemaphore
+// for `redis`, otherwise the process-wide LocalSemaphore so that every worker
+// of the process shares the same permits.
+func NewSemaphore(mode string, rdb *redis.Client) Semaphore {
+	if mode == "redis" && rdb != nil {
+		return NewRedisSemaphore(rdb)
+	}
+	return localSemaphore
+}
+
+// Hold refreshes a permit every ttl/3 until the returned stop function is called.
+func Hold(p Permit, ttl time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := p.Refresh(); err != nil {
+					slog.Error("could not refresh permit", logging.Err(err))
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// LocalSemaphore is an in-memory Semaphore.
+type LocalSemaphore struct {
+	mu      sync.Mutex
+	holders map[string]map[string]time.Time
+	now     func() time.Time
+}
+
+var _ Semaphore = (*LocalSemaphore)(nil)
+
+func NewLocalSemaphore() *LocalSemaphore {
+	return &LocalSemaphore{
+		holders: make(map[string]map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// prune removes expired permits. Must be called with the lock held.
+func (s *LocalSemaphore) prune(key string) map[string]time.Time {
+	holders, exists := s.holders[key]
+	if !exists {
+		holders = make(map[string]time.Time)
+		s.holders[key] = holders
+	}
+	now := s.now()
+	for token, expires := range holders {
+		if !now.Before(expires) {
+			delete(holders, token)
+		}
+	}
+	return holders
+}
+
+func (s *LocalSemaphore) TryAcquire(key string, limit int, ttl time.Duration) (Permit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	holders := s.prune(key)
+	if len(holders) >= limit {
+		return nil, ErrLimitReached
+	}
+
+	token := newToken()
+	holders[token] = s.now().Add(ttl)
+	return &localPermit{sem: s, key: key, token: token, ttl: ttl}, nil
+}
+
+func (s *LocalSemaphore) InUse(key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.prune(key)), nil
+}
+
+type localPermit struct {
+	sem   *LocalSemaphore
+	key   string
+	token string
+	ttl   time.Duration
+}
+
+func (lp *localPermit) Refresh() error {
+	lp.sem.mu.Lock()
+	defer lp.sem.mu.Unlock()
+
+	if _, held := lp.sem.holders[lp.key][lp.token]; held {
+		lp.sem.holders[lp.key][lp.token] = lp.sem.now().Add(lp.ttl)
+	}
+	return nil
+}
+
+func (lp *localPermit) Release() error {
+	lp.sem.mu.Lock()
+	defer lp.sem.mu.Unlock()
+
+	delete(lp.sem.holders[lp.key], lp.token)
+	return nil
+}
+
+var (
+	// takes a permit if fewer than limit unexpired permits are held.
+	// KEYS: semaphore. ARGV: now (unix ms), limit, token, expiry (unix ms), ttl (ms).
+	acquireScript = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[4], ARGV[3])
+redis.call("PEXPIRE", KEYS[1], ARGV[5])
+return 1`)
+
+	// extends a permit's lease if it is still held.
+	// KEYS: semaphore. ARGV: token, expiry (unix ms), ttl (ms).
+	refreshScript = redis.NewScript(`
+if redis.call("ZSCORE", KEYS[1], ARGV[1]) then
+	redis.call("ZADD", KEYS[1], ARGV[2], ARGV[1])
+	redis.call("PEXPIRE", KEYS[1], ARGV[3])
+	return 1
+end
+return 0`)
+)
+
+// RedisSemaphore is a Semaphore backed by a Redis sorted set of permit tokens
+// scored by the time their lease expires.
+type RedisSemaphore struct {
+	rdb *redis.Client
+}
+
+var _ Semaphore = (*RedisSemaphore)(nil)
+
+func NewRedisSemaphore(rdb *redis.Client) *RedisSemaphore {
+	return &RedisSemaphore{rdb: rdb}
+}
+
+func (s *RedisSemaphore) TryAcquire(key string, limit int, ttl time.Duration) (Permit, error) {
+	token := newToken()
+	now := time.Now()
+	ok, err := acquireScript.Run(s.rdb, []string{key},
+		now.UnixMilli(), limit, token, now.Add(ttl).UnixMilli(), ttl.Milliseconds(),
+	).Int()
+	if err != nil {
+		return nil, fmt.Errorf("could not acquire permit %s: %w", key, err)
+	}
+	if ok == 0 {
+		return nil, ErrLimitReached
+	}
+	return &redisPermit{rdb: s.rdb, key: key, token: token, ttl: ttl}, nil
+}
+
+func (s *RedisSemaphore) InUse(key string) (int, error) {
+	n, err := s.rdb.ZCount(key, fmt.Sprint(time.Now().UnixMilli()), "+inf").Result()
+	return int(n), err
+}
+
+type redisPermit struct {
+	rdb   *redis.Client
+	key   string
+	token string
+	ttl   time.Duration
+}
+
+func (rp *redisPermit) Refresh() error {
+	return refreshScript.Run(rp.rdb, []string{rp.key},
+		rp.token, time.Now().Add(rp.ttl).UnixMilli(), rp.ttl.Milliseconds(),
+	).Err()
+}
+
+func (rp *redisPermit) Release() error {
+	return rp.rdb.ZRem(rp.key, rp.token).Err()
+}
@@ -0,0 +1,156 @@
+// Package metrics exports the metrics of this process in the Prometheus text
+// format. Task metrics count the tasks this process enqueued and ran, so a
+// cluster's totals are the sums over its instances.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "qugopy"
+
+// buckets of the wait and run time histograms in seconds, from 5ms to 10 minutes
+var taskBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+var (
+	// Registry holds the metrics of this process. The default registry of the
+	// Prometheus client is not used, so that dependencies can not add metrics.
+	Registry = prometheus.NewRegistry()
+
+	tasksEnqueued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_enqueued_total",
+		Help:      "Tasks enqueued, by task type and queue.",
+	}, []string{"type", "queue"})
+	tasksCompleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_completed_total",
+		Help:      "Tasks which ran successfully, by task type and queue.",
+	}, []string{"type", "queue"})
+	tasksFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_failed_total",
+		Help:      "Tasks which failed, by task type and queue.",
+	}, []string{"type", "queue"})
+	tasksRetried = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_retried_total",
+		Help:      "Tasks which went back into their queue, by task type and queue.",
+	}, []string{"type", "queue"})
+
+	taskWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "task_wait_seconds",
+		Help:      "Time tasks were queued before a worker took them.",
+		Buckets:   taskBuckets,
+	}, []string{"type", "queue"})
+	taskRun = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "task_run_seconds",
+		Help:      "Time tasks ran until they succeeded or failed.",
+		Buckets:   taskBuckets,
+	}, []string{"type", "queue"})
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "REST API requests, by method, route and status code.",
+	}, []string{"method", "route", "code"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time REST API requests took.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	grpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_requests_total",
+		Help:      "gRPC calls handled, by method and status code.",
+	}, []string{"method", "code"})
+	grpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_request_duration_seconds",
+		Help:      "Time gRPC calls took, streams until they ended.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		tasksEnqueued, tasksCompleted, tasksFailed, tasksRetried,
+		taskWait, taskRun,
+		httpRequests, httpDuration,
+		grpcRequests, grpcDuration,
+		workerReports,
+	)
+}
+
+// Handler serves the metrics of Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+var (
+	gaugesMu sync.Mutex
+	gauges   prometheus.Collector
+)
+
+// SetGauges registers the collector of the gauges read at scrape time, e.g. the
+// depth of queues, replacing the one set before.
+func SetGauges(collector prometheus.Collector) error {
+	gaugesMu.Lock()
+	defer gaugesMu.Unlock()
+	if gauges != nil {
+		Registry.Unregister(gauges)
+	}
+	if err := Registry.Register(collector); err != nil {
+		return err
+	}
+	gauges = collector
+	return nil
+}
+
+// TaskEnqueued counts an enqueued task.
+func TaskEnqueued(taskType, queue string) {
+	tasksEnqueued.WithLabelValues(taskType, queue).Inc()
+}
+
+// TaskStarted records how long a task waited before a worker took it.
+func TaskStarted(taskType, queue string, wait time.Duration) {
+	taskWait.WithLabelValues(taskType, queue).Observe(wait.Seconds())
+}
+
+// TaskFinished counts a task which succeeded or failed and records how long it
+// ran. A zero run time is unknown and not recorded.
+func TaskFinished(taskType, queue string, failed bool, run time.Duration) {
+	if failed {
+		tasksFailed.WithLabelValues(taskType, queue).Inc()
+	} else {
+		tasksCompleted.WithLabelValues(taskType, queue).Inc()
+	}
+	if run > 0 {
+		taskRun.WithLabelValues(taskType, queue).Observe(run.Seconds())
+	}
+}
+
+// TaskRetried counts a task which went back into its queue.
+func TaskRetried(taskType, queue string) {
+	tasksRetried.WithLabelValues(taskType, queue).Inc()
+}
+
+// observeHTTP records a REST API request. Requests matching no route share the
+// empty route, so that unknown paths do not add series.
+func observeHTTP(method, route string, code int, took time.Duration) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(code)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(took.Seconds())
+}
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// scrape returns the metrics served by Handler.
+func scrape(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	body, err := io.ReadAll(w.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestTaskMetrics(t *testing.T) {
+	TaskEnqueued("send_email", "go_queue")
+	TaskEnqueued("send_email", "go_queue")
+	TaskStarted("send_email", "go_queue", 40*time.Millisecond)
+	TaskFinished("send_email", "go_queue", false, 2*time.Second)
+	TaskFinished("send_email", "go_queue", true, 0)
+	TaskRetried("send_email", "go_queue")
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(tasksEnqueued.WithLabelValues("send_email", "go_queue")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(tasksCompleted.WithLabelValues("send_email", "go_queue")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(tasksFailed.WithLabelValues("send_email", "go_queue")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(tasksRetried.WithLabelValues("send_email", "go_queue")))
+
+	body := scrape(t)
+	assert.Contains(t, body, `qugopy_task_wait_seconds_bucket{queue="go_queue",type="send_email",le="0.05"} 1`)
+	assert.Contains(t, body, `qugopy_task_run_seconds_count{queue="go_queue",type="send_email"} 1`, "Unknown run times should not be recorded")
+	assert.Contains(t, body, "go_goroutines")
+}
+
+func TestGinMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(GinMiddleware())
+	r.GET("/queues/:name", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	for _, path := range []string{"/queues/a", "/queues/b", "/missing"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	assert.Equal(t, 2.0, testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/queues/:name", "204")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(httpRequests.WithLabelValues("GET", "", "404")))
+}
+
+func TestGRPCInterceptors(t *testing.T) {
+	unary := UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/task.TaskService/Enqueue"}
+	_, err := unary(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		return nil, status.Error(codes.InvalidArgument, "invalid task")
+	})
+	require.Error(t, err)
+	_, err = unary(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		return "ok", nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(grpcRequests.WithLabelValues("/task.TaskService/Enqueue", "InvalidArgument")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(grpcRequests.WithLabelValues("/task.TaskService/Enqueue", "OK")))
+
+	stream := StreamServerInterceptor()
+	err = stream(nil, nil, &grpc.StreamServerInfo{FullMethod: "/task.TaskService/WatchTask"}, func(srv any, ss grpc.ServerStream) error {
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(grpcRequests.WithLabelValues("/task.TaskService/WatchTask", "OK")))
+}
+
+func TestWorkerReports(t *testing.T) {
+	ReportWorker(WorkerReport{
+		WorkerID:    "py-1",
+		Queue:       "python_queue",
+		Tasks:       map[string]TaskTypeTotals{"process_image": {Succeeded: 3, Failed: 1, RunSeconds: 1.5}},
+		CPUSeconds:  2.25,
+		MaxRSSBytes: 1 << 20,
+	})
+	body := scrape(t)
+	assert.Contains(t, body, `qugopy_python_worker_tasks_total{outcome="succeeded",queue="python_queue",type="process_image",worker_id="py-1"} 3`)
+	assert.Contains(t, body, `qugopy_python_worker_tasks_total{outcome="failed",queue="python_queue",type="process_image",worker_id="py-1"} 1`)
+	assert.Contains(t, body, `qugopy_python_worker_cpu_seconds_total{queue="python_queue",worker_id="py-1"} 2.25`)
+	assert.Contains(t, body, `qugopy_python_worker_max_rss_bytes{queue="python_queue",worker_id="py-1"} 1.048576e+06`)
+
+	ForgetWorker("py-1")
+	assert.NotContains(t, scrape(t), `worker_id="py-1"`)
+
+	// workers which stopped reporting are dropped
+	ReportWorker(WorkerReport{WorkerID: "py-2", Queue: "python_queue"})
+	workerReports.mu.Lock()
+	reported := workerReports.workers["py-2"]
+	reported.reportedAt = time.Now().Add(-2 * WorkerReportTTL)
+	workerReports.workers["py-2"] = reported
+	workerReports.mu.Unlock()
+	assert.NotContains(t, scrape(t), `worker_id="py-2"`)
+}
+
+func TestSetGauges(t *testing.T) {
+	gauge := func(value float64) prometheus.Collector {
+		g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "qugopy_test_gauge", Help: "Test gauge."})
+		g.Set(value)
+		return g
+	}
+	require.NoError(t, SetGauges(gauge(1)))
+	require.NoError(t, SetGauges(gauge(2)), "Gauges should be replaceable")
+	assert.Contains(t, scrape(t), "qugopy_test_gauge 2")
+	Registry.Unregister(gauges)
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WorkerReportTTL is how long the last report of a worker is exported. Workers
+// report every few seconds, so a worker which stopped reporting is gone.
+const WorkerReportTTL = time.Minute
+
+// TaskTypeTotals are the totals of the tasks of one type a worker ran.
+type TaskTypeTotals struct {
+	Succeeded int64
+	Failed    int64
+	// RunSeconds is the time the worker spent running the tasks.
+	RunSeconds float64
+}
+
+// WorkerReport is the state a Python worker reports over gRPC. Totals count
+// since the worker started.
+type WorkerReport struct {
+	WorkerID    string
+	Queue       string
+	Tasks       map[string]TaskTypeTotals
+	CPUSeconds  float64
+	MaxRSSBytes int64
+}
+
+var (
+	workerTasksDesc = prometheus.NewDesc(namespace+"_python_worker_tasks_total",
+		"Tasks a Python worker ran, by task type and outcome.",
+		[]string{"worker_id", "queue", "type", "outcome"}, nil)
+	workerTaskSecondsDesc = prometheus.NewDesc(namespace+"_python_worker_task_seconds_total",
+		"Time a Python worker spent running tasks, by task type.",
+		[]string{"worker_id", "queue", "type"}, nil)
+	workerCPUDesc = prometheus.NewDesc(namespace+"_python_worker_cpu_seconds_total",
+		"CPU time of a Python worker process.",
+		[]string{"worker_id", "queue"}, nil)
+	workerRSSDesc = prometheus.NewDesc(namespace+"_python_worker_max_rss_bytes",
+		"Peak resident memory of a Python worker process.",
+		[]string{"worker_id", "queue"}, nil)
+)
+
+type reportedWorker struct {
+	report     WorkerReport
+	reportedAt time.Time
+}
+
+// workerCollector exports the last report of every worker.
+type workerCollector struct {
+	mu      sync.Mutex
+	workers map[string]reportedWorker
+}
+
+var workerReports = &workerCollector{workers: make(map[string]reportedWorker)}
+
+// ReportWorker records the report of a worker, replacing its previous one.
+func ReportWorker(report WorkerReport) {
+	workerReports.mu.Lock()
+	defer workerReports.mu.Unlock()
+	workerReports.workers[report.WorkerID] = reportedWorker{report: report, reportedAt: time.Now()}
+}
+
+// ForgetWorker stops exporting a worker which stopped.
+func ForgetWorker(workerID string) {
+	workerReports.mu.Lock()
+	defer workerReports.mu.Unlock()
+	delete(workerReports.workers, workerID)
+}
+
+func (wc *workerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- workerTasksDesc
+	ch <- workerTaskSecondsDesc
+	ch <- workerCPUDesc
+	ch <- workerRSSDesc
+}
+
+func (wc *workerCollector) Collect(ch chan<- prometheus.Metric) {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	for id, worker := range wc.workers {
+		if time.Since(worker.reportedAt) > WorkerReportTTL {
+			delete(wc.workers, id)
+			continue
+		}
+		report := worker.report
+		for taskType, totals := range report.Tasks {
+			ch <- prometheus.MustNewConstMetric(workerTasksDesc, prometheus.CounterValue,
+				float64(totals.Succeeded), report.WorkerID, report.Queue, taskType, "succeeded")
+			ch <- prometheus.MustNewConstMetric(workerTasksDesc, prometheus.CounterValue,
+				float64(totals.Failed), report.WorkerID, report.Queue, taskType, "failed")
+			ch <- prometheus.MustNewConstMetric(workerTaskSecondsDesc, prometheus.CounterValue,
+				totals.RunSeconds, report.WorkerID, report.Queue, taskType)
+		}
+		ch <- prometheus.MustNewConstMetric(workerCPUDesc, prometheus.CounterValue,
+			report.CPUSeconds, report.WorkerID, report.Queue)
+		ch <- prometheus.MustNewConstMetric(workerRSSDesc, prometheus.GaugeValue,
+			float64(report.MaxRSSBytes), report.WorkerID, report.Queue)
+	}
+}
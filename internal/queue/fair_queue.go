@@ -0,0 +1,142 @@
+package queue
+
+import "github.com/Yulian302/qugopy/config"
+
+// FairQueue schedules tasks across tenants with deficit round robin. Every tenant
+// has its own PriorityQueue, so priority order is kept within a tenant, while a
+// tenant with weight w gets w tasks served per round before the next tenant.
+//
+// Example (weights: a=2, b=1):
+//
+//	a1 a2 b1 a3 a4 b2 ...
+type FairQueue struct {
+	queues map[string]*PriorityQueue
+	// active tenants in round robin order
+	ring    []string
+	cursor  int
+	deficit map[string]int
+	size    int
+}
+
+func (fq *FairQueue) init() {
+	if fq.queues == nil {
+		fq.queues = make(map[string]*PriorityQueue)
+		fq.deficit = make(map[string]int)
+	}
+}
+
+func (fq *FairQueue) Push(value IntTask) {
+	fq.init()
+	tenant := value.Task.Tenant
+	pq, exists := fq.queues[tenant]
+	if !exists {
+		pq = &PriorityQueue{}
+		fq.queues[tenant] = pq
+		// new tenants join at the end of the rotation
+		fq.ring = append(fq.ring, tenant)
+	}
+	pq.Push(value)
+	fq.size++
+}
+
+// Pop removes the next task according to the tenants' weights.
+func (fq *FairQueue) Pop() (IntTask, bool) {
+	if len(fq.ring) == 0 {
+		var zero IntTask
+		return zero, false
+	}
+	if fq.cursor >= len(fq.ring) {
+		fq.cursor = 0
+	}
+	tenant := fq.ring[fq.cursor]
+
+	// a tenant gets its quantum when its turn starts
+	if fq.deficit[tenant] < 1 {
+		fq.deficit[tenant] += config.AppConfig.TenantQuota(tenant).WEIGHT
+	}
+	task, _ := fq.queues[tenant].Pop()
+	fq.size--
+	fq.deficit[tenant]--
+
+	if fq.queues[tenant].IsEmpty() {
+		fq.remove(tenant)
+	} else if fq.deficit[tenant] < 1 {
+		fq.cursor++
+	}
+	return task, true
+}
+
+// remove drops an empty tenant from the rotation. The cursor then points to the
+// tenant after it.
+func (fq *FairQueue) remove(tenant string) {
+	delete(fq.queues, tenant)
+	delete(fq.deficit, tenant)
+	fq.ring = append(fq.ring[:fq.cursor], fq.ring[fq.cursor+1:]...)
+}
+
+func (fq *FairQueue) IsEmpty() bool {
+	return fq.size == 0
+}
+
+// Len returns the number of queued tasks of all tenants.
+func (fq *FairQueue) Len() int {
+	return fq.size
+}
+
+// TenantLen returns the number of queued tasks of a tenant.
+func (fq *FairQueue) TenantLen(tenant string) int {
+	if pq, exists := fq.queues[tenant]; exists {
+		return pq.Len()
+	}
+	return 0
+}
+
+// Find returns the first task of any tenant matching the predicate.
+func (fq *FairQueue) Find(match func(IntTask) bool) (IntTask, bool) {
+	for _, tenant := range fq.ring {
+		if task, ok := fq.queues[tenant].Find(match); ok {
+			return task, true
+		}
+	}
+	var zero IntTask
+	return zero, false
+}
+
+// DeleteByID removes the task with the given ID from whichever tenant holds it.
+func (fq *FairQueue) DeleteByID(id string) bool {
+	for idx, tenant := range fq.ring {
+		if fq.queues[tenant].DeleteByID(id) {
+			fq.size--
+			if fq.queues[tenant].IsEmpty() {
+				delete(fq.queues, tenant)
+				delete(fq.deficit, tenant)
+				fq.ring = append(fq.ring[:idx], fq.ring[idx+1:]...)
+				if idx < fq.cursor {
+					fq.cursor--
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Heads returns the next task of every tenant.
+func (fq *FairQueue) Heads() []IntTask {
+	heads := make([]IntTask, 0, len(fq.ring))
+	for _, tenant := range fq.ring {
+		if task, ok := fq.queues[tenant].Peek(); ok {
+			heads = append(heads, task)
+		}
+	}
+	return heads
+}
+
+// Tasks returns a copy of the queued tasks of all tenants, without removing them.
+func (fq *FairQueue) Tasks() []IntTask {
+	tasks := make([]IntTask, 0, fq.size)
+	for _, tenant := range fq.ring {
+		tasks = append(tasks, fq.queues[tenant].Tasks()...)
+	}
+	return tasks
+}
@@ -0,0 +1,46 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFairQueueWeights(t *testing.T) {
+	original := config.AppConfig.TENANTS
+	config.AppConfig.TENANTS = map[string]config.TenantQuota{"a": {WEIGHT: 2}}
+	t.Cleanup(func() { config.AppConfig.TENANTS = original })
+
+	fq := &FairQueue{}
+	for i := 0; i < 4; i++ {
+		fq.Push(IntTask{ID: "a", Task: models.Task{Tenant: "a", Priority: uint16(i + 1)}})
+		fq.Push(IntTask{ID: "b", Task: models.Task{Tenant: "b", Priority: uint16(i + 1)}})
+	}
+	assert.Equal(t, 8, fq.Len())
+	assert.Equal(t, 4, fq.TenantLen("a"))
+	assert.Len(t, fq.Tasks(), 8)
+	assert.Equal(t, 8, fq.Len(), "Listing tasks must not remove them")
+
+	var order string
+	for !fq.IsEmpty() {
+		task, ok := fq.Pop()
+		assert.True(t, ok)
+		order += task.ID
+	}
+	assert.Equal(t, "aabaabbb", order, "tenant a must get two tasks per round")
+}
+
+func TestFairQueuePriorityWithinTenant(t *testing.T) {
+	fq := &FairQueue{}
+	fq.Push(IntTask{ID: "low", Task: models.Task{Tenant: "a", Priority: 5}})
+	fq.Push(IntTask{ID: "high", Task: models.Task{Tenant: "a", Priority: 1}})
+
+	task, _ := fq.Pop()
+	assert.Equal(t, "high", task.ID)
+
+	assert.True(t, fq.DeleteByID("low"))
+	_, ok := fq.Pop()
+	assert.False(t, ok, "queue must be empty")
+}
@@ -3,15 +3,71 @@ package queue
 import "sync"
 
 type LocalQueue struct {
-	PQ   PriorityQueue
+	PQ   FairQueue
 	Lock sync.Mutex
 }
 
 var (
 	PythonLocalQueue = &LocalQueue{
-		PQ: PriorityQueue{},
+		PQ: FairQueue{},
 	}
 	GoLocalQueue = &LocalQueue{
-		PQ: PriorityQueue{},
+		PQ: FairQueue{},
 	}
 )
+
+var (
+	localQueues = map[string]*LocalQueue{
+		"python_queue": PythonLocalQueue,
+		"go_queue":     GoLocalQueue,
+	}
+	localQueuesMu sync.Mutex
+)
+
+// Local returns the in-memory queue with the given name, creating it on first use.
+func Local(name string) *LocalQueue {
+	localQueuesMu.Lock()
+	defer localQueuesMu.Unlock()
+
+	lq, exists := localQueues[name]
+	if !exists {
+		lq = &LocalQueue{}
+		localQueues[name] = lq
+	}
+	return lq
+}
+
+// Push adds a task to the queue while holding the queue lock.
+func (lq *LocalQueue) Push(task IntTask) {
+	lq.Lock.Lock()
+	defer lq.Lock.Unlock()
+	lq.PQ.Push(task)
+}
+
+// Pop removes the highest priority task while holding the queue lock.
+func (lq *LocalQueue) Pop() (IntTask, bool) {
+	lq.Lock.Lock()
+	defer lq.Lock.Unlock()
+	return lq.PQ.Pop()
+}
+
+// Len returns the number of queued tasks while holding the queue lock.
+func (lq *LocalQueue) Len() int {
+	lq.Lock.Lock()
+	defer lq.Lock.Unlock()
+	return lq.PQ.Len()
+}
+
+// Heads returns the next task of every tenant while holding the queue lock.
+func (lq *LocalQueue) Heads() []IntTask {
+	lq.Lock.Lock()
+	defer lq.Lock.Unlock()
+	return lq.PQ.Heads()
+}
+
+// Tasks returns a copy of all queued tasks while holding the queue lock.
+func (lq *LocalQueue) Tasks() []IntTask {
+	lq.Lock.Lock()
+	defer lq.Lock.Unlock()
+	return lq.PQ.Tasks()
+}
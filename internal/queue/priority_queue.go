@@ -83,6 +83,11 @@ func (pq *PriorityQueue) IsEmpty() bool {
 	return len(pq.data) == 0
 }
 
+// Len returns the number of queued tasks.
+func (pq *PriorityQueue) Len() int {
+	return len(pq.data)
+}
+
 func (pq *PriorityQueue) Push(value IntTask) {
 	pq.data = append(pq.data, value)
 	pq.HeapifyUp(len(pq.data) - 1)
@@ -110,23 +115,56 @@ func (pq *PriorityQueue) Delete(priority uint16) bool {
 	}
 	for idx, val := range pq.data {
 		if val.Task.Priority == uint16(priority) {
-			pq.data[idx] = pq.data[len(pq.data)-1]
-			pq.data = pq.data[:len(pq.data)-1]
-			if idx >= len(pq.data) {
-				return true
-			}
-			parent := pq.Parent(idx)
-			if parent >= 0 && pq.data[parent].GT(&pq.data[idx]) {
-				pq.HeapifyUp(idx)
-			} else {
-				pq.HeapifyDown(idx)
-			}
+			pq.removeAt(idx)
+			return true
+		}
+	}
+	return false
+}
+
+// Find returns the first task matching the predicate. Tasks are visited in
+// storage order, not priority order.
+func (pq *PriorityQueue) Find(match func(IntTask) bool) (IntTask, bool) {
+	for _, val := range pq.data {
+		if match(val) {
+			return val, true
+		}
+	}
+	var zero IntTask
+	return zero, false
+}
+
+// Tasks returns a copy of all queued tasks in storage order.
+func (pq *PriorityQueue) Tasks() []IntTask {
+	return append([]IntTask(nil), pq.data...)
+}
+
+// DeleteByID removes the task with the given ID.
+// Returns true if the task was found and removed.
+func (pq *PriorityQueue) DeleteByID(id string) bool {
+	for idx, val := range pq.data {
+		if val.ID == id {
+			pq.removeAt(idx)
 			return true
 		}
 	}
 	return false
 }
 
+func (pq *PriorityQueue) removeAt(idx int) {
+	pq.data[idx] = pq.data[len(pq.data)-1]
+	pq.data = pq.data[:len(pq.data)-1]
+	if idx >= len(pq.data) {
+		return
+	}
+	parent := pq.Parent(idx)
+	if parent >= 0 && pq.data[parent].GT(&pq.data[idx]) {
+		pq.HeapifyUp(idx)
+	} else {
+		pq.HeapifyDown(idx)
+	}
+}
+
 func (pq *PriorityQueue) HeapifyUp(index int) {
 	for index > 0 {
 		parent := pq.Parent(index)
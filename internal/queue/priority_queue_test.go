@@ -85,3 +85,26 @@ func TestDelete(t *testing.T) {
 	}
 
 }
+
+func TestDeleteByID(t *testing.T) {
+	pq := &PriorityQueue{}
+	for _, task := range tasks {
+		pq.Push(*task)
+	}
+
+	if ok := pq.DeleteByID(tasks[1].ID); !ok {
+		t.Errorf("could not delete task %s", tasks[1].ID)
+	}
+	if _, ok := pq.Find(func(task IntTask) bool { return task.ID == tasks[1].ID }); ok {
+		t.Errorf("deleted task is still in the queue")
+	}
+	if ok := pq.DeleteByID("missing"); ok {
+		t.Errorf("deleted a task which does not exist")
+	}
+
+	for _, want := range []uint16{1, 3, 5} {
+		if val, ok := pq.Pop(); !ok || val.Task.Priority != want {
+			t.Errorf("priority queue pop value: %d, must be %d", val.Task.Priority, want)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+-- allowScript of redis.go, also run by processing/worker.py. Takes a token from
+-- a bucket. Returns 0 if a token was taken, otherwise the number of milliseconds
+-- until the next token is available.
+-- KEYS: bucket. ARGV: rate (tokens/s), burst, now (unix ms), bucket ttl (ms).
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+return wait
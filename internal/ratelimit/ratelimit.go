@@ -0,0 +1,49 @@
+// Package ratelimit provides token bucket rate limiting for task execution.
+//
+// LocalLimiter keeps buckets in memory and is shared by all workers of a process.
+// RedisLimiter keeps buckets in Redis so that limits are shared across processes.
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// Rule describes a token bucket. Rate tokens are added every second up to Burst.
+// Each executed task takes one token.
+type Rule struct {
+	Rate  float64
+	Burst int
+}
+
+// Limiter decides whether a task may run now.
+type Limiter interface {
+	// Allow takes a token from the bucket identified by key.
+	// If no token is available it returns false and how long to wait for the next one.
+	Allow(key string, rule Rule) (bool, time.Duration, error)
+}
+
+// process-wide limiter shared by Go workers and the gRPC server in local mode
+var localLimiter = NewLocalLimiter()
+
+// New returns the limiter matching the queue mode: RedisLimiter for `redis`,
+// otherwise the process-wide LocalLimiter so that every worker of the process
+// takes tokens from the same buckets.
+func New(mode string, rdb *redis.Client) Limiter {
+	if mode == "redis" && rdb != nil {
+		return NewRedisLimiter(rdb)
+	}
+	return localLimiter
+}
+
+// refill returns the tokens in a bucket after elapsed time.
+func refill(tokens float64, elapsed time.Duration, rule Rule) float64 {
+	tokens += elapsed.Seconds() * rule.Rate
+	return min(tokens, float64(rule.Burst))
+}
+
+// waitFor returns the time until the bucket holds a whole token.
+func waitFor(tokens float64, rule Rule) time.Duration {
+	return time.Duration((1 - tokens) / rule.Rate * float64(time.Second))
+}
@@ -0,0 +1,33 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewLocalLimiter()
+	l.now = func() time.Time { return now }
+	rule := Rule{Rate: 2, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		allowed, _, err := l.Allow("send_email", rule)
+		assert.NoError(t, err)
+		assert.True(t, allowed, "burst must be allowed")
+	}
+
+	allowed, wait, err := l.Allow("send_email", rule)
+	assert.NoError(t, err)
+	assert.False(t, allowed, "empty bucket must not allow")
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	allowed, _, _ = l.Allow("download_file", rule)
+	assert.True(t, allowed, "buckets must be independent")
+
+	now = now.Add(500 * time.Millisecond)
+	allowed, _, _ = l.Allow("send_email", rule)
+	assert.True(t, allowed, "bucket must refill over time")
+}
@@ -0,0 +1,47 @@
+package ratelimit
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// takes a token from a bucket. Returns 0 if a token was taken, otherwise the
+// number of milliseconds until the next token is available.
+// KEYS: bucket. ARGV: rate (tokens/s), burst, now (unix ms), bucket ttl (ms).
+var allowScript = redis.NewScript(allowLua)
+
+// allowLua is the source of allowScript. The Python worker, which takes tasks
+// from Redis itself, runs the same file.
+//
+//go:embed allow.lua
+var allowLua string
+
+// RedisLimiter is a Limiter whose buckets are stored in Redis hashes.
+// Idle buckets expire once they would have refilled completely.
+type RedisLimiter struct {
+	rdb *redis.Client
+}
+
+var _ Limiter = (*RedisLimiter)(nil)
+
+func NewRedisLimiter(rdb *redis.Client) *RedisLimiter {
+	return &RedisLimiter{rdb: rdb}
+}
+
+func (rl *RedisLimiter) Allow(key string, rule Rule) (bool, time.Duration, error) {
+	ttl := int64(math.Ceil(float64(rule.Burst)/rule.Rate*1000)) + 1000
+	wait, err := allowScript.Run(rl.rdb, []string{key},
+		rule.Rate, rule.Burst, time.Now().UnixMilli(), ttl,
+	).Int64()
+	if err != nil {
+		return false, 0, fmt.Errorf("could not check rate limit %s: %w", key, err)
+	}
+	if wait > 0 {
+		return false, time.Duration(wait) * time.Millisecond, nil
+	}
+	return true, 0, nil
+}
@@ -0,0 +1,46 @@
+// Package readiness tracks whether the app is ready to serve requests and
+// reports it to the service manager.
+package readiness
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+)
+
+var ready atomic.Bool
+
+// Set marks the app as ready once it serves requests, or as not ready while it
+// is shutting down.
+func Set(r bool) {
+	ready.Store(r)
+}
+
+func Ready() bool {
+	return ready.Load()
+}
+
+// Notify sends a state such as "READY=1" or "STOPPING=1" to systemd when the
+// process runs as a Type=notify service. It does nothing otherwise.
+func Notify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	// abstract socket
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("could not connect to notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("could not notify service manager: %w", err)
+	}
+	return nil
+}
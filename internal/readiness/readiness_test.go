@@ -0,0 +1,44 @@
+package readiness
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotify(t *testing.T) {
+	t.Run("WithoutServiceManager", func(t *testing.T) {
+		t.Setenv("NOTIFY_SOCKET", "")
+		assert.NoError(t, Notify("READY=1"))
+	})
+
+	t.Run("SendsState", func(t *testing.T) {
+		addr := filepath.Join(t.TempDir(), "notify.sock")
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+		require.NoError(t, err)
+		defer conn.Close()
+		t.Setenv("NOTIFY_SOCKET", addr)
+
+		require.NoError(t, Notify("READY=1"))
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "READY=1", string(buf[:n]))
+	})
+
+	t.Run("MissingSocket", func(t *testing.T) {
+		t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+		assert.Error(t, Notify("READY=1"))
+	})
+}
+
+func TestReady(t *testing.T) {
+	assert.False(t, Ready())
+	Set(true)
+	assert.True(t, Ready())
+	Set(false)
+	assert.False(t, Ready())
+}
@@ -0,0 +1,43 @@
+package tasks
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/models"
+)
+
+// AffinityRetryDelay is the delay before a task taken by a worker whose labels
+// do not match its affinity is handed out again.
+const AffinityRetryDelay = 200 * time.Millisecond
+
+// ErrUnschedulable is the error of a task which was unschedulable for
+// AFFINITY_TIMEOUT.
+var ErrUnschedulable = errors.New("no worker has the required labels")
+
+// MatchesWorker reports whether a worker with labels may run a task, see
+// models.Affinity. Preferred labels are ignored once the task waited for
+// AFFINITY_FALLBACK.
+func MatchesWorker(task models.IntTask, labels map[string]string) bool {
+	return task.Task.Affinity.Allows(labels, time.Since(task.EnqueuedAt), config.AppConfig.AFFINITY_FALLBACK)
+}
+
+// MarkUnmatched returns a task a worker with labels may not run, to be deferred
+// for AffinityRetryDelay. The task is marked unschedulable if the worker lacks
+// its required labels, unless it already was.
+func MarkUnmatched(task models.IntTask, labels map[string]string) models.IntTask {
+	if task.Task.Affinity.HasRequired(labels) {
+		task.UnschedulableSince = nil
+	} else if task.UnschedulableSince == nil {
+		now := time.Now()
+		task.UnschedulableSince = &now
+	}
+	return task
+}
+
+// isUnschedulableTooLong reports whether a task was unschedulable for AFFINITY_TIMEOUT.
+func isUnschedulableTooLong(task models.IntTask) bool {
+	timeout := config.AppConfig.AFFINITY_TIMEOUT
+	return timeout > 0 && task.UnschedulableSince != nil && time.Since(*task.UnschedulableSince) >= timeout
+}
@@ -0,0 +1,75 @@
+package tasks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchesWorker(t *testing.T) {
+	config.AppConfig.AFFINITY_FALLBACK = time.Minute
+	t.Cleanup(func() { config.AppConfig.AFFINITY_FALLBACK = 0 })
+
+	task := func(affinity *models.Affinity, waited time.Duration) models.IntTask {
+		return models.IntTask{
+			Task:       models.Task{Type: "download_file", Priority: 1, Affinity: affinity},
+			EnqueuedAt: time.Now().Add(-waited),
+		}
+	}
+	large := map[string]string{"disk": "large", "zone": "eu"}
+	small := map[string]string{"disk": "small", "zone": "eu"}
+
+	assert.True(t, MatchesWorker(task(nil, 0), nil), "Tasks without affinity run anywhere")
+
+	required := &models.Affinity{Required: map[string]string{"disk": "large"}}
+	assert.True(t, MatchesWorker(task(required, 0), large))
+	assert.False(t, MatchesWorker(task(required, 0), small))
+	assert.False(t, MatchesWorker(task(required, time.Hour), nil), "Required labels never fall back")
+
+	preferred := &models.Affinity{Required: map[string]string{"zone": "eu"}, Preferred: map[string]string{"disk": "large"}}
+	assert.True(t, MatchesWorker(task(preferred, 0), large))
+	assert.False(t, MatchesWorker(task(preferred, 0), small))
+	assert.True(t, MatchesWorker(task(preferred, 2*time.Minute), small), "Preferred labels fall back after the timeout")
+	assert.False(t, MatchesWorker(task(preferred, 2*time.Minute), map[string]string{"disk": "large"}))
+}
+
+func TestUnschedulableTasksLocal(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	config.AppConfig.AFFINITY_TIMEOUT = time.Minute
+	t.Cleanup(func() { config.AppConfig.AFFINITY_TIMEOUT = 0 })
+
+	small := map[string]string{"disk": "small"}
+	task := models.IntTask{
+		ID:         "unschedulable_test",
+		Task:       models.Task{Type: "download_file", Priority: 1, Affinity: &models.Affinity{Required: map[string]string{"disk": "large"}}},
+		EnqueuedAt: time.Now(),
+	}
+	unmatched := MarkUnmatched(task, small)
+	require.NotNil(t, unmatched.UnschedulableSince)
+	assert.Equal(t, unmatched.UnschedulableSince, MarkUnmatched(unmatched, small).UnschedulableSince, "The first miss should be kept")
+	assert.Nil(t, MarkUnmatched(unmatched, map[string]string{"disk": "large"}).UnschedulableSince, "Tasks waiting for preferred labels are schedulable")
+	assert.False(t, IsExpired(unmatched))
+
+	require.NoError(t, DeferTask(unmatched, time.Hour, nil))
+	t.Cleanup(func() { _, _ = CancelTask(task.ID, nil) })
+	page, err := ListTasks(TaskListOptions{Filter: TaskFilter{States: []TaskState{TaskUnschedulable}}, Limit: 10}, nil)
+	require.NoError(t, err)
+	require.Len(t, page.Tasks, 1)
+	assert.Equal(t, task.ID, page.Tasks[0].ID)
+	page, err = ListTasks(TaskListOptions{Filter: TaskFilter{States: []TaskState{TaskDeferred}}, Limit: 10}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, page.Tasks, "Unschedulable tasks should not be listed as deferred")
+	taskStatus, err := CancelTask(task.ID, nil)
+	require.NoError(t, err)
+	assert.Equal(t, TaskCancelled, taskStatus.State, "Unschedulable tasks should be cancellable")
+
+	longAgo := time.Now().Add(-2 * time.Minute)
+	unmatched.UnschedulableSince = &longAgo
+	assert.True(t, IsExpired(unmatched), "Tasks should expire once unschedulable for AFFINITY_TIMEOUT")
+	config.AppConfig.AFFINITY_TIMEOUT = 0
+	assert.False(t, IsExpired(unmatched), "A zero AFFINITY_TIMEOUT should keep unschedulable tasks")
+}
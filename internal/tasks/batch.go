@@ -0,0 +1,189 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/events"
+	"github.com/Yulian302/qugopy/internal/metrics"
+	"github.com/Yulian302/qugopy/internal/queue"
+	"github.com/Yulian302/qugopy/internal/tracing"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/go-redis/redis"
+)
+
+// MaxBatchSize is the largest number of tasks EnqueueBatch takes at once.
+const MaxBatchSize = 1000
+
+var (
+	// ErrBatchTooLarge is returned by EnqueueBatch for more than MaxBatchSize tasks.
+	ErrBatchTooLarge = fmt.Errorf("a batch holds at most %d tasks", MaxBatchSize)
+	// ErrBatchAborted is the result of the tasks of an atomic batch which were
+	// not enqueued because another task of the batch failed.
+	ErrBatchAborted = errors.New("batch aborted: another task of the batch failed")
+)
+
+// BatchResult is the outcome of enqueuing one task of a batch.
+type BatchResult struct {
+	// ID of the enqueued task, empty if Err is set
+	ID  string
+	Err error
+}
+
+// EnqueueBatch enqueues tasks holding the lock of each local queue once, or in
+// a single Redis pipeline. Each task is validated and enqueued as by
+// EnqueueTask and gets a result, in the order of tasks. An atomic batch is only
+// enqueued if all of its tasks can be; otherwise none are and the tasks which
+// did not fail get ErrBatchAborted. The error is set if the batch could not be
+// enqueued at all.
+func EnqueueBatch(batch []models.Task, atomic bool, rdb *redis.Client) ([]BatchResult, error) {
+	return EnqueueBatchContext(context.Background(), batch, atomic, rdb)
+}
+
+// EnqueueBatchContext is EnqueueBatch as part of the trace of ctx. The tasks
+// of the batch share the span of enqueuing it.
+func EnqueueBatchContext(ctx context.Context, batch []models.Task, atomic bool, rdb *redis.Client) (results []BatchResult, err error) {
+	ctx, span := tracing.StartEnqueue(ctx, "enqueue batch", tracing.BatchSizeKey.Int(len(batch)))
+	defer func() { tracing.End(span, err) }()
+
+	if len(batch) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+	if shuttingDown.Load() {
+		return nil, ErrShuttingDown
+	}
+
+	// queue states are looked up once per batch
+	states := make(map[QueueType]QueueState)
+	stateOf := func(queueType QueueType) (QueueState, error) {
+		if state, exists := states[queueType]; exists {
+			return state, nil
+		}
+		state, err := GetQueueState(queueType, rdb)
+		if err == nil {
+			states[queueType] = state
+		}
+		return state, err
+	}
+
+	results = make([]BatchResult, len(batch))
+	items := make([]enqueuedTask, len(batch))
+	for i, task := range batch {
+		items[i], results[i].Err = prepareTask(ctx, task, stateOf)
+	}
+	if atomic && abortBatch(results) {
+		return results, nil
+	}
+
+	if config.AppConfig.MODE == "redis" {
+		if err := enqueueBatchRedis(items, results, atomic, rdb); err != nil {
+			return nil, err
+		}
+	} else {
+		enqueueBatchLocal(items, results, atomic)
+	}
+	for i, result := range results {
+		if result.Err == nil {
+			publishEvent(events.Enqueued, items[i].queueType, *items[i].intTask, "", nil)
+			metrics.TaskEnqueued(items[i].intTask.Task.Type, string(items[i].queueType))
+		}
+	}
+	return results, nil
+}
+
+// abortBatch reports whether a task of an atomic batch failed, marking the
+// other tasks as aborted.
+func abortBatch(results []BatchResult) bool {
+	failed := slices.ContainsFunc(results, func(result BatchResult) bool { return result.Err != nil })
+	if !failed {
+		return false
+	}
+	for i := range results {
+		if results[i].Err == nil {
+			results[i] = BatchResult{Err: ErrBatchAborted}
+		}
+	}
+	return true
+}
+
+func enqueueBatchLocal(items []enqueuedTask, results []BatchResult, atomic bool) {
+	// queues are locked in name order, so that concurrent batches can not deadlock
+	var queueTypes []QueueType
+	for i, item := range items {
+		if results[i].Err == nil && !slices.Contains(queueTypes, item.queueType) {
+			queueTypes = append(queueTypes, item.queueType)
+		}
+	}
+	slices.Sort(queueTypes)
+	for _, queueType := range queueTypes {
+		lq := localQueue(queueType)
+		lq.Lock.Lock()
+		defer lq.Lock.Unlock()
+	}
+
+	type pushed struct {
+		lq       *queue.LocalQueue
+		id       string
+		replaced *models.IntTask
+	}
+	var done []pushed
+	for i, item := range items {
+		if results[i].Err != nil {
+			continue
+		}
+		lq := localQueue(item.queueType)
+		replaced, err := enqueueLocked(lq, item)
+		if err != nil {
+			results[i].Err = err
+			if atomic {
+				break
+			}
+			continue
+		}
+		results[i].ID = item.intTask.ID
+		done = append(done, pushed{lq: lq, id: item.intTask.ID, replaced: replaced})
+	}
+
+	if atomic && abortBatch(results) {
+		for _, p := range slices.Backward(done) {
+			p.lq.PQ.DeleteByID(p.id)
+			if p.replaced != nil {
+				p.lq.PQ.Push(*p.replaced)
+			}
+		}
+	}
+}
+
+// enqueueBatchRedis adds the tasks which passed validation with one run of
+// enqueueScript, which checks tenant quotas and duplicates as it goes.
+func enqueueBatchRedis(items []enqueuedTask, results []BatchResult, atomic bool, rdb *redis.Client) error {
+	var pending []enqueuedTask
+	var indexes []int
+	for i, item := range items {
+		if results[i].Err == nil {
+			pending = append(pending, item)
+			indexes = append(indexes, i)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	errs, err := enqueueRedis(pending, atomic, rdb)
+	if err != nil {
+		return err
+	}
+	for j, i := range indexes {
+		results[i].Err = errs[j]
+		if errs[j] == nil {
+			results[i].ID = items[i].intTask.ID
+		}
+	}
+	if atomic {
+		abortBatch(results)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package tasks
+
+import (
+	"testing"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnqueueBatchLocal(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	uniqueDownloads(t)
+	download := func(filename string) models.Task {
+		return models.Task{Type: "download_file", Payload: []byte(`{"url": "https://a.com/1", "filename": "` + filename + `"}`), Priority: 1}
+	}
+	queueType, err := GetQueueType("download_file")
+	require.NoError(t, err)
+	lq := localQueue(queueType)
+	defer func() {
+		for lq.Len() > 0 {
+			lq.Pop()
+		}
+	}()
+
+	results, err := EnqueueBatch([]models.Task{
+		download("batch_a.json"),
+		{Type: "download_file", Payload: []byte(`{}`)},
+		download("batch_a.json"),
+		download("batch_b.json"),
+	}, false, nil)
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+	assert.NotEmpty(t, results[0].ID)
+	assert.ErrorContains(t, results[1].Err, "priority must be positive")
+	assert.ErrorIs(t, results[2].Err, ErrDuplicateTask, "Duplicates within a batch should be rejected")
+	assert.NotEmpty(t, results[3].ID)
+	assert.Equal(t, 2, lq.Len())
+
+	// an atomic batch failing on a duplicate of a queued task
+	results, err = EnqueueBatch([]models.Task{download("batch_c.json"), download("batch_b.json")}, true, nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, ErrBatchAborted)
+	assert.Empty(t, results[0].ID)
+	assert.ErrorIs(t, results[1].Err, ErrDuplicateTask)
+	assert.Equal(t, 2, lq.Len(), "Tasks of a failed atomic batch should be taken back")
+
+	results, err = EnqueueBatch([]models.Task{download("batch_c.json"), download("batch_d.json")}, true, nil)
+	require.NoError(t, err)
+	assert.NoError(t, results[0].Err)
+	assert.NoError(t, results[1].Err)
+	assert.Equal(t, 4, lq.Len())
+
+	_, err = EnqueueBatch(make([]models.Task, MaxBatchSize+1), false, nil)
+	assert.ErrorIs(t, err, ErrBatchTooLarge)
+}
@@ -0,0 +1,50 @@
+package tasks
+
+import (
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/models"
+)
+
+// ConcurrencyLeaseTTL is the lease of a concurrency permit. Go workers refresh
+// their permits while a task runs; permits handed to Python workers must be
+// released within the lease or they expire.
+const ConcurrencyLeaseTTL = 5 * time.Minute
+
+// ConcurrencyLimits cap how many tasks of a type run at once across all
+// workers. They are set in code and take precedence over the
+// CONCURRENCY_LIMITS of the configuration.
+var ConcurrencyLimits = map[models.TaskType]int{}
+
+// concurrencyLimit returns the concurrency limit of a task type.
+func concurrencyLimit(taskType string) (int, bool) {
+	if limit, exists := ConcurrencyLimits[models.TaskType(taskType)]; exists {
+		return limit, true
+	}
+	limit, exists := config.AppConfig.CONCURRENCY_LIMITS[taskType]
+	return limit, exists
+}
+
+// AllConcurrencyLimits returns the concurrency limits of all limited task
+// types, those of the configuration overridden by ConcurrencyLimits.
+func AllConcurrencyLimits() map[models.TaskType]int {
+	limits := make(map[models.TaskType]int, len(config.AppConfig.CONCURRENCY_LIMITS)+len(ConcurrencyLimits))
+	for taskType, limit := range config.AppConfig.CONCURRENCY_LIMITS {
+		limits[models.TaskType(taskType)] = limit
+	}
+	for taskType, limit := range ConcurrencyLimits {
+		limits[taskType] = limit
+	}
+	return limits
+}
+
+// ConcurrencyKey returns the semaphore key and limit of a task type.
+// The boolean is false if the type has no concurrency limit.
+func ConcurrencyKey(taskType string) (string, int, bool) {
+	limit, exists := concurrencyLimit(taskType)
+	if !exists || limit <= 0 {
+		return "", 0, false
+	}
+	return "concurrency:" + taskType, limit, true
+}
@@ -0,0 +1,30 @@
+package tasks
+
+import (
+	"testing"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrencyKey(t *testing.T) {
+	_, _, limited := ConcurrencyKey("download_file")
+	assert.False(t, limited, "Task types should not be limited unless configured")
+
+	config.AppConfig.CONCURRENCY_LIMITS = map[string]int{"download_file": 2}
+	t.Cleanup(func() { config.AppConfig.CONCURRENCY_LIMITS = nil })
+	key, limit, limited := ConcurrencyKey("download_file")
+	assert.True(t, limited)
+	assert.Equal(t, "concurrency:download_file", key)
+	assert.Equal(t, 2, limit)
+
+	ConcurrencyLimits[models.DownloadFile] = 1
+	t.Cleanup(func() { delete(ConcurrencyLimits, models.DownloadFile) })
+	_, limit, _ = ConcurrencyKey("download_file")
+	assert.Equal(t, 1, limit, "Limits set in code should take precedence")
+	assert.Equal(t, map[models.TaskType]int{models.DownloadFile: 1}, AllConcurrencyLimits())
+
+	_, _, limited = ConcurrencyKey("send_email")
+	assert.False(t, limited)
+}
@@ -0,0 +1,105 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/go-redis/redis"
+)
+
+// QueueState controls whether a queue accepts and hands out tasks.
+type QueueState string
+
+const (
+	// QueueActive accepts new tasks and hands them out to workers.
+	QueueActive QueueState = "active"
+	// QueuePaused accepts new tasks but workers stop pulling from it. Queued
+	// tasks are kept until the queue is resumed.
+	QueuePaused QueueState = "paused"
+	// QueueDraining rejects new tasks while workers empty the queue.
+	QueueDraining QueueState = "draining"
+)
+
+func (s QueueState) IsValid() bool {
+	return s == QueueActive || s == QueuePaused || s == QueueDraining
+}
+
+// ErrQueueDraining is returned by EnqueueTask for a queue which is being drained.
+var ErrQueueDraining = errors.New("queue is draining and does not accept new tasks")
+
+// ErrShuttingDown is returned by EnqueueTask once the process is shutting down.
+var ErrShuttingDown = errors.New("server is shutting down and does not accept new tasks")
+
+// shuttingDown is set by StopAccepting.
+var shuttingDown atomic.Bool
+
+// StopAccepting makes EnqueueTask reject all new tasks, see ErrShuttingDown.
+func StopAccepting() {
+	shuttingDown.Store(true)
+}
+
+// QueueStatesKey is the Redis hash mapping queue names to their state, so that
+// every instance honours pauses and drains. Queues without an entry are active.
+const QueueStatesKey = "qugopy:queue_states"
+
+var (
+	queueStatesMu sync.RWMutex
+	// states of local mode queues
+	queueStates = make(map[QueueType]QueueState)
+)
+
+// SetQueueState pauses, resumes or drains a queue.
+func SetQueueState(queueType QueueType, state QueueState, rdb *redis.Client) error {
+	if !state.IsValid() {
+		return fmt.Errorf("invalid queue state: %s", state)
+	}
+	if _, exists := GetQueue(queueType); !exists {
+		return fmt.Errorf("%w: %s", ErrUnknownQueue, queueType)
+	}
+
+	if config.AppConfig.MODE == "redis" {
+		if state == QueueActive {
+			return rdb.HDel(QueueStatesKey, string(queueType)).Err()
+		}
+		return rdb.HSet(QueueStatesKey, string(queueType), string(state)).Err()
+	}
+
+	queueStatesMu.Lock()
+	defer queueStatesMu.Unlock()
+	if state == QueueActive {
+		delete(queueStates, queueType)
+	} else {
+		queueStates[queueType] = state
+	}
+	return nil
+}
+
+// GetQueueState returns the state of a queue.
+func GetQueueState(queueType QueueType, rdb *redis.Client) (QueueState, error) {
+	if config.AppConfig.MODE == "redis" {
+		state, err := rdb.HGet(QueueStatesKey, string(queueType)).Result()
+		if err == redis.Nil {
+			return QueueActive, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return QueueState(state), nil
+	}
+
+	queueStatesMu.RLock()
+	defer queueStatesMu.RUnlock()
+	if state, exists := queueStates[queueType]; exists {
+		return state, nil
+	}
+	return QueueActive, nil
+}
+
+// IsQueuePaused reports whether workers must not pull from a queue.
+func IsQueuePaused(queueType QueueType, rdb *redis.Client) (bool, error) {
+	state, err := GetQueueState(queueType, rdb)
+	return state == QueuePaused, err
+}
@@ -0,0 +1,39 @@
+package tasks
+
+import (
+	"testing"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueStates(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	t.Cleanup(func() {
+		_ = SetQueueState(GoQueue, QueueActive, nil)
+	})
+	task := models.Task{Type: "send_email", Payload: []byte(`{}`), Priority: 1}
+
+	state, err := GetQueueState(GoQueue, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, QueueActive, state)
+
+	assert.NoError(t, SetQueueState(GoQueue, QueuePaused, nil))
+	paused, err := IsQueuePaused(GoQueue, nil)
+	assert.NoError(t, err)
+	assert.True(t, paused)
+	assert.NoError(t, EnqueueTask(task, nil), "paused queues must accept tasks")
+
+	assert.NoError(t, SetQueueState(GoQueue, QueueDraining, nil))
+	assert.ErrorIs(t, EnqueueTask(task, nil), ErrQueueDraining)
+	_, exists, _ := DequeueTask(GoQueue, nil)
+	assert.True(t, exists, "queued tasks must survive pause and drain")
+
+	assert.NoError(t, SetQueueState(GoQueue, QueueActive, nil))
+	assert.NoError(t, EnqueueTask(task, nil))
+	_, _, _ = DequeueTask(GoQueue, nil)
+
+	assert.ErrorIs(t, SetQueueState("missing", QueuePaused, nil), ErrUnknownQueue)
+	assert.Error(t, SetQueueState(GoQueue, "stopped", nil))
+}
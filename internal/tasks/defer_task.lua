@@ -0,0 +1,20 @@
+-- deferScript of utility.go, also run by processing/worker.py. Parks a task in
+-- the delayed set until it is due and updates its entry in the task index. A
+-- unique task stays in the unique index, unless a newer duplicate took over its
+-- key while it ran.
+-- KEYS: delayed set, unique index, task index.
+-- ARGV: task json, due (unix ms), unique key (empty if none), task id.
+redis.call("ZADD", KEYS[1], ARGV[2], ARGV[1])
+local entry = redis.call("HGET", KEYS[3], ARGV[4])
+if entry then
+	entry = cjson.decode(entry)
+	entry.task = ARGV[1]
+	redis.call("HSET", KEYS[3], ARGV[4], cjson.encode(entry))
+end
+if ARGV[3] ~= "" then
+	local indexed = redis.call("HGET", KEYS[2], ARGV[3])
+	if not indexed or cjson.decode(indexed).id == ARGV[4] then
+		redis.call("HSET", KEYS[2], ARGV[3], ARGV[1])
+	end
+end
+return 1
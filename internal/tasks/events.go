@@ -0,0 +1,71 @@
+package tasks
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Yulian302/qugopy/internal/events"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/go-redis/redis"
+)
+
+// ErrDeadlineExceeded is the error of a task which was taken from its queue
+// after its deadline.
+var ErrDeadlineExceeded = errors.New("deadline exceeded")
+
+// publishEvent publishes an event about a task of a queue.
+func publishEvent(eventType events.Type, queueType QueueType, task models.IntTask, workerID string, taskErr error) {
+	event := events.Event{
+		Type:     eventType,
+		TaskID:   task.ID,
+		TaskType: task.Task.Type,
+		Queue:    string(queueType),
+		WorkerID: workerID,
+	}
+	if taskErr != nil {
+		event.Error = taskErr.Error()
+	}
+	events.Publish(event)
+}
+
+// ReportProgress publishes the progress of a running task, from 0 to 1, with
+// an optional message.
+func ReportProgress(queueType QueueType, task models.IntTask, workerID string, progress float64, message string) {
+	events.Publish(events.Event{
+		Type:     events.Progress,
+		TaskID:   task.ID,
+		TaskType: task.Task.Type,
+		Queue:    string(queueType),
+		WorkerID: workerID,
+		Progress: min(max(progress, 0), 1),
+		Message:  message,
+	})
+}
+
+// IsExpired reports whether a task's deadline has passed or it was
+// unschedulable for AFFINITY_TIMEOUT.
+func IsExpired(task models.IntTask) bool {
+	return isPastDeadline(task) || isUnschedulableTooLong(task)
+}
+
+func isPastDeadline(task models.IntTask) bool {
+	return task.Task.Deadline != nil && time.Now().After(*task.Task.Deadline)
+}
+
+// ExpireTask records a task which was taken from its queue after it expired,
+// see IsExpired, as expired instead of running it.
+func ExpireTask(queueType QueueType, task models.IntTask, workerID string, rdb *redis.Client) error {
+	expiryErr := ErrDeadlineExceeded
+	if !isPastDeadline(task) {
+		expiryErr = ErrUnschedulable
+	}
+	publishEvent(events.Expired, queueType, task, workerID, expiryErr)
+	return recordFinal(task, TaskStatus{
+		ID:       task.ID,
+		Type:     task.Task.Type,
+		Queue:    queueType,
+		State:    TaskExpired,
+		WorkerID: workerID,
+		Error:    expiryErr.Error(),
+	}, rdb)
+}
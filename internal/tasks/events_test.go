@@ -0,0 +1,82 @@
+package tasks
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/events"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskEvents(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	sub := events.Subscribe(events.Filter{TaskTypes: []string{"send_email"}})
+	defer sub.Close()
+	queueType, err := GetQueueType("send_email")
+	require.NoError(t, err)
+	lq := localQueue(queueType)
+	defer func() {
+		for lq.Len() > 0 {
+			lq.Pop()
+		}
+	}()
+
+	next := func() events.Event {
+		t.Helper()
+		select {
+		case event := <-sub.C:
+			return event
+		case <-time.After(time.Second):
+			require.FailNow(t, "no event published")
+			return events.Event{}
+		}
+	}
+
+	id, err := Enqueue(models.Task{Type: "send_email", Payload: []byte(`{"to": "a@b.com", "subject": "hi", "body": "hi"}`), Priority: 1}, nil)
+	require.NoError(t, err)
+	event := next()
+	assert.Equal(t, events.Enqueued, event.Type)
+	assert.Equal(t, id, event.TaskID)
+	assert.Equal(t, string(queueType), event.Queue)
+
+	task, exists := lq.Pop()
+	require.True(t, exists)
+	require.NoError(t, StartTask(queueType, "w1", task, nil))
+	assert.Equal(t, events.Started, next().Type)
+	ReportProgress(queueType, task, "w1", 1.5, "sent")
+	event = next()
+	assert.Equal(t, events.Progress, event.Type)
+	assert.Equal(t, 1.0, event.Progress, "Progress should be capped at 1")
+	require.NoError(t, RecordResult(queueType, task, "w1", errors.New("smtp down"), nil))
+	require.NoError(t, FinishTask(queueType, task.ID, nil))
+	event = next()
+	assert.Equal(t, events.Failed, event.Type)
+	assert.Equal(t, "smtp down", event.Error)
+	assert.Equal(t, "w1", event.WorkerID)
+}
+
+func TestExpireTask(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Minute)
+	task := models.IntTask{ID: "expire_me", Task: models.Task{Type: "send_email", Priority: 1, Deadline: &past}}
+	assert.True(t, IsExpired(task))
+	assert.False(t, IsExpired(models.IntTask{Task: models.Task{Deadline: &future}}))
+	assert.False(t, IsExpired(models.IntTask{}), "Tasks without a deadline should never expire")
+
+	sub := events.Subscribe(events.Filter{TaskIDs: []string{"expire_me"}})
+	defer sub.Close()
+	require.NoError(t, ExpireTask("go_queue", task, "w1", nil))
+	require.Len(t, sub.C, 1)
+	assert.Equal(t, events.Expired, (<-sub.C).Type)
+
+	status, err := GetTaskStatus("expire_me", nil)
+	require.NoError(t, err)
+	assert.Equal(t, TaskExpired, status.State)
+	assert.Equal(t, ErrDeadlineExceeded.Error(), status.Error)
+	assert.True(t, status.Finished())
+}
@@ -0,0 +1,45 @@
+-- fairPopScript of utility.go, also run by processing/worker.py.
+-- KEYS: queue, tenants set, scheduler state. ARGV: weights json.
+local weights = cjson.decode(ARGV[1])
+local tenants = redis.call("SMEMBERS", KEYS[2])
+table.sort(tenants)
+table.insert(tenants, 1, "")
+local n = #tenants
+local idx = 1
+local current = redis.call("HGET", KEYS[3], "cursor")
+if current then
+	for i, t in ipairs(tenants) do
+		if t == current then
+			idx = i
+			break
+		end
+	end
+end
+for _ = 1, n do
+	local t = tenants[idx]
+	local key = KEYS[1]
+	if t ~= "" then
+		key = KEYS[1] .. ":tenant:" .. t
+	end
+	local popped = redis.call("ZPOPMIN", key)
+	if #popped == 0 then
+		redis.call("HDEL", KEYS[3], "d:" .. t)
+		if t ~= "" then
+			redis.call("SREM", KEYS[2], t)
+		end
+		idx = idx % n + 1
+	else
+		local deficit = tonumber(redis.call("HGET", KEYS[3], "d:" .. t)) or 0
+		if deficit < 1 then
+			deficit = deficit + (tonumber(weights[t]) or 1)
+		end
+		deficit = deficit - 1
+		redis.call("HSET", KEYS[3], "d:" .. t, deficit)
+		if deficit < 1 then
+			idx = idx % n + 1
+		end
+		redis.call("HSET", KEYS[3], "cursor", tenants[idx])
+		return popped[1]
+	end
+end
+return false
\ No newline at end of file
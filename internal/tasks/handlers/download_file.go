@@ -54,7 +54,7 @@ func DownloadFile(ctx context.Context, url string, filename string) error {
 	if err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
-	logging.DebugLog(fmt.Sprintf("Downloaded %d bytes to %s\n", n, outputPath))
+	logging.FromContext(ctx).InfoContext(ctx, "downloaded file", "bytes", n, "path", outputPath)
 
 	return nil
 }
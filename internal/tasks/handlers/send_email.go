@@ -5,10 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 
 	"github.com/Yulian302/qugopy/config"
-	"github.com/Yulian302/qugopy/logging"
 )
 
 type EmailPayload struct {
@@ -71,6 +71,6 @@ func SendEmail(clientName string, clientEmail string, recipientName string, reci
 		return fmt.Errorf("email send failed: status %s, body: %s", resp.Status, string(body))
 	}
 
-	logging.DebugLog(fmt.Sprintln("Email sent! Status: ", resp.Status))
+	slog.Info("email sent", "status", resp.Status)
 	return nil
 }
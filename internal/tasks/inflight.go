@@ -0,0 +1,233 @@
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/events"
+	"github.com/Yulian302/qugopy/internal/metrics"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/go-redis/redis"
+)
+
+// InFlightTask is a task taken from its queue which a worker is running.
+type InFlightTask struct {
+	Task      models.IntTask `json:"task"`
+	WorkerID  string         `json:"worker_id"`
+	StartedAt time.Time      `json:"started_at"`
+}
+
+// InFlightKey is the Redis hash mapping IDs of running tasks to their InFlightTask.
+func InFlightKey(queueType QueueType) string {
+	return QueueKey(queueType) + ":inflight"
+}
+
+var (
+	inFlightMu sync.Mutex
+	// running tasks of local mode queues, keyed by task ID
+	inFlight = make(map[QueueType]map[string]InFlightTask)
+)
+
+// StartTask records that a worker started running a task of a queue.
+func StartTask(queueType QueueType, workerID string, task models.IntTask, rdb *redis.Client) error {
+	record := InFlightTask{Task: task, WorkerID: workerID, StartedAt: time.Now()}
+	publishEvent(events.Started, queueType, task, workerID, nil)
+	if !task.EnqueuedAt.IsZero() {
+		metrics.TaskStarted(task.Task.Type, string(queueType), record.StartedAt.Sub(task.EnqueuedAt))
+	}
+
+	if config.AppConfig.MODE == "redis" {
+		recordJson, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("marshal error: %w", err)
+		}
+		return rdb.HSet(InFlightKey(queueType), task.ID, recordJson).Err()
+	}
+
+	inFlightMu.Lock()
+	defer inFlightMu.Unlock()
+	if inFlight[queueType] == nil {
+		inFlight[queueType] = make(map[string]InFlightTask)
+	}
+	inFlight[queueType][task.ID] = record
+	return nil
+}
+
+// FinishTask removes a task recorded by StartTask.
+func FinishTask(queueType QueueType, taskID string, rdb *redis.Client) error {
+	if config.AppConfig.MODE == "redis" {
+		return rdb.HDel(InFlightKey(queueType), taskID).Err()
+	}
+
+	inFlightMu.Lock()
+	defer inFlightMu.Unlock()
+	delete(inFlight[queueType], taskID)
+	return nil
+}
+
+// ListInFlight returns the running tasks of a queue.
+func ListInFlight(queueType QueueType, rdb *redis.Client) ([]InFlightTask, error) {
+	if config.AppConfig.MODE == "redis" {
+		records, err := rdb.HGetAll(InFlightKey(queueType)).Result()
+		if err != nil {
+			return nil, err
+		}
+		running := make([]InFlightTask, 0, len(records))
+		for id, recordJson := range records {
+			var record InFlightTask
+			if err := json.Unmarshal([]byte(recordJson), &record); err != nil {
+				return nil, fmt.Errorf("invalid in-flight task %s: %w", id, err)
+			}
+			running = append(running, record)
+		}
+		return running, nil
+	}
+
+	inFlightMu.Lock()
+	defer inFlightMu.Unlock()
+	running := make([]InFlightTask, 0, len(inFlight[queueType]))
+	for _, record := range inFlight[queueType] {
+		running = append(running, record)
+	}
+	return running, nil
+}
+
+// RequeueTask puts a task which was taken from a queue but not run back into it.
+// In redis mode the task is parked in the delayed set, so that the next promotion
+// by any instance puts it back in its tenant's queue.
+func RequeueTask(queueType QueueType, task models.IntTask, rdb *redis.Client) error {
+	publishEvent(events.Retried, queueType, task, "", nil)
+	metrics.TaskRetried(task.Task.Type, string(queueType))
+	if config.AppConfig.MODE != "redis" {
+		localQueue(queueType).Push(task)
+		return nil
+	}
+
+	return deferRedis(queueType, task, time.Now(), rdb)
+}
+
+// RequeueRunningTask requeues a running task of a queue, e.g. because its worker
+// was stopped before running it. Reports whether the task was running.
+func RequeueRunningTask(queueType QueueType, taskID string, rdb *redis.Client) (bool, error) {
+	running, err := ListInFlight(queueType, rdb)
+	if err != nil {
+		return false, err
+	}
+	for _, record := range running {
+		if record.Task.ID != taskID {
+			continue
+		}
+		if err := RequeueTask(queueType, record.Task, rdb); err != nil {
+			return true, fmt.Errorf("could not requeue task %s: %w", taskID, err)
+		}
+		return true, FinishTask(queueType, taskID, rdb)
+	}
+	return false, nil
+}
+
+// RequeueInFlight requeues the running tasks of a queue whose worker is one of
+// workerIDs, e.g. because the workers were killed. Returns the number of requeued tasks.
+func RequeueInFlight(queueType QueueType, workerIDs map[string]bool, rdb *redis.Client) (int, error) {
+	running, err := ListInFlight(queueType, rdb)
+	if err != nil {
+		return 0, err
+	}
+
+	requeued := 0
+	for _, record := range running {
+		if !workerIDs[record.WorkerID] {
+			continue
+		}
+		if err := RequeueTask(queueType, record.Task, rdb); err != nil {
+			return requeued, fmt.Errorf("could not requeue task %s: %w", record.Task.ID, err)
+		}
+		if err := FinishTask(queueType, record.Task.ID, rdb); err != nil {
+			return requeued, err
+		}
+		requeued++
+	}
+	return requeued, nil
+}
+
+// QueueDepth returns the number of tasks waiting in a queue across all tenants.
+// Deferred tasks are not counted.
+func QueueDepth(queueType QueueType, rdb *redis.Client) (int, error) {
+	if config.AppConfig.MODE != "redis" {
+		return localQueue(queueType).Len(), nil
+	}
+
+	keys, err := queueKeys(queueType, rdb)
+	if err != nil {
+		return 0, err
+	}
+	pipe := rdb.Pipeline()
+	cards := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		cards[i] = pipe.ZCard(key)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return 0, err
+	}
+	depth := 0
+	for _, card := range cards {
+		depth += int(card.Val())
+	}
+	return depth, nil
+}
+
+// HeadWait returns how long the longest waiting of the tasks next in line (the
+// head task of every tenant) has been queued. Zero for an empty queue.
+func HeadWait(queueType QueueType, rdb *redis.Client) (time.Duration, error) {
+	var heads []models.IntTask
+
+	if config.AppConfig.MODE != "redis" {
+		heads = localQueue(queueType).Heads()
+	} else {
+		keys, err := queueKeys(queueType, rdb)
+		if err != nil {
+			return 0, err
+		}
+		pipe := rdb.Pipeline()
+		ranges := make([]*redis.StringSliceCmd, len(keys))
+		for i, key := range keys {
+			ranges[i] = pipe.ZRange(key, 0, 0)
+		}
+		if _, err := pipe.Exec(); err != nil {
+			return 0, err
+		}
+		for _, r := range ranges {
+			for _, member := range r.Val() {
+				var task models.IntTask
+				if err := json.Unmarshal([]byte(member), &task); err == nil {
+					heads = append(heads, task)
+				}
+			}
+		}
+	}
+
+	var wait time.Duration
+	for _, task := range heads {
+		if task.EnqueuedAt.IsZero() {
+			continue
+		}
+		wait = max(wait, time.Since(task.EnqueuedAt))
+	}
+	return wait, nil
+}
+
+// queueKeys returns the Redis sorted sets of a queue: its own key and the keys
+// of all tenants with queued tasks.
+func queueKeys(queueType QueueType, rdb *redis.Client) ([]string, error) {
+	tenants, err := rdb.SMembers(TenantsKey(queueType)).Result()
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{QueueKey(queueType)}
+	for _, tenant := range tenants {
+		keys = append(keys, TenantQueueKey(queueType, tenant))
+	}
+	return keys, nil
+}
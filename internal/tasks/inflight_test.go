@@ -0,0 +1,34 @@
+package tasks
+
+import (
+	"testing"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequeueInFlight(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	require.NoError(t, DeclareQueue(QueueSpec{Name: "inflight_test", Runtime: GoRuntime}, nil))
+
+	own := models.IntTask{ID: "own", Task: models.Task{Type: "send_email", Priority: 1}}
+	other := models.IntTask{ID: "other", Task: models.Task{Type: "send_email", Priority: 1}}
+	require.NoError(t, StartTask("inflight_test", "w1", own, nil))
+	require.NoError(t, StartTask("inflight_test", "w2", other, nil))
+
+	n, err := RequeueInFlight("inflight_test", map[string]bool{"w1": true}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	task, exists, err := DequeueTask("inflight_test", nil)
+	require.NoError(t, err)
+	require.True(t, exists, "Task of the given worker should be back in its queue")
+	assert.Equal(t, "own", task.ID)
+
+	running, err := ListInFlight("inflight_test", nil)
+	require.NoError(t, err)
+	require.Len(t, running, 1, "Tasks of other workers should keep running")
+	assert.Equal(t, "other", running[0].Task.ID)
+}
@@ -0,0 +1,692 @@
+package tasks
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/go-redis/redis"
+)
+
+// TaskState is where a task is in its lifecycle.
+type TaskState string
+
+const (
+	TaskQueued TaskState = "queued"
+	// TaskDeferred tasks wait to go back into their queue, e.g. because their
+	// concurrency limit was reached when a worker took them.
+	TaskDeferred TaskState = "deferred"
+	// TaskUnschedulable tasks are deferred tasks which were only taken by workers
+	// without their required labels, see models.Affinity. They expire after
+	// AFFINITY_TIMEOUT.
+	TaskUnschedulable TaskState = "unschedulable"
+	TaskRunning       TaskState = "running"
+)
+
+// TaskStates are the states of the tasks listed by ListTasks, in lifecycle order.
+var TaskStates = []TaskState{TaskQueued, TaskDeferred, TaskUnschedulable, TaskRunning}
+
+// deferredState is the state of a deferred task.
+func deferredState(task models.IntTask) TaskState {
+	if task.UnschedulableSince != nil {
+		return TaskUnschedulable
+	}
+	return TaskDeferred
+}
+
+// TaskRecord is a task as listed by ListTasks.
+type TaskRecord struct {
+	models.IntTask
+	Queue QueueType `json:"queue"`
+	State TaskState `json:"state"`
+
+	// WorkerID and StartedAt are set for running tasks.
+	WorkerID  string     `json:"worker_id,omitempty"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	// DueAt is set for deferred tasks.
+	DueAt *time.Time `json:"due_at,omitempty"`
+}
+
+// TaskFilter selects the tasks listed by ListTasks. Zero fields match all tasks.
+type TaskFilter struct {
+	States []TaskState
+	Types  []string
+	Queues []QueueType
+	Tenant string
+
+	MinPriority uint16
+	MaxPriority uint16
+
+	// bounds of the time tasks were enqueued, inclusive
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+func (f TaskFilter) matches(record TaskRecord) bool {
+	task := record.Task
+	switch {
+	case len(f.Types) > 0 && !slices.Contains(f.Types, task.Type):
+		return false
+	case f.Tenant != "" && task.Tenant != f.Tenant:
+		return false
+	case f.MinPriority > 0 && task.Priority < f.MinPriority:
+		return false
+	case f.MaxPriority > 0 && task.Priority > f.MaxPriority:
+		return false
+	case !f.CreatedAfter.IsZero() && record.EnqueuedAt.Before(f.CreatedAfter):
+		return false
+	case !f.CreatedBefore.IsZero() && record.EnqueuedAt.After(f.CreatedBefore):
+		return false
+	}
+	return true
+}
+
+// TaskSort is the field tasks are listed by.
+type TaskSort string
+
+const (
+	SortByCreatedAt TaskSort = "created_at"
+	SortByPriority  TaskSort = "priority"
+)
+
+const (
+	// DefaultTaskLimit is the page size of ListTasks if none is given.
+	DefaultTaskLimit = 50
+	// MaxTaskLimit is the largest page size of ListTasks.
+	MaxTaskLimit = 500
+)
+
+// ErrInvalidCursor is returned by ListTasks for a cursor it did not return, or
+// one returned for another sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// TaskListOptions select, order and page the tasks listed by ListTasks.
+type TaskListOptions struct {
+	Filter TaskFilter
+	// Sort defaults to SortByCreatedAt. Ties are ordered by task ID.
+	Sort TaskSort
+	Desc bool
+	// Limit defaults to DefaultTaskLimit and is capped at MaxTaskLimit.
+	Limit int
+	// Cursor is the NextCursor of the previous page, empty for the first page.
+	Cursor string
+}
+
+// TaskPage is a page of tasks listed by ListTasks.
+type TaskPage struct {
+	Tasks []TaskRecord `json:"tasks"`
+	// NextCursor fetches the next page, empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// taskCursor points after the last task of a page. Pages continue after it, so
+// tasks which are added or leave meanwhile do not shift the pages.
+type taskCursor struct {
+	Sort TaskSort `json:"s"`
+	Desc bool     `json:"d"`
+	Key  int64    `json:"k"`
+	ID   string   `json:"id"`
+}
+
+func (c taskCursor) encode() string {
+	cursorJson, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(cursorJson)
+}
+
+func decodeCursor(raw string) (taskCursor, error) {
+	var c taskCursor
+	cursorJson, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(cursorJson, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// sortKey is the value a task is ordered by. Creation times are in
+// microseconds, so that they are exact as scores of the sort indexes.
+func sortKey(task models.IntTask, by TaskSort) int64 {
+	if by == SortByPriority {
+		return int64(task.Task.Priority)
+	}
+	return task.EnqueuedAt.UnixMicro()
+}
+
+// TaskIndexKey is the Redis hash mapping the IDs of queued, deferred and running
+// tasks to their indexEntry. Entries are kept until the task finishes or is
+// cancelled.
+const TaskIndexKey = "qugopy:tasks"
+
+// indexEntry locates a task in Redis: its queue and the member it is stored as
+// in its tenant's queue or the delayed set.
+type indexEntry struct {
+	Queue QueueType `json:"queue"`
+	Task  string    `json:"task"`
+}
+
+// SortIndexKey is the Redis sorted set holding the IDs of a queue's indexed
+// tasks scored by their sortKey. Tasks with equal scores are ordered by ID, as
+// ListTasks orders them.
+func SortIndexKey(queueType QueueType, by TaskSort) string {
+	return QueueKey(queueType) + ":sort:" + string(by)
+}
+
+// pages a sort index: returns the IDs and scores of up to ARGV[6] tasks with
+// scores between ARGV[1] and ARGV[2] which come after the task ARGV[4] with score
+// ARGV[3], in no particular order. The cursor's position among the tasks with its
+// score is found by a binary search, as they are ordered by ID.
+// KEYS: sort index. ARGV: min, max, cursor score (empty for the first page),
+// cursor id, descending ("1" or "0"), count.
+var pageScript = redis.NewScript(`
+local key, count, desc = KEYS[1], tonumber(ARGV[6]), ARGV[5] == "1"
+
+-- compares bytes, as Redis orders members
+local function before(a, b)
+	for i = 1, math.min(#a, #b) do
+		local x, y = a:byte(i), b:byte(i)
+		if x ~= y then
+			return x < y
+		end
+	end
+	return #a < #b
+end
+
+-- ascending positions of the first and last task within the bounds
+local first = redis.call("ZCOUNT", key, "-inf", "(" .. ARGV[1])
+local last = redis.call("ZCOUNT", key, "-inf", ARGV[2]) - 1
+if ARGV[3] ~= "" then
+	local lo = redis.call("ZCOUNT", key, "-inf", "(" .. ARGV[3])
+	local hi = lo + redis.call("ZCOUNT", key, ARGV[3], ARGV[3])
+	-- the first position whose ID is after the cursor's or, descending, not before it
+	while lo < hi do
+		local mid = math.floor((lo + hi) / 2)
+		local id = redis.call("ZRANGE", key, mid, mid)[1]
+		if before(id, ARGV[4]) or (not desc and id == ARGV[4]) then
+			lo = mid + 1
+		else
+			hi = mid
+		end
+	end
+	if desc then
+		last = math.min(last, lo - 1)
+	else
+		first = math.max(first, lo)
+	end
+end
+if first > last then
+	return {}
+end
+if desc then
+	first = math.max(first, last - count + 1)
+else
+	last = math.min(last, first + count - 1)
+end
+return redis.call("ZRANGE", key, first, last, "WITHSCORES")`)
+
+// ListTasks returns a page of the queued, deferred and running tasks matching a
+// filter, without taking them from their queues. Tasks are read from the local
+// queues or, in redis mode, paged from the sort indexes of their queues.
+func ListTasks(opts TaskListOptions, rdb *redis.Client) (TaskPage, error) {
+	if opts.Sort == "" {
+		opts.Sort = SortByCreatedAt
+	}
+	if opts.Sort != SortByCreatedAt && opts.Sort != SortByPriority {
+		return TaskPage{}, fmt.Errorf("invalid sort: %s", opts.Sort)
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultTaskLimit
+	}
+	opts.Limit = min(opts.Limit, MaxTaskLimit)
+
+	var cursor *taskCursor
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return TaskPage{}, err
+		}
+		if c.Sort != opts.Sort || c.Desc != opts.Desc {
+			return TaskPage{}, fmt.Errorf("%w: the cursor is for another sort order", ErrInvalidCursor)
+		}
+		cursor = &c
+	}
+
+	var (
+		records []TaskRecord
+		err     error
+	)
+	if config.AppConfig.MODE == "redis" {
+		records, err = pageRedis(opts, cursor, rdb)
+	} else {
+		records, err = pageLocal(opts, cursor)
+	}
+	if err != nil {
+		return TaskPage{}, err
+	}
+
+	page := TaskPage{Tasks: records}
+	if len(records) > opts.Limit {
+		page.Tasks = records[:opts.Limit]
+		last := page.Tasks[opts.Limit-1]
+		page.NextCursor = taskCursor{Sort: opts.Sort, Desc: opts.Desc, Key: sortKey(last.IntTask, opts.Sort), ID: last.ID}.encode()
+	}
+	return page, nil
+}
+
+// compareTasks orders tasks by their sortKey, then by ID.
+func compareTasks(opts TaskListOptions, key int64, id string, task models.IntTask) int {
+	order := cmp.Or(cmp.Compare(key, sortKey(task, opts.Sort)), cmp.Compare(id, task.ID))
+	if opts.Desc {
+		return -order
+	}
+	return order
+}
+
+// pageLocal returns the tasks of local mode after the cursor, in order.
+func pageLocal(opts TaskListOptions, cursor *taskCursor) ([]TaskRecord, error) {
+	records, err := collectTasks(opts.Filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(records, func(a, b TaskRecord) int {
+		return compareTasks(opts, sortKey(a.IntTask, opts.Sort), a.ID, b.IntTask)
+	})
+	if cursor != nil {
+		start, _ := slices.BinarySearchFunc(records, *cursor, func(record TaskRecord, c taskCursor) int {
+			if compareTasks(opts, c.Key, c.ID, record.IntTask) >= 0 {
+				return -1
+			}
+			return 1
+		})
+		records = records[start:]
+	}
+	return records, nil
+}
+
+// indexedTask is a task ID read from a sort index.
+type indexedTask struct {
+	queue QueueType
+	id    string
+	score int64
+}
+
+// pageRedis returns up to opts.Limit+1 tasks after the cursor, in order. The
+// sort indexes of the listed queues are paged in Redis, bounded by the filter
+// of the sorted field. The other filters are applied to the tasks read, so the
+// indexes are read on until the page is full or they are exhausted.
+func pageRedis(opts TaskListOptions, cursor *taskCursor, rdb *redis.Client) ([]TaskRecord, error) {
+	queueTypes, err := listedQueues(opts.Filter)
+	if err != nil {
+		return nil, err
+	}
+	filter := opts.Filter
+	minScore, maxScore := "-inf", "+inf"
+	if opts.Sort == SortByPriority {
+		if filter.MinPriority > 0 {
+			minScore = strconv.Itoa(int(filter.MinPriority))
+		}
+		if filter.MaxPriority > 0 {
+			maxScore = strconv.Itoa(int(filter.MaxPriority))
+		}
+	} else {
+		if !filter.CreatedAfter.IsZero() {
+			minScore = strconv.FormatInt(filter.CreatedAfter.UnixMicro(), 10)
+		}
+		if !filter.CreatedBefore.IsZero() {
+			maxScore = strconv.FormatInt(filter.CreatedBefore.UnixMicro(), 10)
+		}
+	}
+	desc := "0"
+	if opts.Desc {
+		desc = "1"
+	}
+	after, afterID := "", ""
+	if cursor != nil {
+		after, afterID = strconv.FormatInt(cursor.Key, 10), cursor.ID
+	}
+
+	var records []TaskRecord
+	for len(records) <= opts.Limit {
+		// every index returns the tasks it holds next, so the first of all of
+		// them are the next tasks of the listing
+		need := opts.Limit + 1 - len(records)
+		var candidates []indexedTask
+		for _, queueType := range queueTypes {
+			page, err := pageScript.Run(rdb, []string{SortIndexKey(queueType, opts.Sort)},
+				minScore, maxScore, after, afterID, desc, need,
+			).Result()
+			if err != nil {
+				return nil, err
+			}
+			members := page.([]interface{})
+			for i := 0; i+1 < len(members); i += 2 {
+				id, _ := members[i].(string)
+				score, err := strconv.ParseInt(fmt.Sprint(members[i+1]), 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid score of task %s: %w", id, err)
+				}
+				candidates = append(candidates, indexedTask{queue: queueType, id: id, score: score})
+			}
+		}
+		slices.SortFunc(candidates, func(a, b indexedTask) int {
+			order := cmp.Or(cmp.Compare(a.score, b.score), cmp.Compare(a.id, b.id))
+			if opts.Desc {
+				return -order
+			}
+			return order
+		})
+		exhausted := len(candidates) < need
+		candidates = candidates[:min(need, len(candidates))]
+
+		ids := make([]string, len(candidates))
+		for i, candidate := range candidates {
+			ids[i] = candidate.id
+		}
+		found, err := loadIndexed(ids, rdb)
+		if err != nil {
+			return nil, err
+		}
+		for _, candidate := range candidates {
+			record, exists := found[candidate.id]
+			if !exists || (len(filter.States) > 0 && !slices.Contains(filter.States, record.State)) || !filter.matches(record) {
+				continue
+			}
+			records = append(records, record)
+		}
+		if exhausted {
+			break
+		}
+		last := candidates[len(candidates)-1]
+		after, afterID = strconv.FormatInt(last.score, 10), last.id
+	}
+	return records, nil
+}
+
+// loadIndexed reads tasks of the task index where they are stored, keyed by ID.
+// Tasks which are neither queued, deferred nor running are left out, e.g. one a
+// worker took and did not start yet.
+func loadIndexed(ids []string, rdb *redis.Client) (map[string]TaskRecord, error) {
+	found := make(map[string]TaskRecord, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+	entries, err := rdb.HMGet(TaskIndexKey, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	type lookup struct {
+		entry    indexEntry
+		task     models.IntTask
+		queued   *redis.FloatCmd
+		deferred *redis.FloatCmd
+		running  *redis.StringCmd
+	}
+	var lookups []lookup
+	pipe := rdb.Pipeline()
+	for i, raw := range entries {
+		entryJson, ok := raw.(string)
+		if !ok {
+			continue // finished meanwhile
+		}
+		var l lookup
+		if err := json.Unmarshal([]byte(entryJson), &l.entry); err != nil {
+			return nil, fmt.Errorf("invalid index entry of task %s: %w", ids[i], err)
+		}
+		if err := json.Unmarshal([]byte(l.entry.Task), &l.task); err != nil {
+			return nil, fmt.Errorf("invalid indexed task %s: %w", ids[i], err)
+		}
+		l.queued = pipe.ZScore(TenantQueueKey(l.entry.Queue, l.task.Task.Tenant), l.entry.Task)
+		l.deferred = pipe.ZScore(DelayedKey(l.entry.Queue), l.entry.Task)
+		l.running = pipe.HGet(InFlightKey(l.entry.Queue), l.task.ID)
+		lookups = append(lookups, l)
+	}
+	if len(lookups) == 0 {
+		return found, nil
+	}
+	if _, err := pipe.Exec(); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	// states are looked up in lifecycle order, so that a task which moved on
+	// meanwhile is found in a later state
+	for _, l := range lookups {
+		record := TaskRecord{IntTask: l.task, Queue: l.entry.Queue}
+		if due, err := l.deferred.Result(); err == nil {
+			dueAt := time.UnixMilli(int64(due))
+			record.State, record.DueAt = deferredState(l.task), &dueAt
+		}
+		if _, err := l.queued.Result(); err == nil {
+			record.State, record.DueAt = TaskQueued, nil
+		}
+		if record.State == "" {
+			recordJson, err := l.running.Result()
+			if err != nil {
+				continue
+			}
+			var running InFlightTask
+			if err := json.Unmarshal([]byte(recordJson), &running); err != nil {
+				return nil, fmt.Errorf("invalid in-flight task %s: %w", l.task.ID, err)
+			}
+			startedAt := running.StartedAt
+			record.State, record.WorkerID, record.StartedAt = TaskRunning, running.WorkerID, &startedAt
+		}
+		found[l.task.ID] = record
+	}
+	return found, nil
+}
+
+// listedQueues returns the queues a filter selects, all of them if it selects none.
+func listedQueues(filter TaskFilter) ([]QueueType, error) {
+	if len(filter.Queues) == 0 {
+		var queueTypes []QueueType
+		for _, spec := range Queues() {
+			queueTypes = append(queueTypes, spec.Name)
+		}
+		return queueTypes, nil
+	}
+	for _, queueType := range filter.Queues {
+		if _, exists := GetQueue(queueType); !exists {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownQueue, queueType)
+		}
+	}
+	return filter.Queues, nil
+}
+
+// collectTasks returns all tasks matching a filter, in no particular order.
+func collectTasks(filter TaskFilter, rdb *redis.Client) ([]TaskRecord, error) {
+	queueTypes, err := listedQueues(filter)
+	if err != nil {
+		return nil, err
+	}
+	states := filter.States
+	if len(states) == 0 {
+		states = TaskStates
+	}
+
+	var records []TaskRecord
+	add := func(record TaskRecord) {
+		if slices.Contains(states, record.State) && filter.matches(record) {
+			records = append(records, record)
+		}
+	}
+	for _, queueType := range queueTypes {
+		// deferred and unschedulable tasks are collected together
+		collectedDeferred := false
+		for _, state := range states {
+			var err error
+			switch state {
+			case TaskQueued:
+				err = collectQueued(queueType, filter.Tenant, rdb, add)
+			case TaskDeferred, TaskUnschedulable:
+				if collectedDeferred {
+					continue
+				}
+				collectedDeferred = true
+				err = collectDeferred(queueType, rdb, add)
+			case TaskRunning:
+				err = collectRunning(queueType, rdb, add)
+			default:
+				err = fmt.Errorf("invalid task state: %s", state)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return records, nil
+}
+
+func collectQueued(queueType QueueType, tenant string, rdb *redis.Client, add func(TaskRecord)) error {
+	if config.AppConfig.MODE != "redis" {
+		for _, task := range localQueue(queueType).Tasks() {
+			add(TaskRecord{IntTask: task, Queue: queueType, State: TaskQueued})
+		}
+		return nil
+	}
+
+	keys, err := queuedKeys(queueType, tenant, rdb)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		members, err := rdb.ZRange(key, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			var task models.IntTask
+			if err := json.Unmarshal([]byte(member), &task); err != nil {
+				return fmt.Errorf("invalid queued task in %s: %w", key, err)
+			}
+			add(TaskRecord{IntTask: task, Queue: queueType, State: TaskQueued})
+		}
+	}
+	return nil
+}
+
+// queuedKeys returns the Redis keys of a tenant's queue, or of the queues of
+// all tenants if tenant is empty.
+func queuedKeys(queueType QueueType, tenant string, rdb *redis.Client) ([]string, error) {
+	keys := []string{TenantQueueKey(queueType, tenant)}
+	if tenant == "" {
+		tenants, err := rdb.SMembers(TenantsKey(queueType)).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tenants {
+			keys = append(keys, TenantQueueKey(queueType, t))
+		}
+	}
+	return keys, nil
+}
+
+func collectDeferred(queueType QueueType, rdb *redis.Client, add func(TaskRecord)) error {
+	if config.AppConfig.MODE != "redis" {
+		deferredMu.Lock()
+		defer deferredMu.Unlock()
+		for _, d := range deferred[queueType] {
+			dueAt := d.dueAt
+			add(TaskRecord{IntTask: d.task, Queue: queueType, State: deferredState(d.task), DueAt: &dueAt})
+		}
+		return nil
+	}
+
+	members, err := rdb.ZRangeWithScores(DelayedKey(queueType), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		var task models.IntTask
+		if err := json.Unmarshal([]byte(member.Member.(string)), &task); err != nil {
+			return fmt.Errorf("invalid deferred task of %s: %w", queueType, err)
+		}
+		dueAt := time.UnixMilli(int64(member.Score))
+		add(TaskRecord{IntTask: task, Queue: queueType, State: deferredState(task), DueAt: &dueAt})
+	}
+	return nil
+}
+
+func collectRunning(queueType QueueType, rdb *redis.Client, add func(TaskRecord)) error {
+	running, err := ListInFlight(queueType, rdb)
+	if err != nil {
+		return err
+	}
+	for _, record := range running {
+		startedAt := record.StartedAt
+		add(TaskRecord{IntTask: record.Task, Queue: queueType, State: TaskRunning, WorkerID: record.WorkerID, StartedAt: &startedAt})
+	}
+	return nil
+}
+
+type deferredTask struct {
+	task  models.IntTask
+	dueAt time.Time
+	timer *time.Timer
+}
+
+var (
+	deferredMu sync.Mutex
+	// tasks of local mode queues waiting to go back into their queue, keyed by task ID
+	deferred = make(map[QueueType]map[string]deferredTask)
+)
+
+// deferLocal puts a task back into a local queue after delay, keeping it
+// listable meanwhile.
+func deferLocal(queueType QueueType, task models.IntTask, delay time.Duration) {
+	deferredMu.Lock()
+	defer deferredMu.Unlock()
+	if deferred[queueType] == nil {
+		deferred[queueType] = make(map[string]deferredTask)
+	}
+	timer := time.AfterFunc(delay, func() {
+		// the task is moved while both locks are held, so that enqueueLocked
+		// finds it either deferred or queued
+		lq := localQueue(queueType)
+		lq.Lock.Lock()
+		defer lq.Lock.Unlock()
+		deferredMu.Lock()
+		defer deferredMu.Unlock()
+		if _, exists := deferred[queueType][task.ID]; !exists {
+			return // undeferred while the timer fired
+		}
+		delete(deferred[queueType], task.ID)
+		lq.PQ.Push(task)
+	})
+	deferred[queueType][task.ID] = deferredTask{task: task, dueAt: time.Now().Add(delay), timer: timer}
+}
+
+// undeferLocal removes a task deferred by deferLocal before it is due. Reports
+// whether the task was still deferred.
+func undeferLocal(queueType QueueType, taskID string) (models.IntTask, bool) {
+	deferredMu.Lock()
+	defer deferredMu.Unlock()
+	d, exists := deferred[queueType][taskID]
+	if !exists {
+		return models.IntTask{}, false
+	}
+	d.timer.Stop()
+	delete(deferred[queueType], taskID)
+	return d.task, true
+}
+
+// findDeferredLocal returns a task deferred by deferLocal which matches.
+func findDeferredLocal(queueType QueueType, match func(models.IntTask) bool) (models.IntTask, bool) {
+	deferredMu.Lock()
+	defer deferredMu.Unlock()
+	for _, d := range deferred[queueType] {
+		if match(d.task) {
+			return d.task, true
+		}
+	}
+	return models.IntTask{}, false
+}
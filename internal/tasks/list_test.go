@@ -0,0 +1,94 @@
+package tasks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/queue"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListTasks(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	require.NoError(t, DeclareQueue(QueueSpec{Name: "list_test", Runtime: GoRuntime}, nil))
+	lq := queue.Local("list_test")
+	defer func() {
+		for lq.Len() > 0 {
+			lq.Pop()
+		}
+	}()
+
+	created := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	newTask := func(id, taskType, tenant string, priority uint16, age int) models.IntTask {
+		return models.IntTask{
+			ID:         id,
+			Task:       models.Task{Type: taskType, Priority: priority, Tenant: tenant},
+			EnqueuedAt: created.Add(time.Duration(age) * time.Minute),
+		}
+	}
+	lq.Push(newTask("q1", "send_email", "", 1, 1))
+	lq.Push(newTask("q2", "process_image", "acme", 5, 2))
+	lq.Push(newTask("q3", "send_email", "acme", 3, 3))
+	require.NoError(t, StartTask("list_test", "w1", newTask("r1", "send_email", "", 2, 0), nil))
+	defer FinishTask("list_test", "r1", nil)
+	deferLocal("list_test", newTask("d1", "send_email", "", 4, 4), time.Hour)
+	defer func() {
+		deferredMu.Lock()
+		delete(deferred, "list_test")
+		deferredMu.Unlock()
+	}()
+
+	ids := func(page TaskPage) []string {
+		var ids []string
+		for _, record := range page.Tasks {
+			ids = append(ids, record.ID)
+		}
+		return ids
+	}
+	list := func(opts TaskListOptions) TaskPage {
+		opts.Filter.Queues = []QueueType{"list_test"}
+		page, err := ListTasks(opts, nil)
+		require.NoError(t, err)
+		return page
+	}
+
+	page := list(TaskListOptions{})
+	assert.Equal(t, []string{"r1", "q1", "q2", "q3", "d1"}, ids(page), "Tasks should be listed oldest first")
+	assert.Equal(t, 3, lq.Len(), "Listing should not take tasks from the queue")
+	assert.Empty(t, page.NextCursor)
+	assert.Equal(t, TaskRunning, page.Tasks[0].State)
+	assert.Equal(t, "w1", page.Tasks[0].WorkerID)
+	assert.Equal(t, TaskDeferred, page.Tasks[4].State)
+	assert.NotNil(t, page.Tasks[4].DueAt)
+
+	assert.Equal(t, []string{"q1", "q2", "q3"}, ids(list(TaskListOptions{Filter: TaskFilter{States: []TaskState{TaskQueued}}})))
+	assert.Equal(t, []string{"q2"}, ids(list(TaskListOptions{Filter: TaskFilter{Types: []string{"process_image"}}})))
+	assert.Equal(t, []string{"q2", "q3"}, ids(list(TaskListOptions{Filter: TaskFilter{Tenant: "acme"}})))
+	assert.Equal(t, []string{"r1", "q3", "d1"}, ids(list(TaskListOptions{Filter: TaskFilter{MinPriority: 2, MaxPriority: 4}})))
+	assert.Equal(t, []string{"q2", "q3"}, ids(list(TaskListOptions{Filter: TaskFilter{
+		CreatedAfter:  created.Add(2 * time.Minute),
+		CreatedBefore: created.Add(3 * time.Minute),
+	}})))
+
+	opts := TaskListOptions{Sort: SortByPriority, Desc: true, Limit: 2}
+	var all []string
+	for {
+		page := list(opts)
+		all = append(all, ids(page)...)
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	assert.Equal(t, []string{"q2", "d1", "q3", "r1", "q1"}, all)
+
+	_, err := ListTasks(TaskListOptions{Sort: SortByPriority, Cursor: opts.Cursor}, nil)
+	assert.ErrorIs(t, err, ErrInvalidCursor, "A cursor should only continue its own sort order")
+	_, err = ListTasks(TaskListOptions{Cursor: "not a cursor"}, nil)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, err = ListTasks(TaskListOptions{Filter: TaskFilter{Queues: []QueueType{"missing"}}}, nil)
+	assert.ErrorIs(t, err, ErrUnknownQueue)
+}
@@ -0,0 +1,63 @@
+package tasks
+
+import (
+	"log/slog"
+
+	"github.com/Yulian302/qugopy/logging"
+	"github.com/go-redis/redis"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	queueDepthDesc = prometheus.NewDesc("qugopy_queue_depth",
+		"Tasks waiting in a queue, deferred tasks excluded.",
+		[]string{"queue"}, nil)
+	activeWorkersDesc = prometheus.NewDesc("qugopy_active_workers",
+		"Workers in the registry, by queue and runtime.",
+		[]string{"queue", "runtime"}, nil)
+)
+
+// gaugeCollector reads the depth of queues and the registered workers when
+// metrics are scraped. In redis mode they are the same for all instances.
+type gaugeCollector struct {
+	rdb *redis.Client
+}
+
+// NewGaugeCollector returns the collector of the queue depth and active worker
+// gauges, see metrics.SetGauges.
+func NewGaugeCollector(rdb *redis.Client) prometheus.Collector {
+	return gaugeCollector{rdb: rdb}
+}
+
+func (gc gaugeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+	ch <- activeWorkersDesc
+}
+
+func (gc gaugeCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, spec := range Queues() {
+		depth, err := QueueDepth(spec.Name, gc.rdb)
+		if err != nil {
+			slog.Error("could not read queue depth", logging.QueueKey, spec.Name, logging.Err(err))
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(depth), string(spec.Name))
+	}
+
+	workers, err := ListWorkers(gc.rdb)
+	if err != nil {
+		slog.Error("could not list workers", logging.Err(err))
+		return
+	}
+	type poolKey struct {
+		queue   QueueType
+		runtime Runtime
+	}
+	pools := make(map[poolKey]int)
+	for _, w := range workers {
+		pools[poolKey{w.Queue, w.Runtime}]++
+	}
+	for pool, count := range pools {
+		ch <- prometheus.MustNewConstMetric(activeWorkersDesc, prometheus.GaugeValue, float64(count), string(pool.queue), string(pool.runtime))
+	}
+}
@@ -0,0 +1,34 @@
+package tasks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGaugeCollector(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	lq := localQueue(GoQueue)
+	defer func() {
+		for lq.Len() > 0 {
+			lq.Pop()
+		}
+	}()
+	for range 2 {
+		_, err := Enqueue(models.Task{Type: "send_email", Payload: []byte(`{"to": "a@b.com", "subject": "hi", "body": "hi"}`), Priority: 1}, nil)
+		require.NoError(t, err)
+	}
+	require.NoError(t, RecordHeartbeat(WorkerInfo{ID: "gauge-w1", Runtime: GoRuntime, Queue: GoQueue, LastSeen: time.Now()}, nil))
+	defer RemoveWorker("gauge-w1", nil)
+
+	out, err := testutil.CollectAndFormat(NewGaugeCollector(nil), expfmt.TypeTextPlain, "qugopy_queue_depth", "qugopy_active_workers")
+	require.NoError(t, err)
+	assert.Contains(t, string(out), `qugopy_queue_depth{queue="go_queue"} 2`)
+	assert.Contains(t, string(out), `qugopy_active_workers{queue="go_queue",runtime="go"} 1`)
+}
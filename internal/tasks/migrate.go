@@ -0,0 +1,87 @@
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/logging"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/go-redis/redis"
+)
+
+// takes the tasks of a queue stored under its pre-prefix keys, which are named
+// after the queue itself, e.g. go_queue and go_queue:tenant:<tenant>, and deletes
+// these keys, so that only one instance migrates each task.
+// KEYS: legacy queue key.
+var takeLegacyScript = redis.NewScript(`
+local base = KEYS[1]
+local keys = {base, base .. ":delayed"}
+for _, tenant in ipairs(redis.call("SMEMBERS", base .. ":tenants")) do
+	table.insert(keys, base .. ":tenant:" .. tenant)
+end
+local tasks = {}
+for _, key in ipairs(keys) do
+	if redis.call("TYPE", key).ok == "zset" then
+		for _, member in ipairs(redis.call("ZRANGE", key, 0, -1)) do
+			table.insert(tasks, member)
+		end
+		redis.call("DEL", key)
+	end
+end
+redis.call("DEL", base .. ":tenants", base .. ":drr", base .. ":unique")
+return tasks`)
+
+// MigrateLegacyQueues moves the tasks left in go_queue and python_queue by
+// versions which did not prefix queue keys with qugopy:queue: into their
+// queues, keeping their IDs. Deferred tasks are queued right away. Returns the
+// number of migrated tasks. Only used in redis mode.
+func MigrateLegacyQueues(rdb *redis.Client) (int, error) {
+	if config.AppConfig.MODE != "redis" {
+		return 0, nil
+	}
+
+	migrated := 0
+	for _, queueType := range []QueueType{GoQueue, PyQueue} {
+		members, err := takeLegacyScript.Run(rdb, []string{string(queueType)}).Result()
+		if err != nil {
+			return migrated, fmt.Errorf("could not take legacy tasks of %s: %w", queueType, err)
+		}
+		var items []enqueuedTask
+		for _, member := range members.([]interface{}) {
+			var task models.IntTask
+			if err := json.Unmarshal([]byte(member.(string)), &task); err != nil {
+				slog.Warn("dropping invalid legacy task", logging.QueueKey, string(queueType), logging.Err(err))
+				continue
+			}
+			task.AgeOffset = ageOffset(queueType)
+			if task.EnqueuedAt.IsZero() {
+				task.EnqueuedAt = time.Now()
+			}
+			uniqueKey, rule, _ := UniqueKey(task.Task)
+			task.UniqueKey = uniqueKey
+			taskJson, err := json.Marshal(task)
+			if err != nil {
+				return migrated, fmt.Errorf("marshal error: %w", err)
+			}
+			items = append(items, enqueuedTask{queueType: queueType, intTask: &task, taskJson: taskJson, rule: rule})
+		}
+		if len(items) == 0 {
+			continue
+		}
+		errs, err := enqueueRedis(items, false, rdb)
+		if err != nil {
+			return migrated, fmt.Errorf("could not migrate legacy tasks of %s: %w", queueType, err)
+		}
+		for i, err := range errs {
+			if err != nil {
+				slog.Warn("dropping legacy task", logging.TaskIDKey, items[i].intTask.ID, logging.QueueKey, string(queueType), logging.Err(err))
+				continue
+			}
+			migrated++
+		}
+	}
+	return migrated, nil
+}
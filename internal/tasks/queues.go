@@ -0,0 +1,370 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/logging"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/go-redis/redis"
+)
+
+// Runtime is the kind of workers consuming a queue.
+type Runtime string
+
+const (
+	GoRuntime     Runtime = "go"
+	PythonRuntime Runtime = "python"
+	// ExternalRuntime queues are consumed by processes speaking the external
+	// worker protocol, see workers/external.go. They are only declared in the
+	// configuration.
+	ExternalRuntime Runtime = "external"
+)
+
+// QueueSpec declares a named queue.
+type QueueSpec struct {
+	Name    QueueType `json:"name" binding:"required,max=64,excludesall=: "`
+	Runtime Runtime   `json:"runtime" binding:"required,oneof=go python"`
+
+	// Workers is the size of the queue's own worker pool. The built-in queues
+	// share the pool sized by --workers instead.
+	Workers int `json:"workers" binding:"min=0,max=64"`
+
+	// AgingSeconds is the wait after which a queued task ranks one priority level
+	// more urgent than a new task, so that low priority tasks are not starved.
+	// 0 disables aging.
+	AgingSeconds int `json:"aging_seconds,omitempty" binding:"min=0"`
+
+	// TaskTypes are routed to the queue when it is declared.
+	TaskTypes []models.TaskType `json:"task_types,omitempty"`
+}
+
+// Aging returns the queue's aging interval.
+func (spec QueueSpec) Aging() time.Duration {
+	return time.Duration(spec.AgingSeconds) * time.Second
+}
+
+var (
+	// ErrQueueExists is returned by DeclareQueue for a queue that is already declared.
+	ErrQueueExists = errors.New("queue already exists")
+	// ErrUnknownQueue is returned for a queue that was never declared.
+	ErrUnknownQueue = errors.New("unknown queue")
+)
+
+// queuesKey is the Redis hash of queues declared through the API, with the task
+// types routed to them, so that all processes share them and they survive restarts.
+const queuesKey = "qugopy:queues"
+
+// queuesChannel is the Redis pub/sub channel on which DeclareQueue announces
+// queues to the running processes, see WatchQueues.
+const queuesChannel = "qugopy:queues:declared"
+
+// age offsets are counted from this point so that scores stay small
+var agingEpoch = time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	queuesMu   sync.RWMutex
+	queueSpecs = map[QueueType]QueueSpec{
+		GoQueue: {Name: GoQueue, Runtime: GoRuntime},
+		PyQueue: {Name: PyQueue, Runtime: PythonRuntime},
+	}
+	// called with every queue declared after startup, by this or, see
+	// WatchQueues, another process
+	queueHooks []func(QueueSpec)
+)
+
+// QueueKey is the Redis sorted set holding a queue's tasks. All other keys of
+// the queue are derived from it.
+func QueueKey(queueType QueueType) string {
+	return "qugopy:queue:" + string(queueType)
+}
+
+// IsBuiltin reports whether the queue is one of go_queue and python_queue.
+func IsBuiltin(queueType QueueType) bool {
+	return queueType == GoQueue || queueType == PyQueue
+}
+
+// taskRuntime is the runtime able to execute a task type: the runtime of the
+// built-in queue the type is routed to by default. Types which are not built in
+// are only handled by external workers.
+func taskRuntime(taskType models.TaskType) Runtime {
+	if !taskType.IsBuiltin() {
+		return ExternalRuntime
+	}
+	if taskType == models.ProcessImage {
+		return PythonRuntime
+	}
+	return GoRuntime
+}
+
+// canRun reports whether workers of a runtime can execute a task type. External
+// workers can execute any type.
+func canRun(runtime Runtime, taskType models.TaskType) bool {
+	return runtime == ExternalRuntime || taskRuntime(taskType) == runtime
+}
+
+// GetQueue returns the spec of a declared queue.
+func GetQueue(queueType QueueType) (QueueSpec, bool) {
+	queuesMu.RLock()
+	defer queuesMu.RUnlock()
+
+	spec, exists := queueSpecs[queueType]
+	return spec, exists
+}
+
+// Queues returns all declared queues ordered by name.
+func Queues() []QueueSpec {
+	queuesMu.RLock()
+	defer queuesMu.RUnlock()
+
+	specs := make([]QueueSpec, 0, len(queueSpecs))
+	for _, spec := range queueSpecs {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
+
+// OnQueueDeclared registers fn to be called with every queue declared through
+// DeclareQueue, e.g. to start the queue's worker pool. In redis mode this
+// includes the queues other processes declare, once WatchQueues runs.
+func OnQueueDeclared(fn func(QueueSpec)) {
+	queuesMu.Lock()
+	defer queuesMu.Unlock()
+
+	queueHooks = append(queueHooks, fn)
+}
+
+// validateQueue checks a spec before it is declared. Built-in queues can be
+// redeclared in the configuration, e.g. to enable aging, but keep their runtime.
+func validateQueue(spec QueueSpec) error {
+	if spec.Name == "" {
+		return fmt.Errorf("queue name cannot be empty")
+	}
+	if spec.Runtime != GoRuntime && spec.Runtime != PythonRuntime && spec.Runtime != ExternalRuntime {
+		return fmt.Errorf("invalid runtime of queue %s: %s", spec.Name, spec.Runtime)
+	}
+	if spec.Name == GoQueue && spec.Runtime != GoRuntime || spec.Name == PyQueue && spec.Runtime != PythonRuntime {
+		return fmt.Errorf("built-in queue %s can not change its runtime", spec.Name)
+	}
+	for _, taskType := range spec.TaskTypes {
+		if !taskType.IsValid() {
+			return fmt.Errorf("invalid task type: %s", taskType)
+		}
+		if !canRun(spec.Runtime, taskType) {
+			return fmt.Errorf("task type %s can not run on %s workers", taskType, spec.Runtime)
+		}
+	}
+	return nil
+}
+
+// register adds a queue and routes its task types to it. Must be called with the lock held.
+func register(spec QueueSpec) {
+	queueSpecs[spec.Name] = spec
+	for _, taskType := range spec.TaskTypes {
+		TaskQueueDict[taskType] = spec.Name
+	}
+}
+
+// DeclareQueue adds a queue at runtime and routes its task types to it. In redis
+// mode the queue is stored in Redis and announced, so that processes running
+// WatchQueues and those loading queues afterwards know it as well.
+func DeclareQueue(spec QueueSpec, rdb *redis.Client) error {
+	if err := validateQueue(spec); err != nil {
+		return err
+	}
+
+	queuesMu.Lock()
+	if _, exists := queueSpecs[spec.Name]; exists {
+		queuesMu.Unlock()
+		return ErrQueueExists
+	}
+	if config.AppConfig.MODE == "redis" {
+		specJson, err := json.Marshal(spec)
+		if err != nil {
+			queuesMu.Unlock()
+			return fmt.Errorf("marshal error: %w", err)
+		}
+		added, err := rdb.HSetNX(queuesKey, string(spec.Name), specJson).Result()
+		if err != nil {
+			queuesMu.Unlock()
+			return err
+		}
+		if !added {
+			queuesMu.Unlock()
+			return ErrQueueExists
+		}
+	}
+	register(spec)
+	hooks := append([]func(QueueSpec){}, queueHooks...)
+	queuesMu.Unlock()
+
+	for _, hook := range hooks {
+		hook(spec)
+	}
+	if config.AppConfig.MODE == "redis" {
+		if err := rdb.Publish(queuesChannel, string(spec.Name)).Err(); err != nil {
+			slog.Error("could not announce queue", logging.QueueKey, spec.Name, logging.Err(err))
+		}
+	}
+	return nil
+}
+
+// WatchQueues declares the queues other processes declare through the API until
+// ctx is done, as DeclareQueue would. Only used in redis mode.
+func WatchQueues(ctx context.Context, rdb *redis.Client) error {
+	pubsub := rdb.Subscribe(queuesChannel)
+	// queues declared before the subscription took effect are loaded now
+	if _, err := pubsub.Receive(); err != nil {
+		_ = pubsub.Close()
+		return fmt.Errorf("could not watch queues: %w", err)
+	}
+	if err := loadDeclaredQueues(rdb); err != nil {
+		_ = pubsub.Close()
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = pubsub.Close()
+	}()
+
+	go func() {
+		// the announcement only tells that a queue was stored
+		for range pubsub.Channel() {
+			if err := loadDeclaredQueues(rdb); err != nil {
+				slog.Error("could not load declared queues", logging.Err(err))
+			}
+		}
+	}()
+	return nil
+}
+
+// loadDeclaredQueues declares the queues stored in Redis which this process does
+// not know yet.
+func loadDeclaredQueues(rdb *redis.Client) error {
+	stored, err := rdb.HGetAll(queuesKey).Result()
+	if err != nil {
+		return fmt.Errorf("could not load queues: %w", err)
+	}
+	for name, specJson := range stored {
+		var spec QueueSpec
+		if err := json.Unmarshal([]byte(specJson), &spec); err != nil {
+			return fmt.Errorf("invalid queue %s: %w", name, err)
+		}
+
+		queuesMu.Lock()
+		if _, exists := queueSpecs[spec.Name]; exists {
+			queuesMu.Unlock()
+			continue
+		}
+		register(spec)
+		hooks := append([]func(QueueSpec){}, queueHooks...)
+		queuesMu.Unlock()
+
+		slog.Info("loaded queue declared by another process", logging.QueueKey, spec.Name)
+		for _, hook := range hooks {
+			hook(spec)
+		}
+	}
+	return nil
+}
+
+// RouteTaskType sends all tasks of a type enqueued from now on to a queue.
+func RouteTaskType(taskType models.TaskType, queueType QueueType) error {
+	if !taskType.IsValid() {
+		return fmt.Errorf("invalid task type: %s", taskType)
+	}
+
+	queuesMu.Lock()
+	defer queuesMu.Unlock()
+
+	spec, exists := queueSpecs[queueType]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrUnknownQueue, queueType)
+	}
+	if !canRun(spec.Runtime, taskType) {
+		return fmt.Errorf("task type %s can not run on %s workers", taskType, spec.Runtime)
+	}
+	TaskQueueDict[taskType] = queueType
+	return nil
+}
+
+// LoadQueues declares the queues and routes of the configuration and, in redis
+// mode, the queues declared through the API by any process. Must be called
+// before workers are started.
+func LoadQueues(rdb *redis.Client) error {
+	for _, q := range config.AppConfig.QUEUES {
+		spec := QueueSpec{
+			Name:         QueueType(q.NAME),
+			Runtime:      Runtime(q.RUNTIME),
+			Workers:      q.WORKERS,
+			AgingSeconds: int(q.AGING / time.Second),
+		}
+		if err := validateQueue(spec); err != nil {
+			return err
+		}
+		queuesMu.Lock()
+		register(spec)
+		queuesMu.Unlock()
+	}
+
+	for _, pool := range config.AppConfig.EXTERNAL_POOLS {
+		spec := QueueSpec{
+			Name:    QueueType(pool.NAME),
+			Runtime: ExternalRuntime,
+			Workers: pool.WORKERS,
+		}
+		for _, taskType := range pool.TASK_TYPES {
+			models.RegisterTaskType(models.TaskType(taskType))
+			spec.TaskTypes = append(spec.TaskTypes, models.TaskType(taskType))
+		}
+		if IsBuiltin(spec.Name) {
+			return fmt.Errorf("built-in queue %s can not be consumed by external workers", spec.Name)
+		}
+		if err := validateQueue(spec); err != nil {
+			return err
+		}
+		queuesMu.Lock()
+		register(spec)
+		queuesMu.Unlock()
+	}
+
+	if config.AppConfig.MODE == "redis" && rdb != nil {
+		stored, err := rdb.HGetAll(queuesKey).Result()
+		if err != nil {
+			return fmt.Errorf("could not load queues: %w", err)
+		}
+		for name, specJson := range stored {
+			var spec QueueSpec
+			if err := json.Unmarshal([]byte(specJson), &spec); err != nil {
+				return fmt.Errorf("invalid queue %s: %w", name, err)
+			}
+			queuesMu.Lock()
+			register(spec)
+			queuesMu.Unlock()
+		}
+	}
+
+	for taskType, queueName := range config.AppConfig.ROUTES {
+		if err := RouteTaskType(models.TaskType(taskType), QueueType(queueName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ageOffset returns the age offset of a task enqueued now into a queue.
+func ageOffset(queueType QueueType) float64 {
+	spec, exists := GetQueue(queueType)
+	if !exists || spec.AgingSeconds <= 0 {
+		return 0
+	}
+	return float64(time.Since(agingEpoch)) / float64(spec.Aging())
+}
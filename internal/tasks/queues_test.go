@@ -0,0 +1,76 @@
+package tasks
+
+import (
+	"testing"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeclareQueueRoutesTaskTypes(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	t.Cleanup(func() {
+		TaskQueueDict[models.SendEmail] = GoQueue
+	})
+
+	var declared []QueueType
+	OnQueueDeclared(func(spec QueueSpec) { declared = append(declared, spec.Name) })
+
+	err := DeclareQueue(QueueSpec{Name: "emails", Runtime: GoRuntime, TaskTypes: []models.TaskType{models.SendEmail}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []QueueType{"emails"}, declared)
+
+	queueType, err := GetQueueType(string(models.SendEmail))
+	assert.NoError(t, err)
+	assert.Equal(t, QueueType("emails"), queueType)
+
+	assert.ErrorIs(t, DeclareQueue(QueueSpec{Name: "emails", Runtime: GoRuntime}, nil), ErrQueueExists)
+	assert.Error(t, DeclareQueue(QueueSpec{Name: "images", Runtime: GoRuntime, TaskTypes: []models.TaskType{models.ProcessImage}}, nil),
+		"python task types must not be routed to go queues")
+	assert.ErrorIs(t, RouteTaskType(models.SendEmail, "missing"), ErrUnknownQueue)
+}
+
+func TestEnqueueTaskAging(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	assert.NoError(t, DeclareQueue(QueueSpec{Name: "aging", Runtime: GoRuntime, AgingSeconds: 60}, nil))
+	assert.NoError(t, RouteTaskType(models.DownloadFile, "aging"))
+	t.Cleanup(func() {
+		TaskQueueDict[models.DownloadFile] = GoQueue
+	})
+
+	assert.NoError(t, EnqueueTask(models.Task{Type: "download_file", Payload: []byte(`{}`), Priority: 3}, nil))
+	old, ok := localQueue("aging").Pop()
+	assert.True(t, ok)
+	assert.Greater(t, old.AgeOffset, 0.0)
+
+	// a task enqueued 5 intervals earlier overtakes a new task with better priority
+	old.AgeOffset -= 5
+	localQueue("aging").Push(old)
+	assert.NoError(t, EnqueueTask(models.Task{Type: "download_file", Payload: []byte(`{}`), Priority: 1}, nil))
+
+	first, _ := localQueue("aging").Pop()
+	assert.Equal(t, old.ID, first.ID)
+}
+
+func TestLoadExternalQueues(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	config.AppConfig.EXTERNAL_POOLS = []config.ExternalPoolConfig{
+		{NAME: "videos", COMMAND: "node", WORKERS: 2, TASK_TYPES: []string{"resize_video"}},
+	}
+	t.Cleanup(func() {
+		config.AppConfig.EXTERNAL_POOLS = nil
+	})
+
+	assert.NoError(t, LoadQueues(nil))
+	spec, exists := GetQueue("videos")
+	assert.True(t, exists)
+	assert.Equal(t, ExternalRuntime, spec.Runtime)
+	assert.Equal(t, 2, spec.Workers)
+
+	assert.True(t, models.TaskType("resize_video").IsValid())
+	queueType, err := GetQueueType("resize_video")
+	assert.NoError(t, err)
+	assert.Equal(t, QueueType("videos"), queueType)
+	assert.Error(t, RouteTaskType("resize_video", GoQueue), "external task types must not be routed to go queues")
+}
@@ -0,0 +1,96 @@
+package tasks
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/ratelimit"
+	"github.com/Yulian302/qugopy/models"
+)
+
+// RateLimitRule limits how often tasks of a type are executed.
+type RateLimitRule struct {
+	Limit ratelimit.Rule
+
+	// PartitionBy optionally splits the limit into one bucket per payload value,
+	// e.g. per recipient domain. Nil means one bucket for the whole task type.
+	PartitionBy func(payload json.RawMessage) string
+}
+
+// RateLimitRules are rate limits set in code, which take precedence over the
+// RATE_LIMITS of the configuration.
+var RateLimitRules = map[models.TaskType]RateLimitRule{}
+
+// rateLimitRule returns the rule limiting tasks of a type.
+func rateLimitRule(taskType string) (RateLimitRule, bool) {
+	if rule, exists := RateLimitRules[models.TaskType(taskType)]; exists {
+		return rule, true
+	}
+	limit, exists := config.AppConfig.RATE_LIMITS[taskType]
+	if !exists {
+		return RateLimitRule{}, false
+	}
+	rule := RateLimitRule{Limit: ratelimit.Rule{Rate: limit.RATE, Burst: limit.BURST}}
+	if limit.PARTITION_BY != "" {
+		rule.PartitionBy = EmailDomain(limit.PARTITION_BY)
+	}
+	return rule, true
+}
+
+// AllRateLimits returns the rate limits of all limited task types, those of the
+// configuration overridden by RateLimitRules, as passed to Python workers. Rules
+// partitioned in code are left out, as only Go runs their PartitionBy.
+func AllRateLimits() map[models.TaskType]config.RateLimitConfig {
+	limits := make(map[models.TaskType]config.RateLimitConfig, len(config.AppConfig.RATE_LIMITS)+len(RateLimitRules))
+	for taskType, limit := range config.AppConfig.RATE_LIMITS {
+		limits[models.TaskType(taskType)] = limit
+	}
+	for taskType, rule := range RateLimitRules {
+		if rule.PartitionBy != nil {
+			delete(limits, taskType)
+			continue
+		}
+		limits[taskType] = config.RateLimitConfig{RATE: rule.Limit.Rate, BURST: rule.Limit.Burst}
+	}
+	return limits
+}
+
+// EmailDomain partitions a rate limit by the domain of an email address stored
+// in the given payload field. RATE_LIMITS entries with an email field use it.
+//
+// Example:
+//
+//	RateLimitRules[models.SendEmail] = RateLimitRule{
+//		Limit:       ratelimit.Rule{Rate: 1, Burst: 1},
+//		PartitionBy: EmailDomain("recipient_email"),
+//	}
+func EmailDomain(field string) func(payload json.RawMessage) string {
+	return func(payload json.RawMessage) string {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(payload, &fields); err != nil {
+			return ""
+		}
+		var email string
+		if err := json.Unmarshal(fields[field], &email); err != nil {
+			return ""
+		}
+		_, domain, _ := strings.Cut(email, "@")
+		return strings.ToLower(domain)
+	}
+}
+
+// RateLimitKey returns the bucket key and rule limiting a task.
+// The boolean is false if tasks of this type are not rate limited.
+func RateLimitKey(intTask models.IntTask) (string, ratelimit.Rule, bool) {
+	rule, exists := rateLimitRule(intTask.Task.Type)
+	if !exists || rule.Limit.Rate <= 0 {
+		return "", ratelimit.Rule{}, false
+	}
+
+	key := "ratelimit:" + intTask.Task.Type
+	if rule.PartitionBy != nil {
+		key += ":" + rule.PartitionBy(intTask.Task.Payload)
+	}
+	return key, rule.Limit, true
+}
@@ -0,0 +1,55 @@
+package tasks
+
+import (
+	"testing"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/ratelimit"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitKey(t *testing.T) {
+	email := models.IntTask{Task: models.Task{Type: "send_email", Payload: []byte(`{"recipient_email": "bob@Example.com"}`)}}
+	_, _, limited := RateLimitKey(email)
+	assert.False(t, limited, "Task types should not be limited unless configured")
+
+	config.AppConfig.RATE_LIMITS = map[string]config.RateLimitConfig{"send_email": {RATE: 5, BURST: 10}}
+	t.Cleanup(func() { config.AppConfig.RATE_LIMITS = nil })
+	key, rule, limited := RateLimitKey(email)
+	assert.True(t, limited)
+	assert.Equal(t, "ratelimit:send_email", key)
+	assert.Equal(t, ratelimit.Rule{Rate: 5, Burst: 10}, rule)
+
+	config.AppConfig.RATE_LIMITS["send_email"] = config.RateLimitConfig{RATE: 1, BURST: 1, PARTITION_BY: "recipient_email"}
+	key, _, limited = RateLimitKey(email)
+	assert.True(t, limited)
+	assert.Equal(t, "ratelimit:send_email:example.com", key)
+
+	RateLimitRules[models.SendEmail] = RateLimitRule{Limit: ratelimit.Rule{Rate: 2, Burst: 2}}
+	t.Cleanup(func() { delete(RateLimitRules, models.SendEmail) })
+	key, rule, _ = RateLimitKey(email)
+	assert.Equal(t, "ratelimit:send_email", key, "Rules set in code should take precedence")
+	assert.Equal(t, ratelimit.Rule{Rate: 2, Burst: 2}, rule)
+
+	_, _, limited = RateLimitKey(models.IntTask{Task: models.Task{Type: "process_image"}})
+	assert.False(t, limited)
+}
+
+func TestAllRateLimits(t *testing.T) {
+	config.AppConfig.RATE_LIMITS = map[string]config.RateLimitConfig{
+		"send_email":    {RATE: 1, BURST: 1, PARTITION_BY: "recipient_email"},
+		"process_image": {RATE: 0.5, BURST: 1},
+	}
+	t.Cleanup(func() { config.AppConfig.RATE_LIMITS = nil })
+	RateLimitRules[models.ProcessImage] = RateLimitRule{Limit: ratelimit.Rule{Rate: 2, Burst: 4}}
+	RateLimitRules[models.SendEmail] = RateLimitRule{Limit: ratelimit.Rule{Rate: 2, Burst: 2}, PartitionBy: EmailDomain("to")}
+	t.Cleanup(func() {
+		delete(RateLimitRules, models.ProcessImage)
+		delete(RateLimitRules, models.SendEmail)
+	})
+
+	assert.Equal(t, map[models.TaskType]config.RateLimitConfig{
+		models.ProcessImage: {RATE: 2, BURST: 4},
+	}, AllRateLimits(), "Rules set in code should take precedence, unless partitioned in code")
+}
@@ -0,0 +1,129 @@
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/go-redis/redis"
+)
+
+const (
+	// WorkersKey is the Redis hash mapping worker IDs to their last WorkerInfo.
+	WorkersKey = "qugopy:workers"
+	// HeartbeatInterval is how often workers report to the registry.
+	HeartbeatInterval = 5 * time.Second
+	// WorkerTimeout is the time after which a worker which stopped sending
+	// heartbeats, e.g. because its process was killed, is forgotten.
+	WorkerTimeout = 3 * HeartbeatInterval
+)
+
+// WorkerInfo is the state a worker reports with its heartbeats.
+type WorkerInfo struct {
+	ID      string    `json:"id"`
+	Runtime Runtime   `json:"runtime"`
+	Queue   QueueType `json:"queue"`
+	Host    string    `json:"host"`
+	PID     int       `json:"pid"`
+	// Labels the worker advertises, see models.Affinity.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// IDs of the tasks the worker is running
+	CurrentTasks []string `json:"current_tasks"`
+	// tasks finished since the worker started, including failed ones
+	Processed int64 `json:"processed"`
+	Failed    int64 `json:"failed"`
+
+	StartedAt time.Time `json:"started_at"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+var (
+	workersMu sync.Mutex
+	// workers of local mode, keyed by ID
+	workerInfos = make(map[string]WorkerInfo)
+)
+
+// RecordHeartbeat stores the state reported by a worker and marks it as seen now.
+func RecordHeartbeat(info WorkerInfo, rdb *redis.Client) error {
+	info.LastSeen = time.Now()
+	if info.CurrentTasks == nil {
+		info.CurrentTasks = []string{}
+	}
+
+	if config.AppConfig.MODE == "redis" {
+		infoJson, err := json.Marshal(info)
+		if err != nil {
+			return fmt.Errorf("marshal error: %w", err)
+		}
+		return rdb.HSet(WorkersKey, info.ID, infoJson).Err()
+	}
+
+	workersMu.Lock()
+	defer workersMu.Unlock()
+	workerInfos[info.ID] = info
+	return nil
+}
+
+// RemoveWorker forgets a worker which stopped.
+func RemoveWorker(workerID string, rdb *redis.Client) error {
+	if config.AppConfig.MODE == "redis" {
+		return rdb.HDel(WorkersKey, workerID).Err()
+	}
+
+	workersMu.Lock()
+	defer workersMu.Unlock()
+	delete(workerInfos, workerID)
+	return nil
+}
+
+// ListWorkers returns the workers which sent a heartbeat within WorkerTimeout,
+// ordered by queue and ID. Workers which timed out are removed.
+func ListWorkers(rdb *redis.Client) ([]WorkerInfo, error) {
+	var infos []WorkerInfo
+	var expired []string
+
+	if config.AppConfig.MODE == "redis" {
+		records, err := rdb.HGetAll(WorkersKey).Result()
+		if err != nil {
+			return nil, err
+		}
+		for id, infoJson := range records {
+			var info WorkerInfo
+			if err := json.Unmarshal([]byte(infoJson), &info); err != nil {
+				return nil, fmt.Errorf("invalid worker %s: %w", id, err)
+			}
+			if time.Since(info.LastSeen) > WorkerTimeout {
+				expired = append(expired, id)
+				continue
+			}
+			infos = append(infos, info)
+		}
+		if len(expired) > 0 {
+			if err := rdb.HDel(WorkersKey, expired...).Err(); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		workersMu.Lock()
+		for id, info := range workerInfos {
+			if time.Since(info.LastSeen) > WorkerTimeout {
+				delete(workerInfos, id)
+				continue
+			}
+			infos = append(infos, info)
+		}
+		workersMu.Unlock()
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Queue != infos[j].Queue {
+			return infos[i].Queue < infos[j].Queue
+		}
+		return infos[i].ID < infos[j].ID
+	})
+	return infos, nil
+}
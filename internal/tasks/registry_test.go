@@ -0,0 +1,41 @@
+package tasks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerRegistry(t *testing.T) {
+	config.AppConfig.MODE = "local"
+
+	require.NoError(t, RecordHeartbeat(WorkerInfo{ID: "b", Runtime: GoRuntime, Queue: GoQueue, Processed: 3}, nil))
+	require.NoError(t, RecordHeartbeat(WorkerInfo{ID: "a", Runtime: GoRuntime, Queue: GoQueue}, nil))
+	require.NoError(t, RecordHeartbeat(WorkerInfo{ID: "c", Runtime: PythonRuntime, Queue: PyQueue}, nil))
+	require.NoError(t, RecordHeartbeat(WorkerInfo{ID: "b", Runtime: GoRuntime, Queue: GoQueue, Processed: 4}, nil))
+
+	infos, err := ListWorkers(nil)
+	require.NoError(t, err)
+	require.Len(t, infos, 3)
+	assert.Equal(t, []string{"a", "b", "c"}, []string{infos[0].ID, infos[1].ID, infos[2].ID})
+	assert.Equal(t, int64(4), infos[1].Processed, "The last heartbeat should replace the previous one")
+	assert.NotNil(t, infos[0].CurrentTasks)
+	assert.WithinDuration(t, time.Now(), infos[0].LastSeen, time.Second)
+
+	require.NoError(t, RemoveWorker("a", nil))
+	// a worker killed without saying goodbye
+	workersMu.Lock()
+	info := workerInfos["c"]
+	info.LastSeen = time.Now().Add(-WorkerTimeout - time.Second)
+	workerInfos["c"] = info
+	workersMu.Unlock()
+
+	infos, err = ListWorkers(nil)
+	require.NoError(t, err)
+	require.Len(t, infos, 1, "Stopped and timed out workers should be forgotten")
+	assert.Equal(t, "b", infos[0].ID)
+	require.NoError(t, RemoveWorker("b", nil))
+}
@@ -0,0 +1,16 @@
+-- releaseTaskScript of utility.go, also run by processing/worker.py. Removes a
+-- finished or cancelled task from the task index and the sort indexes of its
+-- queue, and its unique index entry unless a newer duplicate took over its key.
+-- KEYS: unique index, task index, created_at sort index, priority sort index.
+-- ARGV: unique key (empty if none), task id.
+redis.call("HDEL", KEYS[2], ARGV[2])
+redis.call("ZREM", KEYS[3], ARGV[2])
+redis.call("ZREM", KEYS[4], ARGV[2])
+if ARGV[1] == "" then
+	return 0
+end
+local indexed = redis.call("HGET", KEYS[1], ARGV[1])
+if indexed and cjson.decode(indexed).id == ARGV[2] then
+	return redis.call("HDEL", KEYS[1], ARGV[1])
+end
+return 0
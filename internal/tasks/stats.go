@@ -0,0 +1,280 @@
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/locks"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/go-redis/redis"
+)
+
+const (
+	// StatsWindow is the period throughput, failure rate and the percentiles of
+	// QueueStats are computed over.
+	StatsWindow = 5 * time.Minute
+	// maxRunSamples caps the samples kept per queue, the oldest are dropped first.
+	maxRunSamples = 10000
+)
+
+// Percentiles of a duration in milliseconds.
+type Percentiles struct {
+	P50 int64 `json:"p50"`
+	P95 int64 `json:"p95"`
+	P99 int64 `json:"p99"`
+}
+
+// QueueStats is a snapshot of a queue.
+type QueueStats struct {
+	// Depth is the number of queued tasks. Deferred tasks are counted separately.
+	Depth    int `json:"depth"`
+	Deferred int `json:"deferred"`
+	InFlight int `json:"in_flight"`
+	// Priorities counts the queued tasks by priority.
+	Priorities map[uint16]int `json:"priorities"`
+	// OldestAgeMs is how long the longest waiting queued task has been queued.
+	OldestAgeMs int64 `json:"oldest_age_ms"`
+	// Concurrency holds the permits in use of the concurrency limited task
+	// types routed to the queue, keyed by task type.
+	Concurrency map[string]ConcurrencyUsage `json:"concurrency"`
+
+	// Completed and Failed count the tasks which finished within StatsWindow.
+	// Failed tasks are included in Completed.
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+	// Throughput is the number of completed tasks per second.
+	Throughput  float64 `json:"throughput"`
+	FailureRate float64 `json:"failure_rate"`
+	// WaitMs is how long completed tasks were queued before a worker took them,
+	// RunMs how long they ran.
+	WaitMs Percentiles `json:"wait_ms"`
+	RunMs  Percentiles `json:"run_ms"`
+
+	WindowSeconds int `json:"window_seconds"`
+}
+
+// ConcurrencyUsage is how many tasks of a concurrency limited type run at once.
+type ConcurrencyUsage struct {
+	InUse int `json:"in_use"`
+	Limit int `json:"limit"`
+}
+
+// runSample is a task which finished, as recorded by RecordResult.
+type runSample struct {
+	ID         string    `json:"id"`
+	WaitMs     int64     `json:"wait_ms"`
+	RunMs      int64     `json:"run_ms"`
+	Failed     bool      `json:"failed,omitempty"`
+	FinishedAt time.Time `json:"-"`
+}
+
+// SamplesKey is the Redis sorted set of the runSamples of a queue, scored by the
+// unix milliseconds they finished at.
+func SamplesKey(queueType QueueType) string {
+	return QueueKey(queueType) + ":samples"
+}
+
+var (
+	samplesMu sync.Mutex
+	// samples of local mode queues, oldest first
+	samples = make(map[QueueType][]runSample)
+)
+
+// recordRunSample records how long a task a worker ran waited and ran, and
+// returns the run time. Tasks which are not recorded as running by StartTask
+// are skipped.
+func recordRunSample(queueType QueueType, task models.IntTask, failed bool, rdb *redis.Client) (time.Duration, error) {
+	running, exists, err := getInFlight(queueType, task.ID, rdb)
+	if err != nil || !exists {
+		return 0, err
+	}
+	now := time.Now()
+	run := now.Sub(running.StartedAt)
+	sample := runSample{
+		ID:         task.ID,
+		RunMs:      run.Milliseconds(),
+		Failed:     failed,
+		FinishedAt: now,
+	}
+	if !task.EnqueuedAt.IsZero() {
+		sample.WaitMs = max(running.StartedAt.Sub(task.EnqueuedAt).Milliseconds(), 0)
+	}
+
+	if config.AppConfig.MODE == "redis" {
+		sampleJson, err := json.Marshal(sample)
+		if err != nil {
+			return run, fmt.Errorf("marshal error: %w", err)
+		}
+		key := SamplesKey(queueType)
+		pipe := rdb.TxPipeline()
+		pipe.ZAdd(key, redis.Z{Score: float64(now.UnixMilli()), Member: sampleJson})
+		pipe.ZRemRangeByScore(key, "-inf", strconv.FormatInt(now.Add(-StatsWindow).UnixMilli(), 10))
+		pipe.ZRemRangeByRank(key, 0, -maxRunSamples-1)
+		_, err = pipe.Exec()
+		return run, err
+	}
+
+	samplesMu.Lock()
+	defer samplesMu.Unlock()
+	kept := pruneSamples(samples[queueType], now)
+	if len(kept) >= maxRunSamples {
+		kept = kept[len(kept)-maxRunSamples+1:]
+	}
+	samples[queueType] = append(kept, sample)
+	return run, nil
+}
+
+// pruneSamples drops the samples which finished before StatsWindow.
+func pruneSamples(queueSamples []runSample, now time.Time) []runSample {
+	start := 0
+	for start < len(queueSamples) && now.Sub(queueSamples[start].FinishedAt) > StatsWindow {
+		start++
+	}
+	return queueSamples[start:]
+}
+
+// getInFlight looks up a running task of a queue.
+func getInFlight(queueType QueueType, taskID string, rdb *redis.Client) (InFlightTask, bool, error) {
+	if config.AppConfig.MODE == "redis" {
+		recordJson, err := rdb.HGet(InFlightKey(queueType), taskID).Result()
+		if err == redis.Nil {
+			return InFlightTask{}, false, nil
+		}
+		if err != nil {
+			return InFlightTask{}, false, err
+		}
+		var record InFlightTask
+		if err := json.Unmarshal([]byte(recordJson), &record); err != nil {
+			return InFlightTask{}, false, fmt.Errorf("invalid in-flight task %s: %w", taskID, err)
+		}
+		return record, true, nil
+	}
+
+	inFlightMu.Lock()
+	defer inFlightMu.Unlock()
+	record, exists := inFlight[queueType][taskID]
+	return record, exists, nil
+}
+
+func listRunSamples(queueType QueueType, rdb *redis.Client) ([]runSample, error) {
+	now := time.Now()
+
+	if config.AppConfig.MODE == "redis" {
+		members, err := rdb.ZRangeByScore(SamplesKey(queueType), redis.ZRangeBy{
+			Min: strconv.FormatInt(now.Add(-StatsWindow).UnixMilli(), 10),
+			Max: "+inf",
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		window := make([]runSample, 0, len(members))
+		for _, member := range members {
+			var sample runSample
+			if err := json.Unmarshal([]byte(member), &sample); err == nil {
+				window = append(window, sample)
+			}
+		}
+		return window, nil
+	}
+
+	samplesMu.Lock()
+	defer samplesMu.Unlock()
+	samples[queueType] = pruneSamples(samples[queueType], now)
+	return append([]runSample(nil), samples[queueType]...), nil
+}
+
+// GetQueueStats returns a snapshot of a queue. Queued tasks are read to build
+// the priority histogram, so it takes longer the more tasks are queued.
+func GetQueueStats(queueType QueueType, rdb *redis.Client) (QueueStats, error) {
+	stats := QueueStats{
+		Priorities:    make(map[uint16]int),
+		Concurrency:   make(map[string]ConcurrencyUsage),
+		WindowSeconds: int(StatsWindow.Seconds()),
+	}
+
+	records, err := collectTasks(TaskFilter{Queues: []QueueType{queueType}}, rdb)
+	if err != nil {
+		return stats, err
+	}
+	for _, record := range records {
+		switch record.State {
+		case TaskQueued:
+			stats.Depth++
+			stats.Priorities[record.Task.Priority]++
+			if !record.EnqueuedAt.IsZero() {
+				stats.OldestAgeMs = max(stats.OldestAgeMs, time.Since(record.EnqueuedAt).Milliseconds())
+			}
+		case TaskDeferred, TaskUnschedulable:
+			stats.Deferred++
+		case TaskRunning:
+			stats.InFlight++
+		}
+	}
+
+	if stats.Concurrency, err = concurrencyUsage(queueType, rdb); err != nil {
+		return stats, err
+	}
+
+	window, err := listRunSamples(queueType, rdb)
+	if err != nil {
+		return stats, err
+	}
+	waits := make([]int64, len(window))
+	runs := make([]int64, len(window))
+	for i, sample := range window {
+		waits[i] = sample.WaitMs
+		runs[i] = sample.RunMs
+		if sample.Failed {
+			stats.Failed++
+		}
+	}
+	stats.Completed = len(window)
+	stats.Throughput = float64(stats.Completed) / StatsWindow.Seconds()
+	if stats.Completed > 0 {
+		stats.FailureRate = float64(stats.Failed) / float64(stats.Completed)
+	}
+	stats.WaitMs = percentiles(waits)
+	stats.RunMs = percentiles(runs)
+	return stats, nil
+}
+
+// concurrencyUsage returns the permits in use of the concurrency limited task
+// types routed to a queue.
+func concurrencyUsage(queueType QueueType, rdb *redis.Client) (map[string]ConcurrencyUsage, error) {
+	sem := locks.NewSemaphore(config.AppConfig.MODE, rdb)
+	usage := make(map[string]ConcurrencyUsage)
+	for taskType := range AllConcurrencyLimits() {
+		if routed, err := GetQueueType(string(taskType)); err != nil || routed != queueType {
+			continue
+		}
+		key, limit, limited := ConcurrencyKey(string(taskType))
+		if !limited {
+			continue
+		}
+		inUse, err := sem.InUse(key)
+		if err != nil {
+			return nil, fmt.Errorf("could not count permits of %s: %w", taskType, err)
+		}
+		usage[string(taskType)] = ConcurrencyUsage{InUse: inUse, Limit: limit}
+	}
+	return usage, nil
+}
+
+// percentiles computes the nearest-rank percentiles of durations, which it sorts.
+func percentiles(durations []int64) Percentiles {
+	if len(durations) == 0 {
+		return Percentiles{}
+	}
+	slices.Sort(durations)
+	rank := func(p float64) int64 {
+		idx := int(math.Ceil(p*float64(len(durations)))) - 1
+		return durations[max(idx, 0)]
+	}
+	return Percentiles{P50: rank(0.5), P95: rank(0.95), P99: rank(0.99)}
+}
@@ -0,0 +1,90 @@
+package tasks
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/locks"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueStats(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	queueType, err := GetQueueType("send_email")
+	require.NoError(t, err)
+	lq := localQueue(queueType)
+	clearSamples := func() {
+		samplesMu.Lock()
+		delete(samples, queueType)
+		samplesMu.Unlock()
+	}
+	// other tests ran tasks of the queue
+	clearSamples()
+	defer func() {
+		for lq.Len() > 0 {
+			lq.Pop()
+		}
+		clearSamples()
+	}()
+
+	for _, priority := range []uint16{1, 1, 3} {
+		_, err := Enqueue(models.Task{Type: "send_email", Payload: []byte(`{"to": "a@b.com", "subject": "hi", "body": "hi"}`), Priority: priority}, nil)
+		require.NoError(t, err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// one task succeeds, another fails and the third keeps running
+	for i := range 3 {
+		task, exists := lq.Pop()
+		require.True(t, exists)
+		require.NoError(t, StartTask(queueType, "w1", task, nil))
+		if i == 2 {
+			defer FinishTask(queueType, task.ID, nil)
+			break
+		}
+		var taskErr error
+		if i == 1 {
+			taskErr = errors.New("smtp down")
+		}
+		require.NoError(t, RecordResult(queueType, task, "w1", taskErr, nil))
+		require.NoError(t, FinishTask(queueType, task.ID, nil))
+	}
+	_, err = Enqueue(models.Task{Type: "send_email", Payload: []byte(`{"to": "a@b.com", "subject": "hi", "body": "hi"}`), Priority: 2}, nil)
+	require.NoError(t, err)
+
+	config.AppConfig.CONCURRENCY_LIMITS = map[string]int{"send_email": 3}
+	t.Cleanup(func() { config.AppConfig.CONCURRENCY_LIMITS = nil })
+	permit, err := locks.NewSemaphore("local", nil).TryAcquire("concurrency:send_email", 3, time.Minute)
+	require.NoError(t, err)
+	defer permit.Release()
+
+	stats, err := GetQueueStats(queueType, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Depth)
+	assert.Equal(t, map[uint16]int{2: 1}, stats.Priorities)
+	assert.Equal(t, 1, stats.InFlight)
+	assert.Equal(t, map[string]ConcurrencyUsage{"send_email": {InUse: 1, Limit: 3}}, stats.Concurrency)
+	assert.Equal(t, 2, stats.Completed)
+	assert.Equal(t, 1, stats.Failed)
+	assert.Equal(t, 0.5, stats.FailureRate)
+	assert.InDelta(t, 2/StatsWindow.Seconds(), stats.Throughput, 1e-9)
+	assert.GreaterOrEqual(t, stats.WaitMs.P99, int64(5), "Tasks should have waited since they were enqueued")
+	assert.Equal(t, int(StatsWindow.Seconds()), stats.WindowSeconds)
+
+	_, err = GetQueueStats("missing", nil)
+	assert.ErrorIs(t, err, ErrUnknownQueue)
+}
+
+func TestPercentiles(t *testing.T) {
+	durations := make([]int64, 100)
+	for i := range durations {
+		durations[i] = int64(100 - i)
+	}
+	assert.Equal(t, Percentiles{P50: 50, P95: 95, P99: 99}, percentiles(durations))
+	assert.Equal(t, Percentiles{P50: 7, P95: 7, P99: 7}, percentiles([]int64{7}))
+	assert.Equal(t, Percentiles{}, percentiles(nil))
+}
@@ -0,0 +1,345 @@
+package tasks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/callbacks"
+	"github.com/Yulian302/qugopy/internal/events"
+	"github.com/Yulian302/qugopy/internal/metrics"
+	"github.com/Yulian302/qugopy/logging"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/go-redis/redis"
+)
+
+// States of finished tasks, kept for OutcomeTTL.
+const (
+	TaskSucceeded TaskState = "succeeded"
+	TaskFailed    TaskState = "failed"
+	TaskCancelled TaskState = "cancelled"
+	// TaskExpired tasks were taken from their queue after their deadline.
+	TaskExpired TaskState = "expired"
+)
+
+// OutcomeTTL is how long the status of a finished task can be looked up.
+const OutcomeTTL = 24 * time.Hour
+
+var (
+	// ErrTaskNotFound is returned for a task which is neither queued nor running,
+	// and did not finish within OutcomeTTL.
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrTaskRunning is returned by CancelTask for a task a worker already runs.
+	ErrTaskRunning = errors.New("task is already running")
+	// ErrTaskFinished is returned by CancelTask for a task which already finished.
+	ErrTaskFinished = errors.New("task already finished")
+)
+
+// TaskStatus is the state of a task looked up by its ID.
+type TaskStatus struct {
+	ID string `json:"id"`
+	// Type of the task, empty if it was not recorded
+	Type  string    `json:"type,omitempty"`
+	Queue QueueType `json:"queue"`
+	State TaskState `json:"state"`
+	// WorkerID is set for running and finished tasks.
+	WorkerID string `json:"worker_id,omitempty"`
+	// Error of a failed or expired task
+	Error string `json:"error,omitempty"`
+	// UpdatedAt is the time the task entered its state.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Finished reports whether the task's state can no longer change.
+func (status TaskStatus) Finished() bool {
+	switch status.State {
+	case TaskSucceeded, TaskFailed, TaskCancelled, TaskExpired:
+		return true
+	default:
+		return false
+	}
+}
+
+// OutcomeKey is the Redis key holding the TaskStatus of a finished task.
+func OutcomeKey(taskID string) string {
+	return "qugopy:task:" + taskID
+}
+
+var (
+	outcomesMu sync.Mutex
+	// statuses of finished tasks of local mode, keyed by task ID
+	outcomes = make(map[string]TaskStatus)
+	// IDs of outcomes in the order they were recorded, so that expired ones are
+	// found without scanning all of them
+	outcomeOrder []string
+)
+
+// RecordOutcome records the status of a finished task, which is looked up by
+// GetTaskStatus for OutcomeTTL.
+func RecordOutcome(status TaskStatus, rdb *redis.Client) error {
+	status.UpdatedAt = time.Now()
+
+	if config.AppConfig.MODE == "redis" {
+		statusJson, err := json.Marshal(status)
+		if err != nil {
+			return fmt.Errorf("marshal error: %w", err)
+		}
+		return rdb.Set(OutcomeKey(status.ID), statusJson, OutcomeTTL).Err()
+	}
+
+	outcomesMu.Lock()
+	defer outcomesMu.Unlock()
+	for len(outcomeOrder) > 0 {
+		oldest, exists := outcomes[outcomeOrder[0]]
+		if exists && time.Since(oldest.UpdatedAt) < OutcomeTTL {
+			break
+		}
+		delete(outcomes, outcomeOrder[0])
+		outcomeOrder = outcomeOrder[1:]
+	}
+	outcomes[status.ID] = status
+	outcomeOrder = append(outcomeOrder, status.ID)
+	return nil
+}
+
+// RecordResult records a task a worker ran as succeeded, or as failed with taskErr.
+// It is called before FinishTask, so that its run time is known.
+func RecordResult(queueType QueueType, task models.IntTask, workerID string, taskErr error, rdb *redis.Client) error {
+	status := TaskStatus{ID: task.ID, Type: task.Task.Type, Queue: queueType, State: TaskSucceeded, WorkerID: workerID}
+	eventType := events.Succeeded
+	if taskErr != nil {
+		status.State = TaskFailed
+		status.Error = taskErr.Error()
+		eventType = events.Failed
+	}
+	publishEvent(eventType, queueType, task, workerID, taskErr)
+	run, err := recordRunSample(queueType, task, taskErr != nil, rdb)
+	if err != nil {
+		logging.Task(string(queueType), workerID, task.ID).Error("could not record run time", logging.Err(err))
+	}
+	metrics.TaskFinished(task.Task.Type, string(queueType), taskErr != nil, run)
+	return recordFinal(task, status, rdb)
+}
+
+// recordFinal records the outcome of a finished task, removes it from the
+// indexes of its queue and notifies its callback.
+// A callback which can not be scheduled does not fail the task, it is logged.
+func recordFinal(task models.IntTask, status TaskStatus, rdb *redis.Client) error {
+	if err := RecordOutcome(status, rdb); err != nil {
+		return err
+	}
+	if config.AppConfig.MODE == "redis" {
+		// the task is no longer listed and no longer blocks duplicates
+		err := releaseTaskScript.Run(rdb, []string{
+			UniqueIndexKey(status.Queue),
+			TaskIndexKey,
+			SortIndexKey(status.Queue, SortByCreatedAt),
+			SortIndexKey(status.Queue, SortByPriority),
+		}, task.UniqueKey, task.ID).Err()
+		if err != nil {
+			logging.Task(string(status.Queue), status.WorkerID, task.ID).Error("could not remove task from the indexes", logging.Err(err))
+		}
+	}
+	err := callbacks.Notify(task.Task.Callback, callbacks.Notification{
+		Event:    string(status.State),
+		TaskID:   task.ID,
+		TaskType: task.Task.Type,
+		Queue:    string(status.Queue),
+		WorkerID: status.WorkerID,
+		Error:    status.Error,
+	}, rdb)
+	if err != nil {
+		logging.Task(string(status.Queue), status.WorkerID, task.ID).Error("could not schedule callback", logging.Err(err))
+	}
+	return nil
+}
+
+func getOutcome(taskID string, rdb *redis.Client) (TaskStatus, bool, error) {
+	if config.AppConfig.MODE == "redis" {
+		statusJson, err := rdb.Get(OutcomeKey(taskID)).Result()
+		if err == redis.Nil {
+			return TaskStatus{}, false, nil
+		}
+		if err != nil {
+			return TaskStatus{}, false, err
+		}
+		var status TaskStatus
+		if err := json.Unmarshal([]byte(statusJson), &status); err != nil {
+			return TaskStatus{}, false, fmt.Errorf("invalid outcome of task %s: %w", taskID, err)
+		}
+		return status, true, nil
+	}
+
+	outcomesMu.Lock()
+	defer outcomesMu.Unlock()
+	status, exists := outcomes[taskID]
+	if !exists || time.Since(status.UpdatedAt) >= OutcomeTTL {
+		return TaskStatus{}, false, nil
+	}
+	return status, true, nil
+}
+
+// GetTaskStatus looks up the state of a task. Tasks which did not finish are
+// found through the task index in redis mode.
+func GetTaskStatus(taskID string, rdb *redis.Client) (TaskStatus, error) {
+	record, exists, err := lookupTask(taskID, rdb)
+	if err != nil {
+		return TaskStatus{}, err
+	}
+	if exists {
+		status := TaskStatus{ID: record.ID, Type: record.Task.Type, Queue: record.Queue, State: record.State, WorkerID: record.WorkerID, UpdatedAt: record.EnqueuedAt}
+		if record.StartedAt != nil {
+			status.UpdatedAt = *record.StartedAt
+		}
+		return status, nil
+	}
+
+	status, exists, err := getOutcome(taskID, rdb)
+	if err != nil {
+		return TaskStatus{}, err
+	}
+	if !exists {
+		return TaskStatus{}, ErrTaskNotFound
+	}
+	return status, nil
+}
+
+// lookupTask returns a queued, deferred or running task by its ID. Reports
+// whether it was found.
+func lookupTask(taskID string, rdb *redis.Client) (TaskRecord, bool, error) {
+	if config.AppConfig.MODE == "redis" {
+		found, err := loadIndexed([]string{taskID}, rdb)
+		if err != nil {
+			return TaskRecord{}, false, err
+		}
+		record, exists := found[taskID]
+		return record, exists, nil
+	}
+
+	// states are looked up in lifecycle order, so that a task which moves on
+	// meanwhile is found in a later state
+	for _, spec := range Queues() {
+		lq := localQueue(spec.Name)
+		lq.Lock.Lock()
+		task, exists := lq.PQ.Find(func(task models.IntTask) bool { return task.ID == taskID })
+		lq.Lock.Unlock()
+		if exists {
+			return TaskRecord{IntTask: task, Queue: spec.Name, State: TaskQueued}, true, nil
+		}
+	}
+	deferredMu.Lock()
+	for queueType, byID := range deferred {
+		if d, exists := byID[taskID]; exists {
+			deferredMu.Unlock()
+			dueAt := d.dueAt
+			return TaskRecord{IntTask: d.task, Queue: queueType, State: deferredState(d.task), DueAt: &dueAt}, true, nil
+		}
+	}
+	deferredMu.Unlock()
+	inFlightMu.Lock()
+	defer inFlightMu.Unlock()
+	for queueType, running := range inFlight {
+		if r, exists := running[taskID]; exists {
+			startedAt := r.StartedAt
+			return TaskRecord{IntTask: r.Task, Queue: queueType, State: TaskRunning, WorkerID: r.WorkerID, StartedAt: &startedAt}, true, nil
+		}
+	}
+	return TaskRecord{}, false, nil
+}
+
+// CancelTask removes a queued or deferred task from its queue and records it as
+// cancelled. Tasks which already run can not be cancelled.
+func CancelTask(taskID string, rdb *redis.Client) (TaskStatus, error) {
+	// a deferred task may go back into its queue while it is removed, and a
+	// queued one may be taken by a worker, so the lookup is repeated
+	for range 3 {
+		status, err := GetTaskStatus(taskID, rdb)
+		if err != nil {
+			return status, err
+		}
+
+		var (
+			task    models.IntTask
+			removed bool
+		)
+		switch {
+		case status.State == TaskQueued:
+			task, removed, err = removeQueued(status.Queue, taskID, rdb)
+		case status.State == TaskDeferred, status.State == TaskUnschedulable:
+			task, removed, err = removeDeferred(status.Queue, taskID, rdb)
+		case status.State == TaskRunning:
+			return status, ErrTaskRunning
+		case status.Finished():
+			return status, fmt.Errorf("%w: %s", ErrTaskFinished, status.State)
+		}
+		if err != nil {
+			return status, err
+		}
+		if !removed {
+			continue
+		}
+
+		status = TaskStatus{ID: taskID, Type: status.Type, Queue: status.Queue, State: TaskCancelled, UpdatedAt: time.Now()}
+		events.Publish(events.Event{Type: events.Cancelled, TaskID: taskID, TaskType: status.Type, Queue: string(status.Queue)})
+		return status, recordFinal(task, status, rdb)
+	}
+	status, err := GetTaskStatus(taskID, rdb)
+	if err == nil {
+		err = fmt.Errorf("task %s kept changing its state while it was cancelled", taskID)
+	}
+	return status, err
+}
+
+// removeQueued removes a task from a queue and returns it. Reports whether it
+// was queued.
+func removeQueued(queueType QueueType, taskID string, rdb *redis.Client) (models.IntTask, bool, error) {
+	if config.AppConfig.MODE != "redis" {
+		lq := localQueue(queueType)
+		lq.Lock.Lock()
+		defer lq.Lock.Unlock()
+		task, exists := lq.PQ.Find(func(task models.IntTask) bool { return task.ID == taskID })
+		return task, exists && lq.PQ.DeleteByID(taskID), nil
+	}
+
+	return removeIndexed(taskID, func(task models.IntTask) string {
+		return TenantQueueKey(queueType, task.Task.Tenant)
+	}, rdb)
+}
+
+// removeDeferred removes a deferred task before it is due and returns it.
+// Reports whether it was deferred.
+func removeDeferred(queueType QueueType, taskID string, rdb *redis.Client) (models.IntTask, bool, error) {
+	if config.AppConfig.MODE != "redis" {
+		task, removed := undeferLocal(queueType, taskID)
+		return task, removed, nil
+	}
+
+	return removeIndexed(taskID, func(models.IntTask) string {
+		return DelayedKey(queueType)
+	}, rdb)
+}
+
+// removeIndexed removes a task of the task index from the sorted set keyOf
+// returns for it. Reports whether it was removed.
+func removeIndexed(taskID string, keyOf func(models.IntTask) string, rdb *redis.Client) (models.IntTask, bool, error) {
+	var task models.IntTask
+	entryJson, err := rdb.HGet(TaskIndexKey, taskID).Result()
+	if err == redis.Nil {
+		return task, false, nil
+	}
+	if err != nil {
+		return task, false, err
+	}
+	var entry indexEntry
+	if err := json.Unmarshal([]byte(entryJson), &entry); err != nil {
+		return task, false, fmt.Errorf("invalid index entry of task %s: %w", taskID, err)
+	}
+	if err := json.Unmarshal([]byte(entry.Task), &task); err != nil {
+		return task, false, fmt.Errorf("invalid indexed task %s: %w", taskID, err)
+	}
+	removed, err := rdb.ZRem(keyOf(task), entry.Task).Result()
+	return task, removed > 0, err
+}
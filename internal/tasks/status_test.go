@@ -0,0 +1,87 @@
+package tasks
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskStatus(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	require.NoError(t, DeclareQueue(QueueSpec{Name: "status_test", Runtime: GoRuntime}, nil))
+	lq := localQueue("status_test")
+	defer func() {
+		for lq.Len() > 0 {
+			lq.Pop()
+		}
+	}()
+
+	queued := models.IntTask{ID: "status_queued", Task: models.Task{Type: "send_email", Priority: 1}}
+	lq.Push(queued)
+	status, err := GetTaskStatus("status_queued", nil)
+	require.NoError(t, err)
+	assert.Equal(t, TaskQueued, status.State)
+	assert.Equal(t, QueueType("status_test"), status.Queue)
+
+	status, err = CancelTask("status_queued", nil)
+	require.NoError(t, err)
+	assert.Equal(t, TaskCancelled, status.State)
+	assert.Zero(t, lq.Len(), "A cancelled task should leave its queue")
+	status, err = GetTaskStatus("status_queued", nil)
+	require.NoError(t, err)
+	assert.Equal(t, TaskCancelled, status.State)
+	_, err = CancelTask("status_queued", nil)
+	assert.ErrorIs(t, err, ErrTaskFinished)
+
+	deferLocal("status_test", models.IntTask{ID: "status_deferred", Task: models.Task{Type: "send_email", Priority: 1}}, 100*time.Millisecond)
+	status, err = CancelTask("status_deferred", nil)
+	require.NoError(t, err)
+	assert.Equal(t, TaskCancelled, status.State)
+	time.Sleep(200 * time.Millisecond)
+	assert.Zero(t, lq.Len(), "A cancelled deferred task should not go back into its queue")
+
+	running := models.IntTask{ID: "status_running", Task: models.Task{Type: "send_email", Priority: 1}}
+	require.NoError(t, StartTask("status_test", "w1", running, nil))
+	_, err = CancelTask("status_running", nil)
+	assert.ErrorIs(t, err, ErrTaskRunning)
+	status, err = GetTaskStatus("status_running", nil)
+	require.NoError(t, err)
+	assert.Equal(t, TaskRunning, status.State)
+	assert.Equal(t, "w1", status.WorkerID)
+
+	require.NoError(t, RecordResult("status_test", running, "w1", errors.New("smtp down"), nil))
+	require.NoError(t, FinishTask("status_test", "status_running", nil))
+	status, err = GetTaskStatus("status_running", nil)
+	require.NoError(t, err)
+	assert.Equal(t, TaskFailed, status.State)
+	assert.Equal(t, "smtp down", status.Error)
+	assert.True(t, status.Finished())
+
+	_, err = GetTaskStatus("status_missing", nil)
+	assert.ErrorIs(t, err, ErrTaskNotFound)
+}
+
+func TestOutcomeExpiry(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	require.NoError(t, RecordOutcome(TaskStatus{ID: "outcome_old", State: TaskSucceeded}, nil))
+	outcomesMu.Lock()
+	for id, status := range outcomes {
+		status.UpdatedAt = time.Now().Add(-OutcomeTTL)
+		outcomes[id] = status
+	}
+	outcomesMu.Unlock()
+
+	_, err := GetTaskStatus("outcome_old", nil)
+	assert.ErrorIs(t, err, ErrTaskNotFound, "Outcomes should expire after OutcomeTTL")
+
+	require.NoError(t, RecordOutcome(TaskStatus{ID: "outcome_new", State: TaskSucceeded}, nil))
+	outcomesMu.Lock()
+	_, exists := outcomes["outcome_old"]
+	outcomesMu.Unlock()
+	assert.False(t, exists, "Expired outcomes should be dropped")
+}
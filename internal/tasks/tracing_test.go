@@ -0,0 +1,55 @@
+package tasks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestEnqueueTraceContext(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	queueType, err := GetQueueType("download_file")
+	require.NoError(t, err)
+	lq := localQueue(queueType)
+	defer func() {
+		for lq.Len() > 0 {
+			lq.Pop()
+		}
+	}()
+
+	// the trace of a request is kept without exporting spans
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+	download := func(filename string) models.Task {
+		return models.Task{Type: "download_file", Payload: []byte(`{"url": "https://a.com/1", "filename": "` + filename + `"}`), Priority: 1}
+	}
+
+	_, err = EnqueueContext(ctx, download("trace_a.json"), nil)
+	require.NoError(t, err)
+	results, err := EnqueueBatchContext(ctx, []models.Task{download("trace_b.json")}, false, nil)
+	require.NoError(t, err)
+	require.NoError(t, results[0].Err)
+	_, err = Enqueue(download("trace_c.json"), nil)
+	require.NoError(t, err)
+
+	traced := 0
+	for lq.Len() > 0 {
+		task, _ := lq.Pop()
+		if task.TraceContext == nil {
+			continue
+		}
+		assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", task.TraceContext["traceparent"])
+		traced++
+	}
+	assert.Equal(t, 2, traced, "Tasks enqueued outside of a trace should have no trace context")
+}
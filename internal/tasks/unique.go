@@ -0,0 +1,102 @@
+package tasks
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/models"
+)
+
+// ConflictPolicy decides what happens when a task is enqueued while a task with
+// the same unique key is already waiting in the queue.
+type ConflictPolicy string
+
+const (
+	// RejectDuplicate keeps the queued task and rejects the new one.
+	RejectDuplicate ConflictPolicy = "reject"
+	// ReplaceDuplicate removes the queued task and enqueues the new one.
+	ReplaceDuplicate ConflictPolicy = "replace"
+)
+
+// ErrDuplicateTask is returned by EnqueueTask when a task with the same unique
+// key is already queued and the rule's policy is RejectDuplicate.
+var ErrDuplicateTask = errors.New("duplicate task is already queued")
+
+// UniqueRule makes tasks of a type unique by selected payload fields.
+type UniqueRule struct {
+	// Fields are the top-level payload fields the unique key is computed from.
+	Fields []string
+
+	// OnConflict decides what happens to a duplicate already in the queue.
+	OnConflict ConflictPolicy
+
+	// LockTTL is the lease of the lock held while a task runs. Zero disables the lock.
+	LockTTL time.Duration
+}
+
+// UniqueTaskRules are uniqueness rules set in code, which take precedence over
+// the UNIQUE_TASKS of the configuration. Tasks are not unique by default.
+var UniqueTaskRules = map[models.TaskType]UniqueRule{}
+
+// uniqueRule returns the uniqueness rule of a task type.
+func uniqueRule(taskType string) (UniqueRule, bool) {
+	if rule, exists := UniqueTaskRules[models.TaskType(taskType)]; exists {
+		return rule, true
+	}
+	unique, exists := config.AppConfig.UNIQUE_TASKS[taskType]
+	if !exists {
+		return UniqueRule{}, false
+	}
+	return UniqueRule{Fields: unique.FIELDS, OnConflict: ConflictPolicy(unique.ON_CONFLICT), LockTTL: unique.LOCK_TTL}, true
+}
+
+// UniqueKey returns the unique key of a task and the rule it was computed for.
+// The boolean is false if the task type has no rule or its payload does not
+// contain the rule's fields, in which case the task is not subject to uniqueness.
+func UniqueKey(task models.Task) (string, UniqueRule, bool) {
+	rule, exists := uniqueRule(task.Type)
+	if !exists || len(rule.Fields) == 0 {
+		return "", rule, false
+	}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(task.Payload, &payload); err != nil {
+		return "", rule, false
+	}
+
+	h := sha256.New()
+	h.Write([]byte(task.Type))
+	for _, field := range rule.Fields {
+		value, ok := payload[field]
+		if !ok {
+			return "", rule, false
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, value); err != nil {
+			return "", rule, false
+		}
+		h.Write([]byte{0})
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+		h.Write(compact.Bytes())
+	}
+	return hex.EncodeToString(h.Sum(nil)), rule, true
+}
+
+// RunLockKey returns the key of the lock a task must hold while it runs and the
+// lease of that lock. The boolean is false if the task does not need a lock.
+func RunLockKey(intTask models.IntTask) (string, time.Duration, bool) {
+	if intTask.UniqueKey == "" {
+		return "", 0, false
+	}
+	rule, exists := uniqueRule(intTask.Task.Type)
+	if !exists || rule.LockTTL <= 0 {
+		return "", 0, false
+	}
+	return "lock:" + intTask.UniqueKey, rule.LockTTL, true
+}
@@ -0,0 +1,86 @@
+package tasks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// uniqueDownloads makes download_file tasks unique by filename for a test.
+func uniqueDownloads(t *testing.T) {
+	config.AppConfig.UNIQUE_TASKS = map[string]config.UniqueTaskConfig{
+		"download_file": {FIELDS: []string{"filename"}, ON_CONFLICT: "reject", LOCK_TTL: 30 * time.Second},
+	}
+	t.Cleanup(func() { config.AppConfig.UNIQUE_TASKS = nil })
+}
+
+func TestUniqueKey(t *testing.T) {
+	_, _, ok := UniqueKey(models.Task{Type: "download_file", Payload: []byte(`{"filename": "a.json"}`)})
+	assert.False(t, ok, "tasks should not be unique by default")
+
+	uniqueDownloads(t)
+	first := models.Task{Type: "download_file", Payload: []byte(`{"url": "https://a.com/1", "filename": "a.json"}`), Priority: 1}
+	second := models.Task{Type: "download_file", Payload: []byte(`{"url": "https://b.com/2", "filename":"a.json"}`), Priority: 5}
+	other := models.Task{Type: "download_file", Payload: []byte(`{"url": "https://a.com/1", "filename": "b.json"}`), Priority: 1}
+
+	key1, _, ok := UniqueKey(first)
+	assert.True(t, ok)
+	key2, _, ok := UniqueKey(second)
+	assert.True(t, ok)
+	key3, _, ok := UniqueKey(other)
+	assert.True(t, ok)
+
+	assert.Equal(t, key1, key2, "tasks with the same filename must share a key")
+	assert.NotEqual(t, key1, key3, "tasks with different filenames must not share a key")
+}
+
+func TestUniqueKeyNotApplicable(t *testing.T) {
+	uniqueDownloads(t)
+	tests := []struct {
+		name string
+		task models.Task
+	}{
+		{"type without rule", models.Task{Type: "send_email", Payload: []byte(`{"filename": "a.json"}`)}},
+		{"payload is not an object", models.Task{Type: "download_file", Payload: []byte(`"test"`)}},
+		{"missing field", models.Task{Type: "download_file", Payload: []byte(`{"url": "https://a.com/1"}`)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, ok := UniqueKey(tt.task)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestDeferredUniqueTaskLocal(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	uniqueDownloads(t)
+	download := models.Task{Type: "download_file", Payload: []byte(`{"url": "https://a.com/1", "filename": "deferred.json"}`), Priority: 1}
+	queueType, err := GetQueueType("download_file")
+	require.NoError(t, err)
+	lq := localQueue(queueType)
+	defer func() {
+		for lq.Len() > 0 {
+			lq.Pop()
+		}
+	}()
+
+	id, err := Enqueue(download, nil)
+	require.NoError(t, err)
+	task, exists := lq.Pop()
+	require.True(t, exists)
+	require.Equal(t, id, task.ID)
+	require.NoError(t, DeferTask(task, 50*time.Millisecond, nil))
+
+	_, err = Enqueue(download, nil)
+	assert.ErrorIs(t, err, ErrDuplicateTask, "A deferred task should block duplicates")
+
+	assert.Eventually(t, func() bool { return lq.Len() == 1 }, time.Second, 10*time.Millisecond)
+	_, err = Enqueue(download, nil)
+	assert.ErrorIs(t, err, ErrDuplicateTask, "A task back in its queue should block duplicates")
+	assert.Equal(t, 1, lq.Len())
+}
@@ -1,11 +1,20 @@
 package tasks
 
 import (
+	"context"
+	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
 
 	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/events"
+	"github.com/Yulian302/qugopy/internal/metrics"
 	"github.com/Yulian302/qugopy/internal/queue"
+	"github.com/Yulian302/qugopy/internal/tracing"
 	"github.com/Yulian302/qugopy/logging"
 	"github.com/Yulian302/qugopy/models"
 	"github.com/go-redis/redis"
@@ -27,18 +36,231 @@ var (
 	}
 )
 
+var (
+	// enqueues tasks in order, checking the quota of their tenant and, for unique
+	// tasks, duplicates in their queue. The duplicate may be queued by another
+	// tenant. Returns a code per task: 1 if it was added, 0 if a duplicate is
+	// queued and the policy is reject, -1 if its tenant's queue is full. The
+	// tasks of an atomic batch are only added if all of them can be. Added
+	// tasks are indexed for listing and lookups by ID.
+	// KEYS: task index. ARGV: tasks json (see scriptTask), atomic ("1" or "0").
+	enqueueScript = redis.NewScript(`
+local tasks = cjson.decode(ARGV[1])
+
+local function unindex(queue, id)
+	redis.call("HDEL", KEYS[1], id)
+	redis.call("ZREM", queue .. ":sort:created_at", id)
+	redis.call("ZREM", queue .. ":sort:priority", id)
+end
+
+-- returns the set holding the indexed task of a unique key if it is queued or
+-- deferred. A task which runs does not block duplicates, its run lock does.
+local function queued_duplicate(t)
+	local old = redis.call("HGET", t.queue .. ":unique", t.unique)
+	if not old then
+		return nil
+	end
+	local tenant = cjson.decode(old).task.tenant
+	local key = t.queue
+	if type(tenant) == "string" and tenant ~= "" then
+		key = t.queue .. ":tenant:" .. tenant
+	end
+	if redis.call("ZSCORE", key, old) then
+		return key, old
+	end
+	if redis.call("ZSCORE", t.queue .. ":delayed", old) then
+		return t.queue .. ":delayed", old
+	end
+	return nil
+end
+
+local function add(t)
+	if t.max_queued > 0 and redis.call("ZCARD", t.key) >= t.max_queued then
+		return -1
+	end
+	if t.unique ~= "" then
+		local key, old = queued_duplicate(t)
+		if key then
+			if t.policy ~= "replace" then
+				return 0
+			end
+			redis.call("ZREM", key, old)
+			unindex(t.queue, cjson.decode(old).id)
+		end
+		redis.call("HSET", t.queue .. ":unique", t.unique, t.task)
+	end
+	redis.call("ZADD", t.key, t.rank, t.task)
+	redis.call("HSET", KEYS[1], t.id, cjson.encode({queue = t.name, task = t.task}))
+	redis.call("ZADD", t.queue .. ":sort:created_at", t.created, t.id)
+	redis.call("ZADD", t.queue .. ":sort:priority", t.priority, t.id)
+	-- registered after the task is added, so the fair scheduler never drops
+	-- a tenant which still has tasks
+	if t.tenant ~= "" then
+		redis.call("SADD", t.queue .. ":tenants", t.tenant)
+	end
+	return 1
+end
+
+local codes = {}
+if ARGV[2] == "1" then
+	-- a dry run counting the tasks of the batch which would be added before
+	local counts, seen, failed = {}, {}, false
+	for i, t in ipairs(tasks) do
+		codes[i] = 1
+		if t.max_queued > 0 then
+			counts[t.key] = counts[t.key] or redis.call("ZCARD", t.key)
+			if counts[t.key] >= t.max_queued then
+				codes[i] = -1
+			end
+		end
+		if codes[i] == 1 and t.unique ~= "" then
+			local slot = t.queue .. ":" .. t.unique
+			if t.policy ~= "replace" and (seen[slot] or queued_duplicate(t)) then
+				codes[i] = 0
+			end
+			seen[slot] = seen[slot] or codes[i] == 1
+		end
+		if codes[i] == 1 then
+			if t.max_queued > 0 then
+				counts[t.key] = counts[t.key] + 1
+			end
+		else
+			failed = true
+		end
+	end
+	if failed then
+		return codes
+	end
+end
+for i, t in ipairs(tasks) do
+	codes[i] = add(t)
+end
+return codes`)
+
+	// moves deferred tasks which are due back to their tenant's queue, ranked by
+	// priority plus age offset. Unique tasks are indexed again, unless a newer
+	// duplicate took over their key while they ran.
+	// KEYS: queue, delayed set, tenants set, unique index. ARGV: now (unix ms).
+	promoteScript = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[2], "-inf", ARGV[1], "LIMIT", 0, 100)
+for _, member in ipairs(due) do
+	local t = cjson.decode(member)
+	local key = KEYS[1]
+	if type(t.task.tenant) == "string" and t.task.tenant ~= "" then
+		key = KEYS[1] .. ":tenant:" .. t.task.tenant
+		redis.call("SADD", KEYS[3], t.task.tenant)
+	end
+	redis.call("ZREM", KEYS[2], member)
+	redis.call("ZADD", key, t.task.priority + (t.age_offset or 0), member)
+	if type(t.unique_key) == "string" and t.unique_key ~= "" then
+		local indexed = redis.call("HGET", KEYS[4], t.unique_key)
+		if not indexed or cjson.decode(indexed).id == t.id then
+			redis.call("HSET", KEYS[4], t.unique_key, member)
+		end
+	end
+end
+return #due`)
+
+	// parks a task in the delayed set until it is due. A unique task stays in the
+	// unique index, unless a newer duplicate took over its key while it ran.
+	// KEYS: delayed set, unique index, task index. ARGV: task json, due (unix ms),
+	// unique key, task id.
+	deferScript = redis.NewScript(deferTaskLua)
+
+	// removes a finished or cancelled task from the indexes of its queue
+	releaseTaskScript = redis.NewScript(releaseTaskLua)
+
+	// pops the next task with deficit round robin across tenants. Tenant queues are
+	// visited in name order, the default tenant first. The scheduler state is kept in
+	// Redis so that fairness holds across all workers and processes.
+	// KEYS: queue, tenants set, scheduler state. ARGV: weights json.
+	fairPopScript = redis.NewScript(fairPopLua)
+)
+
+// fairPopLua is the source of fairPopScript. The Python worker, which pops from
+// Redis itself, reads the same file.
+//
+//go:embed fair_pop.lua
+var fairPopLua string
+
+// releaseTaskLua is the source of releaseTaskScript, which the Python worker
+// runs as well.
+//
+//go:embed release_task.lua
+var releaseTaskLua string
+
+// deferTaskLua is the source of deferScript, which the Python worker runs as well.
+//
+//go:embed defer_task.lua
+var deferTaskLua string
+
+// ErrTenantQuotaExceeded is returned by EnqueueTask when a tenant already has
+// its maximum number of tasks queued.
+var ErrTenantQuotaExceeded = errors.New("tenant has reached its queued tasks quota")
+
+// ErrInvalidTask is returned by EnqueueTask for a task which fails validation,
+// e.g. of an unknown type.
+var ErrInvalidTask = errors.New("invalid task")
+
 func GetQueueType(taskType string) (QueueType, error) {
 	tt := models.TaskType(taskType)
 	if !tt.IsValid() {
 		return "", fmt.Errorf("invalid task type: %s", taskType)
 	}
+	queuesMu.RLock()
 	queueType, exists := TaskQueueDict[tt]
+	queuesMu.RUnlock()
 	if !exists {
 		return GoQueue, nil // default queue for unknown task types
 	}
 	return queueType, nil
 }
 
+// UniqueIndexKey is the Redis hash mapping unique keys to the task last enqueued
+// with them. Entries are kept until the task finishes or is cancelled.
+func UniqueIndexKey(queueType QueueType) string {
+	return QueueKey(queueType) + ":unique"
+}
+
+// DelayedKey is the Redis sorted set holding deferred tasks scored by the time they are due.
+func DelayedKey(queueType QueueType) string {
+	return QueueKey(queueType) + ":delayed"
+}
+
+// TenantQueueKey is the Redis sorted set holding a tenant's queued tasks. Tasks
+// without a tenant stay in the queue's own key.
+func TenantQueueKey(queueType QueueType, tenant string) string {
+	if tenant == "" {
+		return QueueKey(queueType)
+	}
+	return QueueKey(queueType) + ":tenant:" + tenant
+}
+
+// TenantsKey is the Redis set of tenants with queued tasks.
+func TenantsKey(queueType QueueType) string {
+	return QueueKey(queueType) + ":tenants"
+}
+
+// SchedulerKey is the Redis hash holding the fair scheduler's state.
+func SchedulerKey(queueType QueueType) string {
+	return QueueKey(queueType) + ":drr"
+}
+
+// TenantWeights returns the configured weight of every tenant as json, in the
+// form expected by the fair pop script.
+func TenantWeights() string {
+	weights := make(map[string]int, len(config.AppConfig.TENANTS))
+	for tenant := range config.AppConfig.TENANTS {
+		weights[tenant] = config.AppConfig.TenantQuota(tenant).WEIGHT
+	}
+	weightsJson, _ := json.Marshal(weights)
+	return string(weightsJson)
+}
+
+func localQueue(queueType QueueType) *queue.LocalQueue {
+	return queue.Local(string(queueType))
+}
+
 func validateTask(task models.Task) error {
 	if task.Type == "" {
 		return fmt.Errorf("task type cannot be empty")
@@ -52,49 +274,264 @@ func validateTask(task models.Task) error {
 		return fmt.Errorf("someField is required")
 	}
 
+	if err := task.Callback.Validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func EnqueueTask(task models.Task, rdb *redis.Client) error {
+// enqueuedTask is a task validated and ready to be added to its queue.
+type enqueuedTask struct {
+	queueType QueueType
+	intTask   *models.IntTask
+	taskJson  []byte
+	rule      UniqueRule
+}
+
+// prepareTask validates a task and assigns its ID, checking with stateOf that
+// its queue accepts new tasks.
+func prepareTask(ctx context.Context, task models.Task, stateOf func(QueueType) (QueueState, error)) (enqueuedTask, error) {
+	if shuttingDown.Load() {
+		return enqueuedTask{}, ErrShuttingDown
+	}
 	err := validateTask(task)
 	if err != nil {
-		return fmt.Errorf("invalid task: %w", err)
+		return enqueuedTask{}, fmt.Errorf("%w: %w", ErrInvalidTask, err)
+	}
+	queueType, err := GetQueueType(task.Type)
+	if err != nil {
+		return enqueuedTask{}, fmt.Errorf("%w: %w", ErrInvalidTask, err)
+	}
+	state, err := stateOf(queueType)
+	if err != nil {
+		return enqueuedTask{}, err
+	}
+	if state == QueueDraining {
+		return enqueuedTask{}, ErrQueueDraining
 	}
 	internalTask := &models.IntTask{
-		Task: task,
-		ID:   uuid.New().String(),
+		Task:         task,
+		ID:           uuid.New().String(),
+		AgeOffset:    ageOffset(queueType),
+		EnqueuedAt:   time.Now(),
+		TraceContext: tracing.Inject(ctx),
+	}
+	uniqueKey, rule, isUnique := UniqueKey(task)
+	if isUnique {
+		internalTask.UniqueKey = uniqueKey
 	}
 	userTaskJson, err := json.Marshal(internalTask)
 	if err != nil {
-		logging.DebugLog(fmt.Sprintf("Failed to marshal task: %v", err))
-		return fmt.Errorf("marshal error: %w", err)
+		slog.Error("could not marshal task", logging.Err(err))
+		return enqueuedTask{}, fmt.Errorf("marshal error: %w", err)
 	}
+	return enqueuedTask{queueType: queueType, intTask: internalTask, taskJson: userTaskJson, rule: rule}, nil
+}
+
+func EnqueueTask(task models.Task, rdb *redis.Client) error {
+	_, err := Enqueue(task, rdb)
+	return err
+}
 
+// Enqueue adds a task to its queue like EnqueueTask and returns the task's ID.
+func Enqueue(task models.Task, rdb *redis.Client) (string, error) {
+	return EnqueueContext(context.Background(), task, rdb)
+}
+
+// EnqueueContext is Enqueue as part of the trace of ctx, e.g. of the request
+// which enqueued the task. The workers running the task continue the trace.
+func EnqueueContext(ctx context.Context, task models.Task, rdb *redis.Client) (id string, err error) {
+	ctx, span := tracing.StartEnqueue(ctx, "enqueue "+task.Type, tracing.TaskTypeKey.String(task.Type))
+	defer func() { tracing.End(span, err) }()
+
+	prepared, err := prepareTask(ctx, task, func(queueType QueueType) (QueueState, error) {
+		return GetQueueState(queueType, rdb)
+	})
+	if err != nil {
+		return "", err
+	}
+	span.SetAttributes(tracing.TaskIDKey.String(prepared.intTask.ID), tracing.QueueKey.String(string(prepared.queueType)))
+	if err := enqueuePrepared(prepared, rdb); err != nil {
+		return "", err
+	}
+	publishEvent(events.Enqueued, prepared.queueType, *prepared.intTask, "", nil)
+	metrics.TaskEnqueued(task.Type, string(prepared.queueType))
+	return prepared.intTask.ID, nil
+}
+
+func enqueuePrepared(prepared enqueuedTask, rdb *redis.Client) error {
 	// push to redis
 	if config.AppConfig.MODE == "redis" {
-		queueType, err := GetQueueType(task.Type)
+		errs, err := enqueueRedis([]enqueuedTask{prepared}, false, rdb)
 		if err != nil {
-			return fmt.Errorf("wrong task type")
+			return err
 		}
-		return rdb.ZAdd(string(queueType), redis.Z{
-			Score:  float64(task.Priority),
-			Member: userTaskJson,
-		}).Err()
+		return errs[0]
 	} else {
 		// enqueue locally
-		queueType, err := GetQueueType(task.Type)
-		if err != nil {
-			return fmt.Errorf("invalid task type: %w", err)
+		lq := localQueue(prepared.queueType)
+		lq.Lock.Lock()
+		defer lq.Lock.Unlock()
+		_, err := enqueueLocked(lq, prepared)
+		return err
+	}
+}
+
+// scriptTask is a task as passed to enqueueScript.
+type scriptTask struct {
+	ID string `json:"id"`
+	// Name is the task's queue, Queue its key and Key the key of its tenant's queue.
+	Name   string `json:"name"`
+	Queue  string `json:"queue"`
+	Key    string `json:"key"`
+	Tenant string `json:"tenant"`
+	Task   string `json:"task"`
+	// Rank and Created are formatted by Go, as Lua would round them.
+	Rank      string `json:"rank"`
+	Created   string `json:"created"`
+	Priority  uint16 `json:"priority"`
+	MaxQueued int    `json:"max_queued"`
+	Unique    string `json:"unique"`
+	Policy    string `json:"policy"`
+}
+
+// enqueueRedis adds tasks to their queues with enqueueScript and returns the
+// result of each: nil, ErrTenantQuotaExceeded or ErrDuplicateTask. The tasks
+// of an atomic batch are only added if all of them can be.
+func enqueueRedis(items []enqueuedTask, atomic bool, rdb *redis.Client) ([]error, error) {
+	args := make([]scriptTask, len(items))
+	for i, item := range items {
+		task := item.intTask
+		args[i] = scriptTask{
+			ID:        task.ID,
+			Name:      string(item.queueType),
+			Queue:     QueueKey(item.queueType),
+			Key:       TenantQueueKey(item.queueType, task.Task.Tenant),
+			Tenant:    task.Task.Tenant,
+			Task:      string(item.taskJson),
+			Rank:      strconv.FormatFloat(task.Rank(), 'g', -1, 64),
+			Created:   strconv.FormatInt(sortKey(*task, SortByCreatedAt), 10),
+			Priority:  task.Task.Priority,
+			MaxQueued: config.AppConfig.TenantQuota(task.Task.Tenant).MAX_QUEUED,
+			Unique:    task.UniqueKey,
+			Policy:    string(item.rule.OnConflict),
+		}
+	}
+	argsJson, err := json.Marshal(args)
+	if err != nil {
+		return nil, fmt.Errorf("marshal error: %w", err)
+	}
+	atomicArg := "0"
+	if atomic {
+		atomicArg = "1"
+	}
+	codes, err := enqueueScript.Run(rdb, []string{TaskIndexKey}, argsJson, atomicArg).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(items))
+	for i, code := range codes.([]interface{}) {
+		switch code.(int64) {
+		case 0:
+			errs[i] = ErrDuplicateTask
+		case -1:
+			errs[i] = ErrTenantQuotaExceeded
+		}
+	}
+	return errs, nil
+}
+
+// enqueueLocked pushes a task to a local queue whose lock is held. Returns the
+// queued or deferred duplicate the task replaced, if any.
+func enqueueLocked(lq *queue.LocalQueue, prepared enqueuedTask) (*models.IntTask, error) {
+	task := prepared.intTask
+	maxQueued := config.AppConfig.TenantQuota(task.Task.Tenant).MAX_QUEUED
+	if maxQueued > 0 && lq.PQ.TenantLen(task.Task.Tenant) >= maxQueued {
+		return nil, ErrTenantQuotaExceeded
+	}
+	var replaced *models.IntTask
+	if task.UniqueKey != "" {
+		isDuplicate := func(t models.IntTask) bool { return t.UniqueKey == task.UniqueKey }
+		old, exists := lq.PQ.Find(isDuplicate)
+		if !exists {
+			old, exists = findDeferredLocal(prepared.queueType, isDuplicate)
 		}
-		if queueType == PyQueue {
-			queue.PythonLocalQueue.Lock.Lock()
-			defer queue.PythonLocalQueue.Lock.Unlock()
-			queue.PythonLocalQueue.PQ.Push(*internalTask)
-		} else {
-			queue.GoLocalQueue.Lock.Lock()
-			defer queue.GoLocalQueue.Lock.Unlock()
-			queue.GoLocalQueue.PQ.Push(*internalTask)
+		if exists {
+			if prepared.rule.OnConflict != ReplaceDuplicate {
+				return nil, ErrDuplicateTask
+			}
+			if !lq.PQ.DeleteByID(old.ID) {
+				undeferLocal(prepared.queueType, old.ID)
+			}
+			replaced = &old
 		}
+	}
+	lq.PQ.Push(*task)
+	return replaced, nil
+}
+
+// DequeueTask pops the next task from a queue: the highest priority task of the
+// tenant whose turn it is. The boolean is false if the queue is empty.
+func DequeueTask(queueType QueueType, rdb *redis.Client) (models.IntTask, bool, error) {
+	var task models.IntTask
+
+	if config.AppConfig.MODE != "redis" {
+		var exists bool
+		task, exists = localQueue(queueType).Pop()
+		return task, exists, nil
+	}
+
+	member, err := fairPopScript.Run(rdb,
+		[]string{QueueKey(queueType), TenantsKey(queueType), SchedulerKey(queueType)},
+		TenantWeights(),
+	).String()
+	if err == redis.Nil {
+		return task, false, nil
+	}
+	if err != nil {
+		return task, false, err
+	}
+	if err := json.Unmarshal([]byte(member), &task); err != nil {
+		return task, false, fmt.Errorf("failed to unmarshal task: %w. Raw: %s", err, member)
+	}
+	return task, true, nil
+}
+
+// DeferTask puts a dequeued task back into its queue after delay.
+// In redis mode the task is parked in the delayed set until PromoteDueTasks moves it back.
+func DeferTask(intTask models.IntTask, delay time.Duration, rdb *redis.Client) error {
+	queueType, err := GetQueueType(intTask.Task.Type)
+	if err != nil {
+		return err
+	}
+
+	if config.AppConfig.MODE != "redis" {
+		deferLocal(queueType, intTask, delay)
 		return nil
 	}
+
+	return deferRedis(queueType, intTask, time.Now().Add(delay), rdb)
+}
+
+// deferRedis parks a task in the delayed set of its queue until due.
+func deferRedis(queueType QueueType, task models.IntTask, due time.Time, rdb *redis.Client) error {
+	taskJson, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("marshal error: %w", err)
+	}
+	return deferScript.Run(rdb,
+		[]string{DelayedKey(queueType), UniqueIndexKey(queueType), TaskIndexKey},
+		taskJson, due.UnixMilli(), task.UniqueKey, task.ID,
+	).Err()
+}
+
+// PromoteDueTasks moves deferred tasks which are due back into their queue.
+// Returns the number of promoted tasks. Only used in redis mode.
+func PromoteDueTasks(queueType QueueType, rdb *redis.Client) (int, error) {
+	return promoteScript.Run(rdb,
+		[]string{QueueKey(queueType), DelayedKey(queueType), TenantsKey(queueType), UniqueIndexKey(queueType)},
+		time.Now().UnixMilli(),
+	).Int()
 }
@@ -0,0 +1,143 @@
+// Package tracing follows tasks from the request which enqueued them to the
+// worker which ran them with OpenTelemetry. The W3C trace context of a task is
+// captured when it is enqueued and stored with the task, so that the spans of
+// its Go or Python worker continue the trace of its producer.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/Yulian302/qugopy/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName is the service spans are reported for, unless OTEL_SERVICE_NAME is set.
+const ServiceName = "qugopy"
+
+const instrumentationName = "github.com/Yulian302/qugopy"
+
+// Attributes of task spans
+const (
+	TaskIDKey   = attribute.Key("qugopy.task.id")
+	TaskTypeKey = attribute.Key("qugopy.task.type")
+	QueueKey    = attribute.Key("qugopy.queue")
+	WorkerIDKey = attribute.Key("qugopy.worker.id")
+	// DeferredKey marks the run of a task which was deferred instead of executed.
+	DeferredKey = attribute.Key("qugopy.task.deferred")
+	// BatchSizeKey is the number of tasks of an enqueued batch.
+	BatchSizeKey = attribute.Key("qugopy.batch.size")
+)
+
+// propagator carries the trace context of tasks. It is used even if Init was
+// not called, so that tasks keep the trace of their producer when this process
+// does not export spans.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Init sets up the global tracer provider exporting spans to exporter, one of
+// `none`, `stdout` or `otlp`, and returns a function flushing and stopping it.
+// The otlp exporter is configured by the OTEL_EXPORTER_OTLP_* variables.
+func Init(ctx context.Context, exporter string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "", "none":
+		// the global provider stays a no-op
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		spanExporter, err = otlptracegrpc.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter: %s", exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not create %s exporter: %w", exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	// OTEL_SERVICE_NAME takes precedence over the default service name
+	res, err = resource.Merge(res, resource.Environment())
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of qugopy's spans.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Inject returns the trace context of ctx as W3C headers, e.g. traceparent.
+// Nil if ctx is not part of a trace.
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// Extract returns ctx with the trace context of W3C headers stored by Inject.
+func Extract(ctx context.Context, traceContext map[string]string) context.Context {
+	if len(traceContext) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier(traceContext))
+}
+
+// StartEnqueue starts the producer span of enqueuing tasks.
+func StartEnqueue(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(attrs...))
+}
+
+// StartRun starts the consumer span of a worker running a task, continuing the
+// trace the task was enqueued in. The span keeps the cancellation of ctx.
+func StartRun(ctx context.Context, task models.IntTask, queue, workerID string) (context.Context, trace.Span) {
+	parent := Extract(context.Background(), task.TraceContext)
+	if sc := trace.SpanContextFromContext(parent); sc.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, sc)
+	}
+	ctx = baggage.ContextWithBaggage(ctx, baggage.FromContext(parent))
+	return Tracer().Start(ctx, "run "+task.Task.Type,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			TaskIDKey.String(task.ID),
+			TaskTypeKey.String(task.Task.Type),
+			QueueKey.String(queue),
+			WorkerIDKey.String(workerID),
+		),
+	)
+}
+
+// End records err on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Yulian302/qugopy/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// record makes the global tracer provider record the spans ended by a test.
+func record(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestInjectExtract(t *testing.T) {
+	record(t)
+	assert.Nil(t, Inject(context.Background()), "Contexts without a trace should not be injected")
+
+	ctx, span := StartEnqueue(context.Background(), "enqueue send_email")
+	defer span.End()
+	carrier := Inject(ctx)
+	require.Contains(t, carrier, "traceparent")
+
+	extracted := trace.SpanContextFromContext(Extract(context.Background(), carrier))
+	assert.Equal(t, span.SpanContext().TraceID(), extracted.TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), extracted.SpanID())
+	assert.True(t, extracted.IsRemote())
+}
+
+func TestStartRun(t *testing.T) {
+	recorder := record(t)
+
+	ctx, producer := StartEnqueue(context.Background(), "enqueue send_email")
+	task := models.IntTask{ID: "t1", Task: models.Task{Type: "send_email"}, TraceContext: Inject(ctx)}
+	producer.End()
+
+	workerCtx, cancel := context.WithCancel(context.Background())
+	runCtx, span := StartRun(workerCtx, task, "go_queue", "w1")
+	cancel()
+	assert.Error(t, runCtx.Err(), "The run should be cancelled with its worker")
+	End(span, errors.New("smtp down"))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	run := spans[1]
+	assert.Equal(t, "run send_email", run.Name())
+	assert.Equal(t, trace.SpanKindConsumer, run.SpanKind())
+	assert.Equal(t, producer.SpanContext().TraceID(), run.SpanContext().TraceID(), "The run should continue the trace of the producer")
+	assert.Equal(t, producer.SpanContext().SpanID(), run.Parent().SpanID())
+	assert.Equal(t, codes.Error, run.Status().Code)
+	assert.Contains(t, run.Attributes(), TaskIDKey.String("t1"))
+
+	// tasks enqueued outside of a trace start a new one
+	_, span = StartRun(context.Background(), models.IntTask{ID: "t2"}, "go_queue", "w1")
+	span.End()
+	assert.False(t, recorder.Ended()[2].Parent().IsValid())
+}
+
+func TestGinMiddleware(t *testing.T) {
+	recorder := record(t)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(GinMiddleware())
+	var handlerSpan trace.SpanContext
+	r.POST("/tasks", func(c *gin.Context) {
+		handlerSpan = trace.SpanContextFromContext(c.Request.Context())
+		c.Status(http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest("POST", "/tasks", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "POST /tasks", spans[0].Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, spans[0].SpanContext().SpanID(), handlerSpan.SpanID(), "Handlers should see the request's span")
+}
+
+func TestInit(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	shutdown, err := Init(context.Background(), "stdout")
+	require.NoError(t, err)
+	_, isSDK := otel.GetTracerProvider().(*sdktrace.TracerProvider)
+	assert.True(t, isSDK)
+	require.NoError(t, shutdown(context.Background()))
+
+	_, err = Init(context.Background(), "zipkin")
+	assert.Error(t, err)
+}
@@ -1,13 +1,158 @@
+// Package logging sets up the structured logger of qugopy. Log lines are
+// written with log/slog by all packages and the output of worker processes is
+// captured into the same stream, see OutputWriter.
 package logging
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
 
-	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-func DebugLog(msg string) {
-	if gin.Mode() == gin.DebugMode {
-		log.Println(msg)
+// Keys of the fields identifying the task, worker and queue a log line is about.
+const (
+	TaskIDKey   = "task_id"
+	WorkerIDKey = "worker_id"
+	QueueKey    = "queue"
+)
+
+// Config of the logger set up by Setup.
+type Config struct {
+	Level slog.Level
+	// Format is `text` or `json`.
+	Format string
+	// Outputs are `stderr`, `stdout` or paths of files, which are rotated.
+	// Defaults to stderr.
+	Outputs []string
+	// MaxSizeMB is the size at which a file is rotated, MaxBackups the number of
+	// rotated files kept and MaxAgeDays how long they are kept. 0 keeps all.
+	MaxSizeMB  int
+	MaxBackups int
+	MaxAgeDays int
+}
+
+// Setup makes a logger writing to the outputs of cfg the default logger, which
+// the log package writes to as well. The returned function closes the files.
+func Setup(cfg Config) (func() error, error) {
+	outputs := cfg.Outputs
+	if len(outputs) == 0 {
+		outputs = []string{"stderr"}
+	}
+	var writers []io.Writer
+	var files []io.Closer
+	for _, output := range outputs {
+		switch output {
+		case "stderr":
+			writers = append(writers, os.Stderr)
+		case "stdout":
+			writers = append(writers, os.Stdout)
+		default:
+			file := &lumberjack.Logger{
+				Filename:   output,
+				MaxSize:    cfg.MaxSizeMB,
+				MaxBackups: cfg.MaxBackups,
+				MaxAge:     cfg.MaxAgeDays,
+			}
+			writers = append(writers, file)
+			files = append(files, file)
+		}
+	}
+
+	logger, err := New(io.MultiWriter(writers...), cfg.Level, cfg.Format)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(logger)
+	return func() error {
+		var firstErr error
+		for _, file := range files {
+			if err := file.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}, nil
+}
+
+// New returns a logger writing lines of format, `text` or `json`, to w. Lines
+// logged with the context of a span carry its trace_id and span_id.
+func New(w io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format: %s", format)
+	}
+	return slog.New(traceHandler{handler}), nil
+}
+
+// traceHandler adds the trace context of records to their fields.
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
+
+// Task returns the default logger with the fields of a task a worker of a
+// queue runs. Empty fields are left out.
+func Task(queue, workerID, taskID string) *slog.Logger {
+	var fields []any
+	if taskID != "" {
+		fields = append(fields, TaskIDKey, taskID)
+	}
+	if workerID != "" {
+		fields = append(fields, WorkerIDKey, workerID)
+	}
+	if queue != "" {
+		fields = append(fields, QueueKey, queue)
+	}
+	return slog.Default().With(fields...)
+}
+
+// Worker returns the default logger with the fields of a worker of a queue.
+func Worker(queue, workerID string) *slog.Logger {
+	return Task(queue, workerID, "")
+}
+
+// Err is the field of an error.
+func Err(err error) slog.Attr {
+	return slog.Any("error", err)
+}
+
+type loggerKey struct{}
+
+// NewContext returns ctx carrying logger, e.g. the logger of the task a worker
+// runs, so that the code running it logs with the task's fields.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
 	}
+	return slog.Default()
 }
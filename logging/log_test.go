@@ -0,0 +1,169 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// capture makes a JSON logger writing to the returned buffer the default
+// logger for the test.
+func capture(t *testing.T, level slog.Level) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	logger, err := New(&buf, level, "json")
+	require.NoError(t, err)
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+// lines parses the JSON lines of buf.
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &record), line)
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, slog.LevelInfo, "text")
+	require.NoError(t, err)
+	logger.Debug("hidden")
+	logger.Info("shown", "queue", "go_queue")
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "msg=shown queue=go_queue")
+
+	_, err = New(&buf, slog.LevelInfo, "xml")
+	assert.Error(t, err)
+}
+
+func TestTraceFields(t *testing.T) {
+	buf := capture(t, slog.LevelInfo)
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+
+	Task("go_queue", "w1", "t1").InfoContext(ctx, "task done")
+	slog.Info("no trace")
+
+	records := lines(t, buf)
+	require.Len(t, records, 2)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", records[0]["trace_id"])
+	assert.Equal(t, "00f067aa0ba902b7", records[0]["span_id"])
+	assert.Equal(t, "t1", records[0][TaskIDKey])
+	assert.Equal(t, "w1", records[0][WorkerIDKey])
+	assert.Equal(t, "go_queue", records[0][QueueKey])
+	assert.NotContains(t, records[1], "trace_id")
+}
+
+func TestTaskOmitsEmptyFields(t *testing.T) {
+	buf := capture(t, slog.LevelInfo)
+	Worker("py_queue", "w2").Info("started")
+	FromContext(NewContext(context.Background(), Task("", "", "t2"))).Info("running")
+
+	records := lines(t, buf)
+	require.Len(t, records, 2)
+	assert.Equal(t, "w2", records[0][WorkerIDKey])
+	assert.NotContains(t, records[0], TaskIDKey)
+	assert.Equal(t, "t2", records[1][TaskIDKey], "The logger of the context should be used")
+	assert.NotContains(t, records[1], QueueKey)
+}
+
+func TestSetup(t *testing.T) {
+	previous := slog.Default()
+	defer slog.SetDefault(previous)
+
+	file := filepath.Join(t.TempDir(), "qugopy.log")
+	closeLog, err := Setup(Config{Level: slog.LevelWarn, Format: "json", Outputs: []string{file}, MaxSizeMB: 1})
+	require.NoError(t, err)
+	slog.Info("hidden")
+	slog.Warn("queue is full", QueueKey, "go_queue")
+	require.NoError(t, closeLog())
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	records := lines(t, bytes.NewBuffer(data))
+	require.Len(t, records, 1)
+	assert.Equal(t, "queue is full", records[0]["msg"])
+	assert.Equal(t, "WARN", records[0]["level"])
+
+	_, err = Setup(Config{Format: "xml"})
+	assert.Error(t, err)
+}
+
+func TestOutputWriter(t *testing.T) {
+	buf := capture(t, slog.LevelDebug)
+	ow := NewOutputWriter(Worker("py_queue", "w1"), "stderr", slog.LevelError)
+
+	// lines may be split across writes
+	_, err := ow.Write([]byte(`{"level": "WARNING", "msg": "slow task", "task_id": "t1"}` + "\nTraceback (most"))
+	require.NoError(t, err)
+	_, err = ow.Write([]byte(" recent call last):\n\n"))
+	require.NoError(t, err)
+	_, err = ow.Write([]byte(`{"level": "DEBUG", "msg": "no task"}` + "\r\n" + "unterminated"))
+	require.NoError(t, err)
+	ow.Flush()
+	ow.Line(`["not", "a", "record"]`)
+
+	records := lines(t, buf)
+	require.Len(t, records, 5)
+	assert.Equal(t, "WARN", records[0]["level"])
+	assert.Equal(t, "slow task", records[0]["msg"])
+	assert.Equal(t, "t1", records[0][TaskIDKey])
+	assert.Equal(t, "w1", records[0][WorkerIDKey])
+	assert.Equal(t, "stderr", records[0]["stream"])
+	assert.Equal(t, "ERROR", records[1]["level"], "Raw lines should be logged at the writer's level")
+	assert.Equal(t, "Traceback (most recent call last):", records[1]["msg"])
+	assert.Equal(t, "DEBUG", records[2]["level"])
+	assert.Equal(t, "unterminated", records[3]["msg"])
+	assert.Equal(t, `["not", "a", "record"]`, records[4]["msg"])
+}
+
+func TestGinMiddleware(t *testing.T) {
+	buf := capture(t, slog.LevelInfo)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(GinMiddleware())
+	r.GET("/tasks/:id", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	r.POST("/tasks", func(c *gin.Context) {
+		c.Status(http.StatusInternalServerError)
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/tasks/t1", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/tasks", nil))
+
+	records := lines(t, buf)
+	require.Len(t, records, 2)
+	assert.Equal(t, "INFO", records[0]["level"])
+	assert.Equal(t, "/tasks/t1", records[0]["path"])
+	assert.Equal(t, "/tasks/:id", records[0]["route"])
+	assert.EqualValues(t, http.StatusOK, records[0]["status"])
+	assert.Equal(t, "ERROR", records[1]["level"], "Server errors should be logged at error level")
+}
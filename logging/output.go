@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// maxOutputLine is the longest line OutputWriter buffers, longer ones are split.
+const maxOutputLine = 64 * 1024
+
+// OutputWriter logs the lines a worker process writes to its stdout or stderr.
+// A line which is a JSON object with a msg field, as written by the Python
+// workers, is logged with its level and fields. Other lines are logged as they
+// are at the writer's level.
+type OutputWriter struct {
+	logger *slog.Logger
+	level  slog.Level
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewOutputWriter returns a writer logging the lines of a process's stream,
+// e.g. `stderr`, to logger.
+func NewOutputWriter(logger *slog.Logger, stream string, level slog.Level) *OutputWriter {
+	return &OutputWriter{logger: logger.With("stream", stream), level: level}
+}
+
+func (ow *OutputWriter) Write(p []byte) (int, error) {
+	ow.mu.Lock()
+	defer ow.mu.Unlock()
+	ow.buf = append(ow.buf, p...)
+	for {
+		end := bytes.IndexByte(ow.buf, '\n')
+		if end < 0 {
+			if len(ow.buf) >= maxOutputLine {
+				ow.logLine(string(ow.buf))
+				ow.buf = ow.buf[:0]
+			}
+			return len(p), nil
+		}
+		ow.logLine(string(ow.buf[:end]))
+		ow.buf = ow.buf[end+1:]
+	}
+}
+
+// Flush logs the last line if it did not end with a newline, e.g. once the
+// process exited.
+func (ow *OutputWriter) Flush() {
+	ow.mu.Lock()
+	defer ow.mu.Unlock()
+	if len(ow.buf) > 0 {
+		ow.logLine(string(ow.buf))
+		ow.buf = ow.buf[:0]
+	}
+}
+
+// Line logs a line written by a process like OutputWriter.
+func (ow *OutputWriter) Line(line string) {
+	ow.mu.Lock()
+	defer ow.mu.Unlock()
+	ow.logLine(line)
+}
+
+func (ow *OutputWriter) logLine(line string) {
+	line = strings.TrimRight(line, "\r")
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(line), &record); err == nil {
+		if msg, isString := record["msg"].(string); isString {
+			level := ow.level
+			if name, isString := record["level"].(string); isString {
+				level = parseLevel(name, level)
+			}
+			attrs := make([]slog.Attr, 0, len(record))
+			for key, value := range record {
+				if key != "msg" && key != "level" {
+					attrs = append(attrs, slog.Any(key, value))
+				}
+			}
+			ow.logger.LogAttrs(context.Background(), level, msg, attrs...)
+			return
+		}
+	}
+	ow.logger.Log(context.Background(), ow.level, line)
+}
+
+// parseLevel parses the level names of slog and of Python's logging module.
+func parseLevel(name string, fallback slog.Level) slog.Level {
+	switch strings.ToUpper(name) {
+	case "DEBUG":
+		return slog.LevelDebug
+	case "INFO":
+		return slog.LevelInfo
+	case "WARN", "WARNING":
+		return slog.LevelWarn
+	case "ERROR", "CRITICAL":
+		return slog.LevelError
+	default:
+		return fallback
+	}
+}
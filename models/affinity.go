@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// Affinity selects workers by the labels they advertise, e.g. disk=large.
+type Affinity struct {
+	// Required labels a worker must have to run the task.
+	Required map[string]string `json:"required,omitempty"`
+
+	// Preferred labels the task waits for until the fallback timeout. After it,
+	// any worker with the required labels may run the task.
+	Preferred map[string]string `json:"preferred,omitempty"`
+}
+
+// Allows reports whether a worker with labels may run a task which waited for
+// waited in its queue.
+func (a *Affinity) Allows(labels map[string]string, waited, fallback time.Duration) bool {
+	if a == nil {
+		return true
+	}
+	if !a.HasRequired(labels) {
+		return false
+	}
+	return waited >= fallback || hasLabels(labels, a.Preferred)
+}
+
+// HasRequired reports whether a worker with labels has the required labels.
+func (a *Affinity) HasRequired(labels map[string]string) bool {
+	return a == nil || hasLabels(labels, a.Required)
+}
+
+// hasLabels reports whether labels include all of selector.
+func hasLabels(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
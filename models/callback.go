@@ -0,0 +1,46 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+)
+
+// CallbackEvents are the final task states a Callback can be notified of.
+var CallbackEvents = []string{"succeeded", "failed", "expired", "cancelled"}
+
+// Callback is a URL notified with a POST once its task finished.
+type Callback struct {
+	URL string `json:"url" binding:"required,http_url,max=2048"`
+
+	// Events are the final states notified, all of CallbackEvents if empty.
+	Events []string `json:"events,omitempty" binding:"omitempty,dive,oneof=succeeded failed expired cancelled"`
+
+	// Secret signs notifications with HMAC-SHA256. Optional field.
+	Secret string `json:"secret,omitempty" binding:"omitempty,max=256"`
+}
+
+// Notifies reports whether the callback is notified of a task finishing in state.
+func (cb *Callback) Notifies(state string) bool {
+	if cb == nil {
+		return false
+	}
+	return len(cb.Events) == 0 && slices.Contains(CallbackEvents, state) || slices.Contains(cb.Events, state)
+}
+
+// Validate checks a callback of a task which was not bound by gin, e.g. one sent over gRPC.
+func (cb *Callback) Validate() error {
+	if cb == nil {
+		return nil
+	}
+	u, err := url.Parse(cb.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback url: %q", cb.URL)
+	}
+	for _, event := range cb.Events {
+		if !slices.Contains(CallbackEvents, event) {
+			return fmt.Errorf("invalid callback event: %s", event)
+		}
+	}
+	return nil
+}
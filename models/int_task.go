@@ -1,13 +1,41 @@
 package models
 
+import "time"
+
 // IntTask (internal task) represents a task with priority-based ordering capabilities.
-// It's designed for use in priority queues where tasks are ordered by: priority
+// It's designed for use in priority queues where tasks are ordered by: priority (plus age offset in queues with aging)
 type IntTask struct {
 	// User defined task (omits internal properties)
 	Task Task `json:"task"`
 
 	// ID uniquely identifies the task. Used for equality comparisons.
 	ID string `json:"id"`
+
+	// UniqueKey is a hash of the payload fields selected by the task type's
+	// uniqueness rule. Empty if the task is not subject to uniqueness.
+	UniqueKey string `json:"unique_key,omitempty"`
+
+	// AgeOffset is the time the task was enqueued, measured in aging intervals of
+	// its queue. It is added to the priority, so every interval a task waits it
+	// overtakes new tasks one priority level more urgent. Zero in queues without aging.
+	AgeOffset float64 `json:"age_offset,omitempty"`
+
+	// EnqueuedAt is the time the task entered its queue.
+	EnqueuedAt time.Time `json:"enqueued_at"`
+
+	// UnschedulableSince is the time a worker first took the task without having
+	// its required labels. Nil once a worker with them took it.
+	UnschedulableSince *time.Time `json:"unschedulable_since,omitempty"`
+
+	// TraceContext holds the W3C trace context (traceparent, tracestate and
+	// baggage) of the request which enqueued the task, so that workers continue
+	// its trace. Empty if the task was not enqueued as part of a trace.
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+// Rank is the value tasks are ordered by: the priority plus the age offset.
+func (t *IntTask) Rank() float64 {
+	return float64(t.Task.Priority) + t.AgeOffset
 }
 
 // GT (Greater Than) compares task priorities.
@@ -19,25 +47,25 @@ type IntTask struct {
 //	task2 := &IntTask{Priority: 3}
 //	task1.GT(task2) // true
 func (t1 *IntTask) GT(t2 *IntTask) bool {
-	return t1.Task.Priority > t2.Task.Priority
+	return t1.Rank() > t2.Rank()
 }
 
 // GTE (Greater Than or Equal) compares task priorities.
 // Returns true if t1 has equal or higher priority than t2.
 func (t1 *IntTask) GTE(t2 *IntTask) bool {
-	return t1.Task.Priority >= t2.Task.Priority
+	return t1.Rank() >= t2.Rank()
 }
 
 // LT (Less Than) compares task priorities.
 // Returns true if t1 has lower priority than t2.
 func (t1 *IntTask) LT(t2 *IntTask) bool {
-	return t1.Task.Priority < t2.Task.Priority
+	return t1.Rank() < t2.Rank()
 }
 
 // LTE (Less Than or Equal) compares task priorities.
 // Returns true if t1 has equal or lower priority than t2.
 func (t1 *IntTask) LTE(t2 *IntTask) bool {
-	return t1.Task.Priority <= t2.Task.Priority
+	return t1.Rank() <= t2.Rank()
 }
 
 // EQ (Equal) checks task identity.
@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"sync"
 	"time"
 
 	_ "github.com/go-playground/validator"
@@ -15,7 +16,7 @@ const (
 
 type Task struct {
 	// Type categorizes the task (e.g., "email", "notification").
-	Type string `form:"type" json:"type" binding:"required,oneof=download_file send_email process_image"`
+	Type string `form:"type" json:"type" binding:"required,max=64"`
 
 	// Payload contains task-specific data in string format.
 	Payload json.RawMessage `form:"payload" json:"payload" binding:"required"`
@@ -29,11 +30,22 @@ type Task struct {
 
 	// Recurring sets if a task must recur occasionally. Optional field.
 	Recurring *bool `form:"recurring" json:"recurring,omitempty"`
+
+	// Tenant is the team or client owning the task. Tasks of different tenants are
+	// scheduled fairly according to the tenants' weights. Optional field.
+	Tenant string `form:"tenant" json:"tenant,omitempty" binding:"omitempty,max=64"`
+
+	// Affinity selects the workers which may run the task by their labels. Optional field.
+	Affinity *Affinity `form:"affinity" json:"affinity,omitempty"`
+
+	// Callback is notified once the task finished. Optional field.
+	Callback *Callback `form:"callback" json:"callback,omitempty"`
 }
 
 type TaskType string
 
-func (tt TaskType) IsValid() bool {
+// IsBuiltin reports whether the task type is executed by qugopy's own Go or Python workers.
+func (tt TaskType) IsBuiltin() bool {
 	switch tt {
 	case SendEmail, DownloadFile, ProcessImage:
 		return true
@@ -41,3 +53,26 @@ func (tt TaskType) IsValid() bool {
 		return false
 	}
 }
+
+func (tt TaskType) IsValid() bool {
+	if tt.IsBuiltin() {
+		return true
+	}
+	customTypesMu.RLock()
+	defer customTypesMu.RUnlock()
+	return customTypes[tt]
+}
+
+var (
+	customTypesMu sync.RWMutex
+	// task types handled by external workers
+	customTypes = make(map[TaskType]bool)
+)
+
+// RegisterTaskType makes a task type valid which is not built in, but handled
+// by external workers.
+func RegisterTaskType(tt TaskType) {
+	customTypesMu.Lock()
+	defer customTypesMu.Unlock()
+	customTypes[tt] = true
+}
@@ -27,7 +27,7 @@ from google.protobuf import wrappers_pb2 as google_dot_protobuf_dot_wrappers__pb
 from google.protobuf import empty_pb2 as google_dot_protobuf_dot_empty__pb2
 
 
-DESCRIPTOR = _descriptor_pool.Default().AddSerializedFile(b'\n\ntask.proto\x12\x04task\x1a\x1fgoogle/protobuf/timestamp.proto\x1a\x1egoogle/protobuf/wrappers.proto\x1a\x1bgoogle/protobuf/empty.proto\"7\n\x0eGetTaskRequest\x12%\n\x0bworker_type\x18\x01 \x01(\x0e\x32\x10.task.WorkerType\"T\n\x07IntTask\x12\n\n\x02id\x18\x01 \x01(\t\x12\x18\n\x04task\x18\x02 \x01(\x0b\x32\n.task.Task\x12#\n\nqueue_type\x18\x03 \x01(\x0e\x32\x0f.task.QueueType\"\x94\x01\n\x04Task\x12\x0c\n\x04type\x18\x01 \x01(\t\x12\x0f\n\x07payload\x18\x02 \x01(\x0c\x12\x10\n\x08priority\x18\x03 \x01(\r\x12,\n\x08\x64\x65\x61\x64line\x18\x04 \x01(\x0b\x32\x1a.google.protobuf.Timestamp\x12-\n\trecurring\x18\x05 \x01(\x0b\x32\x1a.google.protobuf.BoolValue*U\n\nWorkerType\x12\x1b\n\x17WORKER_TYPE_UNSPECIFIED\x10\x00\x12\x12\n\x0eWORKER_TYPE_GO\x10\x01\x12\x16\n\x12WORKER_TYPE_PYTHON\x10\x02*Q\n\tQueueType\x12\x1a\n\x16QUEUE_TYPE_UNSPECIFIED\x10\x00\x12\x11\n\rQUEUE_TYPE_GO\x10\x01\x12\x15\n\x11QUEUE_TYPE_PYTHON\x10\x02\x32\xa9\x01\n\x0bTaskService\x12.\n\x07GetTask\x12\x14.task.GetTaskRequest\x1a\r.task.IntTask\x12\x32\n\tGetGoTask\x12\x16.google.protobuf.Empty\x1a\r.task.IntTask\x12\x36\n\rGetPythonTask\x12\x16.google.protobuf.Empty\x1a\r.task.IntTaskB*Z(github.com/Yulian302/qugopy/proto;taskpbb\x06proto3')
+DESCRIPTOR = _descriptor_pool.Default().AddSerializedFile(b'\n\ntask.proto\x12\x04task\x1a\x1fgoogle/protobuf/timestamp.proto\x1a\x1egoogle/protobuf/wrappers.proto\x1a\x1bgoogle/protobuf/empty.proto\"\xba\x01\n\x0eGetTaskRequest\x12%\n\x0bworker_type\x18\x01 \x01(\x0e\x32\x10.task.WorkerType\x12\r\n\x05queue\x18\x02 \x01(\t\x12\x11\n\tworker_id\x18\x03 \x01(\t\x12\x30\n\x06labels\x18\x04 \x03(\x0b\x32 .task.GetTaskRequest.LabelsEntry\x1a-\n\x0bLabelsEntry\x12\x0b\n\x03key\x18\x01 \x01(\t\x12\r\n\x05value\x18\x02 \x01(\t:\x02\x38\x01\"e\n\x13\x43ompleteTaskRequest\x12\n\n\x02id\x18\x01 \x01(\t\x12\x11\n\tworker_id\x18\x02 \x01(\t\x12\x0f\n\x07success\x18\x03 \x01(\x08\x12\r\n\x05\x65rror\x18\x04 \x01(\t\x12\x0f\n\x07requeue\x18\x05 \x01(\x08\"\xbc\x02\n\x0fWorkerHeartbeat\x12\x11\n\tworker_id\x18\x01 \x01(\t\x12\x0f\n\x07runtime\x18\x02 \x01(\t\x12\r\n\x05queue\x18\x03 \x01(\t\x12\x0c\n\x04host\x18\x04 \x01(\t\x12\x0b\n\x03pid\x18\x05 \x01(\x05\x12\x15\n\rcurrent_tasks\x18\x06 \x03(\t\x12\x11\n\tprocessed\x18\x07 \x01(\x03\x12\x0e\n\x06\x66\x61iled\x18\x08 \x01(\x03\x12.\n\nstarted_at\x18\t \x01(\x0b\x32\x1a.google.protobuf.Timestamp\x12\x0f\n\x07stopped\x18\n \x01(\x08\x12\x31\n\x06labels\x18\x0b \x03(\x0b\x32!.task.WorkerHeartbeat.LabelsEntry\x1a-\n\x0bLabelsEntry\x12\x0b\n\x03key\x18\x01 \x01(\t\x12\r\n\x05value\x18\x02 \x01(\t:\x02\x38\x01\"\xd1\x01\n\rWorkerMetrics\x12\x11\n\tworker_id\x18\x01 \x01(\t\x12\r\n\x05queue\x18\x02 \x01(\t\x12-\n\x05tasks\x18\x03 \x03(\x0b\x32\x1e.task.WorkerMetrics.TasksEntry\x12\x13\n\x0b\x63pu_seconds\x18\x04 \x01(\x01\x12\x15\n\rmax_rss_bytes\x18\x05 \x01(\x03\x1a\x43\n\nTasksEntry\x12\x0b\n\x03key\x18\x01 \x01(\t\x12$\n\x05value\x18\x02 \x01(\x0b\x32\x15.task.TaskTypeMetrics:\x02\x38\x01\"I\n\x0fTaskTypeMetrics\x12\x11\n\tsucceeded\x18\x01 \x01(\x03\x12\x0e\n\x06\x66\x61iled\x18\x02 \x01(\x03\x12\x13\n\x0brun_seconds\x18\x03 \x01(\x01\"\x1d\n\x0f\x45nqueueResponse\x12\n\n\x02id\x18\x01 \x01(\t\"@\n\x13\x45nqueueBatchRequest\x12\x19\n\x05tasks\x18\x01 \x03(\x0b\x32\n.task.Task\x12\x0e\n\x06\x61tomic\x18\x02 \x01(\x08\"*\n\rEnqueueResult\x12\n\n\x02id\x18\x01 \x01(\t\x12\r\n\x05\x65rror\x18\x02 \x01(\t\"<\n\x14\x45nqueueBatchResponse\x12$\n\x07results\x18\x01 \x03(\x0b\x32\x13.task.EnqueueResult\"\x19\n\x0bTaskRequest\x12\n\n\x02id\x18\x01 \x01(\t\"\x88\x01\n\nTaskStatus\x12\n\n\x02id\x18\x01 \x01(\t\x12\r\n\x05queue\x18\x02 \x01(\t\x12\r\n\x05state\x18\x03 \x01(\t\x12\x11\n\tworker_id\x18\x04 \x01(\t\x12\r\n\x05\x65rror\x18\x05 \x01(\t\x12.\n\nupdated_at\x18\x06 \x01(\x0b\x32\x1a.google.protobuf.Timestamp\"\xc1\x01\n\x07IntTask\x12\n\n\x02id\x18\x01 \x01(\t\x12\x18\n\x04task\x18\x02 \x01(\x0b\x32\n.task.Task\x12#\n\nqueue_type\x18\x03 \x01(\x0e\x32\x0f.task.QueueType\x12\x36\n\rtrace_context\x18\x04 \x03(\x0b\x32\x1f.task.IntTask.TraceContextEntry\x1a\x33\n\x11TraceContextEntry\x12\x0b\n\x03key\x18\x01 \x01(\t\x12\r\n\x05value\x18\x02 \x01(\t:\x02\x38\x01\"\xe8\x01\n\x04Task\x12\x0c\n\x04type\x18\x01 \x01(\t\x12\x0f\n\x07payload\x18\x02 \x01(\x0c\x12\x10\n\x08priority\x18\x03 \x01(\r\x12,\n\x08\x64\x65\x61\x64line\x18\x04 \x01(\x0b\x32\x1a.google.protobuf.Timestamp\x12-\n\trecurring\x18\x05 \x01(\x0b\x32\x1a.google.protobuf.BoolValue\x12\x0e\n\x06tenant\x18\x06 \x01(\t\x12 \n\x08\x61\x66\x66inity\x18\x07 \x01(\x0b\x32\x0e.task.Affinity\x12 \n\x08\x63\x61llback\x18\x08 \x01(\x0b\x32\x0e.task.Callback\"\xcf\x01\n\x08\x41\x66\x66inity\x12.\n\x08required\x18\x01 \x03(\x0b\x32\x1c.task.Affinity.RequiredEntry\x12\x30\n\tpreferred\x18\x02 \x03(\x0b\x32\x1d.task.Affinity.PreferredEntry\x1a/\n\rRequiredEntry\x12\x0b\n\x03key\x18\x01 \x01(\t\x12\r\n\x05value\x18\x02 \x01(\t:\x02\x38\x01\x1a\x30\n\x0ePreferredEntry\x12\x0b\n\x03key\x18\x01 \x01(\t\x12\r\n\x05value\x18\x02 \x01(\t:\x02\x38\x01\"7\n\x08\x43\x61llback\x12\x0b\n\x03url\x18\x01 \x01(\t\x12\x0e\n\x06\x65vents\x18\x02 \x03(\t\x12\x0e\n\x06secret\x18\x03 \x01(\t*U\n\nWorkerType\x12\x1b\n\x17WORKER_TYPE_UNSPECIFIED\x10\x00\x12\x12\n\x0eWORKER_TYPE_GO\x10\x01\x12\x16\n\x12WORKER_TYPE_PYTHON\x10\x02*Q\n\tQueueType\x12\x1a\n\x16QUEUE_TYPE_UNSPECIFIED\x10\x00\x12\x11\n\rQUEUE_TYPE_GO\x10\x01\x12\x15\n\x11QUEUE_TYPE_PYTHON\x10\x02\x32\xf8\x04\n\x0bTaskService\x12.\n\x07GetTask\x12\x14.task.GetTaskRequest\x1a\r.task.IntTask\x12\x32\n\tGetGoTask\x12\x16.google.protobuf.Empty\x1a\r.task.IntTask\x12\x36\n\rGetPythonTask\x12\x16.google.protobuf.Empty\x1a\r.task.IntTask\x12\x41\n\x0c\x43ompleteTask\x12\x19.task.CompleteTaskRequest\x1a\x16.google.protobuf.Empty\x12:\n\tHeartbeat\x12\x15.task.WorkerHeartbeat\x1a\x16.google.protobuf.Empty\x12<\n\rReportMetrics\x12\x13.task.WorkerMetrics\x1a\x16.google.protobuf.Empty\x12,\n\x07\x45nqueue\x12\n.task.Task\x1a\x15.task.EnqueueResponse\x12\x45\n\x0c\x45nqueueBatch\x12\x19.task.EnqueueBatchRequest\x1a\x1a.task.EnqueueBatchResponse\x12\x34\n\rGetTaskStatus\x12\x11.task.TaskRequest\x1a\x10.task.TaskStatus\x12\x31\n\nCancelTask\x12\x11.task.TaskRequest\x1a\x10.task.TaskStatus\x12\x32\n\tWatchTask\x12\x11.task.TaskRequest\x1a\x10.task.TaskStatus0\x01\x42*Z(github.com/Yulian302/qugopy/proto;taskpbb\x06proto3')
 
 _globals = globals()
 _builder.BuildMessageAndEnumDescriptors(DESCRIPTOR, _globals)
@@ -35,16 +35,64 @@ _builder.BuildTopDescriptorsAndMessages(DESCRIPTOR, 'task_pb2', _globals)
 if not _descriptor._USE_C_DESCRIPTORS:
   _globals['DESCRIPTOR']._loaded_options = None
   _globals['DESCRIPTOR']._serialized_options = b'Z(github.com/Yulian302/qugopy/proto;taskpb'
-  _globals['_WORKERTYPE']._serialized_start=408
-  _globals['_WORKERTYPE']._serialized_end=493
-  _globals['_QUEUETYPE']._serialized_start=495
-  _globals['_QUEUETYPE']._serialized_end=576
-  _globals['_GETTASKREQUEST']._serialized_start=114
-  _globals['_GETTASKREQUEST']._serialized_end=169
-  _globals['_INTTASK']._serialized_start=171
-  _globals['_INTTASK']._serialized_end=255
-  _globals['_TASK']._serialized_start=258
-  _globals['_TASK']._serialized_end=406
-  _globals['_TASKSERVICE']._serialized_start=579
-  _globals['_TASKSERVICE']._serialized_end=748
+  _globals['_GETTASKREQUEST_LABELSENTRY']._loaded_options = None
+  _globals['_GETTASKREQUEST_LABELSENTRY']._serialized_options = b'8\001'
+  _globals['_WORKERHEARTBEAT_LABELSENTRY']._loaded_options = None
+  _globals['_WORKERHEARTBEAT_LABELSENTRY']._serialized_options = b'8\001'
+  _globals['_WORKERMETRICS_TASKSENTRY']._loaded_options = None
+  _globals['_WORKERMETRICS_TASKSENTRY']._serialized_options = b'8\001'
+  _globals['_INTTASK_TRACECONTEXTENTRY']._loaded_options = None
+  _globals['_INTTASK_TRACECONTEXTENTRY']._serialized_options = b'8\001'
+  _globals['_AFFINITY_REQUIREDENTRY']._loaded_options = None
+  _globals['_AFFINITY_REQUIREDENTRY']._serialized_options = b'8\001'
+  _globals['_AFFINITY_PREFERREDENTRY']._loaded_options = None
+  _globals['_AFFINITY_PREFERREDENTRY']._serialized_options = b'8\001'
+  _globals['_WORKERTYPE']._serialized_start=2079
+  _globals['_WORKERTYPE']._serialized_end=2164
+  _globals['_QUEUETYPE']._serialized_start=2166
+  _globals['_QUEUETYPE']._serialized_end=2247
+  _globals['_GETTASKREQUEST']._serialized_start=115
+  _globals['_GETTASKREQUEST']._serialized_end=301
+  _globals['_GETTASKREQUEST_LABELSENTRY']._serialized_start=256
+  _globals['_GETTASKREQUEST_LABELSENTRY']._serialized_end=301
+  _globals['_COMPLETETASKREQUEST']._serialized_start=303
+  _globals['_COMPLETETASKREQUEST']._serialized_end=404
+  _globals['_WORKERHEARTBEAT']._serialized_start=407
+  _globals['_WORKERHEARTBEAT']._serialized_end=723
+  _globals['_WORKERHEARTBEAT_LABELSENTRY']._serialized_start=678
+  _globals['_WORKERHEARTBEAT_LABELSENTRY']._serialized_end=723
+  _globals['_WORKERMETRICS']._serialized_start=726
+  _globals['_WORKERMETRICS']._serialized_end=935
+  _globals['_WORKERMETRICS_TASKSENTRY']._serialized_start=868
+  _globals['_WORKERMETRICS_TASKSENTRY']._serialized_end=935
+  _globals['_TASKTYPEMETRICS']._serialized_start=937
+  _globals['_TASKTYPEMETRICS']._serialized_end=1010
+  _globals['_ENQUEUERESPONSE']._serialized_start=1012
+  _globals['_ENQUEUERESPONSE']._serialized_end=1041
+  _globals['_ENQUEUEBATCHREQUEST']._serialized_start=1043
+  _globals['_ENQUEUEBATCHREQUEST']._serialized_end=1107
+  _globals['_ENQUEUERESULT']._serialized_start=1109
+  _globals['_ENQUEUERESULT']._serialized_end=1151
+  _globals['_ENQUEUEBATCHRESPONSE']._serialized_start=1153
+  _globals['_ENQUEUEBATCHRESPONSE']._serialized_end=1213
+  _globals['_TASKREQUEST']._serialized_start=1215
+  _globals['_TASKREQUEST']._serialized_end=1240
+  _globals['_TASKSTATUS']._serialized_start=1243
+  _globals['_TASKSTATUS']._serialized_end=1379
+  _globals['_INTTASK']._serialized_start=1382
+  _globals['_INTTASK']._serialized_end=1575
+  _globals['_INTTASK_TRACECONTEXTENTRY']._serialized_start=1524
+  _globals['_INTTASK_TRACECONTEXTENTRY']._serialized_end=1575
+  _globals['_TASK']._serialized_start=1578
+  _globals['_TASK']._serialized_end=1810
+  _globals['_AFFINITY']._serialized_start=1813
+  _globals['_AFFINITY']._serialized_end=2020
+  _globals['_AFFINITY_REQUIREDENTRY']._serialized_start=1923
+  _globals['_AFFINITY_REQUIREDENTRY']._serialized_end=1970
+  _globals['_AFFINITY_PREFERREDENTRY']._serialized_start=1972
+  _globals['_AFFINITY_PREFERREDENTRY']._serialized_end=2020
+  _globals['_CALLBACK']._serialized_start=2022
+  _globals['_CALLBACK']._serialized_end=2077
+  _globals['_TASKSERVICE']._serialized_start=2250
+  _globals['_TASKSERVICE']._serialized_end=2882
 # @@protoc_insertion_point(module_scope)
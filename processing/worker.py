@@ -1,34 +1,103 @@
 
 import json
+import math
+import resource
+import socket
 import sys
-from typing import Any, Dict, Union
+import threading
+import uuid
+from datetime import datetime, timezone
+from typing import Any, Dict, List, Optional, Union
 import redis
 import time
 import grpc
 import signal
 import logging
-from google.protobuf.empty_pb2 import Empty
-from os import getenv, path
+from os import getenv, getpid, path
+from google.protobuf.timestamp_pb2 import Timestamp
 from pydantic import BaseModel, field_validator
 from dotenv import load_dotenv
+from opentelemetry import propagate, trace
+from opentelemetry.trace import Status, StatusCode
 
+import task_pb2
 import task_pb2_grpc
 from handlers.image_processor import handle_task
 
 
+class JSONFormatter(logging.Formatter):
+    """Formats records as the JSON lines the Go process logs with its fields."""
+
+    def format(self, record: logging.LogRecord) -> str:
+        line: Dict[str, Any] = {
+            "level": record.levelname,
+            "msg": record.getMessage(),
+        }
+        task_id = getattr(record, "task_id", None)
+        if task_id:
+            line["task_id"] = task_id
+        if record.exc_info:
+            line["exception"] = self.formatException(record.exc_info)
+        return json.dumps(line, default=str)
+
+
+class TaskFilter(logging.Filter):
+    """Adds the task the worker runs to the records logged while running it."""
+
+    def __init__(self, worker: "Worker"):
+        super().__init__()
+        self.worker = worker
+
+    def filter(self, record: logging.LogRecord) -> bool:
+        record.task_id = self.worker.current_task
+        return True
+
+
+def setup_logging(level: str) -> logging.Handler:
+    """Logs JSON lines to stderr, which the Go process captures into its log."""
+    level = level.upper()
+    if level == "WARN":
+        level = "WARNING"
+    handler = logging.StreamHandler(sys.stderr)
+    handler.setFormatter(JSONFormatter())
+    logging.basicConfig(level=getattr(
+        logging, level, logging.INFO), handlers=[handler])
+    return handler
+
+
+# set by the first shutdown signal; the worker exits after its current task
+stopping = False
+
+
 def shutdown_handler(signum, frame):
-    print("👋 Received shutdown signal", flush=True)
-    sys.exit(0)
+    global stopping
+    if stopping:
+        print("👋 Received second shutdown signal, exiting now", flush=True)
+        sys.exit(0)
+    print("👋 Received shutdown signal, finishing current task", flush=True)
+    stopping = True
 
 
 signal.signal(signal.SIGINT, shutdown_handler)
 signal.signal(signal.SIGTERM, shutdown_handler)
 
 
+class Callback(BaseModel):
+    url: str
+    events: List[str] = []
+    secret: Optional[str] = None
+
+    def notifies(self, state: str) -> bool:
+        """Mirrors models.Callback.Notifies"""
+        return state in (self.events or CALLBACK_EVENTS)
+
+
 class Task(BaseModel):
     type: str
     payload: Union[bytes, Dict[str, Any]]
     priority: int
+    tenant: Optional[str] = None
+    callback: Optional[Callback] = None
     # deadline: Optional[datetime] = None
     # recurring: Optional[bool] = False
 
@@ -42,6 +111,182 @@ class Task(BaseModel):
 class IntTask(BaseModel):
     id: str
     task: Task
+    unique_key: Optional[str] = None
+    age_offset: Optional[float] = None
+    trace_context: Optional[Dict[str, str]] = None
+
+
+# queue consumed by this worker and its Redis key, passed by the Go process
+QUEUE = getenv("QUEUE", "python_queue")
+QUEUE_KEY = getenv("QUEUE_KEY", f"qugopy:queue:{QUEUE}")
+# hash of paused and draining queues, shared by all instances in redis mode
+QUEUE_STATES_KEY = getenv("QUEUE_STATES_KEY", "qugopy:queue_states")
+# gRPC server handing out tasks in local mode, another host for `qugopy worker`.
+# Metrics are reported to it in both modes.
+GRPC_ADDR = getenv("GRPC_ADDR", "localhost:50051")
+# API key of the gRPC calls, passed by the Go process while AUTH_ENABLED is set
+API_KEY = getenv("QUGOPY_API_KEY", "")
+CALL_METADATA = (("authorization", f"Bearer {API_KEY}"),) if API_KEY else None
+# registry of workers in redis mode and how often this worker reports to it
+WORKERS_KEY = getenv("WORKERS_KEY", "qugopy:workers")
+HEARTBEAT_INTERVAL = int(getenv("HEARTBEAT_INTERVAL_MS", "5000")) / 1000
+
+# Concurrency limits per task type, passed by the Go process. Shared with Go
+# workers through the same Redis semaphore keys in redis mode.
+CONCURRENCY_LIMITS: Dict[str, int] = json.loads(
+    getenv("CONCURRENCY_LIMITS") or "{}")
+CONCURRENCY_LEASE_MS = int(getenv("CONCURRENCY_LEASE_MS", "300000"))
+LIMITED_TASK_RETRY_DELAY_MS = 200
+# rate limits by task type, passed by the Go process as tasks.AllRateLimits and
+# shared with the Go workers through the same Redis buckets in redis mode.
+RATE_LIMITS: Dict[str, Dict[str, Any]] = json.loads(getenv("RATE_LIMITS") or "{}")
+# mirrors tasks.AffinityRetryDelay
+AFFINITY_RETRY_DELAY_MS = 200
+# how long finished tasks can be looked up by their ID, mirroring tasks.OutcomeTTL
+OUTCOME_TTL_S = 24 * 3600
+# IDs of queued, deferred and running tasks, mirroring tasks.TaskIndexKey
+TASK_INDEX_KEY = "qugopy:tasks"
+# pub/sub channel the events of all instances are shared on, mirroring events.Channel
+EVENTS_CHANNEL = "qugopy:events"
+# samples of finished tasks kept for the queue statistics, mirroring tasks.StatsWindow
+STATS_WINDOW_MS = 5 * 60 * 1000
+MAX_RUN_SAMPLES = 10000
+# deliveries of callbacks, made by the API servers, mirroring package callbacks
+CALLBACK_EVENTS = ["succeeded", "failed", "expired", "cancelled"]
+CALLBACKS_DUE_KEY = "qugopy:callbacks"
+CALLBACK_TTL_S = 24 * 3600
+# where spans are exported, mirroring tracing.Init: none, stdout or otlp
+TRACING_EXPORTER = getenv("TRACING_EXPORTER", "none").lower() or "none"
+
+tracer = trace.get_tracer("qugopy.worker")
+
+# takes a permit if fewer than limit unexpired permits are held.
+# KEYS: semaphore. ARGV: now (unix ms), limit, token, expiry (unix ms), ttl (ms).
+ACQUIRE_SCRIPT = """
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[2]) then
+    return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[4], ARGV[3])
+redis.call("PEXPIRE", KEYS[1], ARGV[5])
+return 1
+"""
+
+
+def parse_tenant_weights(raw: str) -> Dict[str, int]:
+    """Parses `tenant:weight[:max_queued]` entries separated by commas"""
+    weights = {}
+    for entry in raw.split(","):
+        parts = entry.strip().split(":")
+        if len(parts) >= 2:
+            weights[parts[0]] = max(int(parts[1]), 1)
+    return weights
+
+
+TENANT_WEIGHTS = json.dumps(parse_tenant_weights(getenv("TENANT_QUOTAS", "")))
+
+
+def parse_labels(raw: str) -> Dict[str, str]:
+    """Parses `key=value` labels separated by commas, as config.ParseLabels"""
+    labels = {}
+    for entry in raw.split(","):
+        key, sep, value = entry.partition("=")
+        if sep and key.strip() and value.strip():
+            labels[key.strip()] = value.strip()
+    return labels
+
+
+# labels of this worker and how long tasks wait for their preferred labels
+WORKER_LABELS = parse_labels(getenv("WORKER_LABELS", ""))
+AFFINITY_FALLBACK = int(getenv("AFFINITY_FALLBACK_MS", "30000")) / 1000
+# how long a task may be taken only by workers without its required labels
+AFFINITY_TIMEOUT = int(getenv("AFFINITY_TIMEOUT_MS", "600000")) / 1000
+
+
+def has_labels(selector) -> bool:
+    return all(WORKER_LABELS.get(key) == value for key, value in (selector or {}).items())
+
+
+def matches_worker(task_dict) -> bool:
+    """Reports whether this worker may run a task, mirroring tasks.MatchesWorker"""
+    affinity = task_dict["task"].get("affinity") or {}
+    if not has_labels(affinity.get("required")):
+        return False
+    enqueued_at = datetime.fromisoformat(task_dict["enqueued_at"])
+    waited = (datetime.now(timezone.utc) - enqueued_at).total_seconds()
+    return waited >= AFFINITY_FALLBACK or has_labels(affinity.get("preferred"))
+
+
+def mark_unmatched(task_dict):
+    """Marks a task this worker may not run as unschedulable if it lacks the
+    task's required labels, mirroring tasks.MarkUnmatched"""
+    affinity = task_dict["task"].get("affinity") or {}
+    if has_labels(affinity.get("required")):
+        task_dict.pop("unschedulable_since", None)
+    elif not task_dict.get("unschedulable_since"):
+        task_dict["unschedulable_since"] = datetime.now(timezone.utc).isoformat()
+
+
+def is_past_deadline(task_dict) -> bool:
+    deadline = task_dict["task"].get("deadline")
+    return bool(deadline) and datetime.fromisoformat(deadline) < datetime.now(timezone.utc)
+
+
+def expiry_error(task_dict) -> str:
+    """Returns why a task expired, mirroring tasks.IsExpired: its deadline passed
+    or it was unschedulable for AFFINITY_TIMEOUT. Empty if it did not expire"""
+    if is_past_deadline(task_dict):
+        return "deadline exceeded"
+    since = task_dict.get("unschedulable_since")
+    if AFFINITY_TIMEOUT > 0 and since and \
+            (datetime.now(timezone.utc) - datetime.fromisoformat(since)).total_seconds() >= AFFINITY_TIMEOUT:
+        return "no worker has the required labels"
+    return ""
+
+
+def read_script(name: str, package: str = "tasks") -> str:
+    """Reads a Lua script shared with the Go workers from its Go package"""
+    with open(path.join(path.dirname(path.abspath(__file__)), "..", "internal", package, name)) as f:
+        return f.read()
+
+
+# pops the next task with deficit round robin across tenants, like the Go workers
+# KEYS: queue, tenants set, scheduler state. ARGV: weights json.
+FAIR_POP_SCRIPT = read_script("fair_pop.lua")
+# removes a finished task from the indexes of its queue
+# KEYS: unique index, task index, created_at sort index, priority sort index.
+# ARGV: unique key, task id.
+RELEASE_TASK_SCRIPT = read_script("release_task.lua")
+# parks a task in the delayed set and updates its entry in the task index
+# KEYS: delayed set, unique index, task index. ARGV: task json, due, unique key, task id.
+DEFER_TASK_SCRIPT = read_script("defer_task.lua")
+# takes a token from a rate limit bucket
+# KEYS: bucket. ARGV: rate (tokens/s), burst, now (unix ms), bucket ttl (ms).
+ALLOW_SCRIPT = read_script("allow.lua", "ratelimit")
+
+
+def setup_tracing():
+    """Exports the spans of this worker as configured by TRACING_EXPORTER. Without
+    an exporter spans are not recorded, but tasks keep their trace context."""
+    if TRACING_EXPORTER == "none":
+        return
+    from opentelemetry.sdk.resources import Resource
+    from opentelemetry.sdk.trace import TracerProvider
+    from opentelemetry.sdk.trace.export import BatchSpanProcessor, ConsoleSpanExporter
+
+    if TRACING_EXPORTER == "otlp":
+        # configured by the OTEL_EXPORTER_OTLP_* variables like the Go exporter
+        from opentelemetry.exporter.otlp.proto.grpc.trace_exporter import OTLPSpanExporter
+        exporter = OTLPSpanExporter()
+    elif TRACING_EXPORTER == "stdout":
+        exporter = ConsoleSpanExporter()
+    else:
+        raise ValueError(f"unknown tracing exporter: {TRACING_EXPORTER}")
+    provider = TracerProvider(resource=Resource.create(
+        {"service.name": getenv("OTEL_SERVICE_NAME", "qugopy")}))
+    # spans not exported yet are flushed when the worker exits
+    provider.add_span_processor(BatchSpanProcessor(exporter))
+    trace.set_tracer_provider(provider)
 
 
 def wait_for_grpc_ready(channel):
@@ -59,44 +304,370 @@ class Worker:
     def __init__(self, rdb=None, is_local=True):
         self.rdb = rdb
         self.is_local = is_local
-        if is_local:
-            channel = grpc.insecure_channel("localhost:50051")
-            if not wait_for_grpc_ready(channel):
-                print("❌ gRPC server never became ready", flush=True)
-                sys.exit(1)
-            self.stub = task_pb2_grpc.TaskServiceStub(channel)
+        self.worker_id = getenv("WORKER_ID", "")
+        # state reported with heartbeats, see send_heartbeat
+        self.started_at = datetime.now(timezone.utc)
+        self.current_task: Optional[str] = None
+        self.processed = 0
+        self.failed = 0
+        # totals of the tasks run by type, see report_metrics
+        self.task_metrics: Dict[str, Dict[str, Any]] = {}
+        self.metrics_lock = threading.Lock()
+        self.heartbeats_stopped = threading.Event()
+        # takes tasks in local mode, metrics are reported to it in both modes
+        channel = grpc.insecure_channel(GRPC_ADDR)
+        if is_local and not wait_for_grpc_ready(channel):
+            print("❌ gRPC server never became ready", flush=True)
+            sys.exit(1)
+        self.stub = task_pb2_grpc.TaskServiceStub(channel)
+        if not is_local:
+            self.acquire_script = rdb.register_script(ACQUIRE_SCRIPT)
+            self.fair_pop_script = rdb.register_script(FAIR_POP_SCRIPT)
+            self.release_task_script = rdb.register_script(RELEASE_TASK_SCRIPT)
+            self.defer_task_script = rdb.register_script(DEFER_TASK_SCRIPT)
+            self.allow_script = rdb.register_script(ALLOW_SCRIPT)
 
     def process_task(self, int_task: IntTask):
+        started = time.monotonic()
+        result = None
+        # continues the trace the task was enqueued in, see tracing.StartRun
+        parent = propagate.extract(dict(int_task.trace_context or {}))
+        with tracer.start_as_current_span(
+                f"run {int_task.task.type}", context=parent, kind=trace.SpanKind.CONSUMER,
+                attributes={"qugopy.task.id": int_task.id, "qugopy.task.type": int_task.task.type,
+                            "qugopy.queue": QUEUE, "qugopy.worker.id": self.worker_id}) as span:
+            try:
+                result = self.run_handler(int_task)
+                error = self.task_error(result)
+                if error:
+                    span.set_status(Status(StatusCode.ERROR, error))
+                return result
+            finally:
+                self.count_task(int_task.task.type, result, time.monotonic() - started)
+
+    def run_handler(self, int_task: IntTask):
         task_type = int_task.task.type
         if task_type == "process_image":
-            logging.info(handle_task(payload=int_task.task.payload))
+            result = handle_task(payload=int_task.task.payload)
+            logging.info(result)
+            return result
         else:
+            return None
+
+    def count_task(self, task_type: str, result, run_seconds: float):
+        """Adds a finished task to the totals reported by report_metrics"""
+        with self.metrics_lock:
+            totals = self.task_metrics.setdefault(
+                task_type, {"succeeded": 0, "failed": 0, "run_seconds": 0.0})
+            totals["failed" if self.task_error(result) else "succeeded"] += 1
+            totals["run_seconds"] += run_seconds
+
+    def report_metrics(self):
+        """Reports the totals of this worker to the gRPC server, which exports them
+        on /metrics"""
+        with self.metrics_lock:
+            task_metrics = {task_type: task_pb2.TaskTypeMetrics(**totals)
+                            for task_type, totals in self.task_metrics.items()}
+        max_rss = resource.getrusage(resource.RUSAGE_SELF).ru_maxrss
+        # kilobytes on Linux, bytes on macOS
+        if sys.platform != "darwin":
+            max_rss *= 1024
+        self.stub.ReportMetrics(task_pb2.WorkerMetrics(
+            worker_id=self.worker_id, queue=QUEUE, tasks=task_metrics,
+            cpu_seconds=time.process_time(), max_rss_bytes=max_rss), timeout=5, metadata=CALL_METADATA)
+
+    @staticmethod
+    def task_error(result) -> str:
+        """Error of a task's result, empty if it succeeded"""
+        if result and result.get("success"):
+            return ""
+        return str(result.get("message") if result else "unknown task type")
+
+    def complete_task(self, task_id: str, result):
+        """Reports a task handed out by the gRPC server as finished"""
+        error = self.task_error(result)
+        success = not error
+        try:
+            self.stub.CompleteTask(task_pb2.CompleteTaskRequest(
+                id=task_id, worker_id=self.worker_id, success=success, error=error), timeout=5,
+                metadata=CALL_METADATA)
+        except grpc.RpcError as e:
+            logging.error(f"❌ Could not complete task {task_id}: {e}")
+
+    def record_outcome(self, task: IntTask, state: str, error: str = ""):
+        """Records how a task popped from Redis ended, mirroring tasks.RecordOutcome,
+        and publishes it as an event"""
+        status = {
+            "id": task.id,
+            "type": task.task.type,
+            "queue": QUEUE,
+            "state": state,
+            "worker_id": self.worker_id,
+            "updated_at": datetime.now(timezone.utc).isoformat(),
+        }
+        if error:
+            status["error"] = error
+        self.rdb.set(f"qugopy:task:{task.id}", json.dumps(status), ex=OUTCOME_TTL_S)
+        # the task is no longer listed and no longer blocks duplicates
+        self.release_task_script(
+            keys=[f"{QUEUE_KEY}:unique", TASK_INDEX_KEY, f"{QUEUE_KEY}:sort:created_at", f"{QUEUE_KEY}:sort:priority"],
+            args=[task.unique_key or "", task.id],
+        )
+        self.publish_event(state, task, error)
+        self.schedule_callback(task, state, error)
+
+    def schedule_callback(self, task: IntTask, state: str, error: str = ""):
+        """Records the delivery of a task's callback, mirroring callbacks.Notify.
+        The API servers POST it"""
+        callback = task.task.callback
+        if callback is None or not callback.notifies(state):
             return
+        now = datetime.now(timezone.utc)
+        notification = {
+            "event": state,
+            "task_id": task.id,
+            "task_type": task.task.type,
+            "queue": QUEUE,
+            "worker_id": self.worker_id,
+            "time": now.isoformat(),
+        }
+        if error:
+            notification["error"] = error
+        delivery = {
+            "task_id": task.id,
+            "url": callback.url,
+            "event": state,
+            "state": "pending",
+            "notification": notification,
+            "attempts": [],
+            "next_attempt_at": now.isoformat(),
+            "created_at": now.isoformat(),
+        }
+        if callback.secret:
+            delivery["secret"] = callback.secret
+        pipe = self.rdb.pipeline()
+        pipe.set(f"qugopy:callback:{task.id}", json.dumps(delivery), ex=CALLBACK_TTL_S)
+        pipe.zadd(CALLBACKS_DUE_KEY, {task.id: int(now.timestamp() * 1000)})
+        pipe.execute()
+
+    def publish_event(self, event_type: str, task: IntTask, error: str = ""):
+        """Publishes an event about a task popped from Redis to the API servers,
+        mirroring events.Publish"""
+        event = {
+            "type": event_type,
+            "task_id": task.id,
+            "task_type": task.task.type,
+            "queue": QUEUE,
+            "worker_id": self.worker_id,
+            "time": datetime.now(timezone.utc).isoformat(),
+            "origin": f"python:{self.worker_id}",
+        }
+        if error:
+            event["error"] = error
+        self.rdb.publish(EVENTS_CHANNEL, json.dumps(event))
+
+    def finish_task(self, result):
+        """Counts a finished task for heartbeats"""
+        self.current_task = None
+        self.processed += 1
+        if not (result and result.get("success")):
+            self.failed += 1
+
+    def send_heartbeat(self, stopped=False):
+        """Reports this worker to the registry, mirroring tasks.RecordHeartbeat,
+        or removes it once stopped"""
+        current_tasks = [self.current_task] if self.current_task else []
+        if self.is_local:
+            started_at = Timestamp()
+            started_at.FromDatetime(self.started_at)
+            self.stub.Heartbeat(task_pb2.WorkerHeartbeat(
+                worker_id=self.worker_id, runtime="python", queue=QUEUE, host=socket.gethostname(),
+                pid=getpid(), labels=WORKER_LABELS, current_tasks=current_tasks, processed=self.processed,
+                failed=self.failed, started_at=started_at, stopped=stopped), timeout=5,
+                metadata=CALL_METADATA)
+        elif stopped:
+            self.rdb.hdel(WORKERS_KEY, self.worker_id)
+        else:
+            self.rdb.hset(WORKERS_KEY, self.worker_id, json.dumps({
+                "id": self.worker_id,
+                "runtime": "python",
+                "queue": QUEUE,
+                "host": socket.gethostname(),
+                "pid": getpid(),
+                "labels": WORKER_LABELS,
+                "current_tasks": current_tasks,
+                "processed": self.processed,
+                "failed": self.failed,
+                "started_at": self.started_at.isoformat(),
+                "last_seen": datetime.now(timezone.utc).isoformat(),
+            }))
+
+    def heartbeat_loop(self):
+        while not self.heartbeats_stopped.is_set():
+            try:
+                self.send_heartbeat()
+            except Exception as e:
+                logging.warning(f"Could not send heartbeat: {e}")
+            try:
+                self.report_metrics()
+            except Exception as e:
+                logging.warning(f"Could not report metrics: {e}")
+            self.heartbeats_stopped.wait(HEARTBEAT_INTERVAL)
+
+    def stop_heartbeats(self):
+        self.heartbeats_stopped.set()
+        try:
+            self.send_heartbeat(stopped=True)
+        except Exception as e:
+            logging.warning(f"Could not remove worker from registry: {e}")
+
+    def acquire_permit(self, task_type: str):
+        """Takes a concurrency permit. Returns (key, token), None if the type is
+        not limited or False if the limit is reached"""
+        limit = CONCURRENCY_LIMITS.get(task_type)
+        if not limit:
+            return None
+        key = f"concurrency:{task_type}"
+        token = str(uuid.uuid4())
+        now = int(time.time() * 1000)
+        acquired = self.acquire_script(
+            keys=[key], args=[now, limit, token, now + CONCURRENCY_LEASE_MS, CONCURRENCY_LEASE_MS])
+        return (key, token) if acquired else False
+
+    def take_rate_token(self, task_dict) -> int:
+        """Takes a token from the rate limit bucket of a task, mirroring
+        tasks.RateLimitKey and ratelimit.RedisLimiter. Returns 0 if the task may
+        run, otherwise the milliseconds until the next token"""
+        task_type = task_dict["task"]["type"]
+        limit = RATE_LIMITS.get(task_type)
+        if not limit or limit["RATE"] <= 0:
+            return 0
+        key = f"ratelimit:{task_type}"
+        if limit.get("PARTITION_BY"):
+            # the domain of an email address, as tasks.EmailDomain
+            payload = task_dict["task"].get("payload")
+            email = payload.get(limit["PARTITION_BY"]) if isinstance(payload, dict) else None
+            domain = email.partition("@")[2].lower() if isinstance(email, str) else ""
+            key += f":{domain}"
+        ttl = math.ceil(limit["BURST"] / limit["RATE"] * 1000) + 1000
+        return int(self.allow_script(keys=[key], args=[limit["RATE"], limit["BURST"], int(time.time() * 1000), ttl]))
+
+    def start_task(self, task_id: str, task_dict) -> datetime:
+        """Records a popped task as running by this worker, mirroring tasks.StartTask"""
+        started_at = datetime.now(timezone.utc)
+        record = {
+            "task": task_dict,
+            "worker_id": self.worker_id,
+            "started_at": started_at.isoformat(),
+        }
+        self.rdb.hset(f"{QUEUE_KEY}:inflight", task_id, json.dumps(record))
+        return started_at
+
+    def record_sample(self, task_dict, started_at: datetime, failed: bool):
+        """Records how long a task waited and ran for the queue statistics,
+        mirroring tasks.recordRunSample"""
+        now = datetime.now(timezone.utc)
+        sample = {
+            "id": task_dict["id"],
+            "wait_ms": 0,
+            "run_ms": int((now - started_at).total_seconds() * 1000),
+        }
+        if task_dict.get("enqueued_at"):
+            enqueued_at = datetime.fromisoformat(task_dict["enqueued_at"])
+            sample["wait_ms"] = max(int((started_at - enqueued_at).total_seconds() * 1000), 0)
+        if failed:
+            sample["failed"] = True
+        now_ms = int(now.timestamp() * 1000)
+        key = f"{QUEUE_KEY}:samples"
+        pipe = self.rdb.pipeline()
+        pipe.zadd(key, {json.dumps(sample): now_ms})
+        pipe.zremrangebyscore(key, "-inf", now_ms - STATS_WINDOW_MS)
+        pipe.zremrangebyrank(key, 0, -MAX_RUN_SAMPLES - 1)
+        pipe.execute()
+
+    def defer_task(self, task_dict, delay_ms=LIMITED_TASK_RETRY_DELAY_MS):
+        """Parks a task in the delayed set, mirroring tasks.DeferTask; the Go
+        process moves it back when due"""
+        due = int(time.time() * 1000) + delay_ms
+        self.defer_task_script(
+            keys=[f"{QUEUE_KEY}:delayed", f"{QUEUE_KEY}:unique", TASK_INDEX_KEY],
+            args=[json.dumps(task_dict), due, task_dict.get("unique_key") or "", task_dict["id"]],
+        )
 
     def run(self):
-        while True:
+        threading.Thread(target=self.heartbeat_loop, daemon=True).start()
+        try:
+            self.consume()
+        finally:
+            self.stop_heartbeats()
+
+    def consume(self):
+        while not stopping:
             if self.is_local:
                 try:
-                    task: IntTask = self.stub.GetPythonTask(
-                        Empty(), timeout=5)
-                    self.process_task(task)
+                    task: IntTask = self.stub.GetTask(
+                        task_pb2.GetTaskRequest(worker_type=task_pb2.WORKER_TYPE_PYTHON, queue=QUEUE, worker_id=self.worker_id,
+                                                labels=WORKER_LABELS), timeout=5, metadata=CALL_METADATA)
+                    self.current_task = task.id
+                    result = self.process_task(task)
+                    self.complete_task(task.id, result)
+                    self.finish_task(result)
                 except grpc.RpcError as e:
                     if e.code() == grpc.StatusCode.NOT_FOUND:
                         logging.info("No task in queue")
                         time.sleep(0.2)
                     elif e.code() == grpc.StatusCode.UNAVAILABLE:
                         print("Server unavailable, retrying...")
-                        channel = grpc.insecure_channel("localhost:50051")
+                        channel = grpc.insecure_channel(GRPC_ADDR)
                         self.stub = task_pb2_grpc.TaskServiceStub(channel)
                         time.sleep(1)
+                    elif e.code() in (grpc.StatusCode.UNAUTHENTICATED, grpc.StatusCode.PERMISSION_DENIED):
+                        logging.error(f"❌ gRPC server rejected the API key: {e.details()}")
+                        sys.exit(1)
             else:
                 try:
-                    task_data = rdb.zpopmin("python_queue", 1)
-                    if task_data:
-                        raw, _ = task_data[0]
+                    if self.rdb.hget(QUEUE_STATES_KEY, QUEUE) == b"paused":
+                        logging.info("Queue is paused! Sleeping...")
+                        time.sleep(0.2)
+                        continue
+                    raw = self.fair_pop_script(
+                        keys=[QUEUE_KEY, f"{QUEUE_KEY}:tenants", f"{QUEUE_KEY}:drr"], args=[TENANT_WEIGHTS])
+                    if raw:
                         task_dict = json.loads(raw)
                         task = IntTask(**task_dict)
-                        self.process_task(task)
+                        expired = expiry_error(task_dict)
+                        if expired:
+                            self.record_outcome(task, "expired", expired)
+                            continue
+                        if not matches_worker(task_dict):
+                            mark_unmatched(task_dict)
+                            self.defer_task(task_dict, AFFINITY_RETRY_DELAY_MS)
+                            continue
+                        task_dict.pop("unschedulable_since", None)
+                        permit = self.acquire_permit(task.task.type)
+                        if permit is False:
+                            self.defer_task(task_dict)
+                            continue
+                        wait = self.take_rate_token(task_dict)
+                        if wait:
+                            if permit:
+                                self.rdb.zrem(*permit)
+                            self.defer_task(task_dict, wait)
+                            continue
+                        started_at = self.start_task(task.id, task_dict)
+                        self.publish_event("started", task)
+                        self.current_task = task.id
+                        result = None
+                        try:
+                            result = self.process_task(task)
+                        finally:
+                            self.finish_task(result)
+                            error = self.task_error(result)
+                            self.record_sample(task_dict, started_at, bool(error))
+                            self.record_outcome(task, "failed" if error else "succeeded", error)
+                            self.rdb.hdel(f"{QUEUE_KEY}:inflight", task.id)
+                            if permit:
+                                self.rdb.zrem(*permit)
                     else:
                         logging.info("No task! Sleeping...")
                         time.sleep(0.2)
@@ -108,21 +679,10 @@ class Worker:
 if __name__ == "__main__":
     load_dotenv(path.abspath(path.join(path.dirname(__file__), "..", ".env")))
     is_production = getenv("IS_PRODUCTION", "false").lower() == "true"
-    if is_production:
-        logging.basicConfig(
-            level=logging.INFO,
-            format='%(asctime)s - %(levelname)s - %(message)s',
-            handlers=[
-                logging.FileHandler('workers.log'),
-            ]
-        )
-    else:
-        logging.basicConfig(
-            level=logging.DEBUG,
-            format='%(asctime)s - %(levelname)s - %(message)s',
-            stream=sys.stdout
-        )
+    log_handler = setup_logging(
+        getenv("LOG_LEVEL", "info" if is_production else "debug"))
 
+    setup_tracing()
     MODE = getenv("MODE", "local").lower()
 
     if MODE == "redis":
@@ -132,6 +692,7 @@ if __name__ == "__main__":
         worker = Worker(rdb=rdb, is_local=False)
     else:
         worker = Worker(is_local=True)
+    log_handler.addFilter(TaskFilter(worker))
 
     logging.info(
         f"🚀 Starting worker in {'LOCAL' if MODE != "redis" else 'REDIS'} mode...")
@@ -1,9 +1,14 @@
 package shell
 
-import "syscall"
+import (
+	"sync/atomic"
+	"syscall"
+)
 
 var (
 	originalState syscall.Termios
+	// whether the terminal is in raw mode, see enableTermRawMode
+	rawMode atomic.Bool
 
 	BACKSPACE_1      = uint8(127)
 	BACKSPACE_2      = uint8(8)
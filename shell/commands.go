@@ -0,0 +1,167 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/go-redis/redis"
+)
+
+var queueStateCommands = map[string]tasks.QueueState{
+	"pause":  tasks.QueuePaused,
+	"resume": tasks.QueueActive,
+	"drain":  tasks.QueueDraining,
+}
+
+// runQueueCommand handles `pause|resume|drain <queue>`. Returns false if the
+// line is not a queue command.
+func runQueueCommand(line string, rdb *redis.Client) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	state, isQueueCommand := queueStateCommands[fields[0]]
+	if !isQueueCommand {
+		return false
+	}
+	if len(fields) != 2 {
+		fmt.Printf("Usage: %s <queue>\n", fields[0])
+		return true
+	}
+
+	if err := tasks.SetQueueState(tasks.QueueType(fields[1]), state, rdb); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	fmt.Printf("Queue %s is %s\n", fields[1], state)
+	return true
+}
+
+// runWorkersCommand handles `workers`, listing the workers known to the
+// registry. Returns false if the line is not the workers command.
+func runWorkersCommand(line string, rdb *redis.Client) bool {
+	if strings.TrimSpace(line) != "workers" {
+		return false
+	}
+
+	workers, err := tasks.ListWorkers(rdb)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return true
+	}
+	if len(workers) == 0 {
+		fmt.Println("No workers")
+		return true
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tRUNTIME\tQUEUE\tHOST\tPID\tRUNNING\tPROCESSED\tFAILED\tLAST SEEN")
+	for _, w := range workers {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s ago\n",
+			shortID(w.ID), w.Runtime, w.Queue, w.Host, w.PID, len(w.CurrentTasks),
+			w.Processed, w.Failed, time.Since(w.LastSeen).Round(time.Second))
+	}
+	_ = tw.Flush()
+	return true
+}
+
+// shortID shortens a worker's UUID for display.
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// runStatsCommand handles `stats [queue]`, rendering the statistics of all or
+// one queue like GET /queues does. Returns false if the line is not the stats command.
+func runStatsCommand(line string, rdb *redis.Client) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != "stats" {
+		return false
+	}
+	if len(fields) > 2 {
+		fmt.Println("Usage: stats [queue]")
+		return true
+	}
+
+	specs := tasks.Queues()
+	if len(fields) == 2 {
+		spec, exists := tasks.GetQueue(tasks.QueueType(fields[1]))
+		if !exists {
+			fmt.Printf("Error: %v: %s\n", tasks.ErrUnknownQueue, fields[1])
+			return true
+		}
+		specs = []tasks.QueueSpec{spec}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "QUEUE\tSTATE\tDEPTH\tDEFERRED\tIN FLIGHT\tCONCURRENCY\tOLDEST\tPRIORITIES\tTHROUGHPUT\tFAILURES\tWAIT P50/P95/P99\tRUN P50/P95/P99")
+	for _, spec := range specs {
+		state, err := tasks.GetQueueState(spec.Name, rdb)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return true
+		}
+		stats, err := tasks.GetQueueStats(spec.Name, rdb)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return true
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%s\t%s\t%s\t%.2f/s\t%.1f%%\t%s\t%s\n",
+			spec.Name, state, stats.Depth, stats.Deferred, stats.InFlight, formatConcurrency(stats.Concurrency),
+			formatMs(stats.OldestAgeMs), formatPriorities(stats.Priorities),
+			stats.Throughput, stats.FailureRate*100, formatPercentiles(stats.WaitMs), formatPercentiles(stats.RunMs))
+	}
+	_ = tw.Flush()
+	fmt.Printf("Throughput, failures and percentiles of the last %s\n", tasks.StatsWindow)
+	return true
+}
+
+// formatPriorities renders a priority histogram as `priority:count` pairs.
+func formatPriorities(priorities map[uint16]int) string {
+	if len(priorities) == 0 {
+		return "-"
+	}
+	keys := make([]uint16, 0, len(priorities))
+	for priority := range priorities {
+		keys = append(keys, priority)
+	}
+	slices.Sort(keys)
+	pairs := make([]string, len(keys))
+	for i, priority := range keys {
+		pairs[i] = fmt.Sprintf("%d:%d", priority, priorities[priority])
+	}
+	return strings.Join(pairs, " ")
+}
+
+// formatConcurrency renders the permits in use of limited task types as
+// `type:in_use/limit` pairs.
+func formatConcurrency(usage map[string]tasks.ConcurrencyUsage) string {
+	if len(usage) == 0 {
+		return "-"
+	}
+	taskTypes := make([]string, 0, len(usage))
+	for taskType := range usage {
+		taskTypes = append(taskTypes, taskType)
+	}
+	slices.Sort(taskTypes)
+	pairs := make([]string, len(taskTypes))
+	for i, taskType := range taskTypes {
+		pairs[i] = fmt.Sprintf("%s:%d/%d", taskType, usage[taskType].InUse, usage[taskType].Limit)
+	}
+	return strings.Join(pairs, " ")
+}
+
+func formatPercentiles(p tasks.Percentiles) string {
+	return fmt.Sprintf("%s/%s/%s", formatMs(p.P50), formatMs(p.P95), formatMs(p.P99))
+}
+
+func formatMs(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).String()
+}
@@ -3,6 +3,7 @@ package shell
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"strings"
 	"syscall"
@@ -408,13 +409,23 @@ func (sh *Shell) Start(tokenGroups [][]string, rdb *redis.Client) {
 		}
 		sh.history.Add(line)
 
+		if runQueueCommand(line, rdb) {
+			continue
+		}
+		if runWorkersCommand(line, rdb) {
+			continue
+		}
+		if runStatsCommand(line, rdb) {
+			continue
+		}
+
 		task, err := parseTaskFromCmd(line)
 		if err != nil {
 			fmt.Println("Could not process task!")
 			fmt.Printf("Error: %v\n", err)
 		}
 		if err := tasks.EnqueueTask(task, rdb); err != nil {
-			logging.DebugLog(fmt.Sprintf("task could not be added: %v", err))
+			slog.Debug("task could not be added", logging.Err(err))
 
 			if len(sh.input) == 0 {
 				fmt.Println("(empty)")
@@ -442,10 +453,14 @@ func enableTermRawMode(fd int) error {
 	if err != 0 {
 		return errors.New("Error enabling raw mode")
 	}
+	rawMode.Store(true)
 	return nil
 }
 
 func disableRawMode(fd int) error {
+	if !rawMode.CompareAndSwap(true, false) {
+		return nil
+	}
 	_, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(syscall.TIOCSETA), uintptr(unsafe.Pointer(&originalState)))
 	if err != 0 {
 		return errors.New("Error disabling raw mode")
@@ -453,8 +468,14 @@ func disableRawMode(fd int) error {
 	return nil
 }
 
+// StartInteractiveShell runs the shell until the user exits it.
 func StartInteractiveShell(rdb *redis.Client) {
 	sh := NewShell()
 	sh.Start(tokenGroups, rdb)
-	os.Exit(0)
+}
+
+// RestoreTerminal leaves the raw mode of a shell which is still running, e.g.
+// when the process is terminated by a signal.
+func RestoreTerminal() {
+	_ = disableRawMode(int(os.Stdin.Fd()))
 }
@@ -5,5 +5,15 @@ var (
 		{"add", "task", "--type", "download_file", "--payload", "*", "--priority", "*"},
 		{"add", "task", "--type", "send_email", "--payload", "*", "--priority", "*"},
 		{"add", "task", "--type", "process_image", "--payload", "*", "--priority", "*"},
+		{"pause", "go_queue"},
+		{"pause", "python_queue"},
+		{"resume", "go_queue"},
+		{"resume", "python_queue"},
+		{"drain", "go_queue"},
+		{"drain", "python_queue"},
+		{"workers"},
+		{"stats"},
+		{"stats", "go_queue"},
+		{"stats", "python_queue"},
 	}
 )
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCAuthRedisKey(t *testing.T) {
+	config.AppConfig.AUTH_ENABLED = true
+	t.Cleanup(func() { config.AppConfig.AUTH_ENABLED = false })
+
+	// like `qugopy keys create --mode redis`
+	record, key, err := auth.NewKey("grpc_test", []auth.Scope{auth.ScopeEnqueue}, nil)
+	if err != nil {
+		t.Fatalf("NewKey() error = %v", err)
+	}
+	if err := auth.Save(record, r); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	t.Cleanup(func() { _ = auth.Revoke(record.ID, r) })
+
+	interceptor := auth.UnaryServerInterceptor(r)
+	call := func(method string) error {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+key))
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/task.TaskService/" + method}, func(ctx context.Context, req any) (any, error) {
+			return nil, nil
+		})
+		return err
+	}
+	if err := call("Enqueue"); err != nil {
+		t.Fatalf("Expected a Redis-stored key to authenticate, got %v", err)
+	}
+	if code := status.Code(call("GetTask")); code != codes.PermissionDenied {
+		t.Fatalf("Expected PermissionDenied, got %v", code)
+	}
+}
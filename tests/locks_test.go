@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Yulian302/qugopy/internal/locks"
+)
+
+func TestRedisLocker(t *testing.T) {
+	key := "lock:redis_locker_test"
+	_ = r.Del(key).Err()
+	t.Cleanup(func() { _ = r.Del(key).Err() })
+
+	l := locks.NewRedisLocker(r)
+	lock, err := l.TryLock(key, 300*time.Millisecond)
+	if err != nil {
+		t.Fatalf("TryLock() error = %v", err)
+	}
+	if _, err := l.TryLock(key, 300*time.Millisecond); !errors.Is(err, locks.ErrLocked) {
+		t.Fatalf("Expected a held lock not to be acquired twice, got %v", err)
+	}
+
+	// the lease is renewed while the lock is held
+	time.Sleep(time.Second)
+	if _, err := l.TryLock(key, 300*time.Millisecond); !errors.Is(err, locks.ErrLocked) {
+		t.Fatalf("Expected the lock to be held past its first lease, got %v", err)
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	second, err := l.TryLock(key, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected a released lock to be acquirable, got %v", err)
+	}
+
+	// unlocking a stale handle must not release the new holder's lock
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if r.Exists(key).Val() != 1 {
+		t.Fatal("Expected a stale unlock to keep the lock of its new holder")
+	}
+	if err := second.Unlock(); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+}
+
+func TestRedisLockTakenOver(t *testing.T) {
+	key := "lock:redis_lock_takeover_test"
+	_ = r.Del(key).Err()
+	t.Cleanup(func() { _ = r.Del(key).Err() })
+
+	l := locks.NewRedisLocker(r)
+	lock, err := l.TryLock(key, time.Minute)
+	if err != nil {
+		t.Fatalf("TryLock() error = %v", err)
+	}
+	if ttl := r.PTTL(key).Val(); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("Expected the lock to have a lease of at most a minute, got %v", ttl)
+	}
+
+	// a lock taken over after its lease expired is kept by its new holder
+	_ = r.Set(key, "other", time.Minute).Err()
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if r.Get(key).Val() != "other" {
+		t.Fatal("Expected Unlock to leave a lock taken over by another holder")
+	}
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/Yulian302/qugopy/models"
 	"github.com/go-redis/redis"
 )
 
@@ -60,3 +66,120 @@ func TestCreateInitQueue(t *testing.T) {
 		t.Fatalf("Expected list length 3, got %d", length)
 	}
 }
+
+func TestTenantQuotaRedis(t *testing.T) {
+	config.AppConfig.MODE = "redis"
+	config.AppConfig.TENANTS = map[string]config.TenantQuota{"quota_test": {WEIGHT: 1, MAX_QUEUED: 5}}
+	key := tasks.TenantQueueKey(tasks.GoQueue, "quota_test")
+	_ = r.Del(key).Err()
+	t.Cleanup(func() {
+		config.AppConfig.TENANTS = nil
+		_ = r.Del(key).Err()
+	})
+
+	// concurrent producers must not overshoot the quota
+	var wg sync.WaitGroup
+	var enqueued atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := tasks.EnqueueTask(models.Task{Type: "send_email", Priority: 1, Payload: []byte(`{}`), Tenant: "quota_test"}, r)
+			if err == nil {
+				enqueued.Add(1)
+			} else if !errors.Is(err, tasks.ErrTenantQuotaExceeded) {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if enqueued.Load() != 5 || r.ZCard(key).Val() != 5 {
+		t.Fatalf("Expected 5 queued tasks, enqueued %d, queued %d", enqueued.Load(), r.ZCard(key).Val())
+	}
+}
+
+func TestListTasksRedis(t *testing.T) {
+	config.AppConfig.MODE = "redis"
+	var ids []string
+	t.Cleanup(func() {
+		for _, id := range ids {
+			_, _ = tasks.CancelTask(id, r)
+		}
+	})
+	for i := 0; i < 12; i++ {
+		id, err := tasks.Enqueue(models.Task{Type: "send_email", Priority: uint16(1 + i%3), Payload: []byte(`{}`), Tenant: "list_test"}, r)
+		if err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	// pages are read from the sort index, ties in the order of their IDs
+	opts := tasks.TaskListOptions{Filter: tasks.TaskFilter{Tenant: "list_test", MinPriority: 2}, Sort: tasks.SortByPriority, Desc: true, Limit: 3}
+	var listed []tasks.TaskRecord
+	for {
+		page, err := tasks.ListTasks(opts, r)
+		if err != nil {
+			t.Fatalf("ListTasks() error = %v", err)
+		}
+		listed = append(listed, page.Tasks...)
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	if len(listed) != 8 {
+		t.Fatalf("Expected 8 tasks, listed %d", len(listed))
+	}
+	for i := 1; i < len(listed); i++ {
+		prev, cur := listed[i-1], listed[i]
+		if prev.Task.Priority < cur.Task.Priority || (prev.Task.Priority == cur.Task.Priority && prev.ID < cur.ID) {
+			t.Fatalf("Task %d is out of order: %s (%d) after %s (%d)", i, cur.ID, cur.Task.Priority, prev.ID, prev.Task.Priority)
+		}
+	}
+}
+
+func TestTaskStatusRedis(t *testing.T) {
+	config.AppConfig.MODE = "redis"
+	id, err := tasks.Enqueue(models.Task{Type: "send_email", Priority: 1, Payload: []byte(`{}`), Tenant: "status_test"}, r)
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	taskStatus, err := tasks.GetTaskStatus(id, r)
+	if err != nil || taskStatus.State != tasks.TaskQueued {
+		t.Fatalf("Expected a queued task, got %+v, %v", taskStatus, err)
+	}
+	if _, err := tasks.CancelTask(id, r); err != nil {
+		t.Fatalf("CancelTask() error = %v", err)
+	}
+	taskStatus, err = tasks.GetTaskStatus(id, r)
+	if err != nil || taskStatus.State != tasks.TaskCancelled {
+		t.Fatalf("Expected a cancelled task, got %+v, %v", taskStatus, err)
+	}
+	if r.HExists(tasks.TaskIndexKey, id).Val() {
+		t.Fatal("Expected the cancelled task to leave the task index")
+	}
+}
+
+func TestMigrateLegacyQueues(t *testing.T) {
+	config.AppConfig.MODE = "redis"
+	legacy := models.IntTask{ID: "legacy_test", Task: models.Task{Type: "send_email", Priority: 2, Payload: []byte(`{}`), Tenant: "legacy_test"}}
+	legacyJson, _ := json.Marshal(legacy)
+	// the keys of go_queue before they were prefixed
+	_ = r.ZAdd("go_queue:tenant:legacy_test", redis.Z{Score: 2, Member: legacyJson}).Err()
+	_ = r.SAdd("go_queue:tenants", "legacy_test").Err()
+	t.Cleanup(func() { _, _ = tasks.CancelTask(legacy.ID, r) })
+
+	migrated, err := tasks.MigrateLegacyQueues(r)
+	if err != nil || migrated != 1 {
+		t.Fatalf("Expected 1 migrated task, got %d, %v", migrated, err)
+	}
+	if n := r.Exists("go_queue:tenant:legacy_test", "go_queue:tenants").Val(); n != 0 {
+		t.Fatalf("Expected the legacy keys to be deleted, %d remain", n)
+	}
+	taskStatus, err := tasks.GetTaskStatus(legacy.ID, r)
+	if err != nil || taskStatus.State != tasks.TaskQueued {
+		t.Fatalf("Expected the migrated task to be queued, got %+v, %v", taskStatus, err)
+	}
+}
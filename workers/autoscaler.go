@@ -0,0 +1,195 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/Yulian302/qugopy/logging"
+)
+
+// ScalePolicy bounds and tunes the autoscaling of a queue's worker pool.
+//
+// The pool grows when tasks pile up (more than TargetDepth queued tasks per
+// worker, or the tasks next in line waiting longer than MaxWait) while the
+// workers are busy. It shrinks one worker at a time when the workers are mostly
+// idle and the queue is well below those thresholds. The gap between the grow
+// and shrink thresholds, together with the cooldowns, keeps the pool from
+// flapping.
+type ScalePolicy struct {
+	Min int
+	Max int
+
+	// TargetDepth is the number of queued tasks per worker the pool is sized for.
+	TargetDepth int
+	// MaxWait is the wait of the tasks next in line above which the pool grows.
+	MaxWait time.Duration
+
+	// ScaleUpUtilization is the share of busy task slots required to grow;
+	// adding workers to a queue whose workers idle (e.g. rate limited) is useless.
+	ScaleUpUtilization float64
+	// ScaleDownUtilization is the share of busy task slots below which the pool shrinks.
+	ScaleDownUtilization float64
+
+	// ScaleUpCooldown and ScaleDownCooldown are the minimum times since the
+	// last scaling of the pool before it grows or shrinks again.
+	ScaleUpCooldown   time.Duration
+	ScaleDownCooldown time.Duration
+}
+
+// DefaultScalePolicy returns a policy with the given bounds and default tuning.
+func DefaultScalePolicy(min, max int) ScalePolicy {
+	return ScalePolicy{
+		Min:                  min,
+		Max:                  max,
+		TargetDepth:          10,
+		MaxWait:              5 * time.Second,
+		ScaleUpUtilization:   0.75,
+		ScaleDownUtilization: 0.25,
+		ScaleUpCooldown:      15 * time.Second,
+		ScaleDownCooldown:    time.Minute,
+	}
+}
+
+// decide returns the pool size the policy wants for the measured pool and the
+// reason. The size equals m.Workers if the pool should stay as it is.
+func (p ScalePolicy) decide(m PoolMetrics) (int, string) {
+	if m.Workers < p.Min {
+		return p.Min, "below minimum"
+	}
+	if m.Workers > p.Max {
+		return p.Max, "above maximum"
+	}
+
+	metrics := fmt.Sprintf("depth %d, wait %s, utilization %.0f%%", m.Depth, m.Wait.Round(time.Millisecond), m.Utilization*100)
+	backlog := m.Depth > p.TargetDepth*m.Workers
+	slow := p.MaxWait > 0 && m.Wait > p.MaxWait
+	if (backlog || slow) && (m.Workers == 0 || m.Utilization >= p.ScaleUpUtilization) {
+		// enough workers for the backlog at once, but at least one more
+		wanted := int(math.Ceil(float64(m.Depth) / float64(max(p.TargetDepth, 1))))
+		return min(max(wanted, m.Workers+1), p.Max), metrics
+	}
+
+	idle := m.Utilization < p.ScaleDownUtilization
+	shallow := m.Depth <= p.TargetDepth*m.Workers/2
+	fast := p.MaxWait <= 0 || m.Wait <= p.MaxWait/2
+	if idle && shallow && fast && m.Workers > p.Min {
+		return m.Workers - 1, metrics
+	}
+	return m.Workers, ""
+}
+
+// ScaleEvent records a scaling decision of the autoscaler.
+type ScaleEvent struct {
+	Time   time.Time       `json:"time"`
+	Queue  tasks.QueueType `json:"queue"`
+	From   int             `json:"from"`
+	To     int             `json:"to"`
+	Reason string          `json:"reason"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// number of recent events kept by the autoscaler
+const maxScaleEvents = 100
+
+// Autoscaler periodically resizes the worker pools of queues with a ScalePolicy.
+type Autoscaler struct {
+	wd       *WorkerDistributor
+	policies map[tasks.QueueType]ScalePolicy
+	interval time.Duration
+
+	mu         sync.Mutex
+	lastScaled map[tasks.QueueType]time.Time
+	events     []ScaleEvent
+	now        func() time.Time
+}
+
+func NewAutoscaler(wd *WorkerDistributor, policies map[tasks.QueueType]ScalePolicy, interval time.Duration) *Autoscaler {
+	return &Autoscaler{
+		wd:         wd,
+		policies:   policies,
+		interval:   interval,
+		lastScaled: make(map[tasks.QueueType]time.Time),
+		now:        time.Now,
+	}
+}
+
+// Run evaluates all pools every interval until ctx is cancelled.
+func (a *Autoscaler) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for queueType := range a.policies {
+				a.evaluate(queueType)
+			}
+		}
+	}
+}
+
+// evaluate measures a pool and resizes it if its policy and cooldowns allow.
+func (a *Autoscaler) evaluate(queueType tasks.QueueType) {
+	policy := a.policies[queueType]
+	m, err := a.wd.poolMetrics(queueType)
+	if err != nil {
+		slog.Error("autoscaler could not measure queue", logging.QueueKey, queueType, logging.Err(err))
+		return
+	}
+
+	target, reason := policy.decide(m)
+	if target == m.Workers {
+		return
+	}
+
+	a.mu.Lock()
+	since := a.now().Sub(a.lastScaled[queueType])
+	a.mu.Unlock()
+	// bounds are enforced right away, only load based decisions wait
+	if m.Workers >= policy.Min && m.Workers <= policy.Max {
+		if target > m.Workers && since < policy.ScaleUpCooldown {
+			return
+		}
+		if target < m.Workers && since < policy.ScaleDownCooldown {
+			return
+		}
+	}
+
+	event := ScaleEvent{Time: a.now(), Queue: queueType, From: m.Workers, To: target, Reason: reason}
+	if err := a.wd.ScalePool(queueType, target); err != nil {
+		event.Error = err.Error()
+	}
+	a.record(event)
+}
+
+func (a *Autoscaler) record(event ScaleEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.lastScaled[event.Queue] = event.Time
+	a.events = append(a.events, event)
+	if len(a.events) > maxScaleEvents {
+		a.events = a.events[len(a.events)-maxScaleEvents:]
+	}
+
+	if event.Error != "" {
+		slog.Error("autoscaler could not scale workers", logging.QueueKey, event.Queue, "from", event.From, "to", event.To, "reason", event.Reason, "error", event.Error)
+		return
+	}
+	slog.Info("autoscaler scaled workers", logging.QueueKey, event.Queue, "from", event.From, "to", event.To, "reason", event.Reason)
+}
+
+// Events returns the most recent scaling decisions, oldest first.
+func (a *Autoscaler) Events() []ScaleEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]ScaleEvent{}, a.events...)
+}
@@ -1,20 +1,37 @@
 package workers
 
 import (
+	"cmp"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"path"
-	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Yulian302/qugopy/config"
+	taskpb "github.com/Yulian302/qugopy/github.com/Yulian302/qugopy/proto"
+	"github.com/Yulian302/qugopy/internal/locks"
 	"github.com/Yulian302/qugopy/internal/queue"
+	"github.com/Yulian302/qugopy/internal/ratelimit"
 	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/Yulian302/qugopy/internal/tracing"
 	"github.com/Yulian302/qugopy/logging"
 	"github.com/go-redis/redis"
-	"github.com/google/uuid"
+	"google.golang.org/grpc"
+)
+
+const (
+	// delay before a task whose lock is held by a running duplicate is retried
+	lockedTaskRetryDelay = time.Second
+	// delay before a task held back by its concurrency limit is retried
+	limitedTaskRetryDelay = 200 * time.Millisecond
+	// interval between scaling decisions of autoscaled pools
+	autoscaleInterval = 5 * time.Second
+	// time workers get to exit once their tasks were cancelled on shutdown
+	forcedStopTimeout = 5 * time.Second
 )
 
 type WorkerDistributor struct {
@@ -24,86 +41,121 @@ type WorkerDistributor struct {
 	cancel    context.CancelFunc
 	wg        sync.WaitGroup
 	rdb       *redis.Client
+	locker    locks.Locker
+	sem       locks.Semaphore
+	limiter   ratelimit.Limiter
+	pyConfig  PythonWorkerConfig
+
+	// workers of external queues, see external.go
+	extManager   *WorkerManager
+	isProduction bool
+
+	// Go tasks being run, waited for by Shutdown
+	running sync.WaitGroup
+	// set by Shutdown, keeps pools from growing
+	stopping atomic.Bool
+
+	// tasks each Go worker runs at once
+	goConcurrency int
+	// labels of the workers, matched against the affinity of tasks
+	labels map[string]string
+
+	// workers of every queue, see pool.go
+	pools   map[tasks.QueueType]*pool
+	poolsMu sync.Mutex
+
+	autoscaler *Autoscaler
+	supervisor *Supervisor
+
+	// queues consumed, all if nil
+	queues map[tasks.QueueType]bool
+
+	// server handing out tasks in local mode, see remote.go
+	remote     taskpb.TaskServiceClient
+	remoteConn *grpc.ClientConn
+	remoteAddr string
+	// server Python workers report their metrics to in redis mode, see ReportMetricsTo
+	metricsAddr string
 }
 
 func NewWorkerDistributor(rdb *redis.Client) *WorkerDistributor {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &WorkerDistributor{
-		pyManager: NewWorkerManager(),
-		goManager: NewWorkerManager(),
-		ctx:       ctx,
-		cancel:    cancel,
-		rdb:       rdb,
+		pyManager:  NewWorkerManager(),
+		goManager:  NewWorkerManager(),
+		extManager: NewWorkerManager(),
+		ctx:        ctx,
+		cancel:     cancel,
+		rdb:        rdb,
+		locker:     locks.New(config.AppConfig.MODE, rdb),
+		sem:        locks.NewSemaphore(config.AppConfig.MODE, rdb),
+		limiter:    ratelimit.New(config.AppConfig.MODE, rdb),
+		pools:      make(map[tasks.QueueType]*pool),
+		labels:     config.AppConfig.WORKER_LABELS,
 	}
 }
 
+// DistributeWorkers splits totalWorkers in half between Python and Go workers,
+// see SplitWorkers, and starts them with Distribute.
 func (wd *WorkerDistributor) DistributeWorkers(totalWorkers int, mode string, isProduction bool, rdb *redis.Client) (context.CancelFunc, error) {
 	if totalWorkers < 1 {
 		return nil, fmt.Errorf("totalWorkers must be at least 1")
 	}
+	return wd.Distribute(SplitWorkers(totalWorkers), mode, isProduction, rdb)
+}
+
+// Distribute starts the worker pools of the built-in queues sized by plan and
+// the pools of named queues. The returned function shuts them down, see Shutdown.
+func (wd *WorkerDistributor) Distribute(plan WorkerPlan, mode string, isProduction bool, rdb *redis.Client) (context.CancelFunc, error) {
+	plan, err := plan.apply()
+	if err != nil {
+		return nil, err
+	}
+	pyCount, goCount := plan.Python, plan.Go
+	wd.goConcurrency = plan.GoConcurrency
+	wd.isProduction = isProduction
 
-	totalWorkers = min(totalWorkers, runtime.NumCPU())
-	pyCount := totalWorkers / 2
-	goCount := totalWorkers - pyCount
-
-	config := PythonWorkerConfig{
-		EnvPath:      path.Join(config.ProjectRootPath, "processing", "venv", "bin"),
-		FilePath:     path.Join(config.ProjectRootPath, "processing", "worker.py"),
-		Mode:         mode,
-		IsProduction: isProduction,
-	}
-
-	ctx := wd.ctx
-
-	for i := 0; i < pyCount; i++ {
-		wd.wg.Add(1)
-		wd.pyManager.AddWorker(NewPythonWorker(ctx, uuid.New().String(), config))
-	}
-
-	for i := 0; i < goCount; i++ {
-		wd.wg.Add(1)
-		wd.goManager.AddWorker(NewGoWorker(
-			uuid.New().String(),
-			func(ctx context.Context) error {
-				for {
-					select {
-					case <-ctx.Done():
-						return nil
-					default:
-						var task queue.IntTask
-						var exists bool
-
-						if mode == "redis" {
-							res, err := rdb.ZPopMin("go_queue", 1).Result()
-							if err != nil || len(res) == 0 {
-								time.Sleep(100 * time.Millisecond)
-								continue
-							}
-							if err := json.Unmarshal([]byte(res[0].Member.(string)), &task); err != nil {
-								// skip task
-								fmt.Printf("Failed to unmarshal task: %v. Raw: %s\n", err, res[0].Member)
-								time.Sleep(100 * time.Millisecond)
-								continue
-							}
-
-						} else {
-							task, exists = queue.GoLocalQueue.PQ.Pop()
-							// queue is empty
-							if !exists {
-								time.Sleep(100 * time.Millisecond)
-								continue
-							}
-						}
-
-						err := tasks.DispatchTask(ctx, task)
-						if err != nil {
-							logging.DebugLog(fmt.Sprintf("could not complete task (id=%s): %v", task.ID, err))
-							continue
-						}
-					}
-				}
-			},
-		))
+	wd.pyConfig = PythonWorkerConfig{
+		EnvPath:           path.Join(config.ProjectRootPath, "processing", "venv", "bin"),
+		FilePath:          path.Join(config.ProjectRootPath, "processing", "worker.py"),
+		Mode:              mode,
+		IsProduction:      isProduction,
+		ConcurrencyLimits: tasks.AllConcurrencyLimits(),
+		RateLimits:        tasks.AllRateLimits(),
+		StopGrace:         config.AppConfig.SHUTDOWN_GRACE,
+		GrpcAddr:          cmp.Or(wd.remoteAddr, wd.metricsAddr),
+		APIKey:            wd.apiKey(),
+		Labels:            wd.labels,
+		AffinityFallback:  config.AppConfig.AFFINITY_FALLBACK,
+		AffinityTimeout:   config.AppConfig.AFFINITY_TIMEOUT,
+	}
+
+	if len(plan.Queues) > 0 {
+		wd.queues = make(map[tasks.QueueType]bool)
+		for _, queueType := range plan.Queues {
+			if _, exists := tasks.GetQueue(queueType); !exists {
+				return nil, fmt.Errorf("%w: %s", tasks.ErrUnknownQueue, queueType)
+			}
+			wd.queues[queueType] = true
+		}
+	}
+
+	if wd.consumes(tasks.PyQueue) {
+		wd.addWorkers(tasks.PyQueue, tasks.PythonRuntime, pyCount)
+	}
+	if wd.consumes(tasks.GoQueue) {
+		wd.addWorkers(tasks.GoQueue, tasks.GoRuntime, goCount)
+	}
+
+	// named queues get their own pools
+	for _, spec := range tasks.Queues() {
+		if !tasks.IsBuiltin(spec.Name) && wd.consumes(spec.Name) {
+			wd.addWorkers(spec.Name, spec.Runtime, spec.Workers)
+		}
+	}
+
+	if mode == "redis" && rdb != nil {
+		go wd.promoteDeferredTasks()
 	}
 
 	if err := wd.pyManager.StartAll(); err != nil {
@@ -114,29 +166,358 @@ func (wd *WorkerDistributor) DistributeWorkers(totalWorkers int, mode string, is
 		wd.cleanup()
 		return nil, fmt.Errorf("go worker startup failed: %w", err)
 	}
+	if err := wd.extManager.StartAll(); err != nil {
+		wd.cleanup()
+		return nil, fmt.Errorf("external worker startup failed: %w", err)
+	}
+
+	tasks.OnQueueDeclared(wd.startQueueWorkers)
 
-	return nil, nil
+	wd.supervisor = NewSupervisor(DefaultSupervisorPolicy(), map[tasks.Runtime]*WorkerManager{
+		tasks.PythonRuntime:   wd.pyManager,
+		tasks.GoRuntime:       wd.goManager,
+		tasks.ExternalRuntime: wd.extManager,
+	})
+	go wd.supervisor.Run(wd.ctx)
+
+	if len(config.AppConfig.AUTOSCALE) > 0 {
+		policies := make(map[tasks.QueueType]ScalePolicy)
+		for name, bounds := range config.AppConfig.AUTOSCALE {
+			if _, exists := tasks.GetQueue(tasks.QueueType(name)); !exists {
+				wd.cleanup()
+				return nil, fmt.Errorf("cannot autoscale %w: %s", tasks.ErrUnknownQueue, name)
+			}
+			if !wd.consumes(tasks.QueueType(name)) {
+				continue
+			}
+			policies[tasks.QueueType(name)] = DefaultScalePolicy(bounds.MIN, bounds.MAX)
+		}
+		if len(policies) > 0 {
+			wd.autoscaler = NewAutoscaler(wd, policies, autoscaleInterval)
+			go wd.autoscaler.Run(wd.ctx)
+		}
+	}
+
+	return func() {
+		if err := wd.Shutdown(config.AppConfig.SHUTDOWN_GRACE); err != nil {
+			slog.Error("workers did not stop cleanly", logging.Err(err))
+		}
+	}, nil
 }
 
-func (wd *WorkerDistributor) Shutdown() error {
-	wd.cancel()
+// WorkerStatuses returns the supervision state of all workers, including their restart counts.
+func (wd *WorkerDistributor) WorkerStatuses() []WorkerStatus {
+	if wd.supervisor == nil {
+		return nil
+	}
+	return wd.supervisor.Statuses()
+}
+
+// Autoscaler returns the running autoscaler, nil if no queue is autoscaled.
+func (wd *WorkerDistributor) Autoscaler() *Autoscaler {
+	return wd.autoscaler
+}
+
+// consumes reports whether the distributor runs workers for a queue.
+func (wd *WorkerDistributor) consumes(queueType tasks.QueueType) bool {
+	return wd.queues == nil || wd.queues[queueType]
+}
+
+// startQueueWorkers starts the pool of a queue declared while running.
+func (wd *WorkerDistributor) startQueueWorkers(spec tasks.QueueSpec) {
+	if wd.ctx.Err() != nil || wd.stopping.Load() || !wd.consumes(spec.Name) {
+		return
+	}
+	for _, w := range wd.addWorkers(spec.Name, spec.Runtime, spec.Workers) {
+		if err := w.Start(); err != nil {
+			logging.Worker(string(spec.Name), w.ID()).Error("could not start worker", logging.Err(err))
+		}
+	}
+}
+
+// errTaskDeferred is returned by runTask for a task it deferred instead of running.
+var errTaskDeferred = errors.New("task deferred")
+
+// dispatchFunc executes a task, e.g. in this process or in an external worker process.
+type dispatchFunc func(ctx context.Context, task queue.IntTask) error
+
+// goWorkerLoop returns the loop of a Go worker, executing tasks in this process.
+func (wd *WorkerDistributor) goWorkerLoop(queueType tasks.QueueType, workerID string) func(ctx context.Context) error {
+	return wd.workerLoop(queueType, workerID, wd.goConcurrency, tasks.DispatchTask)
+}
+
+// workerLoop returns a loop popping tasks from a queue and executing them with
+// dispatch until ctx is cancelled. Up to concurrency tasks run at once; the loop
+// only pops a task when one of them has finished. Running tasks are bound to the
+// distributor's context, so a stopped worker finishes them before its loop returns.
+func (wd *WorkerDistributor) workerLoop(queueType tasks.QueueType, workerID string, concurrency int, dispatch dispatchFunc) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		act := newActivity(queueType, workerID, wd.labels)
+		defer wd.startHeartbeats(act)()
+
+		slots := make(chan struct{}, max(concurrency, 1))
+		var running sync.WaitGroup
+		defer running.Wait()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case slots <- struct{}{}:
+			}
+
+			task, exists, err := wd.nextTask(queueType, workerID)
+			if err != nil {
+				// skip task
+				logging.Worker(string(queueType), workerID).Error("could not take task", logging.Err(err))
+				<-slots
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			// queue is empty or paused
+			if !exists {
+				<-slots
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+
+			running.Add(1)
+			wd.running.Add(1)
+			go func() {
+				defer func() {
+					<-slots
+					running.Done()
+					wd.running.Done()
+				}()
+				act.start(task.ID)
+				err := wd.execute(queueType, workerID, task, dispatch)
+				act.finish(task.ID, err, errors.Is(err, errTaskDeferred) || err != nil && wd.interrupted(err))
+			}()
+		}
+	}
+}
+
+// nextTask takes the next task of a queue for a worker. Tasks whose deadline
+// passed are recorded as expired and skipped. The boolean is false if the queue
+// is empty or paused.
+func (wd *WorkerDistributor) nextTask(queueType tasks.QueueType, workerID string) (queue.IntTask, bool, error) {
+	if wd.remote != nil {
+		return wd.remoteTask(queueType, workerID)
+	}
+
+	if paused, err := tasks.IsQueuePaused(queueType, wd.rdb); err != nil || paused {
+		return queue.IntTask{}, false, nil
+	}
+	task, exists, err := tasks.DequeueTask(queueType, wd.rdb)
+	for err == nil && exists && tasks.IsExpired(task) {
+		if err := tasks.ExpireTask(queueType, task, workerID, wd.rdb); err != nil {
+			logging.Task(string(queueType), workerID, task.ID).Error("could not record task as expired", logging.Err(err))
+		}
+		task, exists, err = tasks.DequeueTask(queueType, wd.rdb)
+	}
+	if err != nil || !exists {
+		return task, false, err
+	}
+	return task, true, nil
+}
+
+// execute runs a task taken by nextTask, records it as finished and returns the
+// task's error. A task cancelled by Shutdown or lost by a crashed worker process
+// is requeued, so that it runs again. The run is traced as a span continuing
+// the trace the task was enqueued in.
+func (wd *WorkerDistributor) execute(queueType tasks.QueueType, workerID string, task queue.IntTask, dispatch dispatchFunc) (err error) {
+	ctx, span := tracing.StartRun(wd.ctx, task, string(queueType), workerID)
+	log := logging.Task(string(queueType), workerID, task.ID)
+	ctx = logging.NewContext(ctx, log)
+	defer func() {
+		if errors.Is(err, errTaskDeferred) {
+			span.SetAttributes(tracing.DeferredKey.Bool(true))
+			span.End()
+			return
+		}
+		tracing.End(span, err)
+	}()
+
+	if wd.remote != nil {
+		err := dispatch(ctx, task)
+		if err != nil {
+			log.WarnContext(ctx, "task failed", logging.Err(err))
+		}
+		if err := wd.completeRemoteTask(task, workerID, err); err != nil {
+			log.ErrorContext(ctx, "could not report task to server", logging.Err(err))
+		}
+		return err
+	}
+
+	defer func() {
+		if err := tasks.FinishTask(queueType, task.ID, wd.rdb); err != nil {
+			log.ErrorContext(ctx, "could not record task as finished", logging.Err(err))
+		}
+	}()
+	err = wd.runTask(ctx, queueType, workerID, task, dispatch)
+	if errors.Is(err, errTaskDeferred) {
+		return err
+	}
+	if err != nil && wd.interrupted(err) {
+		if err := tasks.RequeueTask(queueType, task, wd.rdb); err != nil {
+			log.ErrorContext(ctx, "could not requeue interrupted task", logging.Err(err))
+		}
+		return err
+	}
+	if err != nil {
+		log.WarnContext(ctx, "task failed", logging.Err(err))
+	}
+	if err := tasks.RecordResult(queueType, task, workerID, err, wd.rdb); err != nil {
+		log.ErrorContext(ctx, "could not record result", logging.Err(err))
+	}
+	return err
+}
+
+// interrupted reports whether a task failed because it was cancelled by Shutdown
+// or because the worker process running it exited, not because of the task itself.
+func (wd *WorkerDistributor) interrupted(err error) bool {
+	return wd.ctx.Err() != nil || errors.Is(err, errProcessExited)
+}
+
+// runTask dispatches a task, holding its unique lock and concurrency permit while
+// it runs. A task whose affinity does not match the workers' labels, whose lock
+// is held by a running duplicate, whose type is at its concurrency limit or which
+// exceeds its type's rate limit is deferred instead of executed, see deferTask.
+// The task is only recorded as running once it is dispatched.
+func (wd *WorkerDistributor) runTask(ctx context.Context, queueType tasks.QueueType, workerID string, task queue.IntTask, dispatch dispatchFunc) error {
+	if !tasks.MatchesWorker(task, wd.labels) {
+		return wd.deferTask(tasks.MarkUnmatched(task, wd.labels), tasks.AffinityRetryDelay)
+	}
+	task.UnschedulableSince = nil
+
+	if key, ttl, needsLock := tasks.RunLockKey(task); needsLock {
+		lock, err := wd.locker.TryLock(key, ttl)
+		if errors.Is(err, locks.ErrLocked) {
+			logging.FromContext(ctx).DebugContext(ctx, "task is locked by a running duplicate, deferring")
+			return wd.deferTask(task, lockedTaskRetryDelay)
+		}
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+	}
+
+	if key, limit, limited := tasks.ConcurrencyKey(task.Task.Type); limited {
+		permit, err := wd.sem.TryAcquire(key, limit, tasks.ConcurrencyLeaseTTL)
+		if errors.Is(err, locks.ErrLimitReached) {
+			return wd.deferTask(task, limitedTaskRetryDelay)
+		}
+		if err != nil {
+			return err
+		}
+		stop := locks.Hold(permit, tasks.ConcurrencyLeaseTTL)
+		defer func() {
+			stop()
+			_ = permit.Release()
+		}()
+	}
+
+	if key, rule, limited := tasks.RateLimitKey(task); limited {
+		allowed, wait, err := wd.limiter.Allow(key, rule)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			logging.FromContext(ctx).DebugContext(ctx, "task exceeds rate limit, deferring", "rate_limit", key, "delay", wait)
+			return wd.deferTask(task, wait)
+		}
+	}
+
+	if err := tasks.StartTask(queueType, workerID, task, wd.rdb); err != nil {
+		logging.FromContext(ctx).ErrorContext(ctx, "could not record task as running", logging.Err(err))
+	}
+	return dispatch(ctx, task)
+}
+
+// deferTask runs a task again after delay. Returns errTaskDeferred once the task
+// is deferred, so that it is neither counted as run nor requeued.
+func (wd *WorkerDistributor) deferTask(task queue.IntTask, delay time.Duration) error {
+	if err := tasks.DeferTask(task, delay, wd.rdb); err != nil {
+		return err
+	}
+	return errTaskDeferred
+}
+
+// promoteDeferredTasks periodically moves due deferred tasks back into their queues.
+func (wd *WorkerDistributor) promoteDeferredTasks() {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-wd.ctx.Done():
+			return
+		case <-ticker.C:
+			for _, spec := range tasks.Queues() {
+				if _, err := tasks.PromoteDueTasks(spec.Name, wd.rdb); err != nil {
+					slog.Error("could not promote deferred tasks", logging.QueueKey, spec.Name, logging.Err(err))
+				}
+			}
+		}
+	}
+}
+
+// Shutdown stops all workers. They stop taking tasks at once and get grace to
+// finish the running ones. Then running Go tasks are cancelled and Python
+// workers killed, and the tasks they were running are put back into their queues.
+func (wd *WorkerDistributor) Shutdown(grace time.Duration) error {
+	wd.stopping.Store(true)
+
+	owned := make(map[string]bool)
+	for _, w := range wd.removeAllWorkers() {
+		owned[w.ID()] = true
+		go func(w Worker) {
+			defer wd.wg.Done()
+			if err := w.Stop(); err != nil {
+				logging.Worker("", w.ID()).Error("could not stop worker", logging.Err(err))
+			}
+		}(w)
+	}
 
 	done := make(chan struct{})
 	go func() {
 		wd.wg.Wait()
+		wd.running.Wait()
 		close(done)
 	}()
 
+	var err error
 	select {
 	case <-done:
-		return nil
-	case <-time.After(5 * time.Second):
-		return fmt.Errorf("shutdown timeout")
+	case <-time.After(grace):
+		err = fmt.Errorf("shutdown timeout: tasks still running after %s were cancelled", grace)
+		wd.cancel()
+		select {
+		case <-done:
+		case <-time.After(forcedStopTimeout):
+		}
+	}
+	wd.cancel()
+	if wd.remoteConn != nil {
+		_ = wd.remoteConn.Close()
+	}
+
+	// tasks of killed Python workers are still recorded as running
+	for _, spec := range tasks.Queues() {
+		n, rerr := tasks.RequeueInFlight(spec.Name, owned, wd.rdb)
+		if rerr != nil {
+			slog.Error("could not requeue running tasks", logging.QueueKey, spec.Name, logging.Err(rerr))
+		}
+		if n > 0 {
+			slog.Info("requeued unfinished tasks", logging.QueueKey, spec.Name, "count", n)
+		}
 	}
+	return err
 }
 
 func (wd *WorkerDistributor) cleanup() {
 	wd.cancel()
 	_ = wd.pyManager.StopAll()
 	_ = wd.goManager.StopAll()
+	_ = wd.extManager.StopAll()
 }
@@ -1,15 +1,32 @@
 package workers
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/Yulian302/qugopy/config"
+	taskpb "github.com/Yulian302/qugopy/github.com/Yulian302/qugopy/proto"
+	qgrpc "github.com/Yulian302/qugopy/grpc"
+	"github.com/Yulian302/qugopy/internal/events"
+	"github.com/Yulian302/qugopy/internal/queue"
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/Yulian302/qugopy/models"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
 )
 
 // MockWorker implements Worker interface for testing
@@ -71,8 +88,35 @@ func TestDistributeWorkers(t *testing.T) {
 		wd := NewWorkerDistributor(nil)
 		_, err := wd.DistributeWorkers(9999, "local", false, nil) // Exceeds core count
 		require.NoError(t, err)
-		assert.LessOrEqual(t, len(wd.pyManager.workers)+len(wd.goManager.workers), runtime.NumCPU())
+		assert.LessOrEqual(t, len(wd.pyManager.workers), runtime.NumCPU(), "Python workers should be capped at CPU count")
+		assert.Equal(t, 5000, len(wd.goManager.workers), "Go workers should not be capped")
 	})
+
+	t.Run("ExplicitCounts", func(t *testing.T) {
+		wd := NewWorkerDistributor(nil)
+		_, err := wd.Distribute(WorkerPlan{Go: 3, Python: 0, Cap: CapNone}, "local", false, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 0, len(wd.pyManager.workers))
+		assert.Equal(t, 3, len(wd.goManager.workers))
+	})
+
+	t.Run("FixedCapExceeded", func(t *testing.T) {
+		wd := NewWorkerDistributor(nil)
+		_, err := wd.Distribute(WorkerPlan{Go: 3, Python: 2, Cap: "4"}, "local", false, nil)
+		assert.ErrorContains(t, err, "cap is 4", "Exceeding a fixed cap should not drop workers silently")
+	})
+}
+
+func TestParseCapPolicy(t *testing.T) {
+	for raw, want := range map[string]CapPolicy{"": CapCPU, "cpu": CapCPU, "none": CapNone, "8": "8"} {
+		got, err := ParseCapPolicy(raw)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	for _, raw := range []string{"0", "-1", "all"} {
+		_, err := ParseCapPolicy(raw)
+		assert.Error(t, err)
+	}
 }
 
 func TestWorkerStartupFailures(t *testing.T) {
@@ -106,6 +150,25 @@ func TestWorkerStartupFailures(t *testing.T) {
 	})
 }
 
+func TestStopGoWorkerNeverStarted(t *testing.T) {
+	t.Parallel()
+
+	// e.g. a Go worker which was not started because a Python worker failed to start
+	gw := NewGoWorker("go1", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	stopped := make(chan error, 1)
+	go func() { stopped <- gw.Stop() }()
+
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Stopping a Go worker which never started should return at once")
+	}
+}
+
 func TestShutdown(t *testing.T) {
 	t.Parallel()
 
@@ -124,7 +187,7 @@ func TestShutdown(t *testing.T) {
 			wd.wg.Done()
 		}()
 
-		err := wd.Shutdown()
+		err := wd.Shutdown(5 * time.Second)
 		assert.NoError(t, err, "Should shutdown gracefully")
 	})
 
@@ -132,7 +195,7 @@ func TestShutdown(t *testing.T) {
 		wd := NewWorkerDistributor(nil)
 		wd.wg.Add(1) // Block shutdown
 
-		err := wd.Shutdown()
+		err := wd.Shutdown(100 * time.Millisecond)
 		assert.ErrorContains(t, err, "shutdown timeout")
 	})
 }
@@ -157,3 +220,360 @@ func TestConcurrentOperations(t *testing.T) {
 	assert.Equal(t, 10, len(wd.pyManager.workers), "Should handle concurrent adds")
 	assert.Equal(t, 10, len(wd.goManager.workers), "Should handle concurrent adds")
 }
+
+func TestGoWorkerConcurrency(t *testing.T) {
+	config.AppConfig.MODE = "local"
+
+	release := make(chan struct{})
+	arrived := make(chan struct{}, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	// own queue, so that workers of other tests do not take the tasks
+	require.NoError(t, tasks.DeclareQueue(tasks.QueueSpec{Name: "concurrency_test", Runtime: tasks.GoRuntime}, nil))
+	for i := 0; i < 2; i++ {
+		payload, _ := json.Marshal(map[string]string{"url": srv.URL, "filename": fmt.Sprintf("concurrency-%d.json", i)})
+		queue.Local("concurrency_test").Push(models.IntTask{
+			ID:   uuid.New().String(),
+			Task: models.Task{Type: "download_file", Payload: payload, Priority: 1},
+		})
+	}
+
+	wd := NewWorkerDistributor(nil)
+	wd.goConcurrency = 2
+	worker := NewGoWorker(uuid.New().String(), wd.goWorkerLoop("concurrency_test", uuid.New().String()))
+	require.NoError(t, worker.Start())
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-arrived:
+		case <-time.After(2 * time.Second):
+			t.Fatal("A single Go worker should run both tasks at once")
+		}
+	}
+	close(release)
+	assert.NoError(t, worker.Stop())
+	for i := 0; i < 2; i++ {
+		_ = os.Remove(path.Join(config.ProjectRootPath, "storage", fmt.Sprintf("concurrency-%d.json", i)))
+	}
+}
+
+func TestScalePolicyDecide(t *testing.T) {
+	t.Parallel()
+
+	policy := DefaultScalePolicy(1, 5)
+	tests := []struct {
+		name    string
+		metrics PoolMetrics
+		want    int
+	}{
+		{"BelowMinimum", PoolMetrics{Workers: 0}, 1},
+		{"AboveMaximum", PoolMetrics{Workers: 7}, 5},
+		{"BusyBacklog", PoolMetrics{Workers: 2, Depth: 40, Utilization: 1}, 4},
+		{"BacklogCappedAtMaximum", PoolMetrics{Workers: 2, Depth: 500, Utilization: 1}, 5},
+		{"SlowTasks", PoolMetrics{Workers: 2, Depth: 3, Wait: 10 * time.Second, Utilization: 0.9}, 3},
+		{"IdleBacklog", PoolMetrics{Workers: 2, Depth: 40, Utilization: 0.1}, 2},
+		{"Steady", PoolMetrics{Workers: 2, Depth: 15, Utilization: 0.5}, 2},
+		{"Idle", PoolMetrics{Workers: 3, Depth: 2, Utilization: 0.1}, 2},
+		{"IdleAtMinimum", PoolMetrics{Workers: 1, Utilization: 0}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := policy.decide(tt.metrics)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAutoscalerScalesPool(t *testing.T) {
+	config.AppConfig.MODE = "local"
+
+	// a paused queue keeps its tasks, so that only the autoscaler changes the metrics
+	require.NoError(t, tasks.DeclareQueue(tasks.QueueSpec{Name: "autoscale_test", Runtime: tasks.GoRuntime}, nil))
+	require.NoError(t, tasks.SetQueueState("autoscale_test", tasks.QueuePaused, nil))
+	for i := 0; i < 30; i++ {
+		queue.Local("autoscale_test").Push(models.IntTask{
+			ID:   uuid.New().String(),
+			Task: models.Task{Type: "send_email", Priority: 1},
+		})
+	}
+
+	wd := NewWorkerDistributor(nil)
+	// the started workers poll the queue until they are stopped and waited for
+	t.Cleanup(func() { assert.NoError(t, wd.Shutdown(5*time.Second)) })
+	now := time.Now()
+	a := NewAutoscaler(wd, map[tasks.QueueType]ScalePolicy{"autoscale_test": DefaultScalePolicy(0, 4)}, time.Second)
+	a.now = func() time.Time { return now }
+
+	a.evaluate("autoscale_test")
+	assert.Equal(t, 3, wd.PoolSize("autoscale_test"), "An empty pool should grow for the backlog")
+
+	for _, ok := queue.Local("autoscale_test").Pop(); ok; _, ok = queue.Local("autoscale_test").Pop() {
+	}
+	a.evaluate("autoscale_test")
+	assert.Equal(t, 3, wd.PoolSize("autoscale_test"), "The pool should not shrink during the cooldown")
+
+	now = now.Add(2 * time.Minute)
+	a.evaluate("autoscale_test")
+	assert.Equal(t, 2, wd.PoolSize("autoscale_test"), "An idle pool should shrink by one worker")
+
+	events := a.Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, 0, events[0].From)
+	assert.Equal(t, 3, events[0].To)
+	assert.Equal(t, 2, events[1].To)
+}
+
+// flakyWorker is a worker which crashes on demand.
+type flakyWorker struct {
+	MockWorker
+	alive  bool
+	starts int
+}
+
+func (f *flakyWorker) Start() error {
+	if f.startErr != nil {
+		return f.startErr
+	}
+	f.alive = true
+	f.starts++
+	return nil
+}
+
+func (f *flakyWorker) HealthCheck() error {
+	if !f.alive {
+		return errors.New("worker has exited")
+	}
+	return nil
+}
+
+func TestSupervisorRestartsCrashedWorkers(t *testing.T) {
+	t.Parallel()
+
+	worker := &flakyWorker{MockWorker: MockWorker{id: "flaky"}, alive: true}
+	wm := NewWorkerManager()
+	wm.AddWorker(worker)
+
+	s := NewSupervisor(SupervisorPolicy{
+		Interval:        time.Second,
+		InitialBackoff:  time.Second,
+		MaxBackoff:      time.Minute,
+		MaxRestarts:     2,
+		CrashLoopWindow: time.Minute,
+	}, map[tasks.Runtime]*WorkerManager{tasks.GoRuntime: wm})
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	s.check(ctx)
+	assert.Equal(t, WorkerRunning, s.Statuses()[0].State)
+
+	// first crash is restarted after the initial backoff
+	worker.alive = false
+	s.check(ctx)
+	assert.Equal(t, WorkerRestarting, s.Statuses()[0].State)
+	assert.Equal(t, 0, worker.starts, "Should wait for the backoff")
+	now = now.Add(time.Second)
+	s.check(ctx)
+	assert.Equal(t, 1, worker.starts)
+
+	// second crash waits twice as long
+	worker.alive = false
+	s.check(ctx)
+	now = now.Add(time.Second)
+	s.check(ctx)
+	assert.Equal(t, 1, worker.starts, "Backoff should double")
+	now = now.Add(time.Second)
+	s.check(ctx)
+	assert.Equal(t, 2, worker.starts)
+
+	// third crash within the window exceeds the limit
+	worker.alive = false
+	s.check(ctx)
+	now = now.Add(time.Minute)
+	s.check(ctx)
+	assert.Equal(t, 2, worker.starts, "A crash looping worker should not be restarted")
+
+	statuses := s.Statuses()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, WorkerUnhealthy, statuses[0].State)
+	assert.Equal(t, 2, statuses[0].Restarts)
+	assert.Equal(t, "worker has exited", statuses[0].LastError)
+
+	// removed workers are forgotten
+	wm.RemoveWorker("flaky")
+	s.check(ctx)
+	assert.Empty(t, s.Statuses())
+}
+
+func TestShutdownRequeuesUnfinishedTasks(t *testing.T) {
+	config.AppConfig.MODE = "local"
+
+	arrived := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	require.NoError(t, tasks.DeclareQueue(tasks.QueueSpec{Name: "shutdown_test", Runtime: tasks.GoRuntime}, nil))
+	payload, _ := json.Marshal(map[string]string{"url": srv.URL, "filename": "shutdown.json"})
+	queue.Local("shutdown_test").Push(models.IntTask{
+		ID:   uuid.New().String(),
+		Task: models.Task{Type: "download_file", Payload: payload, Priority: 1},
+	})
+
+	wd := NewWorkerDistributor(nil)
+	for _, w := range wd.addWorkers("shutdown_test", tasks.GoRuntime, 1) {
+		require.NoError(t, w.Start())
+	}
+	select {
+	case <-arrived:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Worker should run the task")
+	}
+
+	err := wd.Shutdown(100 * time.Millisecond)
+	assert.ErrorContains(t, err, "shutdown timeout")
+	assert.Equal(t, 1, queue.Local("shutdown_test").Len(), "Cancelled task should be requeued")
+	running, err := tasks.ListInFlight("shutdown_test", nil)
+	require.NoError(t, err)
+	assert.Empty(t, running)
+	assert.Equal(t, 0, wd.PoolSize("shutdown_test"))
+	assert.Error(t, wd.ScalePool("shutdown_test", 1), "Pools should not grow after shutdown")
+	_ = os.Remove(path.Join(config.ProjectRootPath, "storage", "shutdown.json"))
+}
+
+func TestRemoteGoWorker(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	heartbeatInterval = 50 * time.Millisecond
+	t.Cleanup(func() { heartbeatInterval = tasks.HeartbeatInterval })
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		close(release)
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	gs := grpc.NewServer()
+	taskpb.RegisterTaskServiceServer(gs, qgrpc.NewServer(nil))
+	go func() { _ = gs.Serve(lis) }()
+	defer gs.Stop()
+
+	require.NoError(t, tasks.DeclareQueue(tasks.QueueSpec{Name: "remote_test", Runtime: tasks.GoRuntime}, nil))
+	for i := 0; i < 2; i++ {
+		payload, _ := json.Marshal(map[string]string{"url": srv.URL, "filename": fmt.Sprintf("remote-%d.json", i)})
+		queue.Local("remote_test").Push(models.IntTask{
+			ID:   uuid.New().String(),
+			Task: models.Task{Type: "download_file", Payload: payload, Priority: uint16(2 - i)},
+		})
+	}
+
+	wd := NewWorkerDistributor(nil)
+	require.NoError(t, wd.ConnectRemote(lis.Addr().String()))
+	_, err = wd.Distribute(WorkerPlan{Cap: CapNone, Queues: []tasks.QueueType{"remote_test"}}, "local", false, nil)
+	require.NoError(t, err)
+	require.NoError(t, wd.ScalePool("remote_test", 1))
+	assert.Equal(t, 0, wd.PoolSize(tasks.GoQueue), "Queues which are not selected get no workers")
+
+	select {
+	case <-release:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Remote worker should take both tasks from the server")
+	}
+	assert.Equal(t, 0, queue.Local("remote_test").Len())
+
+	// the worker reports to the server's registry
+	var worker tasks.WorkerInfo
+	infos, err := tasks.ListWorkers(nil)
+	require.NoError(t, err)
+	for _, info := range infos {
+		if info.Queue == "remote_test" {
+			worker = info
+		}
+	}
+	assert.Equal(t, tasks.GoRuntime, worker.Runtime)
+	assert.Equal(t, os.Getpid(), worker.PID)
+	assert.Eventually(t, func() bool {
+		infos, _ := tasks.ListWorkers(nil)
+		for _, info := range infos {
+			if info.ID == worker.ID {
+				return len(info.CurrentTasks) == 1
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond, "Heartbeats should report the running task")
+
+	// the running task is handed back to the server
+	assert.ErrorContains(t, wd.Shutdown(100*time.Millisecond), "shutdown timeout")
+	assert.Equal(t, 1, queue.Local("remote_test").Len(), "Cancelled task should be requeued on the server")
+	running, err := tasks.ListInFlight("remote_test", nil)
+	require.NoError(t, err)
+	assert.Empty(t, running)
+	infos, err = tasks.ListWorkers(nil)
+	require.NoError(t, err)
+	for _, info := range infos {
+		assert.NotEqual(t, worker.ID, info.ID, "Stopped workers should be removed from the registry")
+	}
+	for i := 0; i < 2; i++ {
+		_ = os.Remove(path.Join(config.ProjectRootPath, "storage", fmt.Sprintf("remote-%d.json", i)))
+	}
+}
+
+func TestRunTaskAffinity(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	config.AppConfig.AFFINITY_FALLBACK = time.Minute
+	t.Cleanup(func() { config.AppConfig.AFFINITY_FALLBACK = 0 })
+
+	wd := NewWorkerDistributor(nil)
+	wd.labels = map[string]string{"disk": "small", "zone": "eu"}
+
+	var ran int
+	dispatch := func(ctx context.Context, task queue.IntTask) error {
+		ran++
+		return nil
+	}
+	task := func(affinity *models.Affinity, waited time.Duration) queue.IntTask {
+		return models.IntTask{
+			ID:         uuid.New().String(),
+			Task:       models.Task{Type: "send_email", Payload: json.RawMessage(`{}`), Priority: 1, Affinity: affinity},
+			EnqueuedAt: time.Now().Add(-waited),
+		}
+	}
+	required := &models.Affinity{Required: map[string]string{"disk": "large"}}
+	preferred := &models.Affinity{Required: map[string]string{"zone": "eu"}, Preferred: map[string]string{"disk": "large"}}
+
+	started := events.Subscribe(events.Filter{Types: []events.Type{events.Started}})
+	defer started.Close()
+	ctx := context.Background()
+	run := func(task queue.IntTask) error {
+		t.Cleanup(func() { _ = tasks.FinishTask(tasks.GoQueue, task.ID, nil) })
+		return wd.runTask(ctx, tasks.GoQueue, "affinity_test", task, dispatch)
+	}
+	assert.ErrorIs(t, run(task(required, 0)), errTaskDeferred)
+	assert.ErrorIs(t, run(task(preferred, 0)), errTaskDeferred)
+	assert.NoError(t, run(task(preferred, 2*time.Minute)), "Preferred labels should fall back")
+	assert.NoError(t, run(task(nil, 0)))
+	assert.Equal(t, 2, ran)
+	assert.Len(t, started.C, 2, "Only dispatched tasks should be started")
+
+	// deferred tasks go back into their queue for other workers
+	lq := queue.Local(string(tasks.GoQueue))
+	assert.Eventually(t, func() bool { return lq.Len() >= 2 }, time.Second, 10*time.Millisecond)
+	for lq.Len() > 0 {
+		lq.Pop()
+	}
+}
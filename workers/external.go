@@ -0,0 +1,487 @@
+package workers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/queue"
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/Yulian302/qugopy/logging"
+)
+
+// The external worker protocol lets processes written in any language execute
+// tasks. qugopy writes one JSON request per line to the process, which answers
+// every "run" request with one JSON result line carrying the task's id. A result
+// without an error means the task succeeded:
+//
+//	-> {"op":"run","id":"6f1c…","type":"resize_video","payload":{…},"priority":3}
+//	<- {"id":"6f1c…"}
+//	<- {"id":"6f1c…","error":"unsupported codec"}
+//
+// Before its result, a task may report its progress, from 0 to 1, any number of
+// times. Progress lines are published as progress events:
+//
+//	<- {"id":"6f1c…","progress":0.5,"message":"encoding"}
+//
+// A "cancel" request tells the process that a task was abandoned, e.g. on
+// shutdown, and is requeued. Closing the process' input asks it to exit.
+//
+// With the stdio transport the lines are exchanged over stdin and stdout. With
+// the unix transport the process connects to the Unix socket in QUGOPY_SOCKET
+// and stdout is free for logs.
+
+const (
+	// time a process gets to connect to its Unix socket
+	externalConnectTimeout = 10 * time.Second
+	// time a process gets to exit once its input was closed
+	externalExitTimeout = 3 * time.Second
+	// longest result line accepted from a process
+	maxExternalLine = 4 << 20
+)
+
+// errProcessExited is returned for tasks a worker process did not answer before
+// it exited. They are requeued.
+var errProcessExited = errors.New("worker process exited")
+
+type externalRequest struct {
+	Op       string          `json:"op"`
+	ID       string          `json:"id"`
+	Type     string          `json:"type,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+	Priority uint16          `json:"priority,omitempty"`
+	Tenant   string          `json:"tenant,omitempty"`
+}
+
+type externalResult struct {
+	ID    string `json:"id"`
+	Error string `json:"error,omitempty"`
+	// Progress is set on progress lines, which do not finish the task.
+	Progress *float64 `json:"progress,omitempty"`
+	Message  string   `json:"message,omitempty"`
+}
+
+type ExternalWorkerConfig struct {
+	Command string
+	Args    []string
+	Env     map[string]string
+	Dir     string
+
+	// Transport is "stdio" or "unix".
+	Transport string
+
+	// Concurrency is the number of tasks sent to the process at once.
+	Concurrency int
+
+	// Queue is the queue the worker consumes.
+	Queue tasks.QueueType
+}
+
+// ExternalWorker runs a process speaking the external worker protocol and sends
+// it the tasks of its queue. Tasks are taken by a worker loop in this process, so
+// unique locks, limits and requeueing work as for Go workers.
+type ExternalWorker struct {
+	id     string
+	ctx    context.Context
+	cancel context.CancelFunc
+	config ExternalWorkerConfig
+	// loop returns the worker loop sending its tasks to the process with dispatch
+	loop func(dispatch dispatchFunc) func(ctx context.Context) error
+
+	mu sync.Mutex
+	// closed when the current process has exited and its loop returned, nil before the first Start
+	done chan struct{}
+}
+
+func NewExternalWorker(parentCtx context.Context, id string, config ExternalWorkerConfig, loop func(dispatch dispatchFunc) func(ctx context.Context) error) *ExternalWorker {
+	ctx, cancel := context.WithCancel(parentCtx)
+	return &ExternalWorker{
+		id:     id,
+		ctx:    ctx,
+		cancel: cancel,
+		config: config,
+		loop:   loop,
+	}
+}
+
+// externalConfig returns the worker configuration of an external queue.
+func (wd *WorkerDistributor) externalConfig(queueType tasks.QueueType) ExternalWorkerConfig {
+	cfg := ExternalWorkerConfig{Queue: queueType, Concurrency: 1}
+	for _, pool := range config.AppConfig.EXTERNAL_POOLS {
+		if pool.NAME == string(queueType) {
+			cfg.Command = pool.COMMAND
+			cfg.Args = pool.ARGS
+			cfg.Env = pool.ENV
+			cfg.Dir = pool.DIR
+			cfg.Transport = pool.TRANSPORT
+			cfg.Concurrency = max(pool.CONCURRENCY, 1)
+		}
+	}
+	return cfg
+}
+
+// Start launches the worker process. It does nothing while the process runs and
+// launches a new one if it exited on its own.
+func (ew *ExternalWorker) Start() error {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+
+	if ew.ctx.Err() != nil {
+		return fmt.Errorf("worker has been stopped")
+	}
+	if ew.done != nil && !isClosed(ew.done) {
+		return nil
+	}
+	if ew.config.Command == "" {
+		return fmt.Errorf("no command configured for external queue %s", ew.config.Queue)
+	}
+
+	transport := ew.config.Transport
+	if transport == "" {
+		transport = "stdio"
+	}
+
+	cmd := exec.Command(ew.config.Command, ew.config.Args...)
+	cmd.Dir = ew.config.Dir
+	cmd.Env = append(os.Environ(),
+		"WORKER_ID="+ew.id,
+		"QUEUE="+string(ew.config.Queue),
+		"QUGOPY_TRANSPORT="+transport,
+	)
+	for name, value := range ew.config.Env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	// the output of the process goes to the log, as the output of Python workers
+	log := logging.Worker(string(ew.config.Queue), ew.id)
+	stderr := logging.NewOutputWriter(log, "stderr", slog.LevelInfo)
+	stdout := logging.NewOutputWriter(log, "stdout", slog.LevelInfo)
+	cmd.Stderr = stderr
+	// own process group, so that the worker is only stopped through Stop
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	var connect func(exited <-chan struct{}) (io.ReadCloser, io.WriteCloser, error)
+	switch transport {
+	case "stdio":
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		connect = func(<-chan struct{}) (io.ReadCloser, io.WriteCloser, error) {
+			return stdout, stdin, nil
+		}
+	case "unix":
+		socket := filepath.Join(os.TempDir(), "qugopy-"+ew.id+".sock")
+		_ = os.Remove(socket)
+		lis, err := net.Listen("unix", socket)
+		if err != nil {
+			return fmt.Errorf("could not listen on %s: %w", socket, err)
+		}
+		cmd.Env = append(cmd.Env, "QUGOPY_SOCKET="+socket)
+		cmd.Stdout = stdout
+		connect = func(exited <-chan struct{}) (io.ReadCloser, io.WriteCloser, error) {
+			return ew.accept(lis, socket, exited)
+		}
+	default:
+		return fmt.Errorf("invalid transport: %s", transport)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	ew.done = done
+	go ew.run(cmd, transport, connect, stdout, stderr, done)
+	return nil
+}
+
+// accept waits for the process to connect to its socket. It gives up when the
+// process exits, the worker is stopped or externalConnectTimeout passes.
+func (ew *ExternalWorker) accept(lis net.Listener, socket string, exited <-chan struct{}) (io.ReadCloser, io.WriteCloser, error) {
+	defer os.Remove(socket)
+	defer lis.Close()
+
+	accepted := make(chan struct{})
+	defer close(accepted)
+	go func() {
+		select {
+		case <-accepted:
+		case <-exited:
+		case <-ew.ctx.Done():
+		case <-time.After(externalConnectTimeout):
+		}
+		_ = lis.Close()
+	}()
+
+	conn, err := lis.Accept()
+	if err != nil {
+		return nil, nil, fmt.Errorf("worker process did not connect to %s", socket)
+	}
+	unixConn := conn.(*net.UnixConn)
+	return unixConn, halfCloser{unixConn}, nil
+}
+
+// halfCloser closes only the writing side of a connection, so that results
+// are still read after the process was asked to exit.
+type halfCloser struct {
+	*net.UnixConn
+}
+
+func (hc halfCloser) Close() error {
+	return hc.CloseWrite()
+}
+
+// run serves a started process until it exits or the worker is stopped, then
+// closes its input and kills it if it does not exit in time. Lines of its
+// stdout which are not results are logged by stdout.
+func (ew *ExternalWorker) run(cmd *exec.Cmd, transport string, connect func(exited <-chan struct{}) (io.ReadCloser, io.WriteCloser, error), stdout, stderr *logging.OutputWriter, done chan struct{}) {
+	defer close(done)
+
+	log := logging.Worker(string(ew.config.Queue), ew.id)
+	conn := &externalConn{workerID: ew.id, queue: ew.config.Queue, stdout: stdout, pending: make(map[string]pendingTask)}
+	readerDone := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		// with stdio, Wait must not close stdout before all results were read
+		if transport == "stdio" {
+			<-readerDone
+		}
+		if err := cmd.Wait(); err != nil && ew.ctx.Err() == nil {
+			log.Error("external worker exited", logging.Err(err))
+		}
+		stdout.Flush()
+		stderr.Flush()
+		close(exited)
+	}()
+
+	r, w, err := connect(exited)
+	if err != nil {
+		log.Error("could not connect to external worker", logging.Err(err))
+		close(readerDone)
+		ew.kill(cmd, exited)
+		return
+	}
+	conn.w = w
+	go func() {
+		defer close(readerDone)
+		conn.read(r)
+	}()
+
+	loopCtx, stopLoop := context.WithCancel(ew.ctx)
+	defer stopLoop()
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		if err := ew.loop(conn.dispatch)(loopCtx); err != nil {
+			log.Error("external worker stopped taking tasks", logging.Err(err))
+		}
+	}()
+
+	select {
+	case <-exited:
+		// running tasks are requeued, the loop stops taking new ones
+		conn.fail(errProcessExited)
+		stopLoop()
+		<-loopDone
+	case <-loopDone:
+	}
+
+	// no more tasks are sent, the process may exit
+	_ = w.Close()
+	select {
+	case <-exited:
+	case <-time.After(externalExitTimeout):
+		log.Warn("external worker did not exit in time, killing it", "timeout", externalExitTimeout)
+		ew.kill(cmd, exited)
+	}
+	if transport == "unix" {
+		_ = r.Close()
+		<-readerDone
+	}
+}
+
+// kill kills the process group of a worker process and waits for it to exit.
+func (ew *ExternalWorker) kill(cmd *exec.Cmd, exited <-chan struct{}) {
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	<-exited
+}
+
+// Stop stops taking tasks, waits for the running ones and lets the process exit.
+func (ew *ExternalWorker) Stop() error {
+	ew.cancel()
+
+	ew.mu.Lock()
+	done := ew.done
+	ew.mu.Unlock()
+	// a worker which never started has nothing to wait for
+	if done == nil {
+		return nil
+	}
+	<-done
+	return nil
+}
+
+// HealthCheck reports a worker which never started or whose process exited on
+// its own. A stopped worker did not fail.
+func (ew *ExternalWorker) HealthCheck() error {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+
+	if ew.done == nil {
+		return fmt.Errorf("worker not running")
+	}
+	if isClosed(ew.done) && ew.ctx.Err() == nil {
+		return fmt.Errorf("worker process has exited")
+	}
+	return nil
+}
+
+func (ew *ExternalWorker) ID() string {
+	return ew.id
+}
+
+// externalConn sends requests to a worker process and hands its results to the
+// dispatches waiting for them.
+type externalConn struct {
+	workerID string
+	queue    tasks.QueueType
+	// logs the lines of the process which are not results
+	stdout *logging.OutputWriter
+
+	writeMu sync.Mutex
+	w       io.Writer
+
+	mu      sync.Mutex
+	pending map[string]pendingTask
+	// set once the process is gone
+	err error
+}
+
+// pendingTask is a task sent to a worker process and the channel its result is
+// handed to.
+type pendingTask struct {
+	task   queue.IntTask
+	result chan error
+}
+
+func (c *externalConn) send(req externalRequest) error {
+	line, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = c.w.Write(append(line, '\n'))
+	return err
+}
+
+// dispatch sends a task to the process and waits for its result.
+func (c *externalConn) dispatch(ctx context.Context, task queue.IntTask) error {
+	result := make(chan error, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.pending[task.ID] = pendingTask{task: task, result: result}
+	c.mu.Unlock()
+
+	err := c.send(externalRequest{
+		Op:       "run",
+		ID:       task.ID,
+		Type:     task.Task.Type,
+		Payload:  task.Task.Payload,
+		Priority: task.Task.Priority,
+		Tenant:   task.Task.Tenant,
+	})
+	if err != nil {
+		c.forget(task.ID)
+		return fmt.Errorf("%w: %v", errProcessExited, err)
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		c.forget(task.ID)
+		_ = c.send(externalRequest{Op: "cancel", ID: task.ID})
+		return ctx.Err()
+	}
+}
+
+func (c *externalConn) forget(taskID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, taskID)
+}
+
+// read hands the results read from the process to their dispatches until the
+// process closes its output.
+func (c *externalConn) read(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxExternalLine)
+	for scanner.Scan() {
+		var res externalResult
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil || res.ID == "" {
+			// not a result, e.g. a stray print
+			c.stdout.Line(scanner.Text())
+			continue
+		}
+
+		c.mu.Lock()
+		pending, exists := c.pending[res.ID]
+		if exists && res.Progress == nil {
+			delete(c.pending, res.ID)
+		}
+		c.mu.Unlock()
+		if !exists {
+			logging.Task(string(c.queue), c.workerID, res.ID).Warn("external worker sent a result for an unknown task")
+			continue
+		}
+		if res.Progress != nil {
+			tasks.ReportProgress(c.queue, pending.task, c.workerID, *res.Progress, res.Message)
+			continue
+		}
+		if res.Error != "" {
+			pending.result <- errors.New(res.Error)
+		} else {
+			pending.result <- nil
+		}
+	}
+	// a closed connection is closed by run once the process exited
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		logging.Worker(string(c.queue), c.workerID).Error("could not read results of external worker", logging.Err(err))
+	}
+	c.fail(errProcessExited)
+}
+
+// fail fails all waiting dispatches and all later ones with err.
+func (c *externalConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		c.err = err
+	}
+	for id, pending := range c.pending {
+		pending.result <- c.err
+		delete(c.pending, id)
+	}
+}
@@ -0,0 +1,193 @@
+package workers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/events"
+	"github.com/Yulian302/qugopy/internal/queue"
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/Yulian302/qugopy/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHelperExternalWorker is not a test, but the external worker process run
+// by the tests below. It answers tasks with the error in their payload, exits on
+// tasks asking it to crash, never answers blocking tasks and reports halfway
+// progress if asked to.
+func TestHelperExternalWorker(t *testing.T) {
+	if os.Getenv("QUGOPY_HELPER_WORKER") != "1" {
+		return
+	}
+
+	var r io.Reader = os.Stdin
+	var w io.Writer = os.Stdout
+	if socket := os.Getenv("QUGOPY_SOCKET"); socket != "" {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			os.Exit(2)
+		}
+		r, w = conn, conn
+	}
+	// not a result, must be skipped
+	_, _ = io.WriteString(w, "worker started\n")
+
+	enc := json.NewEncoder(w)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var req externalRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil || req.Op != "run" {
+			continue
+		}
+		var payload struct {
+			Fail     string `json:"fail"`
+			Crash    bool   `json:"crash"`
+			Block    bool   `json:"block"`
+			Progress bool   `json:"progress"`
+		}
+		_ = json.Unmarshal(req.Payload, &payload)
+		switch {
+		case payload.Crash:
+			os.Exit(3)
+		case payload.Block:
+			continue
+		case payload.Progress:
+			halfway := 0.5
+			_ = enc.Encode(externalResult{ID: req.ID, Progress: &halfway, Message: "halfway"})
+		}
+		_ = enc.Encode(externalResult{ID: req.ID, Error: payload.Fail})
+	}
+	os.Exit(0)
+}
+
+func helperWorkerConfig(transport string) ExternalWorkerConfig {
+	return ExternalWorkerConfig{
+		Command:     os.Args[0],
+		Args:        []string{"-test.run=^TestHelperExternalWorker$"},
+		Env:         map[string]string{"QUGOPY_HELPER_WORKER": "1"},
+		Transport:   transport,
+		Concurrency: 1,
+		Queue:       "external_test",
+	}
+}
+
+func externalTask(payload string) queue.IntTask {
+	return models.IntTask{
+		ID:   uuid.New().String(),
+		Task: models.Task{Type: "external_echo", Payload: json.RawMessage(payload), Priority: 1},
+	}
+}
+
+// dispatchLoop returns a worker loop handing the dispatch function of a worker to the test.
+func dispatchLoop(dispatches chan<- dispatchFunc) func(dispatch dispatchFunc) func(ctx context.Context) error {
+	return func(dispatch dispatchFunc) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			select {
+			case dispatches <- dispatch:
+			case <-ctx.Done():
+			}
+			<-ctx.Done()
+			return nil
+		}
+	}
+}
+
+func TestExternalWorkerProtocol(t *testing.T) {
+	for _, transport := range []string{"stdio", "unix"} {
+		t.Run(transport, func(t *testing.T) {
+			dispatches := make(chan dispatchFunc)
+			worker := NewExternalWorker(context.Background(), uuid.New().String(), helperWorkerConfig(transport), dispatchLoop(dispatches))
+			require.NoError(t, worker.Start())
+
+			var dispatch dispatchFunc
+			select {
+			case dispatch = <-dispatches:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Worker should connect to its process")
+			}
+
+			ctx := context.Background()
+			assert.NoError(t, dispatch(ctx, externalTask(`{}`)))
+			assert.EqualError(t, dispatch(ctx, externalTask(`{"fail": "boom"}`)), "boom")
+
+			task := externalTask(`{"progress": true}`)
+			sub := events.Subscribe(events.Filter{TaskIDs: []string{task.ID}})
+			defer sub.Close()
+			assert.NoError(t, dispatch(ctx, task), "Progress lines should not finish a task")
+			require.Len(t, sub.C, 1)
+			progress := <-sub.C
+			assert.Equal(t, events.Progress, progress.Type)
+			assert.Equal(t, 0.5, progress.Progress)
+			assert.Equal(t, "halfway", progress.Message)
+			assert.Equal(t, "external_test", progress.Queue)
+
+			timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+			assert.ErrorIs(t, dispatch(timeout, externalTask(`{"block": true}`)), context.DeadlineExceeded,
+				"Unanswered tasks should be abandoned with their context")
+
+			assert.NoError(t, worker.HealthCheck())
+			assert.NoError(t, worker.Stop())
+			assert.NoError(t, worker.HealthCheck(), "A stopped worker did not fail")
+		})
+	}
+}
+
+func TestExternalWorkerCrash(t *testing.T) {
+	dispatches := make(chan dispatchFunc)
+	worker := NewExternalWorker(context.Background(), uuid.New().String(), helperWorkerConfig("stdio"), dispatchLoop(dispatches))
+	require.NoError(t, worker.Start())
+	defer worker.Stop()
+
+	dispatch := <-dispatches
+	assert.ErrorIs(t, dispatch(context.Background(), externalTask(`{"crash": true}`)), errProcessExited,
+		"Tasks of a crashed process should be reported as interrupted")
+	assert.Eventually(t, func() bool { return worker.HealthCheck() != nil }, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, worker.Start(), "A crashed worker should restart")
+	dispatch = <-dispatches
+	assert.NoError(t, dispatch(context.Background(), externalTask(`{}`)))
+}
+
+func TestExternalPool(t *testing.T) {
+	config.AppConfig.MODE = "local"
+	cfg := helperWorkerConfig("stdio")
+	config.AppConfig.EXTERNAL_POOLS = []config.ExternalPoolConfig{{
+		NAME:        "external_test",
+		COMMAND:     cfg.Command,
+		ARGS:        cfg.Args,
+		ENV:         cfg.Env,
+		TASK_TYPES:  []string{"external_echo"},
+		CONCURRENCY: 1,
+	}}
+	t.Cleanup(func() { config.AppConfig.EXTERNAL_POOLS = nil })
+	require.NoError(t, tasks.LoadQueues(nil))
+
+	wd := NewWorkerDistributor(nil)
+	_, err := wd.Distribute(WorkerPlan{Cap: CapNone, Queues: []tasks.QueueType{"external_test"}}, "local", true, nil)
+	require.NoError(t, err)
+	require.NoError(t, wd.ScalePool("external_test", 1))
+
+	require.NoError(t, tasks.EnqueueTask(models.Task{Type: "external_echo", Payload: json.RawMessage(`{}`), Priority: 1}, nil))
+	assert.Eventually(t, func() bool { return queue.Local("external_test").Len() == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	// a task the process never answers is requeued on shutdown
+	require.NoError(t, tasks.EnqueueTask(models.Task{Type: "external_echo", Payload: json.RawMessage(`{"block": true}`), Priority: 1}, nil))
+	assert.Eventually(t, func() bool {
+		running, _ := tasks.ListInFlight("external_test", nil)
+		return len(running) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.ErrorContains(t, wd.Shutdown(100*time.Millisecond), "shutdown timeout")
+	assert.Equal(t, 1, queue.Local("external_test").Len(), "Cancelled task should be requeued")
+	queue.Local("external_test").Pop()
+}
@@ -3,8 +3,10 @@ package workers
 import (
 	"context"
 	"fmt"
-	"log"
+	"sync"
 	"time"
+
+	"github.com/Yulian302/qugopy/logging"
 )
 
 type GoWorker struct {
@@ -12,7 +14,10 @@ type GoWorker struct {
 	cancel     context.CancelFunc
 	id         string
 	workerFunc func(context.Context) error
-	done       chan struct{}
+
+	mu sync.Mutex
+	// closed when the current run of workerFunc returns, nil before the first Start
+	done chan struct{}
 }
 
 func NewGoWorker(id string, workerFunc func(context.Context) error) *GoWorker {
@@ -22,16 +27,33 @@ func NewGoWorker(id string, workerFunc func(context.Context) error) *GoWorker {
 		ctx:        ctx,
 		cancel:     cancel,
 		workerFunc: workerFunc,
-		done:       make(chan struct{}),
 	}
 }
 
 func (gw *GoWorker) Start() error {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	if gw.ctx.Err() != nil {
+		return fmt.Errorf("worker has been stopped")
+	}
+	if gw.done != nil && !isClosed(gw.done) {
+		return nil
+	}
+
+	done := make(chan struct{})
+	gw.done = done
 	go func() {
-		defer close(gw.done)
+		defer close(done)
+		// a panicking loop exits the worker instead of the process, so that it can be restarted
+		defer func() {
+			if r := recover(); r != nil {
+				logging.Worker("", gw.id).Error("worker panicked", "panic", r)
+			}
+		}()
 		err := gw.workerFunc(gw.ctx)
 		if err != nil && err != context.Canceled {
-			log.Printf("Worker %s exited with error: %v", gw.id, err)
+			logging.Worker("", gw.id).Error("worker exited", logging.Err(err))
 		}
 	}()
 	return nil
@@ -39,8 +61,17 @@ func (gw *GoWorker) Start() error {
 
 func (gw *GoWorker) Stop() error {
 	gw.cancel()
+
+	gw.mu.Lock()
+	done := gw.done
+	gw.mu.Unlock()
+	// a worker which never started has nothing to wait for
+	if done == nil {
+		return nil
+	}
+
 	select {
-	case <-gw.done:
+	case <-done:
 		return nil
 	case <-time.After(5 * time.Second):
 		return fmt.Errorf("timeout waiting for worker to stop")
@@ -48,14 +79,28 @@ func (gw *GoWorker) Stop() error {
 }
 
 func (gw *GoWorker) HealthCheck() error {
-	select {
-	case <-gw.done:
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+
+	if gw.done == nil {
+		return fmt.Errorf("worker not running")
+	}
+	// a stopped worker did not fail
+	if isClosed(gw.done) && gw.ctx.Err() == nil {
 		return fmt.Errorf("worker has exited")
-	default:
-		return nil
 	}
+	return nil
 }
 
 func (gw *GoWorker) ID() string {
 	return gw.id
 }
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
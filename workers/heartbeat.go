@@ -0,0 +1,117 @@
+package workers
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/Yulian302/qugopy/logging"
+)
+
+// interval between heartbeats of worker loops, shortened by tests
+var heartbeatInterval = tasks.HeartbeatInterval
+
+// activity tracks the tasks of a worker loop, reported to the registry with its heartbeats.
+type activity struct {
+	mu   sync.Mutex
+	info tasks.WorkerInfo
+	// running tasks, keyed by ID
+	current map[string]bool
+}
+
+func newActivity(queueType tasks.QueueType, workerID string, labels map[string]string) *activity {
+	runtime := tasks.GoRuntime
+	if spec, exists := tasks.GetQueue(queueType); exists && spec.Runtime == tasks.ExternalRuntime {
+		runtime = tasks.ExternalRuntime
+	}
+	host, _ := os.Hostname()
+	return &activity{
+		info: tasks.WorkerInfo{
+			ID:        workerID,
+			Runtime:   runtime,
+			Queue:     queueType,
+			Host:      host,
+			PID:       os.Getpid(),
+			Labels:    labels,
+			StartedAt: time.Now(),
+		},
+		current: make(map[string]bool),
+	}
+}
+
+func (a *activity) start(taskID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.current[taskID] = true
+}
+
+// finish records a task as done. Interrupted tasks are not counted, as they run again.
+func (a *activity) finish(taskID string, err error, interrupted bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.current, taskID)
+	if interrupted {
+		return
+	}
+	a.info.Processed++
+	if err != nil {
+		a.info.Failed++
+	}
+}
+
+// snapshot returns the worker's current state.
+func (a *activity) snapshot() tasks.WorkerInfo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	info := a.info
+	info.CurrentTasks = make([]string, 0, len(a.current))
+	for id := range a.current {
+		info.CurrentTasks = append(info.CurrentTasks, id)
+	}
+	return info
+}
+
+// startHeartbeats reports a worker to the registry now and every
+// heartbeatInterval. The returned function stops the heartbeats and
+// removes the worker from the registry.
+func (wd *WorkerDistributor) startHeartbeats(a *activity) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		for {
+			wd.heartbeat(a.snapshot(), false)
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		wd.heartbeat(a.snapshot(), true)
+	}
+}
+
+// heartbeat reports a worker's state to the registry, the remote server's one
+// for workers connected with ConnectRemote.
+func (wd *WorkerDistributor) heartbeat(info tasks.WorkerInfo, stopped bool) {
+	var err error
+	switch {
+	case wd.remote != nil:
+		err = wd.remoteHeartbeat(info, stopped)
+	case stopped:
+		err = tasks.RemoveWorker(info.ID, wd.rdb)
+	default:
+		err = tasks.RecordHeartbeat(info, wd.rdb)
+	}
+	if err != nil {
+		logging.Worker(string(info.Queue), info.ID).Error("could not send heartbeat", logging.Err(err))
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"sync"
 )
 
+// Worker is a unit consuming a queue. Start may be called again after the
+// worker exited on its own, which restarts it; a stopped worker stays stopped.
 type Worker interface {
 	Start() error
 	Stop() error
@@ -28,6 +30,19 @@ func (wm *WorkerManager) AddWorker(w Worker) {
 	wm.workers = append(wm.workers, w)
 }
 
+// RemoveWorker removes a worker from the manager without stopping it.
+func (wm *WorkerManager) RemoveWorker(id string) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	for i, w := range wm.workers {
+		if w.ID() == id {
+			wm.workers = append(wm.workers[:i], wm.workers[i+1:]...)
+			return
+		}
+	}
+}
+
 func (wm *WorkerManager) StartAll() error {
 	wm.mu.Lock()
 	defer wm.mu.Unlock()
@@ -67,3 +82,16 @@ func (wm *WorkerManager) HealthCheck() map[string]error {
 	}
 	return status
 }
+
+// Get returns the worker with the given id.
+func (wm *WorkerManager) Get(id string) (Worker, bool) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	for _, w := range wm.workers {
+		if w.ID() == id {
+			return w, true
+		}
+	}
+	return nil, false
+}
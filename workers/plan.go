@@ -0,0 +1,96 @@
+package workers
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strconv"
+
+	"github.com/Yulian302/qugopy/internal/tasks"
+)
+
+// CapPolicy limits how many workers of the built-in queues are started.
+//
+//   - "none": no limit.
+//   - "cpu": Python workers, which are separate processes running CPU bound
+//     tasks, are capped at the number of CPUs. Go workers are not capped, as
+//     their tasks are mostly I/O bound.
+//   - a positive number: the total number of workers may not exceed it.
+type CapPolicy string
+
+const (
+	CapNone CapPolicy = "none"
+	CapCPU  CapPolicy = "cpu"
+)
+
+// ParseCapPolicy validates a cap policy. An empty string means CapCPU.
+func ParseCapPolicy(raw string) (CapPolicy, error) {
+	switch CapPolicy(raw) {
+	case "":
+		return CapCPU, nil
+	case CapNone, CapCPU:
+		return CapPolicy(raw), nil
+	}
+	if n, err := strconv.Atoi(raw); err != nil || n < 1 {
+		return "", fmt.Errorf("invalid worker cap %q: must be none, cpu or a positive number", raw)
+	}
+	return CapPolicy(raw), nil
+}
+
+// WorkerPlan sizes the worker pools of the built-in queues.
+type WorkerPlan struct {
+	Go     int
+	Python int
+
+	// GoConcurrency is the number of tasks every Go worker runs at once.
+	GoConcurrency int
+
+	Cap CapPolicy
+
+	// Queues limits the pools started to these queues. All queues if empty.
+	Queues []tasks.QueueType
+}
+
+// SplitWorkers splits a total number of workers in half between Python and Go,
+// Go getting the remainder.
+func SplitWorkers(total int) WorkerPlan {
+	return WorkerPlan{
+		Go:            total - total/2,
+		Python:        total / 2,
+		GoConcurrency: 1,
+		Cap:           CapCPU,
+	}
+}
+
+// apply enforces the plan's cap policy. Capping the Python pool is logged;
+// exceeding a fixed total is an error, so that no worker is dropped silently.
+func (p WorkerPlan) apply() (WorkerPlan, error) {
+	if p.Go < 0 || p.Python < 0 {
+		return p, fmt.Errorf("worker counts must not be negative")
+	}
+	// a plan limited to named queues needs no built-in workers
+	if p.Go+p.Python < 1 && len(p.Queues) == 0 {
+		return p, fmt.Errorf("totalWorkers must be at least 1")
+	}
+	if p.GoConcurrency < 1 {
+		p.GoConcurrency = 1
+	}
+
+	switch p.Cap {
+	case "", CapNone:
+	case CapCPU:
+		if cpus := runtime.NumCPU(); p.Python > cpus {
+			slog.Warn("capping python workers at the number of CPUs", "cpus", cpus, "requested", p.Python)
+			p.Python = cpus
+		}
+	default:
+		limit, err := strconv.Atoi(string(p.Cap))
+		if err != nil {
+			return p, fmt.Errorf("invalid worker cap %q", p.Cap)
+		}
+		if p.Go+p.Python > limit {
+			return p, fmt.Errorf("%d workers requested, cap is %d", p.Go+p.Python, limit)
+		}
+	}
+	return p, nil
+}
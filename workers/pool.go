@@ -0,0 +1,201 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/Yulian302/qugopy/logging"
+	"github.com/google/uuid"
+)
+
+// pool is the set of workers consuming one queue.
+type pool struct {
+	runtime tasks.Runtime
+	workers []Worker
+}
+
+func (wd *WorkerDistributor) manager(runtime tasks.Runtime) *WorkerManager {
+	switch runtime {
+	case tasks.PythonRuntime:
+		return wd.pyManager
+	case tasks.ExternalRuntime:
+		return wd.extManager
+	default:
+		return wd.goManager
+	}
+}
+
+func (wd *WorkerDistributor) newWorker(queueType tasks.QueueType, runtime tasks.Runtime) Worker {
+	id := uuid.New().String()
+	switch runtime {
+	case tasks.PythonRuntime:
+		cfg := wd.pyConfig
+		cfg.Queue = queueType
+		return NewPythonWorker(wd.ctx, id, cfg)
+	case tasks.ExternalRuntime:
+		cfg := wd.externalConfig(queueType)
+		return NewExternalWorker(wd.ctx, id, cfg, func(dispatch dispatchFunc) func(ctx context.Context) error {
+			return wd.workerLoop(queueType, id, cfg.Concurrency, dispatch)
+		})
+	default:
+		return NewGoWorker(id, wd.goWorkerLoop(queueType, id))
+	}
+}
+
+// addWorkers creates n workers for a queue and registers them with the queue's
+// pool and their manager. The workers are not started.
+func (wd *WorkerDistributor) addWorkers(queueType tasks.QueueType, runtime tasks.Runtime, n int) []Worker {
+	wd.poolsMu.Lock()
+	defer wd.poolsMu.Unlock()
+
+	p, exists := wd.pools[queueType]
+	if !exists {
+		p = &pool{runtime: runtime}
+		wd.pools[queueType] = p
+	}
+
+	added := make([]Worker, 0, n)
+	for i := 0; i < n; i++ {
+		w := wd.newWorker(queueType, runtime)
+		wd.wg.Add(1)
+		wd.manager(runtime).AddWorker(w)
+		p.workers = append(p.workers, w)
+		added = append(added, w)
+	}
+	return added
+}
+
+// PoolSize returns the number of workers consuming a queue.
+func (wd *WorkerDistributor) PoolSize(queueType tasks.QueueType) int {
+	wd.poolsMu.Lock()
+	defer wd.poolsMu.Unlock()
+
+	if p, exists := wd.pools[queueType]; exists {
+		return len(p.workers)
+	}
+	return 0
+}
+
+// ScalePool starts or stops workers of a queue until it has size workers.
+// Stopped workers finish their running tasks in the background first.
+func (wd *WorkerDistributor) ScalePool(queueType tasks.QueueType, size int) error {
+	spec, exists := tasks.GetQueue(queueType)
+	if !exists {
+		return fmt.Errorf("%w: %s", tasks.ErrUnknownQueue, queueType)
+	}
+	if size < 0 {
+		return fmt.Errorf("pool size must not be negative")
+	}
+
+	if current := wd.PoolSize(queueType); size > current {
+		if wd.stopping.Load() {
+			return fmt.Errorf("workers are shutting down")
+		}
+		for _, w := range wd.addWorkers(queueType, spec.Runtime, size-current) {
+			if err := w.Start(); err != nil {
+				return fmt.Errorf("failed to start worker %s: %w", w.ID(), err)
+			}
+		}
+		return nil
+	}
+
+	for _, w := range wd.removeWorkers(queueType, size) {
+		go func(w Worker) {
+			defer wd.wg.Done()
+			if err := w.Stop(); err != nil {
+				logging.Worker(string(queueType), w.ID()).Error("could not stop worker", logging.Err(err))
+			}
+		}(w)
+	}
+	return nil
+}
+
+// removeWorkers unregisters the newest workers of a queue beyond size and returns them.
+func (wd *WorkerDistributor) removeWorkers(queueType tasks.QueueType, size int) []Worker {
+	wd.poolsMu.Lock()
+	defer wd.poolsMu.Unlock()
+
+	p, exists := wd.pools[queueType]
+	if !exists || len(p.workers) <= size {
+		return nil
+	}
+	removed := append([]Worker{}, p.workers[size:]...)
+	p.workers = p.workers[:size]
+	for _, w := range removed {
+		wd.manager(p.runtime).RemoveWorker(w.ID())
+	}
+	return removed
+}
+
+// removeAllWorkers unregisters the workers of all queues and returns them.
+func (wd *WorkerDistributor) removeAllWorkers() []Worker {
+	wd.poolsMu.Lock()
+	defer wd.poolsMu.Unlock()
+
+	var removed []Worker
+	for _, p := range wd.pools {
+		for _, w := range p.workers {
+			wd.manager(p.runtime).RemoveWorker(w.ID())
+		}
+		removed = append(removed, p.workers...)
+		p.workers = nil
+	}
+	return removed
+}
+
+// PoolMetrics are the inputs of a scaling decision for a queue's pool.
+type PoolMetrics struct {
+	Workers int
+	// Depth is the number of queued tasks.
+	Depth int
+	// Wait is how long the tasks next in line have been queued.
+	Wait time.Duration
+	// Utilization is the share of the pool's task slots running a task.
+	Utilization float64
+}
+
+// poolMetrics measures a queue and the share of its pool's workers that are busy.
+// Only tasks run by this pool count, not those of other processes sharing the queue.
+func (wd *WorkerDistributor) poolMetrics(queueType tasks.QueueType) (PoolMetrics, error) {
+	wd.poolsMu.Lock()
+	ids := make(map[string]bool)
+	slots := 0
+	if p, exists := wd.pools[queueType]; exists {
+		for _, w := range p.workers {
+			ids[w.ID()] = true
+		}
+		slots = len(p.workers)
+		switch p.runtime {
+		case tasks.GoRuntime:
+			slots *= max(wd.goConcurrency, 1)
+		case tasks.ExternalRuntime:
+			slots *= wd.externalConfig(queueType).Concurrency
+		}
+	}
+	wd.poolsMu.Unlock()
+
+	m := PoolMetrics{Workers: len(ids)}
+	var err error
+	if m.Depth, err = tasks.QueueDepth(queueType, wd.rdb); err != nil {
+		return m, err
+	}
+	if m.Wait, err = tasks.HeadWait(queueType, wd.rdb); err != nil {
+		return m, err
+	}
+	running, err := tasks.ListInFlight(queueType, wd.rdb)
+	if err != nil {
+		return m, err
+	}
+	busy := 0
+	for _, r := range running {
+		if ids[r.WorkerID] {
+			busy++
+		}
+	}
+	if slots > 0 {
+		m.Utilization = min(float64(busy)/float64(slots), 1)
+	}
+	return m, nil
+}
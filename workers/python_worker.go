@@ -2,21 +2,36 @@ package workers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/Yulian302/qugopy/logging"
+	"github.com/Yulian302/qugopy/models"
 )
 
+// time a stopped Python worker gets to finish its current task, unless configured
+const pythonStopGrace = 30 * time.Second
+
 type PythonWorker struct {
-	cmd        *exec.Cmd
-	ctx        context.Context
-	cancel     context.CancelFunc
-	id         string
-	config     PythonWorkerConfig
-	mu         sync.Mutex
+	cmd    *exec.Cmd
+	ctx    context.Context
+	cancel context.CancelFunc
+	id     string
+	config PythonWorkerConfig
+	mu     sync.Mutex
+	// closed when the current process exits
 	waitDoneCh chan struct{}
 }
 
@@ -25,74 +40,170 @@ type PythonWorkerConfig struct {
 	FilePath     string
 	Mode         string
 	IsProduction bool
+
+	// ConcurrencyLimits and RateLimits are enforced by the worker itself in redis mode.
+	ConcurrencyLimits map[models.TaskType]int
+	RateLimits        map[models.TaskType]config.RateLimitConfig
+
+	// Queue is the queue the worker consumes. Defaults to python_queue.
+	Queue tasks.QueueType
+
+	// GrpcAddr is the address of the gRPC server handing out tasks in local
+	// mode, which the worker reports its metrics to in both modes. Defaults to
+	// the server of this process.
+	GrpcAddr string
+	// APIKey is the key the worker calls the gRPC server with.
+	APIKey string
+
+	// StopGrace is the time a stopped worker gets to finish its current task.
+	// Defaults to pythonStopGrace.
+	StopGrace time.Duration
+
+	// Labels of the worker, matched against the affinity of tasks. Preferred
+	// labels are ignored for tasks which waited for AffinityFallback. Tasks
+	// which were unschedulable for AffinityTimeout expire.
+	Labels           map[string]string
+	AffinityFallback time.Duration
+	AffinityTimeout  time.Duration
 }
 
 func NewPythonWorker(parentCtx context.Context, id string, config PythonWorkerConfig) *PythonWorker {
 	ctx, cancel := context.WithCancel(parentCtx)
 	return &PythonWorker{
-		id:         id,
-		ctx:        ctx,
-		cancel:     cancel,
-		config:     config,
-		waitDoneCh: make(chan struct{}),
+		id:     id,
+		ctx:    ctx,
+		cancel: cancel,
+		config: config,
 	}
 }
 
+// Start launches the worker process. It does nothing while the process runs
+// and launches a new one if it exited on its own.
 func (pw *PythonWorker) Start() error {
-	pw.cmd = exec.CommandContext(pw.ctx, path.Join(pw.config.EnvPath, "python3"), pw.config.FilePath)
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
 
-	pw.cmd.Env = append(os.Environ(),
+	if pw.ctx.Err() != nil {
+		return fmt.Errorf("worker has been stopped")
+	}
+	if pw.cmd != nil && !isClosed(pw.waitDoneCh) {
+		return nil
+	}
+
+	cmd := exec.CommandContext(pw.ctx, path.Join(pw.config.EnvPath, "python3"), pw.config.FilePath)
+
+	limits, err := json.Marshal(pw.config.ConcurrencyLimits)
+	if err != nil {
+		return fmt.Errorf("could not marshal concurrency limits: %w", err)
+	}
+	rateLimits, err := json.Marshal(pw.config.RateLimits)
+	if err != nil {
+		return fmt.Errorf("could not marshal rate limits: %w", err)
+	}
+
+	queueType := pw.config.Queue
+	if queueType == "" {
+		queueType = tasks.PyQueue
+	}
+
+	cmd.Env = append(os.Environ(),
 		"IS_PRODUCTION="+strconv.FormatBool(pw.config.IsProduction),
 		"MODE="+pw.config.Mode,
 		"PYTHONUNBUFFERED=1",
 		"WORKER_ID="+pw.id,
+		"CONCURRENCY_LIMITS="+string(limits),
+		"CONCURRENCY_LEASE_MS="+strconv.FormatInt(tasks.ConcurrencyLeaseTTL.Milliseconds(), 10),
+		"RATE_LIMITS="+string(rateLimits),
+		"QUEUE="+string(queueType),
+		"QUEUE_KEY="+tasks.QueueKey(queueType),
+		"QUEUE_STATES_KEY="+tasks.QueueStatesKey,
+		"WORKERS_KEY="+tasks.WorkersKey,
+		"HEARTBEAT_INTERVAL_MS="+strconv.FormatInt(tasks.HeartbeatInterval.Milliseconds(), 10),
+		"WORKER_LABELS="+formatLabels(pw.config.Labels),
+		"AFFINITY_FALLBACK_MS="+strconv.FormatInt(pw.config.AffinityFallback.Milliseconds(), 10),
+		"AFFINITY_TIMEOUT_MS="+strconv.FormatInt(pw.config.AffinityTimeout.Milliseconds(), 10),
 	)
-
-	if !pw.config.IsProduction {
-		pw.cmd.Stdout = os.Stdout
-		pw.cmd.Stderr = os.Stderr
+	if pw.config.GrpcAddr != "" {
+		cmd.Env = append(cmd.Env, "GRPC_ADDR="+pw.config.GrpcAddr)
 	}
+	if pw.config.APIKey != "" {
+		cmd.Env = append(cmd.Env, "QUGOPY_API_KEY="+pw.config.APIKey)
+	}
+
+	// the worker logs JSON lines to stderr, which are logged with its fields
+	log := pw.logger()
+	stdout := logging.NewOutputWriter(log, "stdout", slog.LevelInfo)
+	stderr := logging.NewOutputWriter(log, "stderr", slog.LevelError)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	// own process group, so that a Ctrl-C in the terminal reaches the worker
+	// only through Stop and it finishes its current task
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
-	err := pw.cmd.Start()
+	err = cmd.Start()
 	if err != nil {
 		return err
 	}
 
+	done := make(chan struct{})
+	pw.cmd, pw.waitDoneCh = cmd, done
 	go func() {
-		err := pw.cmd.Wait()
+		err := cmd.Wait()
+		stdout.Flush()
+		stderr.Flush()
 		if err != nil {
-			fmt.Printf("Python worker %s exited with error: %v\n", pw.id, err)
+			log.Error("python worker exited", logging.Err(err))
 		} else {
-			fmt.Printf("Python worker %s exited normally\n", pw.id)
+			log.Info("python worker exited")
 		}
-		close(pw.waitDoneCh)
+		close(done)
 	}()
 
 	return nil
 }
 
+// Stop asks the worker to exit after its current task and kills it if it is
+// still running after its stop grace.
 func (pw *PythonWorker) Stop() error {
 	pw.mu.Lock()
-	defer pw.mu.Unlock()
-
-	pw.cancel()
+	cmd, done := pw.cmd, pw.waitDoneCh
+	if cmd == nil {
+		// a stopped worker is not started again
+		pw.cancel()
+		pw.mu.Unlock()
+		return nil
+	}
+	pw.mu.Unlock()
 
-	if pw.cmd != nil && pw.cmd.Process != nil {
-		if err := pw.cmd.Process.Signal(os.Interrupt); err != nil {
-			fmt.Printf("Error signaling process %s: %v\n", pw.id, err)
-		}
-		<-pw.waitDoneCh
+	if err := cmd.Process.Signal(os.Interrupt); err != nil && !isClosed(done) {
+		pw.logger().Error("could not signal python worker", logging.Err(err))
+	}
+	grace := pw.config.StopGrace
+	if grace <= 0 {
+		grace = pythonStopGrace
+	}
+	select {
+	case <-done:
+	case <-time.After(grace):
+		pw.logger().Warn("python worker did not exit in time, killing it", "timeout", grace)
 	}
 
+	// kills the process if it is still running, and keeps it from being restarted
+	pw.cancel()
+	<-done
+
 	return nil
 }
 
+// HealthCheck reports a worker which never started or whose process exited
+// on its own. A stopped worker did not fail.
 func (pw *PythonWorker) HealthCheck() error {
-	if pw.cmd == nil || pw.cmd.Process == nil {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	if pw.cmd == nil {
 		return fmt.Errorf("worker not running")
 	}
-
-	if pw.cmd.ProcessState != nil && pw.cmd.ProcessState.Exited() {
+	if isClosed(pw.waitDoneCh) && pw.ctx.Err() == nil {
 		return fmt.Errorf("worker has exited")
 	}
 	return nil
@@ -101,3 +212,21 @@ func (pw *PythonWorker) HealthCheck() error {
 func (pw *PythonWorker) ID() string {
 	return pw.id
 }
+
+func (pw *PythonWorker) logger() *slog.Logger {
+	queueType := pw.config.Queue
+	if queueType == "" {
+		queueType = tasks.PyQueue
+	}
+	return logging.Worker(string(queueType), pw.id)
+}
+
+// formatLabels formats labels as parsed by config.ParseLabels.
+func formatLabels(labels map[string]string) string {
+	entries := make([]string, 0, len(labels))
+	for key, value := range labels {
+		entries = append(entries, key+"="+value)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
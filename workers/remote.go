@@ -0,0 +1,123 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Yulian302/qugopy/config"
+	taskpb "github.com/Yulian302/qugopy/github.com/Yulian302/qugopy/proto"
+	qgrpc "github.com/Yulian302/qugopy/grpc"
+	"github.com/Yulian302/qugopy/internal/auth"
+	"github.com/Yulian302/qugopy/internal/queue"
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// timeout of a single call to the remote gRPC server
+const remoteCallTimeout = 5 * time.Second
+
+// ConnectRemote makes the workers take tasks from the local mode queues of a
+// qugopy server at addr over gRPC, instead of from this process' queues.
+// Unique run locks, concurrency limits and rate limits are enforced by the
+// server when it hands out tasks. Calls are made with the key of QUGOPY_API_KEY.
+func (wd *WorkerDistributor) ConnectRemote(addr string) error {
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if config.AppConfig.API_KEY != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(auth.Credentials(config.AppConfig.API_KEY)))
+	}
+	conn, err := grpc.NewClient(addr, opts...)
+	if err != nil {
+		return fmt.Errorf("could not connect to %s: %w", addr, err)
+	}
+	wd.remote = taskpb.NewTaskServiceClient(conn)
+	wd.remoteConn = conn
+	wd.remoteAddr = addr
+	return nil
+}
+
+// ReportMetricsTo makes the Python workers of redis mode report their metrics
+// to the gRPC server at addr instead of the one of this process' app, for
+// processes which only run workers.
+func (wd *WorkerDistributor) ReportMetricsTo(addr string) {
+	wd.metricsAddr = addr
+}
+
+// apiKey returns the key Python workers call the gRPC server with: the key of
+// QUGOPY_API_KEY for a remote server, the key of this process' server otherwise.
+func (wd *WorkerDistributor) apiKey() string {
+	if wd.remoteAddr != "" {
+		return config.AppConfig.API_KEY
+	}
+	if !config.AppConfig.AUTH_ENABLED {
+		return ""
+	}
+	return auth.WorkerKey()
+}
+
+// remoteTask asks the remote server for the next task of a queue. The boolean
+// is false if the queue is empty or paused.
+func (wd *WorkerDistributor) remoteTask(queueType tasks.QueueType, workerID string) (queue.IntTask, bool, error) {
+	ctx, cancel := context.WithTimeout(wd.ctx, remoteCallTimeout)
+	defer cancel()
+
+	res, err := wd.remote.GetTask(ctx, &taskpb.GetTaskRequest{
+		WorkerType: taskpb.WorkerType_WORKER_TYPE_GO,
+		Queue:      string(queueType),
+		WorkerId:   workerID,
+		Labels:     wd.labels,
+	})
+	if status.Code(err) == codes.NotFound {
+		return queue.IntTask{}, false, nil
+	}
+	if err != nil {
+		return queue.IntTask{}, false, err
+	}
+	return qgrpc.FromProto(res), true, nil
+}
+
+// completeRemoteTask reports a task handed out by the remote server as finished,
+// or hands it back if it was interrupted, see interrupted.
+func (wd *WorkerDistributor) completeRemoteTask(task queue.IntTask, workerID string, taskErr error) error {
+	// the distributor's context may be cancelled already
+	ctx, cancel := context.WithTimeout(context.Background(), remoteCallTimeout)
+	defer cancel()
+
+	req := &taskpb.CompleteTaskRequest{
+		Id:       task.ID,
+		WorkerId: workerID,
+		Success:  taskErr == nil,
+		Requeue:  taskErr != nil && wd.interrupted(taskErr),
+	}
+	if taskErr != nil {
+		req.Error = taskErr.Error()
+	}
+	_, err := wd.remote.CompleteTask(ctx, req)
+	return err
+}
+
+// remoteHeartbeat reports a worker's state to the remote server's registry.
+func (wd *WorkerDistributor) remoteHeartbeat(info tasks.WorkerInfo, stopped bool) error {
+	// the distributor's context is cancelled before the last heartbeat
+	ctx, cancel := context.WithTimeout(context.Background(), remoteCallTimeout)
+	defer cancel()
+
+	_, err := wd.remote.Heartbeat(ctx, &taskpb.WorkerHeartbeat{
+		WorkerId:     info.ID,
+		Runtime:      string(info.Runtime),
+		Queue:        string(info.Queue),
+		Host:         info.Host,
+		Pid:          int32(info.PID),
+		Labels:       info.Labels,
+		CurrentTasks: info.CurrentTasks,
+		Processed:    info.Processed,
+		Failed:       info.Failed,
+		StartedAt:    timestamppb.New(info.StartedAt),
+		Stopped:      stopped,
+	})
+	return err
+}
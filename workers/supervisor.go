@@ -0,0 +1,207 @@
+package workers
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Yulian302/qugopy/internal/tasks"
+	"github.com/Yulian302/qugopy/logging"
+)
+
+// SupervisorPolicy tunes how the supervisor restarts crashed workers.
+type SupervisorPolicy struct {
+	// Interval between health checks of all workers.
+	Interval time.Duration
+
+	// A crashed worker is restarted after InitialBackoff, doubled for every
+	// further crash within CrashLoopWindow, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// A worker crashing more than MaxRestarts times within CrashLoopWindow is
+	// marked unhealthy and no longer restarted.
+	MaxRestarts     int
+	CrashLoopWindow time.Duration
+}
+
+func DefaultSupervisorPolicy() SupervisorPolicy {
+	return SupervisorPolicy{
+		Interval:        time.Second,
+		InitialBackoff:  time.Second,
+		MaxBackoff:      time.Minute,
+		MaxRestarts:     5,
+		CrashLoopWindow: 5 * time.Minute,
+	}
+}
+
+// backoff returns the delay before restarting a worker after its nth recent crash.
+func (p SupervisorPolicy) backoff(crashes int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < crashes && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxBackoff)
+}
+
+type WorkerState string
+
+const (
+	WorkerRunning    WorkerState = "running"
+	WorkerRestarting WorkerState = "restarting"
+	WorkerUnhealthy  WorkerState = "unhealthy"
+)
+
+// WorkerStatus is the supervision state of a worker.
+type WorkerStatus struct {
+	ID          string        `json:"id"`
+	Runtime     tasks.Runtime `json:"runtime"`
+	State       WorkerState   `json:"state"`
+	Restarts    int           `json:"restarts"`
+	LastError   string        `json:"last_error,omitempty"`
+	LastRestart time.Time     `json:"last_restart"`
+}
+
+type supervised struct {
+	status WorkerStatus
+	// recent crashes, within the crash loop window
+	crashes []time.Time
+	// when the crashed worker is restarted, zero if no restart is pending
+	restartAt time.Time
+}
+
+// Supervisor health-checks the workers of its managers and restarts crashed ones.
+type Supervisor struct {
+	policy   SupervisorPolicy
+	managers map[tasks.Runtime]*WorkerManager
+
+	mu      sync.Mutex
+	workers map[string]*supervised
+	now     func() time.Time
+}
+
+func NewSupervisor(policy SupervisorPolicy, managers map[tasks.Runtime]*WorkerManager) *Supervisor {
+	return &Supervisor{
+		policy:   policy,
+		managers: managers,
+		workers:  make(map[string]*supervised),
+		now:      time.Now,
+	}
+}
+
+// Run checks all workers every interval until ctx is cancelled.
+func (s *Supervisor) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.check(ctx)
+		}
+	}
+}
+
+// check records crashes of workers, restarts those whose backoff has passed and
+// forgets workers which were removed from their manager.
+func (s *Supervisor) check(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	seen := make(map[string]bool)
+	for runtime, wm := range s.managers {
+		for id, err := range wm.HealthCheck() {
+			seen[id] = true
+			sw, exists := s.workers[id]
+			if !exists {
+				sw = &supervised{status: WorkerStatus{ID: id, Runtime: runtime, State: WorkerRunning}}
+				s.workers[id] = sw
+			}
+
+			if err == nil {
+				sw.status.State = WorkerRunning
+				sw.restartAt = time.Time{}
+				continue
+			}
+			if sw.status.State == WorkerUnhealthy {
+				continue
+			}
+
+			if sw.restartAt.IsZero() {
+				s.crashed(sw, err, now)
+				continue
+			}
+			if now.Before(sw.restartAt) || ctx.Err() != nil {
+				continue
+			}
+
+			sw.restartAt = time.Time{}
+			w, exists := wm.Get(id)
+			if !exists {
+				continue
+			}
+			// a failed restart is seen as another crash on the next check
+			if err := w.Start(); err != nil {
+				sw.status.LastError = err.Error()
+				logging.Worker("", id).Error("supervisor could not restart worker", logging.Err(err))
+				continue
+			}
+			sw.status.State = WorkerRunning
+			sw.status.Restarts++
+			sw.status.LastRestart = now
+			logging.Worker("", id).Info("supervisor restarted worker", "runtime", runtime, "restarts", sw.status.Restarts)
+		}
+	}
+
+	for id := range s.workers {
+		if !seen[id] {
+			delete(s.workers, id)
+		}
+	}
+}
+
+// crashed records a crash of a worker and schedules its restart, or marks it
+// unhealthy if it is crash looping.
+func (s *Supervisor) crashed(sw *supervised, err error, now time.Time) {
+	recent := sw.crashes[:0]
+	for _, t := range sw.crashes {
+		if now.Sub(t) < s.policy.CrashLoopWindow {
+			recent = append(recent, t)
+		}
+	}
+	sw.crashes = append(recent, now)
+	sw.status.LastError = err.Error()
+
+	if len(sw.crashes) > s.policy.MaxRestarts {
+		sw.status.State = WorkerUnhealthy
+		logging.Worker("", sw.status.ID).Error("supervisor marked crash looping worker unhealthy", "crashes", len(sw.crashes), "window", s.policy.CrashLoopWindow, logging.Err(err))
+		return
+	}
+
+	delay := s.policy.backoff(len(sw.crashes))
+	sw.status.State = WorkerRestarting
+	sw.restartAt = now.Add(delay)
+	logging.Worker("", sw.status.ID).Warn("supervisor restarting failed worker", "delay", delay, logging.Err(err))
+}
+
+// Statuses returns the supervision state of all workers, ordered by runtime and id.
+func (s *Supervisor) Statuses() []WorkerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]WorkerStatus, 0, len(s.workers))
+	for _, sw := range s.workers {
+		statuses = append(statuses, sw.status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Runtime != statuses[j].Runtime {
+			return statuses[i].Runtime < statuses[j].Runtime
+		}
+		return statuses[i].ID < statuses[j].ID
+	})
+	return statuses
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"path"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -131,3 +132,31 @@ func TestWorkerLifecycle(t *testing.T) {
 		}
 	})
 }
+
+func TestGoWorkerRestart(t *testing.T) {
+	t.Parallel()
+
+	var starts atomic.Int32
+	runs := make(chan struct{}, 2)
+	worker := NewGoWorker(uuid.New().String(), func(ctx context.Context) error {
+		runs <- struct{}{}
+		if starts.Add(1) == 1 {
+			panic("crash")
+		}
+		<-ctx.Done()
+		return nil
+	})
+	assert.Error(t, worker.HealthCheck(), "Worker should not be running before start")
+
+	require.NoError(t, worker.Start())
+	<-runs
+	assert.Eventually(t, func() bool { return worker.HealthCheck() != nil }, time.Second, 10*time.Millisecond, "A panicking worker should exit")
+
+	require.NoError(t, worker.Start())
+	<-runs
+	assert.NoError(t, worker.HealthCheck(), "Restarted worker should be running")
+
+	require.NoError(t, worker.Stop())
+	assert.NoError(t, worker.HealthCheck(), "A stopped worker did not fail")
+	assert.Error(t, worker.Start(), "A stopped worker should not start again")
+}